@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// profileLookup returns the creation time of the oldest kind-0 profile known for pubkey.
+// found is false if no profile exists.
+type profileLookup func(ctx context.Context, pubkey string) (createdAt time.Time, found bool, err error)
+
+// upstreamProfileLookup finds the oldest kind-0 for a pubkey on the bootstrap relays.
+func upstreamProfileLookup(up *upstream, timeout time.Duration) profileLookup {
+	return func(ctx context.Context, pubkey string) (time.Time, bool, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		events, err := up.fetch(ctx, nostr.Filter{Kinds: []int{nostr.KindProfileMetadata}, Authors: []string{pubkey}})
+		if err != nil {
+			return time.Time{}, false, err
+		}
+
+		var oldest nostr.Timestamp
+		for _, event := range events {
+			if oldest == 0 || event.CreatedAt < oldest {
+				oldest = event.CreatedAt
+			}
+		}
+		if oldest == 0 {
+			return time.Time{}, false, nil
+		}
+		return oldest.Time(), true, nil
+	}
+}
+
+// accountAgeEntry is a cached profile lookup result.
+type accountAgeEntry struct {
+	createdAt time.Time
+	found     bool
+	checkedAt time.Time
+}
+
+// minAccountAge rejects events from pubkeys whose profile is younger than minAge.
+// Profile ages never shrink, so found profiles are cached indefinitely; missing profiles
+// are looked up again after missingTTL. Lookup failures are reported as temporary errors.
+func minAccountAge(lookup profileLookup, minAge, missingTTL time.Duration, ownerPubKey string) func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	var mu sync.Mutex
+	cache := make(map[string]accountAgeEntry)
+
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if event.PubKey == ownerPubKey {
+			return false, ""
+		}
+
+		now := time.Now()
+		mu.Lock()
+		entry, ok := cache[event.PubKey]
+		mu.Unlock()
+
+		if !ok || (!entry.found && now.Sub(entry.checkedAt) > missingTTL) {
+			createdAt, found, err := lookup(ctx, event.PubKey)
+			if err != nil {
+				log.Printf("Error looking up account age for %s: %v", event.PubKey, err)
+				return true, "error: could not verify account age, try again later"
+			}
+
+			entry = accountAgeEntry{createdAt: createdAt, found: found, checkedAt: now}
+			mu.Lock()
+			cache[event.PubKey] = entry
+			mu.Unlock()
+		}
+
+		if !entry.found || now.Sub(entry.createdAt) < minAge {
+			return true, "blocked: account too new"
+		}
+		return false, ""
+	}
+}
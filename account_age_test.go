@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMinAccountAge(t *testing.T) {
+	profiles := map[string]time.Time{
+		"old": time.Now().Add(-60 * 24 * time.Hour),
+		"new": time.Now().Add(-time.Hour),
+	}
+	lookups := 0
+	lookup := func(ctx context.Context, pubkey string) (time.Time, bool, error) {
+		lookups++
+		if pubkey == "unreachable" {
+			return time.Time{}, false, errors.New("no relays")
+		}
+		createdAt, found := profiles[pubkey]
+		return createdAt, found, nil
+	}
+	policy := minAccountAge(lookup, 30*24*time.Hour, time.Hour, "owner")
+
+	tests := []struct {
+		pubkey string
+		reject bool
+		msg    string
+	}{
+		{"old", false, ""},
+		{"new", true, "blocked: account too new"},
+		{"missing", true, "blocked: account too new"},
+		{"unreachable", true, "error: could not verify account age, try again later"},
+		{"owner", false, ""},
+	}
+	for _, tt := range tests {
+		reject, msg := policy(context.Background(), &nostr.Event{PubKey: tt.pubkey})
+		if reject != tt.reject || msg != tt.msg {
+			t.Errorf("%s: expected (%t, %q), got (%t, %q)", tt.pubkey, tt.reject, tt.msg, reject, msg)
+		}
+	}
+
+	before := lookups
+	policy(context.Background(), &nostr.Event{PubKey: "old"})
+	policy(context.Background(), &nostr.Event{PubKey: "missing"})
+	if lookups != before {
+		t.Fatalf("expected cached results to be reused, got %d extra lookups", lookups-before)
+	}
+}
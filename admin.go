@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// nip98MaxSkew is how far the created_at of a NIP-98 auth event may be from the server clock.
+const nip98MaxSkew = 60
+
+// requestURL reconstructs the absolute URL a client used, honoring the usual proxy headers.
+func requestURL(r *http.Request) string {
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" {
+		proto = "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+	}
+	return proto + "://" + host + r.URL.RequestURI()
+}
+
+// verifyNIP98 checks the NIP-98 Authorization header of r and returns the authenticated pubkey.
+func verifyNIP98(r *http.Request, now nostr.Timestamp) (string, error) {
+	event, err := parseNIP98(r, now)
+	if err != nil {
+		return "", err
+	}
+	return event.PubKey, nil
+}
+
+// verifyNIP86 checks the NIP-98 Authorization header of a management request, which must also
+// commit to the request body with a payload tag, and returns the authenticated pubkey.
+func verifyNIP86(r *http.Request, payload []byte, now nostr.Timestamp) (string, error) {
+	event, err := parseNIP98(r, now)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(payload)
+	if tag := event.Tags.Find("payload"); tag == nil || tag[1] != hex.EncodeToString(hash[:]) {
+		return "", fmt.Errorf("auth event 'payload' tag does not match the request body")
+	}
+	return event.PubKey, nil
+}
+
+// parseNIP98 decodes the NIP-98 auth event of r and checks it against the request.
+func parseNIP98(r *http.Request, now nostr.Timestamp) (*nostr.Event, error) {
+	encoded, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Nostr ")
+	if !ok {
+		return nil, fmt.Errorf("missing NIP-98 authorization")
+	}
+
+	eventJSON, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 in authorization")
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(eventJSON, &event); err != nil {
+		return nil, fmt.Errorf("invalid auth event json")
+	}
+
+	if event.Kind != 27235 {
+		return nil, fmt.Errorf("auth event must be kind 27235")
+	}
+	if ok, _ := event.CheckSignature(); !ok {
+		return nil, fmt.Errorf("invalid auth event signature")
+	}
+	if event.CreatedAt < now-nip98MaxSkew || event.CreatedAt > now+nip98MaxSkew {
+		return nil, fmt.Errorf("auth event is too old or in the future")
+	}
+	if u := event.Tags.Find("u"); u == nil || nostr.NormalizeURL(u[1]) != nostr.NormalizeURL(requestURL(r)) {
+		return nil, fmt.Errorf("auth event 'u' tag does not match the request url")
+	}
+	if method := event.Tags.Find("method"); method == nil || !strings.EqualFold(method[1], r.Method) {
+		return nil, fmt.Errorf("auth event 'method' tag does not match the request method")
+	}
+
+	return &event, nil
+}
+
+// requireOwner only lets requests through that carry a valid NIP-98 authorization by the relay owner.
+func requireOwner(ownerPubKey string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pubkey, err := verifyNIP98(r, nostr.Now())
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		if ownerPubKey == "" || pubkey != ownerPubKey {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "only the relay owner can use this endpoint"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// readerKey is the request context key requireReader stores the reader's pubkey under.
+type readerKey struct{}
+
+// readerPubkey returns the pubkey requireReader let through, or "" outside of it.
+func readerPubkey(ctx context.Context) string {
+	pubkey, _ := ctx.Value(readerKey{}).(string)
+	return pubkey
+}
+
+// requireReader only lets requests through that carry a valid NIP-98 authorization by a pubkey
+// that may read from the relay, the same rule the websocket applies to REQs.
+func requireReader(isAllowedPubkey func(pubkey string) (bool, error), ownerPubKey string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pubkey, err := verifyNIP98(r, nostr.Now())
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		if pubkey != ownerPubKey {
+			isAllowed, err := isAllowedPubkey(pubkey)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "error checking authorization"})
+				return
+			}
+			if !isAllowed {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "this is a private relay, only authorized users can read here"})
+				return
+			}
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), readerKey{}, pubkey)))
+	}
+}
+
+// dbErrorStatus maps a DBManager error to an HTTP status: bad input and missing or duplicate
+// entries are the caller's problem, anything else is a database failure worth retrying.
+func dbErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidPubkey):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrPubkeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrPubkeyExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// normalizeLabels lowercases and trims labels, dropping empty ones and duplicates.
+func normalizeLabels(labels []string) []string {
+	normalized := []string{}
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label != "" && !slices.Contains(normalized, label) {
+			normalized = append(normalized, label)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized
+}
+
+// handleAdminAllowlist serves GET /admin/allowlist, optionally filtered with ?label=.
+func handleAdminAllowlist(dbManager *DBManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		label := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("label")))
+		entries, err := dbManager.GetAllowedPubkeyEntries(label)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
+
+// handleAdminAllowlistLabels serves POST /admin/allowlist/labels with a body like
+// {"pubkey": "<hex>", "labels": ["friends", "paying"]}, replacing the labels of that entry.
+func handleAdminAllowlistLabels(dbManager *DBManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		var body struct {
+			Pubkey string   `json:"pubkey"`
+			Labels []string `json:"labels"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json body"})
+			return
+		}
+		if !nostr.IsValidPublicKey(body.Pubkey) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid pubkey"})
+			return
+		}
+
+		labels := normalizeLabels(body.Labels)
+		if err := dbManager.SetAllowedPubkeyLabels(body.Pubkey, labels); err != nil {
+			writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"pubkey": body.Pubkey, "labels": labels})
+	}
+}
+
+// Limits on the owner's records about an allowlist entry, in bytes.
+const (
+	maxContactLength = 256
+	maxNotesLength   = 4096
+)
+
+// handleAdminAllowlistMetadata serves /admin/allowlist/metadata, the contact and notes the owner
+// keeps about allowed pubkeys: GET ?pubkey=<hex> returns the entry, and POST with a body like
+// {"pubkey": "<hex>", "contact": "alice@example.com", "notes": "..."} sets them. A field left
+// out of the body is kept as it is, an empty string clears it. With a nil set, as in archive
+// mode, only GET is served.
+func handleAdminAllowlistMetadata(get func(pubkey string) (AllowedPubkey, error), set func(pubkey string, contact, notes *string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			entry, err := get(strings.ToLower(r.URL.Query().Get("pubkey")))
+			if err != nil {
+				writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, entry)
+		case http.MethodPost:
+			if set == nil {
+				writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+				return
+			}
+			var body struct {
+				Pubkey  string  `json:"pubkey"`
+				Contact *string `json:"contact"`
+				Notes   *string `json:"notes"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json body"})
+				return
+			}
+			if body.Contact != nil {
+				*body.Contact = strings.TrimSpace(*body.Contact)
+				if len(*body.Contact) > maxContactLength {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("contact must be at most %d bytes", maxContactLength)})
+					return
+				}
+			}
+			if body.Notes != nil && len(*body.Notes) > maxNotesLength {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("notes must be at most %d bytes", maxNotesLength)})
+				return
+			}
+
+			if err := set(body.Pubkey, body.Contact, body.Notes); err != nil {
+				writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			entry, err := get(body.Pubkey)
+			if err != nil {
+				writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, entry)
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	}
+}
+
+// listAllowedPubKeys is the NIP-86 listallowedpubkeys handler. When private is set, every caller
+// but the owner gets an empty list, so not even moderators learn who the members are.
+func listAllowedPubKeys(getAllowed func() ([]string, error), ownerPubKey string, private bool) func(ctx context.Context) ([]nip86.PubKeyReason, error) {
+	return func(ctx context.Context) ([]nip86.PubKeyReason, error) {
+		result := []nip86.PubKeyReason{}
+		if private && (ownerPubKey == "" || getAuthed(ctx) != ownerPubKey) {
+			return result, nil
+		}
+
+		pubkeys, err := getAllowed()
+		if err != nil {
+			return nil, err
+		}
+		for _, pubkey := range pubkeys {
+			result = append(result, nip86.PubKeyReason{PubKey: pubkey})
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 500
+)
+
+// page is the JSON envelope of paginated admin query results.
+type page struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	Items  any `json:"items"`
+}
+
+// parsePage reads ?limit= and ?offset=, capping the limit at maxPageSize.
+func parsePage(r *http.Request) (limit, offset int, err error) {
+	limit, offset = defaultPageSize, 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit")
+		}
+		limit = min(limit, maxPageSize)
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset")
+		}
+	}
+	return limit, offset, nil
+}
+
+// handleQueryAllowlist serves GET /admin/query/allowlist with optional ?label=,
+// ?pubkey_prefix=, ?status=active|expired, ?limit= and ?offset=.
+func handleQueryAllowlist(query func(AllowlistQuery) ([]AllowedPubkey, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		limit, offset, err := parsePage(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		params := r.URL.Query()
+		q := AllowlistQuery{
+			Label:        strings.ToLower(strings.TrimSpace(params.Get("label"))),
+			PubkeyPrefix: strings.ToLower(params.Get("pubkey_prefix")),
+			Status:       params.Get("status"),
+			Limit:        limit,
+			Offset:       offset,
+		}
+		if q.PubkeyPrefix != "" && (len(q.PubkeyPrefix) > 64 || !isHex(q.PubkeyPrefix)) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid pubkey_prefix"})
+			return
+		}
+		if q.Status != "" && q.Status != "active" && q.Status != "expired" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be active or expired"})
+			return
+		}
+
+		entries, total, err := query(q)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, page{Total: total, Limit: limit, Offset: offset, Items: entries})
+	}
+}
+
+// handleQueryModerators serves GET /admin/query/moderators with optional ?limit= and ?offset=.
+func handleQueryModerators(query func(limit, offset int) ([]Moderator, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		limit, offset, err := parsePage(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		moderators, total, err := query(limit, offset)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, page{Total: total, Limit: limit, Offset: offset, Items: moderators})
+	}
+}
+
+// isHex reports whether s only contains lowercase hex digits.
+func isHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
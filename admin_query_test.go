@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleQueryAllowlist(t *testing.T) {
+	var got AllowlistQuery
+	handler := handleQueryAllowlist(func(q AllowlistQuery) ([]AllowedPubkey, int, error) {
+		got = q
+		return []AllowedPubkey{{Pubkey: "ab", Labels: []string{}}}, 42, nil
+	})
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		query  AllowlistQuery
+	}{
+		{"defaults", "/admin/query/allowlist", http.StatusOK, AllowlistQuery{Limit: 100}},
+		{"filters", "/admin/query/allowlist?label=Friends&pubkey_prefix=AB12&status=expired&limit=10&offset=20", http.StatusOK,
+			AllowlistQuery{Label: "friends", PubkeyPrefix: "ab12", Status: "expired", Limit: 10, Offset: 20}},
+		{"page size capped", "/admin/query/allowlist?limit=100000", http.StatusOK, AllowlistQuery{Limit: maxPageSize}},
+		{"bad limit", "/admin/query/allowlist?limit=0", http.StatusBadRequest, AllowlistQuery{}},
+		{"bad offset", "/admin/query/allowlist?offset=-1", http.StatusBadRequest, AllowlistQuery{}},
+		{"bad prefix", "/admin/query/allowlist?pubkey_prefix=ab%27%20or%201=1", http.StatusBadRequest, AllowlistQuery{}},
+		{"bad status", "/admin/query/allowlist?status=banned", http.StatusBadRequest, AllowlistQuery{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = AllowlistQuery{}
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+			if got != tt.query {
+				t.Errorf("expected query %+v, got %+v", tt.query, got)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var result struct {
+				Total int             `json:"total"`
+				Items []AllowedPubkey `json:"items"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || result.Total != 42 || len(result.Items) != 1 {
+				t.Errorf("unexpected page %+v (%v)", result, err)
+			}
+		})
+	}
+}
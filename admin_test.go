@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// nip98Request builds a request to url carrying a NIP-98 authorization signed by sk.
+func nip98Request(t *testing.T, sk, method, url string, createdAt nostr.Timestamp) *http.Request {
+	t.Helper()
+	event := nostr.Event{
+		Kind:      27235,
+		CreatedAt: createdAt,
+		Tags:      nostr.Tags{{"u", url}, {"method", method}},
+	}
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	eventJSON, _ := json.Marshal(event)
+
+	req := httptest.NewRequest(method, url, nil)
+	req.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(eventJSON))
+	return req
+}
+
+func TestRequireOwner(t *testing.T) {
+	ownerSK, ownerPK := newKeypair(t)
+	otherSK, _ := newKeypair(t)
+	handler := requireOwner(ownerPK, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	url := "http://relay.example.com/admin/selftest"
+	now := nostr.Now()
+
+	// signed for another endpoint, replayed against this one
+	wrongURL := nip98Request(t, ownerSK, "GET", "http://relay.example.com/other", now)
+	wrongURL.URL.Path = "/admin/selftest"
+	wrongURL.RequestURI = "/admin/selftest"
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"owner", nip98Request(t, ownerSK, "GET", url, now), http.StatusNoContent},
+		{"other pubkey", nip98Request(t, otherSK, "GET", url, now), http.StatusForbidden},
+		{"stale event", nip98Request(t, ownerSK, "GET", url, now-600), http.StatusUnauthorized},
+		{"wrong url", wrongURL, http.StatusUnauthorized},
+		{"no authorization", httptest.NewRequest("GET", url, nil), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, tt.req)
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestNormalizeLabels(t *testing.T) {
+	got := normalizeLabels([]string{" Friends", "bots", "", "friends", "Paying "})
+	want := []string{"bots", "friends", "paying"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestListAllowedPubKeysPrivate(t *testing.T) {
+	_, owner := newKeypair(t)
+	_, member := newKeypair(t)
+	getAllowed := func() ([]string, error) { return []string{member}, nil }
+
+	tests := []struct {
+		name    string
+		caller  string
+		private bool
+		listed  int
+	}{
+		{"owner", owner, true, 1},
+		{"member", member, true, 0},
+		{"anonymous", "", true, 0},
+		{"member without private allowlist", member, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAuthed(t, tt.caller)
+			result, err := listAllowedPubKeys(getAllowed, owner, tt.private)(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result == nil || len(result) != tt.listed {
+				t.Errorf("expected %d entries, got %v", tt.listed, result)
+			}
+		})
+	}
+
+	// with no owner configured, the private list is hidden from everyone
+	withAuthed(t, "")
+	if result, _ := listAllowedPubKeys(getAllowed, "", true)(context.Background()); len(result) != 0 {
+		t.Errorf("expected an empty list without an owner, got %v", result)
+	}
+}
+
+func TestAllowlistMetadata(t *testing.T) {
+	member := fakeID(1)
+	entries := map[string]*AllowedPubkey{member: {Pubkey: member, Labels: []string{}}}
+	get := func(pubkey string) (AllowedPubkey, error) {
+		if entry, ok := entries[pubkey]; ok {
+			return *entry, nil
+		}
+		return AllowedPubkey{}, fmt.Errorf("%w: %s", ErrPubkeyNotFound, pubkey)
+	}
+	set := func(pubkey string, contact, notes *string) error {
+		entry, ok := entries[pubkey]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrPubkeyNotFound, pubkey)
+		}
+		if contact != nil {
+			entry.Contact = *contact
+		}
+		if notes != nil {
+			entry.Notes = *notes
+		}
+		return nil
+	}
+	handler := handleAdminAllowlistMetadata(get, set)
+	post := func(body string) (*httptest.ResponseRecorder, AllowedPubkey) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/admin/allowlist/metadata", strings.NewReader(body)))
+		var entry AllowedPubkey
+		json.Unmarshal(rec.Body.Bytes(), &entry)
+		return rec, entry
+	}
+
+	rec, entry := post(`{"pubkey":"` + member + `","contact":" alice@example.com ","notes":"met at the meetup"}`)
+	if rec.Code != http.StatusOK || entry.Contact != "alice@example.com" || entry.Notes != "met at the meetup" {
+		t.Fatalf("expected contact and notes to be set, got %d %s", rec.Code, rec.Body)
+	}
+
+	// fields left out are kept, empty ones are cleared
+	rec, entry = post(`{"pubkey":"` + member + `","notes":""}`)
+	if rec.Code != http.StatusOK || entry.Contact != "alice@example.com" || entry.Notes != "" {
+		t.Fatalf("expected only the notes to be cleared, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/admin/allowlist/metadata?pubkey="+member, nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &entry); err != nil || entry.Contact != "alice@example.com" {
+		t.Fatalf("expected the entry with its contact, got %d %s", rec.Code, rec.Body)
+	}
+
+	if rec, _ := post(`{"pubkey":"` + fakeID(2) + `","contact":"bob"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("expected pubkeys not on the allowlist to be a 404, got %d", rec.Code)
+	}
+	if rec, _ := post(`{"pubkey":"` + member + `","notes":"` + strings.Repeat("x", maxNotesLength+1) + `"}`); rec.Code != http.StatusBadRequest || len(entries[member].Notes) != 0 {
+		t.Errorf("expected overlong notes to be refused, got %d", rec.Code)
+	}
+
+	// an archive can read the entries but not change them
+	archive := handleAdminAllowlistMetadata(get, nil)
+	rec = httptest.NewRecorder()
+	archive(rec, httptest.NewRequest(http.MethodGet, "/admin/allowlist/metadata?pubkey="+member, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected an archive to serve the entry, got %d %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	archive(rec, httptest.NewRequest(http.MethodPost, "/admin/allowlist/metadata", strings.NewReader(`{"pubkey":"`+member+`","contact":"carol"}`)))
+	if rec.Code != http.StatusMethodNotAllowed || entries[member].Contact != "alice@example.com" {
+		t.Errorf("expected an archive to refuse changes, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// allowCache remembers recent allowlist decisions, allowed and denied, for the most recently
+// checked pubkeys, so big allowlists are never loaded as a whole and active pubkeys do not
+// cost a query per event. Entries expire after ttl, which bounds how long a change made
+// outside this process (such as the CLI) takes to be seen; changes made through the
+// management API are applied at once with forget.
+type allowCache struct {
+	lookup func(pubkey string) (bool, error)
+	size   int
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+
+	hits, misses atomic.Uint64
+}
+
+type allowCacheEntry struct {
+	pubkey    string
+	allowed   bool
+	checkedAt time.Time
+}
+
+func newAllowCache(lookup func(pubkey string) (bool, error), size int, ttl time.Duration) *allowCache {
+	return &allowCache{
+		lookup:  lookup,
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// isAllowed answers from the cache when it can and asks lookup otherwise. Errors are not cached.
+func (c *allowCache) isAllowed(pubkey string) (bool, error) {
+	now := c.now()
+	c.mu.Lock()
+	if element, ok := c.entries[pubkey]; ok {
+		entry := element.Value.(*allowCacheEntry)
+		if now.Sub(entry.checkedAt) <= c.ttl {
+			c.order.MoveToFront(element)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return entry.allowed, nil
+		}
+		c.order.Remove(element)
+		delete(c.entries, pubkey)
+	}
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	allowed, err := c.lookup(pubkey)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[pubkey]; ok {
+		// another check got here first
+		c.order.Remove(element)
+	}
+	c.entries[pubkey] = c.order.PushFront(&allowCacheEntry{pubkey: pubkey, allowed: allowed, checkedAt: now})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*allowCacheEntry).pubkey)
+	}
+	return allowed, nil
+}
+
+// forget drops the cached decision for pubkey, after it was allowed or removed.
+func (c *allowCache) forget(pubkey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[pubkey]; ok {
+		c.order.Remove(element)
+		delete(c.entries, pubkey)
+	}
+}
+
+// metrics reports the cache size and hit rate in the Prometheus text format.
+func (c *allowCache) metrics(w *bufio.Writer) {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+	hits, misses := c.hits.Load(), c.misses.Load()
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+
+	fmt.Fprintln(w, "# HELP brove_allowlist_cache_entries Pubkeys with a cached allowlist decision.")
+	fmt.Fprintln(w, "# TYPE brove_allowlist_cache_entries gauge")
+	fmt.Fprintf(w, "brove_allowlist_cache_entries %d\n", entries)
+	fmt.Fprintln(w, "# HELP brove_allowlist_cache_hits_total Allowlist checks answered from the cache.")
+	fmt.Fprintln(w, "# TYPE brove_allowlist_cache_hits_total counter")
+	fmt.Fprintf(w, "brove_allowlist_cache_hits_total %d\n", hits)
+	fmt.Fprintln(w, "# HELP brove_allowlist_cache_misses_total Allowlist checks that queried the database.")
+	fmt.Fprintln(w, "# TYPE brove_allowlist_cache_misses_total counter")
+	fmt.Fprintf(w, "brove_allowlist_cache_misses_total %d\n", misses)
+	fmt.Fprintln(w, "# HELP brove_allowlist_cache_hit_ratio Share of allowlist checks answered from the cache since startup.")
+	fmt.Fprintln(w, "# TYPE brove_allowlist_cache_hit_ratio gauge")
+	fmt.Fprintf(w, "brove_allowlist_cache_hit_ratio %g\n", ratio)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllowCache(t *testing.T) {
+	lookups := map[string]int{}
+	members := map[string]bool{"alice": true}
+	failing := false
+	cache := newAllowCache(func(pubkey string) (bool, error) {
+		lookups[pubkey]++
+		if failing {
+			return false, errors.New("connection refused")
+		}
+		return members[pubkey], nil
+	}, 2, time.Minute)
+	now := time.Unix(1700000000, 0)
+	cache.now = func() time.Time { return now }
+
+	check := func(pubkey string, want bool) {
+		t.Helper()
+		if allowed, err := cache.isAllowed(pubkey); err != nil || allowed != want {
+			t.Fatalf("%s: expected %v, got %v, %v", pubkey, want, allowed, err)
+		}
+	}
+
+	// both decisions are cached
+	check("alice", true)
+	check("alice", true)
+	check("mallory", false)
+	check("mallory", false)
+	if lookups["alice"] != 1 || lookups["mallory"] != 1 {
+		t.Fatalf("expected one lookup each, got %v", lookups)
+	}
+
+	// the least recently used entry is evicted
+	check("alice", true)
+	check("bob", false)
+	check("alice", true)
+	check("mallory", false)
+	if lookups["alice"] != 1 || lookups["mallory"] != 2 {
+		t.Fatalf("expected mallory to have been evicted, got %v", lookups)
+	}
+
+	// entries expire, and forget drops them at once
+	now = now.Add(2 * time.Minute)
+	members["alice"] = false
+	check("alice", false)
+	members["alice"] = true
+	cache.forget("alice")
+	check("alice", true)
+	if lookups["alice"] != 3 {
+		t.Fatalf("expected alice to be looked up again, got %v", lookups)
+	}
+
+	// errors are returned and not cached
+	failing = true
+	if _, err := cache.isAllowed("carol"); err == nil {
+		t.Fatal("expected the lookup error")
+	}
+	failing = false
+	check("carol", false)
+
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	cache.metrics(w)
+	w.Flush()
+	for _, line := range []string{"brove_allowlist_cache_entries 2", "brove_allowlist_cache_hits_total 4", "brove_allowlist_cache_misses_total 8", "brove_allowlist_cache_hit_ratio 0.3333333333333333"} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("expected %q in\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// syncAllowlist fetches the remote relay's allowlist through NIP-86 and adds every pubkey
+// that is not yet in the local allowlist, keeping the remote reason.
+// Returns how many pubkeys were added and how many were skipped, because they were already
+// present or are not valid pubkeys.
+func syncAllowlist(ctx context.Context, client *managementClient, dbManager *DBManager) (added, skipped int, err error) {
+	var remote []nip86.PubKeyReason
+	if err := client.call(ctx, "listallowedpubkeys", nil, &remote); err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch remote allowlist: %w", err)
+	}
+
+	existing, err := dbManager.GetAllowedPubkeys()
+	if err != nil {
+		return 0, 0, err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, pubkey := range existing {
+		known[pubkey] = true
+	}
+
+	for _, entry := range remote {
+		if known[entry.PubKey] {
+			skipped++
+			continue
+		}
+		if err := dbManager.AddAllowedPubkey(entry.PubKey, entry.Reason); errors.Is(err, ErrInvalidPubkey) {
+			log.Printf("Skipping remote allowlist entry: %v", err)
+			skipped++
+			continue
+		} else if err != nil {
+			return added, skipped, err
+		}
+		known[entry.PubKey] = true
+		added++
+	}
+
+	return added, skipped, nil
+}
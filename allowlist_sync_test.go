@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+func TestSyncAllowlist(t *testing.T) {
+	sk, _ := newKeypair(t)
+	remote := []nip86.PubKeyReason{
+		{PubKey: fakeID(1), Reason: "already here"},
+		{PubKey: fakeID(2), Reason: "new member"},
+		{PubKey: "npub1notahexkey", Reason: "invalid"},
+		{PubKey: fakeID(3)},
+		{PubKey: fakeID(2), Reason: "listed twice"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req nip86.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "listallowedpubkeys" {
+			t.Errorf("expected the allowlist to be requested, got %+v %v", req, err)
+		}
+		json.NewEncoder(w).Encode(nip86.Response{Result: remote})
+	}))
+	defer server.Close()
+
+	table := &memoryAllowlist{rows: map[string]*allowlistRow{fakeID(1): {reason: "local"}}}
+	added, skipped, err := syncAllowlist(context.Background(), newManagementClient(server.URL, sk), table.dbManager())
+	if err != nil || added != 2 || skipped != 3 {
+		t.Fatalf("expected 2 added and 3 skipped, got %d, %d, %v", added, skipped, err)
+	}
+	if len(table.rows) != 3 || table.rows[fakeID(1)].reason != "local" || table.rows[fakeID(2)].reason != "new member" {
+		t.Errorf("expected new pubkeys to be added with their remote reason and existing ones kept, got %v", table.rows)
+	}
+}
+
+func TestSyncAllowlistRefused(t *testing.T) {
+	sk, _ := newKeypair(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	table := &memoryAllowlist{rows: map[string]*allowlistRow{}}
+	added, skipped, err := syncAllowlist(context.Background(), newManagementClient(server.URL, sk), table.dbManager())
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to fetch remote allowlist: ") || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected the refusal to be reported, got %v", err)
+	}
+	if added != 0 || skipped != 0 || len(table.rows) != 0 {
+		t.Errorf("expected nothing to be imported, got %d, %d", added, skipped)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// announcementSetting is the relay_settings key the announcement is persisted under.
+const announcementSetting = "announcement"
+
+// settingsStore persists runtime-changeable relay settings; DBManager implements it.
+type settingsStore interface {
+	GetSetting(key string) (string, bool, error)
+	SetSetting(key, value string) error
+	DeleteSetting(key string) error
+}
+
+// announcement is a relay-wide message set by the owner at runtime. Every connecting client
+// gets it as a NOTICE, and it is published as "announcement" in the NIP-11 document.
+type announcement struct {
+	settings settingsStore
+
+	mu   sync.RWMutex
+	text string
+}
+
+// loadAnnouncement reads the persisted announcement, so it survives restarts.
+func loadAnnouncement(settings settingsStore) (*announcement, error) {
+	text, _, err := settings.GetSetting(announcementSetting)
+	if err != nil {
+		return nil, err
+	}
+	return &announcement{settings: settings, text: text}, nil
+}
+
+func (a *announcement) get() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.text
+}
+
+// set replaces the announcement; an empty text clears it.
+func (a *announcement) set(text string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var err error
+	if text == "" {
+		err = a.settings.DeleteSetting(announcementSetting)
+	} else {
+		err = a.settings.SetSetting(announcementSetting, text)
+	}
+	if err != nil {
+		return err
+	}
+	a.text = text
+	return nil
+}
+
+func (a *announcement) onConnect(ctx context.Context) {
+	if text := a.get(); text != "" {
+		if ws := getConnection(ctx); ws != nil {
+			ws.WriteJSON(nostr.NoticeEnvelope(text))
+		}
+	}
+}
+
+func (a *announcement) nip11(r *http.Request, doc map[string]any) {
+	if text := a.get(); text != "" {
+		doc["announcement"] = text
+	}
+}
+
+// handleAdminAnnouncement serves the owner-only announcement: GET returns it,
+// PUT {"text": "..."} sets it and DELETE clears it.
+func handleAdminAnnouncement(a *announcement) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct {
+				Text string `json:"text"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json body"})
+				return
+			}
+			if err := a.set(strings.TrimSpace(body.Text)); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+		case http.MethodDelete:
+			if err := a.set(""); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"text": a.get()})
+	}
+}
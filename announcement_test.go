@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+)
+
+// memorySettings is an in-memory settingsStore.
+type memorySettings map[string]string
+
+func (m memorySettings) GetSetting(key string) (string, bool, error) {
+	value, ok := m[key]
+	return value, ok, nil
+}
+
+func (m memorySettings) SetSetting(key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func (m memorySettings) DeleteSetting(key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestAnnouncement(t *testing.T) {
+	settings := memorySettings{announcementSetting: "maintenance tonight"}
+	notice, err := loadAnnouncement(settings)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	relay := khatru.NewRelay()
+	relay.OnConnect = append(relay.OnConnect, notice.onConnect)
+
+	// the persisted announcement is delivered on connect
+	conn := dialRelay(t, relay)
+	if message := readEnvelope(t, conn); message[0] != "NOTICE" || message[1] != "maintenance tonight" {
+		t.Fatalf("expected the announcement as a NOTICE, got %v", message)
+	}
+
+	handler := handleAdminAnnouncement(notice)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPut, "/admin/announcement", strings.NewReader(`{"text":" new rules "}`)))
+	if rec.Code != http.StatusOK || settings[announcementSetting] != "new rules" {
+		t.Fatalf("expected the announcement to be replaced, got %d %q", rec.Code, settings[announcementSetting])
+	}
+	doc := map[string]any{}
+	notice.nip11(nil, doc)
+	if doc["announcement"] != "new rules" {
+		t.Errorf("expected the announcement in NIP-11, got %v", doc)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodDelete, "/admin/announcement", nil))
+	if _, ok := settings[announcementSetting]; rec.Code != http.StatusOK || ok {
+		t.Fatalf("expected the announcement to be cleared, got %d %v", rec.Code, settings)
+	}
+	doc = map[string]any{}
+	notice.nip11(nil, doc)
+	if _, ok := doc["announcement"]; ok {
+		t.Errorf("expected no announcement in NIP-11 once cleared, got %v", doc)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pendingApproval is an allowlist request that has not reached the approval quorum yet.
+type pendingApproval struct {
+	Pubkey          string    `json:"pubkey"`
+	Reason          string    `json:"reason"`
+	Approvers       []string  `json:"approvers"`
+	FirstApprovedAt time.Time `json:"first_approved_at"`
+}
+
+// approvalStore keeps the approvals of pubkeys that are not allowed yet.
+type approvalStore interface {
+	// approve records an approval of pubkey and returns everyone who has approved it so far
+	approve(ctx context.Context, pubkey, approver, reason string) (approvers []string, err error)
+	pending(ctx context.Context) ([]pendingApproval, error)
+	// clear forgets the approvals of pubkey, once it has been allowed or rejected
+	clear(ctx context.Context, pubkey string) error
+}
+
+// storedApprovals keeps approvals in the allowlist_approvals table.
+type storedApprovals struct {
+	db *sql.DB
+}
+
+func newStoredApprovals(db *sql.DB) (*storedApprovals, error) {
+	query := `
+	CREATE TABLE IF NOT EXISTS allowlist_approvals (
+		pubkey VARCHAR(64) NOT NULL,
+		approver VARCHAR(64) NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		approved_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (pubkey, approver)
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return nil, fmt.Errorf("failed to create allowlist_approvals table: %w", err)
+	}
+	return &storedApprovals{db: db}, nil
+}
+
+func (s *storedApprovals) approve(ctx context.Context, pubkey, approver, reason string) ([]string, error) {
+	query := `INSERT INTO allowlist_approvals (pubkey, approver, reason) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, query, pubkey, approver, reason); err != nil {
+		return nil, fmt.Errorf("failed to record approval of %s: %w", pubkey, err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT approver FROM allowlist_approvals WHERE pubkey = $1 ORDER BY approved_at`, pubkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load approvals of %s: %w", pubkey, err)
+	}
+	defer rows.Close()
+
+	var approvers []string
+	for rows.Next() {
+		var approver string
+		if err := rows.Scan(&approver); err != nil {
+			return nil, fmt.Errorf("failed to scan approval: %w", err)
+		}
+		approvers = append(approvers, approver)
+	}
+	return approvers, rows.Err()
+}
+
+func (s *storedApprovals) pending(ctx context.Context) ([]pendingApproval, error) {
+	query := `SELECT pubkey, approver, reason, approved_at FROM allowlist_approvals ORDER BY approved_at`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load approvals: %w", err)
+	}
+	defer rows.Close()
+
+	requests := []pendingApproval{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var pubkey, approver, reason string
+		var approvedAt time.Time
+		if err := rows.Scan(&pubkey, &approver, &reason, &approvedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan approval: %w", err)
+		}
+		i, ok := index[pubkey]
+		if !ok {
+			i = len(requests)
+			index[pubkey] = i
+			requests = append(requests, pendingApproval{Pubkey: pubkey, Reason: reason, FirstApprovedAt: approvedAt})
+		}
+		requests[i].Approvers = append(requests[i].Approvers, approver)
+	}
+	return requests, rows.Err()
+}
+
+func (s *storedApprovals) clear(ctx context.Context, pubkey string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM allowlist_approvals WHERE pubkey = $1`, pubkey); err != nil {
+		return fmt.Errorf("failed to clear approvals of %s: %w", pubkey, err)
+	}
+	return nil
+}
+
+// approvalQuorum makes allowpubkey take effect only once quorum distinct admins (the owner and
+// the moderators) have called it for the same pubkey (APPROVAL_QUORUM), so a single
+// compromised admin can't add members. Until then the request stays pending.
+type approvalQuorum struct {
+	store   approvalStore
+	quorum  int
+	isAdmin func(pubkey string) (bool, error)
+}
+
+// approve records the approval of pubkey by the admin behind ctx and reports whether the quorum
+// has been reached. Approvals of admins that have lost their moderator rights since no longer
+// count.
+func (q *approvalQuorum) approve(ctx context.Context, pubkey, reason string) (approved bool, approvals int, err error) {
+	approver := getAuthed(ctx)
+	if approver == "" {
+		return false, 0, fmt.Errorf("approvals need an authenticated admin")
+	}
+	if err := validatePubkey(pubkey); err != nil {
+		return false, 0, err
+	}
+
+	approvers, err := q.store.approve(ctx, pubkey, approver, reason)
+	if err != nil {
+		return false, 0, err
+	}
+	for _, approver := range approvers {
+		admin, err := q.isAdmin(approver)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to check approver %s: %w", approver, err)
+		}
+		if admin {
+			approvals++
+		}
+	}
+	return approvals >= q.quorum, approvals, nil
+}
+
+// handleAdminApprovals serves /admin/approvals: GET lists the pending requests with their
+// approvers, DELETE ?pubkey=<hex> rejects a pending request.
+func handleAdminApprovals(q *approvalQuorum) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			requests, err := q.store.pending(r.Context())
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"quorum": q.quorum, "pending": requests})
+		case http.MethodDelete:
+			pubkey := strings.ToLower(r.URL.Query().Get("pubkey"))
+			if err := validatePubkey(pubkey); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if err := q.store.clear(r.Context(), pubkey); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			log.Printf("Approvals: rejected the pending request of %s", pubkey)
+			writeJSON(w, http.StatusOK, map[string]string{"rejected": pubkey})
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	}
+}
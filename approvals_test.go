@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// memoryApprovals is an in-memory approvalStore.
+type memoryApprovals struct {
+	approvals map[string][]string
+	reasons   map[string]string
+}
+
+func newMemoryApprovals() *memoryApprovals {
+	return &memoryApprovals{approvals: make(map[string][]string), reasons: make(map[string]string)}
+}
+
+func (m *memoryApprovals) approve(ctx context.Context, pubkey, approver, reason string) ([]string, error) {
+	if !slices.Contains(m.approvals[pubkey], approver) {
+		m.approvals[pubkey] = append(m.approvals[pubkey], approver)
+	}
+	if _, ok := m.reasons[pubkey]; !ok {
+		m.reasons[pubkey] = reason
+	}
+	return m.approvals[pubkey], nil
+}
+
+func (m *memoryApprovals) pending(ctx context.Context) ([]pendingApproval, error) {
+	requests := []pendingApproval{}
+	for pubkey, approvers := range m.approvals {
+		requests = append(requests, pendingApproval{Pubkey: pubkey, Reason: m.reasons[pubkey], Approvers: approvers, FirstApprovedAt: time.Now()})
+	}
+	return requests, nil
+}
+
+func (m *memoryApprovals) clear(ctx context.Context, pubkey string) error {
+	delete(m.approvals, pubkey)
+	delete(m.reasons, pubkey)
+	return nil
+}
+
+func TestApprovalQuorum(t *testing.T) {
+	ctx := context.Background()
+	_, owner := newKeypair(t)
+	_, first := newKeypair(t)
+	_, second := newKeypair(t)
+	_, member := newKeypair(t)
+	moderators := map[string]bool{first: true, second: true}
+
+	store := newMemoryApprovals()
+	quorum := &approvalQuorum{store: store, quorum: 2, isAdmin: func(pubkey string) (bool, error) {
+		return pubkey == owner || moderators[pubkey], nil
+	}}
+
+	withAuthed(t, first)
+	if approved, count, err := quorum.approve(ctx, member, "friend of ours"); err != nil || approved || count != 1 {
+		t.Fatalf("expected one approval to stay pending, got %v %d %v", approved, count, err)
+	}
+	// the same admin approving again is still one approval
+	if approved, count, _ := quorum.approve(ctx, member, "really"); approved || count != 1 {
+		t.Fatalf("expected a repeated approval not to count, got %v %d", approved, count)
+	}
+
+	rec := httptest.NewRecorder()
+	handleAdminApprovals(quorum)(rec, httptest.NewRequest(http.MethodGet, "/admin/approvals", nil))
+	var listing struct {
+		Quorum  int               `json:"quorum"`
+		Pending []pendingApproval `json:"pending"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listing); err != nil || listing.Quorum != 2 || len(listing.Pending) != 1 || listing.Pending[0].Reason != "friend of ours" {
+		t.Fatalf("expected the pending request to be listed, got %s", rec.Body.String())
+	}
+
+	withAuthed(t, second)
+	if approved, count, err := quorum.approve(ctx, member, ""); err != nil || !approved || count != 2 {
+		t.Fatalf("expected a second admin to reach the quorum, got %v %d %v", approved, count, err)
+	}
+}
+
+func TestApprovalQuorumNotReached(t *testing.T) {
+	ctx := context.Background()
+	_, owner := newKeypair(t)
+	_, demoted := newKeypair(t)
+	_, member := newKeypair(t)
+	store := newMemoryApprovals()
+	quorum := &approvalQuorum{store: store, quorum: 2, isAdmin: func(pubkey string) (bool, error) {
+		return pubkey == owner, nil
+	}}
+
+	// approvals of a moderator removed since no longer count
+	store.approve(ctx, member, demoted, "")
+	withAuthed(t, owner)
+	if approved, count, _ := quorum.approve(ctx, member, ""); approved || count != 1 {
+		t.Fatalf("expected only the owner's approval to count, got %v %d", approved, count)
+	}
+
+	withAuthed(t, "")
+	if _, _, err := quorum.approve(ctx, member, ""); err == nil {
+		t.Errorf("expected unauthenticated approvals to fail")
+	}
+	withAuthed(t, owner)
+	if _, _, err := quorum.approve(ctx, "not a pubkey", ""); err == nil {
+		t.Errorf("expected invalid pubkeys to fail")
+	}
+
+	// the owner rejects the request
+	rec := httptest.NewRecorder()
+	handleAdminApprovals(quorum)(rec, httptest.NewRequest(http.MethodDelete, "/admin/approvals?pubkey="+member, nil))
+	if rec.Code != http.StatusOK || len(store.approvals) != 0 {
+		t.Fatalf("expected the request to be rejected, got %d %v", rec.Code, store.approvals)
+	}
+}
+
+func TestApprovalMethodsForModerators(t *testing.T) {
+	_, owner := newKeypair(t)
+	_, moderator := newKeypair(t)
+	_, member := newKeypair(t)
+	isModerator := func(pubkey string) (bool, error) { return pubkey == moderator, nil }
+
+	withAuthed(t, moderator)
+	access := managementAccess(owner, isModerator, "allowpubkey")
+	if reject, msg := access(context.Background(), nip86.AllowPubKey{PubKey: member}); reject {
+		t.Errorf("expected moderators to be allowed to approve, got %q", msg)
+	}
+	if reject, _ := access(context.Background(), nip86.ChangeRelayName{Name: "mine"}); !reject {
+		t.Errorf("expected other owner-only methods to stay owner-only")
+	}
+}
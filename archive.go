@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// enableArchiveMode turns the relay into a read-only mirror: every event is rejected, nothing
+// can reach the event store's write path and only the read-only management methods remain.
+// Unlike a runtime toggle this is applied once at startup and cannot be undone.
+func enableArchiveMode(relay *khatru.Relay) {
+	relay.RejectEvent = []func(ctx context.Context, event *nostr.Event) (bool, string){
+		func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+			return true, "blocked: this relay is a read-only archive"
+		},
+	}
+	relay.StoreEvent = nil
+	relay.ReplaceEvent = nil
+	relay.DeleteEvent = nil
+
+	disableManagementMutations(relay)
+}
+
+// disableManagementMutations removes every NIP-86 handler except the list* and stats methods,
+// so they are answered with "method not supported".
+func disableManagementMutations(relay *khatru.Relay) {
+	api := reflect.ValueOf(&relay.ManagementAPI).Elem()
+	for i := 0; i < api.NumField(); i++ {
+		field := api.Type().Field(i)
+		if field.Type.Kind() != reflect.Func || strings.HasPrefix(field.Name, "List") || field.Name == "Stats" {
+			continue
+		}
+		api.Field(i).Set(reflect.Zero(field.Type))
+	}
+}
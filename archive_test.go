@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+func TestEnableArchiveMode(t *testing.T) {
+	relay := khatru.NewRelay()
+	relay.StoreEvent = append(relay.StoreEvent, func(ctx context.Context, event *nostr.Event) error { return nil })
+	relay.ManagementAPI.AllowPubKey = func(ctx context.Context, pubkey string, reason string) error { return nil }
+	relay.ManagementAPI.BanPubKey = func(ctx context.Context, pubkey string, reason string) error { return nil }
+	relay.ManagementAPI.ListAllowedPubKeys = func(ctx context.Context) ([]nip86.PubKeyReason, error) { return nil, nil }
+
+	enableArchiveMode(relay)
+
+	if len(relay.StoreEvent) != 0 {
+		t.Fatal("expected the store hooks to be removed")
+	}
+	if reject, msg := relay.RejectEvent[0](context.Background(), &nostr.Event{}); !reject || msg != "blocked: this relay is a read-only archive" {
+		t.Fatalf("expected events to be rejected, got %v %q", reject, msg)
+	}
+
+	methods := supportedManagementMethods(relay)
+	if !slices.Equal(methods, []string{"supportedmethods", "listallowedpubkeys"}) {
+		t.Fatalf("expected only read-only methods, got %v", methods)
+	}
+}
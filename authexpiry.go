@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// expiringSession is what sessionExpiry knows about one authenticated connection.
+type expiringSession struct {
+	pubkey        string
+	deadline      time.Time
+	timer         *time.Timer
+	subscriptions map[string]<-chan struct{}
+}
+
+// sessionExpiry makes authentication last at most maxAge (AUTH_SESSION_MAX_AGE). Once a
+// session is older than that, the connection is unauthenticated again: its subscriptions are
+// closed with "auth-required: session expired", it gets a fresh AUTH challenge and its next
+// REQ, COUNT or EVENT has to wait for a new AUTH.
+//
+// The auth time comes from authTimes, so a session never outlives maxAge but may expire
+// slightly early. Besides the checks on every REQ, COUNT and EVENT, a timer expires the session
+// at its deadline so that an idle connection can't keep its subscriptions forever.
+type sessionExpiry struct {
+	maxAge time.Duration
+	times  *authTimes
+	// reauth closes the open subscriptions of an expired session and, with requestAuth, sends
+	// a new AUTH challenge; otherwise the rejection that follows makes khatru send it
+	reauth func(ctx context.Context, ws *khatru.WebSocket, subscriptions []string, requestAuth bool)
+
+	mu       sync.Mutex
+	sessions map[*khatru.WebSocket]*expiringSession
+}
+
+func newSessionExpiry(relay *khatru.Relay, maxAge time.Duration) *sessionExpiry {
+	return &sessionExpiry{
+		maxAge: maxAge,
+		times:  newAuthTimes(),
+		reauth: func(ctx context.Context, ws *khatru.WebSocket, subscriptions []string, requestAuth bool) {
+			for _, id := range subscriptions {
+				closeSubscription(relay, ws, id)
+				ws.WriteJSON(nostr.ClosedEnvelope{SubscriptionID: id, Reason: "auth-required: session expired"})
+			}
+			if requestAuth {
+				khatru.RequestAuth(ctx)
+			}
+		},
+		sessions: make(map[*khatru.WebSocket]*expiringSession),
+	}
+}
+
+func (e *sessionExpiry) onConnect(ctx context.Context) {
+	e.times.onConnect(ctx)
+}
+
+func (e *sessionExpiry) onDisconnect(ctx context.Context) {
+	e.times.onDisconnect(ctx)
+	ws := getConnection(ctx)
+	if ws == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if session, ok := e.sessions[ws]; ok && session.timer != nil {
+		session.timer.Stop()
+	}
+	delete(e.sessions, ws)
+}
+
+// check expires the session behind ctx if it is older than maxAge and reports whether it did.
+// Otherwise it makes sure a timer expires the session at its deadline.
+func (e *sessionExpiry) check(ctx context.Context) bool {
+	ws := getConnection(ctx)
+	authedAt, ok := e.times.observe(ctx)
+	if ws == nil || !ok {
+		return false
+	}
+	pubkey := ws.AuthedPublicKey
+	deadline := authedAt.Add(e.maxAge)
+	now := e.times.now()
+	if !now.Before(deadline) {
+		e.expire(ctx, ws, pubkey, false)
+		return true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	session := e.session(ws, pubkey)
+	if session.timer == nil || !session.deadline.Equal(deadline) {
+		if session.timer != nil {
+			session.timer.Stop()
+		}
+		session.deadline = deadline
+		session.timer = time.AfterFunc(deadline.Sub(now), func() { e.expire(ctx, ws, pubkey, true) })
+	}
+	return false
+}
+
+// session returns the state of ws as a session of pubkey, starting over if it authenticated
+// again as someone else. The caller must hold e.mu.
+func (e *sessionExpiry) session(ws *khatru.WebSocket, pubkey string) *expiringSession {
+	session, ok := e.sessions[ws]
+	if !ok || session.pubkey != pubkey {
+		if ok && session.timer != nil {
+			session.timer.Stop()
+		}
+		session = &expiringSession{pubkey: pubkey, subscriptions: make(map[string]<-chan struct{})}
+		e.sessions[ws] = session
+	}
+	return session
+}
+
+// expire unauthenticates ws, unless it has authenticated as someone else in the meantime.
+func (e *sessionExpiry) expire(ctx context.Context, ws *khatru.WebSocket, pubkey string, requestAuth bool) {
+	e.mu.Lock()
+	if ws.AuthedPublicKey != pubkey {
+		e.mu.Unlock()
+		return
+	}
+	var open []string
+	if session, ok := e.sessions[ws]; ok {
+		if session.timer != nil {
+			session.timer.Stop()
+		}
+		for id, done := range session.subscriptions {
+			select {
+			case <-done:
+			default:
+				open = append(open, id)
+			}
+		}
+		delete(e.sessions, ws)
+	}
+	// a new challenge, so the AUTH event of the expired session can't be sent again
+	challenge := make([]byte, 8)
+	rand.Read(challenge)
+	ws.Challenge = hex.EncodeToString(challenge)
+	ws.AuthedPublicKey = ""
+	e.times.restart(ws)
+	e.mu.Unlock()
+
+	log.Printf("Session of %s expired after %s, asking it to authenticate again", pubkey, e.maxAge)
+	e.reauth(ctx, ws, open, requestAuth)
+}
+
+// overwriteFilter is an OverwriteFilter hook because khatru runs those first for every filter,
+// including limit:0 ones that skip the reject hooks. An expired session is unauthenticated
+// here, so the auth policies that follow ask it to authenticate again.
+func (e *sessionExpiry) overwriteFilter(ctx context.Context, filter *nostr.Filter) {
+	if e.check(ctx) || eventstore.IsNegentropySession(ctx) {
+		return
+	}
+	ws := getConnection(ctx)
+	id := khatru.GetSubscriptionID(ctx)
+	if ws == nil || ws.AuthedPublicKey == "" || id == "" {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.session(ws, ws.AuthedPublicKey).subscriptions[id] = ctx.Done()
+}
+
+func (e *sessionExpiry) rejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if e.check(ctx) {
+		return true, "auth-required: session expired"
+	}
+	return false, ""
+}
+
+func (e *sessionExpiry) rejectFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	if e.check(ctx) {
+		return true, "auth-required: session expired"
+	}
+	return false, ""
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSessionExpiresAfterMaxAge(t *testing.T) {
+	ws := &khatru.WebSocket{Challenge: "first"}
+	withConnection(t, ws)
+	ctx := context.Background()
+
+	now := time.Unix(1_700_000_000, 0)
+	expiry := newSessionExpiry(nil, time.Hour)
+	expiry.times.now = func() time.Time { return now }
+	reauths := 0
+	expiry.reauth = func(ctx context.Context, ws *khatru.WebSocket, subscriptions []string, requestAuth bool) { reauths++ }
+	expiry.onConnect(ctx)
+	defer expiry.onDisconnect(ctx)
+
+	// the REQ that made the client authenticate
+	expiry.rejectFilter(ctx, nostr.Filter{})
+	ws.AuthedPublicKey = "alice"
+
+	now = now.Add(59 * time.Minute)
+	if reject, msg := expiry.rejectEvent(ctx, &nostr.Event{}); reject {
+		t.Fatalf("expected the session to be valid within the max age, got %s", msg)
+	}
+
+	now = now.Add(time.Minute)
+	if reject, msg := expiry.rejectFilter(ctx, nostr.Filter{}); !reject || msg != "auth-required: session expired" {
+		t.Fatalf("expected the session to expire after the max age, got %v %q", reject, msg)
+	}
+	if ws.AuthedPublicKey != "" || ws.Challenge == "first" || reauths != 1 {
+		t.Fatalf("expected the connection to be unauthenticated with a new challenge, got %q %q", ws.AuthedPublicKey, ws.Challenge)
+	}
+
+	// authenticating again starts a new session
+	now = now.Add(time.Second)
+	ws.AuthedPublicKey = "alice"
+	now = now.Add(59 * time.Minute)
+	if reject, msg := expiry.rejectEvent(ctx, &nostr.Event{}); reject {
+		t.Fatalf("expected the new session to be valid, got %s", msg)
+	}
+	now = now.Add(time.Minute)
+	if reject, _ := expiry.rejectEvent(ctx, &nostr.Event{}); !reject {
+		t.Fatalf("expected the new session to expire in turn")
+	}
+
+	// events added by the relay itself have no connection
+	withConnection(t, nil)
+	if reject, msg := expiry.rejectEvent(ctx, &nostr.Event{}); reject {
+		t.Errorf("expected internal events to pass, got %s", msg)
+	}
+}
+
+func TestIdleSessionExpires(t *testing.T) {
+	relay := khatru.NewRelay()
+	expiry := newSessionExpiry(relay, 300*time.Millisecond)
+	relay.OnConnect = append(relay.OnConnect, expiry.onConnect)
+	relay.OnDisconnect = append(relay.OnDisconnect, expiry.onDisconnect)
+	relay.OverwriteFilter = append(relay.OverwriteFilter, expiry.overwriteFilter)
+	relay.RejectFilter = append(relay.RejectFilter, expiry.rejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if khatru.GetAuthed(ctx) == "" {
+			return true, "auth-required: please authenticate"
+		}
+		return false, ""
+	})
+	sk, _ := newKeypair(t)
+
+	conn := dialRelay(t, relay)
+	authenticate(t, conn, sk)
+	if reply := sendReq(t, conn, "feed", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected the subscription to be open, got %v", reply)
+	}
+
+	// the session outlives the max age without sending anything
+	if message := readEnvelope(t, conn); message[0] != "CLOSED" || message[1] != "feed" || message[2] != "auth-required: session expired" {
+		t.Fatalf("expected the subscription to be closed, got %v", message)
+	}
+	if message := readEnvelope(t, conn); message[0] != "AUTH" {
+		t.Fatalf("expected a new AUTH challenge, got %v", message)
+	}
+	if reply := sendReq(t, conn, "again", `{"kinds":[1]}`); reply[0] != "AUTH" {
+		t.Fatalf("expected the expired session to be asked to authenticate, got %v", reply)
+	}
+	if reply := readEnvelope(t, conn); reply[0] != "CLOSED" || reply[2] != "auth-required: please authenticate" {
+		t.Fatalf("expected the expired session to be unauthenticated, got %v", reply)
+	}
+
+	authenticate(t, conn, sk)
+	if reply := sendReq(t, conn, "feed", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected a new AUTH to start a new session, got %v", reply)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/fiatjaf/khatru"
+)
+
+// authChallengeLimiter caps the connections per IP that have been sent an AUTH challenge and
+// not answered it yet. khatru sends a challenge whenever a policy answers "auth-required: ...",
+// so beyond the cap such answers are replaced with a plain refusal and no challenge is issued.
+type authChallengeLimiter struct {
+	max     int
+	trusted []*net.IPNet
+
+	mu      sync.Mutex
+	pending map[string]map[*khatru.WebSocket]bool // by client IP
+}
+
+func newAuthChallengeLimiter(max int, trusted []*net.IPNet) *authChallengeLimiter {
+	return &authChallengeLimiter{
+		max:     max,
+		trusted: trusted,
+		pending: make(map[string]map[*khatru.WebSocket]bool),
+	}
+}
+
+// admit reports whether the connection behind ctx may be sent a challenge, and counts it as
+// pending if so. Connections that authenticated since are no longer counted.
+func (l *authChallengeLimiter) admit(ctx context.Context) bool {
+	ws := getConnection(ctx)
+	if ws == nil || ws.Request == nil {
+		return true
+	}
+	ip := clientIP(ws.Request, l.trusted).String()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	challenged := l.pending[ip]
+	for other := range challenged {
+		if other.AuthedPublicKey != "" {
+			delete(challenged, other)
+		}
+	}
+	if challenged[ws] {
+		return true
+	}
+	if len(challenged) >= l.max {
+		return false
+	}
+	if challenged == nil {
+		challenged = make(map[*khatru.WebSocket]bool)
+		l.pending[ip] = challenged
+	}
+	challenged[ws] = true
+	return true
+}
+
+func (l *authChallengeLimiter) onDisconnect(ctx context.Context) {
+	ws := getConnection(ctx)
+	if ws == nil || ws.Request == nil {
+		return
+	}
+	ip := clientIP(ws.Request, l.trusted).String()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.pending[ip], ws)
+	if len(l.pending[ip]) == 0 {
+		delete(l.pending, ip)
+	}
+}
+
+// limitAuthChallenges wraps event or filter policies so their auth-required answers go through
+// the limiter. It has to be applied after the last policy is added.
+func limitAuthChallenges[T any](l *authChallengeLimiter, policies []func(context.Context, T) (bool, string)) []func(context.Context, T) (bool, string) {
+	wrapped := make([]func(context.Context, T) (bool, string), len(policies))
+	for i, policy := range policies {
+		wrapped[i] = func(ctx context.Context, value T) (bool, string) {
+			reject, msg := policy(ctx, value)
+			if reject && strings.HasPrefix(msg, "auth-required:") && !l.admit(ctx) {
+				return true, "rate-limited: too many pending authentication challenges from your address"
+			}
+			return reject, msg
+		}
+	}
+	return wrapped
+}
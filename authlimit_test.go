@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPendingAuthChallengesPerIP(t *testing.T) {
+	relay := khatru.NewRelay()
+	relay.RejectFilter = append(relay.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if khatru.GetAuthed(ctx) == "" {
+			return true, "auth-required: please authenticate"
+		}
+		return false, ""
+	})
+	challenges := newAuthChallengeLimiter(1, nil)
+	relay.RejectFilter = limitAuthChallenges(challenges, relay.RejectFilter)
+	relay.OnDisconnect = append(relay.OnDisconnect, challenges.onDisconnect)
+
+	request := func(conn *websocket.Conn) (challenge string, closed []any) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`["REQ","sub",{"kinds":[1]}]`)); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+		for {
+			message := readEnvelope(t, conn)
+			switch message[0] {
+			case "AUTH":
+				challenge = message[1].(string)
+			case "CLOSED":
+				return challenge, message
+			}
+		}
+	}
+
+	// the first connection from this address gets a challenge
+	first := dialRelay(t, relay)
+	challenge, closed := request(first)
+	if challenge == "" || closed[2] != "auth-required: please authenticate" {
+		t.Fatalf("expected a challenge, got %q %v", challenge, closed)
+	}
+
+	// a second one is refused without a challenge while the first is pending
+	second := dialRelay(t, relay)
+	if challenge, closed := request(second); challenge != "" || closed[2] != "rate-limited: too many pending authentication challenges from your address" {
+		t.Fatalf("expected no challenge, got %q %v", challenge, closed)
+	}
+
+	// once the first connection answers, its challenge no longer counts
+	sk, _ := newKeypair(t)
+	auth := nostr.Event{
+		Kind:      nostr.KindClientAuthentication,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"relay", "ws://" + first.RemoteAddr().String()}, {"challenge", challenge}},
+	}
+	if err := auth.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if err := first.WriteJSON(nostr.AuthEnvelope{Event: auth}); err != nil {
+		t.Fatalf("failed to send AUTH: %v", err)
+	}
+	if message := readEnvelope(t, first); message[0] != "OK" || message[2] != true {
+		t.Fatalf("expected AUTH to succeed, got %v", message)
+	}
+	if challenge, closed := request(second); challenge == "" || closed[2] != "auth-required: please authenticate" {
+		t.Fatalf("expected a challenge now, got %q %v", challenge, closed)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// latestEventLookup returns the created_at of the newest stored event by pubkey, or 0 if none.
+type latestEventLookup func(ctx context.Context, pubkey string) (nostr.Timestamp, error)
+
+// storedLatestEvent looks the newest event of a pubkey up in the event table.
+func storedLatestEvent(db *sql.DB) latestEventLookup {
+	return func(ctx context.Context, pubkey string) (nostr.Timestamp, error) {
+		var latest sql.NullInt64
+		if err := db.QueryRowContext(ctx, `SELECT MAX(created_at) FROM event WHERE pubkey = $1`, pubkey).Scan(&latest); err != nil {
+			return 0, fmt.Errorf("failed to look up latest event of %s: %w", pubkey, err)
+		}
+		return nostr.Timestamp(latest.Int64), nil
+	}
+}
+
+// backdatingGuard rejects events that are older than their author's newest stored event by
+// more than slack. The newest created_at per pubkey is cached and kept current on every save.
+type backdatingGuard struct {
+	mu          sync.Mutex
+	latest      map[string]nostr.Timestamp
+	lookup      latestEventLookup
+	slack       nostr.Timestamp
+	ownerPubKey string
+}
+
+func newBackdatingGuard(lookup latestEventLookup, slack time.Duration, ownerPubKey string) *backdatingGuard {
+	return &backdatingGuard{
+		latest:      make(map[string]nostr.Timestamp),
+		lookup:      lookup,
+		slack:       nostr.Timestamp(slack.Seconds()),
+		ownerPubKey: ownerPubKey,
+	}
+}
+
+// reject is the RejectEvent policy.
+func (g *backdatingGuard) reject(ctx context.Context, event *nostr.Event) (bool, string) {
+	if event.PubKey == g.ownerPubKey || isGiftWrap(event) {
+		return false, ""
+	}
+
+	g.mu.Lock()
+	latest, cached := g.latest[event.PubKey]
+	g.mu.Unlock()
+
+	if !cached {
+		var err error
+		latest, err = g.lookup(ctx, event.PubKey)
+		if err != nil {
+			log.Printf("Error checking for backdated event: %v", err)
+			return false, "" // don't block writes on a failed lookup
+		}
+		g.remember(event.PubKey, latest)
+	}
+
+	if latest > 0 && event.CreatedAt+g.slack < latest {
+		return true, "invalid: older than your latest event"
+	}
+	return false, ""
+}
+
+// onSaved is the OnEventSaved hook keeping the cache current.
+func (g *backdatingGuard) onSaved(ctx context.Context, event *nostr.Event) {
+	g.remember(event.PubKey, event.CreatedAt)
+}
+
+func (g *backdatingGuard) remember(pubkey string, createdAt nostr.Timestamp) {
+	g.mu.Lock()
+	if current, ok := g.latest[pubkey]; !ok || createdAt > current {
+		g.latest[pubkey] = createdAt
+	}
+	g.mu.Unlock()
+}
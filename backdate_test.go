@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBackdatingGuard(t *testing.T) {
+	_, author := newKeypair(t)
+	_, owner := newKeypair(t)
+	lookups := 0
+	guard := newBackdatingGuard(func(ctx context.Context, pubkey string) (nostr.Timestamp, error) {
+		lookups++
+		return 10_000, nil
+	}, time.Hour, owner)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		event     *nostr.Event
+		rejected  bool
+		saveAfter bool
+	}{
+		{"newer than the latest", &nostr.Event{PubKey: author, CreatedAt: 10_500}, false, true},
+		{"within the slack", &nostr.Event{PubKey: author, CreatedAt: 10_500 - 3600}, false, false},
+		{"backdated beyond the slack", &nostr.Event{PubKey: author, CreatedAt: 10_500 - 3601}, true, false},
+		{"owner is exempt", &nostr.Event{PubKey: owner, CreatedAt: 1}, false, false},
+		{"gift wraps are exempt", &nostr.Event{PubKey: author, Kind: nostr.KindGiftWrap, CreatedAt: 1}, false, false},
+	}
+
+	for _, tt := range tests {
+		rejected, msg := guard.reject(ctx, tt.event)
+		if rejected != tt.rejected {
+			t.Fatalf("%s: expected rejected=%v, got %v %q", tt.name, tt.rejected, rejected, msg)
+		}
+		if rejected && msg != "invalid: older than your latest event" {
+			t.Fatalf("%s: unexpected message %q", tt.name, msg)
+		}
+		if tt.saveAfter {
+			guard.onSaved(ctx, tt.event)
+		}
+	}
+
+	if lookups != 1 {
+		t.Fatalf("expected the stored latest event to be looked up once, got %d", lookups)
+	}
+}
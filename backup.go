@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// snapshotParts are the files of a snapshot, each the NDJSON output of a query returning one
+// JSON document per row: the allowlist tables as they are, and the events as NIP-01 JSON.
+var snapshotParts = []struct {
+	name  string
+	query string
+}{
+	{"allowed_pubkeys.ndjson", `SELECT row_to_json(t)::text FROM allowed_pubkeys t ORDER BY pubkey`},
+	{"moderators.ndjson", `SELECT row_to_json(t)::text FROM moderators t ORDER BY pubkey`},
+	{"policy_exemptions.ndjson", `SELECT row_to_json(t)::text FROM policy_exemptions t ORDER BY pubkey, policy`},
+	{"pinned_events.ndjson", `SELECT row_to_json(t)::text FROM pinned_events t ORDER BY event_id`},
+	{"events.ndjson", `
+	SELECT json_build_object('id', id, 'pubkey', pubkey, 'created_at', created_at, 'kind', kind,
+		'tags', tags, 'content', content, 'sig', sig)::text
+	FROM event ORDER BY created_at, id`},
+}
+
+// writeSnapshot writes a gzipped tar of the snapshot parts to w. Every part is spooled to a
+// temporary file first, since a tar header needs the size of what follows.
+func writeSnapshot(ctx context.Context, db *sql.DB, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	now := time.Now()
+	for _, part := range snapshotParts {
+		spool, err := os.CreateTemp("", "brove-snapshot-*.ndjson")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		err = func() error {
+			defer os.Remove(spool.Name())
+			defer spool.Close()
+
+			size, err := dumpRows(ctx, db, part.query, spool)
+			if err != nil {
+				return fmt.Errorf("failed to dump %s: %w", part.name, err)
+			}
+			if _, err := spool.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if err := archive.WriteHeader(&tar.Header{Name: part.name, Mode: 0o644, Size: size, ModTime: now}); err != nil {
+				return err
+			}
+			_, err = io.Copy(archive, spool)
+			return err
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// dumpRows writes the single text column of every row of query as a line to w.
+func dumpRows(ctx context.Context, db *sql.DB, query string, w io.Writer) (int64, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	out := bufio.NewWriter(w)
+	var size int64
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return 0, err
+		}
+		n, _ := out.WriteString(line + "\n")
+		size += int64(n)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return size, out.Flush()
+}
+
+// backupStore is where backups are kept; s3Client implements it.
+type backupStore interface {
+	put(ctx context.Context, key string, body io.Reader, size int64, payloadHash string) error
+	list(ctx context.Context, prefix string) ([]string, error)
+	delete(ctx context.Context, key string) error
+}
+
+const (
+	backupKeyPrefix = "brove-"
+	backupKeySuffix = ".tar.gz"
+	// backupTimeLayout sorts in time order, so listing the keys lists the backups oldest first
+	backupTimeLayout = "20060102T150405Z"
+	// backupUploadAttempts is how often an upload is tried before the backup is given up
+	backupUploadAttempts = 4
+)
+
+// backupJob periodically takes a snapshot, uploads it and deletes all but the latest keep
+// backups.
+type backupJob struct {
+	snapshot func(ctx context.Context, w io.Writer) error
+	store    backupStore
+	prefix   string
+	keep     int
+	// retryDelay is the wait before the second upload attempt, doubling for each one after
+	retryDelay time.Duration
+}
+
+func (j *backupJob) key(at time.Time) string {
+	return j.prefix + backupKeyPrefix + at.UTC().Format(backupTimeLayout) + backupKeySuffix
+}
+
+// backups returns the keys of the stored backups, oldest first.
+func (j *backupJob) backups(ctx context.Context) ([]string, error) {
+	keys, err := j.store.list(ctx, j.prefix+backupKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	backups := keys[:0]
+	for _, key := range keys {
+		if strings.HasSuffix(key, backupKeySuffix) {
+			backups = append(backups, key)
+		}
+	}
+	return backups, nil
+}
+
+// run takes and uploads one backup, then applies the retention.
+func (j *backupJob) run(ctx context.Context, now time.Time) error {
+	file, err := os.CreateTemp("", "brove-backup-*"+backupKeySuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	if err := j.snapshot(ctx, io.MultiWriter(file, hash)); err != nil {
+		return fmt.Errorf("failed to take snapshot: %w", err)
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	payloadHash := hex.EncodeToString(hash.Sum(nil))
+
+	key := j.key(now)
+	delay := j.retryDelay
+	for attempt := 1; ; attempt++ {
+		err = j.store.put(ctx, key, io.NewSectionReader(file, 0, size), size, payloadHash)
+		if err == nil {
+			break
+		}
+		if attempt == backupUploadAttempts {
+			return fmt.Errorf("failed to upload %s after %d attempts: %w", key, attempt, err)
+		}
+		log.Printf("Backup upload attempt %d failed, retrying in %s: %v", attempt, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	log.Printf("Backup uploaded to %s (%d bytes)", key, size)
+
+	backups, err := j.backups(ctx)
+	if err != nil {
+		return err
+	}
+	for len(backups) > j.keep {
+		if err := j.store.delete(ctx, backups[0]); err != nil {
+			return fmt.Errorf("failed to delete old backup %s: %w", backups[0], err)
+		}
+		log.Printf("Deleted old backup %s", backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// start backs up every interval until ctx is done. The first backup is due an interval after
+// the latest stored one, so restarts don't postpone backups, nor take one each time.
+func (j *backupJob) start(ctx context.Context, interval time.Duration) {
+	go func() {
+		next := time.Now()
+		if backups, err := j.backups(ctx); err != nil {
+			log.Printf("Backup: %v", err)
+		} else if len(backups) > 0 {
+			latest := strings.TrimSuffix(strings.TrimPrefix(backups[len(backups)-1], j.prefix+backupKeyPrefix), backupKeySuffix)
+			if at, err := time.Parse(backupTimeLayout, latest); err == nil {
+				next = at.Add(interval)
+			}
+		}
+
+		for {
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			now := time.Now()
+			if err := j.run(ctx, now); err != nil {
+				log.Printf("Backup failed: %v", err)
+			}
+			next = now.Add(interval)
+		}
+	}()
+}
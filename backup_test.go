@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryBackups is a backupStore failing the first failPuts uploads.
+type memoryBackups struct {
+	objects  map[string]string
+	failPuts int
+	puts     int
+}
+
+func (m *memoryBackups) put(ctx context.Context, key string, body io.Reader, size int64, payloadHash string) error {
+	m.puts++
+	if m.puts <= m.failPuts {
+		// a failed attempt may have read part of the body already
+		io.CopyN(io.Discard, body, 2)
+		return errors.New("connection reset")
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return errors.New("short body")
+	}
+	m.objects[key] = string(data)
+	return nil
+}
+
+func (m *memoryBackups) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memoryBackups) delete(ctx context.Context, key string) error {
+	delete(m.objects, key)
+	return nil
+}
+
+func TestBackupJobRetriesAndKeepsLatest(t *testing.T) {
+	store := &memoryBackups{objects: map[string]string{"brove/notes.txt": "unrelated"}, failPuts: 2}
+	job := &backupJob{
+		snapshot: func(ctx context.Context, w io.Writer) error {
+			_, err := io.WriteString(w, "snapshot")
+			return err
+		},
+		store:      store,
+		prefix:     "brove/",
+		keep:       2,
+		retryDelay: time.Millisecond,
+	}
+
+	start := time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC)
+	for day := 0; day < 3; day++ {
+		if err := job.run(context.Background(), start.AddDate(0, 0, day)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if store.puts != 5 {
+		t.Errorf("expected 2 failed and 3 successful uploads, got %d uploads", store.puts)
+	}
+	keys, _ := store.list(context.Background(), "")
+	want := []string{"brove/brove-20261002T030000Z.tar.gz", "brove/brove-20261003T030000Z.tar.gz", "brove/notes.txt"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("expected %v, got %v", want, keys)
+	}
+	if store.objects[want[0]] != "snapshot" {
+		t.Errorf("expected the whole snapshot to be uploaded, got %q", store.objects[want[0]])
+	}
+}
+
+func TestBackupJobGivesUp(t *testing.T) {
+	store := &memoryBackups{objects: map[string]string{}, failPuts: backupUploadAttempts}
+	job := &backupJob{
+		snapshot:   func(ctx context.Context, w io.Writer) error { return nil },
+		store:      store,
+		keep:       1,
+		retryDelay: time.Millisecond,
+	}
+	err := job.run(context.Background(), time.Now())
+	if err == nil || !strings.Contains(err.Error(), "after 4 attempts") {
+		t.Errorf("expected the upload to be given up, got %v", err)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("expected nothing to be stored, got %v", store.objects)
+	}
+
+	job.snapshot = func(ctx context.Context, w io.Writer) error { return errors.New("database down") }
+	if err := job.run(context.Background(), time.Now()); err == nil || !strings.Contains(err.Error(), "database down") {
+		t.Errorf("expected the snapshot error, got %v", err)
+	}
+}
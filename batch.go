@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// pendingWrite is an event waiting in a batch, with the channel its SaveEvent call waits on.
+type pendingWrite struct {
+	event *nostr.Event
+	done  chan error
+}
+
+// batchWriter groups concurrent SaveEvent calls and writes them together. Each caller only
+// returns once its batch has been flushed, so the OK sent to the client still means "stored".
+type batchWriter struct {
+	queue    chan *pendingWrite
+	size     int
+	interval time.Duration
+	flush    func(ctx context.Context, batch []*pendingWrite)
+
+	// stopped is set once shutdown began, senders counts the callers handing over a write
+	mu      sync.RWMutex
+	stopped bool
+	senders sync.WaitGroup
+}
+
+// newBatchWriter starts a writer that flushes once size events are queued or interval has passed
+// since the first one, whichever comes first. When ctx is cancelled, new writes are refused and
+// every write already handed over is flushed.
+func newBatchWriter(ctx context.Context, size int, interval time.Duration, flush func(ctx context.Context, batch []*pendingWrite)) *batchWriter {
+	b := &batchWriter{
+		queue:    make(chan *pendingWrite, size),
+		size:     size,
+		interval: interval,
+		flush:    flush,
+	}
+	go b.run(ctx)
+	return b
+}
+
+func (b *batchWriter) run(ctx context.Context) {
+	for {
+		var batch []*pendingWrite
+		select {
+		case <-ctx.Done():
+			b.drain(ctx)
+			return
+		case write := <-b.queue:
+			batch = append(batch, write)
+		}
+
+		timer := time.NewTimer(b.interval)
+	collect:
+		for len(batch) < b.size {
+			select {
+			case write := <-b.queue:
+				batch = append(batch, write)
+			case <-timer.C:
+				break collect
+			case <-ctx.Done():
+				break collect
+			}
+		}
+		timer.Stop()
+
+		// flushing must finish even during shutdown, callers are waiting on it
+		b.flush(context.WithoutCancel(ctx), batch)
+	}
+}
+
+// drain refuses new writes and flushes those still queued, including the ones of callers in the
+// middle of handing theirs over, so that no caller is left waiting for a batch that never comes.
+func (b *batchWriter) drain(ctx context.Context) {
+	b.mu.Lock()
+	b.stopped = true
+	b.mu.Unlock()
+
+	handedOver := make(chan struct{})
+	go func() {
+		b.senders.Wait()
+		close(handedOver)
+	}()
+	var pending []*pendingWrite
+	for waiting := true; waiting; {
+		select {
+		case write := <-b.queue:
+			pending = append(pending, write)
+		case <-handedOver:
+			waiting = false
+		}
+	}
+	for len(b.queue) > 0 {
+		pending = append(pending, <-b.queue)
+	}
+
+	for batch := range slices.Chunk(pending, b.size) {
+		b.flush(context.WithoutCancel(ctx), batch)
+	}
+}
+
+// SaveEvent queues the event and waits until its batch is written.
+func (b *batchWriter) SaveEvent(ctx context.Context, event *nostr.Event) error {
+	b.mu.RLock()
+	if b.stopped {
+		b.mu.RUnlock()
+		return errors.New("error: relay is shutting down")
+	}
+	b.senders.Add(1)
+	b.mu.RUnlock()
+
+	write := &pendingWrite{event: event, done: make(chan error, 1)}
+	select {
+	case b.queue <- write:
+		b.senders.Done()
+	case <-ctx.Done():
+		b.senders.Done()
+		return ctx.Err()
+	}
+	return <-write.done
+}
+
+const insertEventQuery = `INSERT INTO event (id, pubkey, created_at, kind, tags, content, sig)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (id) DO NOTHING`
+
+// flushEvents writes a batch in one transaction, mirroring the eventstore's own insert.
+// If the transaction fails, every event is retried on its own so that one bad event
+// cannot fail the others.
+func flushEvents(db *sql.DB) func(ctx context.Context, batch []*pendingWrite) {
+	return func(ctx context.Context, batch []*pendingWrite) {
+		results, err := insertEventsInTx(ctx, db, batch)
+		if err == nil {
+			for i, write := range batch {
+				write.done <- results[i]
+			}
+			return
+		}
+
+		for _, write := range batch {
+			write.done <- insertEvent(ctx, db.ExecContext, write.event)
+		}
+	}
+}
+
+func insertEventsInTx(ctx context.Context, db *sql.DB, batch []*pendingWrite) ([]error, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin batch transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	results := make([]error, len(batch))
+	for i, write := range batch {
+		err := insertEvent(ctx, tx.ExecContext, write.event)
+		if err != nil && err != eventstore.ErrDupEvent {
+			return nil, err
+		}
+		results[i] = err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit batch transaction: %w", err)
+	}
+	return results, nil
+}
+
+func insertEvent(ctx context.Context, exec func(context.Context, string, ...any) (sql.Result, error), event *nostr.Event) error {
+	tags, _ := json.Marshal(event.Tags)
+	result, err := exec(ctx, insertEventQuery, event.ID, event.PubKey, event.CreatedAt, event.Kind, tags, event.Content, event.Sig)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return eventstore.ErrDupEvent
+	}
+	return nil
+}
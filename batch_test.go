@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBatchWriter(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	errBad := errors.New("bad event")
+	flush := func(ctx context.Context, batch []*pendingWrite) {
+		var ids []string
+		for _, write := range batch {
+			ids = append(ids, write.event.ID)
+		}
+		mu.Lock()
+		batches = append(batches, ids)
+		mu.Unlock()
+
+		for _, write := range batch {
+			if write.event.ID == "bad" {
+				write.done <- errBad
+			} else {
+				write.done <- nil
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := newBatchWriter(ctx, 3, time.Hour, flush)
+
+	// three concurrent writes fill a batch without waiting for the interval
+	var wg sync.WaitGroup
+	results := make(map[string]error)
+	for _, id := range []string{"a", "b", "bad"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			err := writer.SaveEvent(context.Background(), &nostr.Event{ID: id})
+			mu.Lock()
+			results[id] = err
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expected a single batch of three, got %v", batches)
+	}
+	if results["a"] != nil || results["b"] != nil || results["bad"] != errBad {
+		t.Fatalf("expected each caller to get its own result, got %v", results)
+	}
+}
+
+func TestBatchWriterFlushesAfterInterval(t *testing.T) {
+	flushed := make(chan int, 1)
+	writer := newBatchWriter(context.Background(), 100, 10*time.Millisecond, func(ctx context.Context, batch []*pendingWrite) {
+		flushed <- len(batch)
+		for _, write := range batch {
+			write.done <- nil
+		}
+	})
+
+	if err := writer.SaveEvent(context.Background(), &nostr.Event{ID: "lonely"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := <-flushed; n != 1 {
+		t.Fatalf("expected a batch of one, got %d", n)
+	}
+}
+
+func TestBatchWriterFlushesQueuedWritesOnShutdown(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var flushed []string
+	flush := func(ctx context.Context, batch []*pendingWrite) {
+		<-release
+		mu.Lock()
+		for _, write := range batch {
+			flushed = append(flushed, write.event.ID)
+		}
+		mu.Unlock()
+		for _, write := range batch {
+			write.done <- nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := newBatchWriter(ctx, 2, time.Hour, flush)
+	results := make(chan error, 6)
+	save := func(id string) {
+		go func() { results <- writer.SaveEvent(context.Background(), &nostr.Event{ID: id}) }()
+	}
+
+	// the first batch is stuck flushing while the next writes fill the queue
+	save("a")
+	save("b")
+	for len(writer.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	save("c")
+	save("d")
+	for len(writer.queue) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	save("e")
+
+	cancel()
+	close(release)
+	timeout := time.After(5 * time.Second)
+	for range 5 {
+		select {
+		case err := <-results:
+			if err != nil && err.Error() != "error: relay is shutting down" {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-timeout:
+			t.Fatal("expected every caller to get an answer during shutdown")
+		}
+	}
+	mu.Lock()
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if !slices.Contains(flushed, id) {
+			t.Errorf("expected queued write %s to be flushed, got %v", id, flushed)
+		}
+	}
+	mu.Unlock()
+
+	save("late")
+	select {
+	case err := <-results:
+		if err == nil || err.Error() != "error: relay is shutting down" {
+			t.Errorf("expected writes after shutdown to be refused, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a write after shutdown to be refused rather than left waiting")
+	}
+}
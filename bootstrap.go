@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// bootstrapOwner allows the owner on the first start of a fresh database, so features that
+// look the owner up in allowed_pubkeys work before anyone has been added. Once the allowlist
+// has any entry, it does nothing.
+func bootstrapOwner(owner string, allowIfEmpty func(pubkey, reason string) (bool, error)) error {
+	if owner == "" {
+		return fmt.Errorf("RELAY_PUBKEY is not set")
+	}
+	added, err := allowIfEmpty(owner, "bootstrap owner")
+	if err != nil {
+		return err
+	}
+	if added {
+		log.Printf("Bootstrapped the empty allowlist with the relay owner %s", owner)
+	}
+	return nil
+}
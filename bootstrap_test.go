@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestBootstrapOwner(t *testing.T) {
+	allowed := map[string]string{}
+	allowIfEmpty := func(pubkey, reason string) (bool, error) {
+		if len(allowed) > 0 {
+			return false, nil
+		}
+		allowed[pubkey] = reason
+		return true, nil
+	}
+
+	if err := bootstrapOwner("owner", allowIfEmpty); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if allowed["owner"] != "bootstrap owner" {
+		t.Fatalf("expected the owner to be allowed, got %v", allowed)
+	}
+
+	// a no-op once the allowlist has entries
+	delete(allowed, "owner")
+	allowed["alice"] = ""
+	if err := bootstrapOwner("owner", allowIfEmpty); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := allowed["owner"]; ok {
+		t.Fatal("expected no bootstrap into a non-empty allowlist")
+	}
+
+	if err := bootstrapOwner("", allowIfEmpty); err == nil {
+		t.Fatal("expected an error without an owner")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// commands are the CLI subcommands; each returns the process exit code.
+var commands = map[string]func(args []string) int{
+	"sync-allowlist":  runSyncAllowlist,
+	"allow":           runAllow,
+	"deny":            runDeny,
+	"list":            runList,
+	"status":          runStatus,
+	"validate-config": runValidateConfig,
+	"-version":        runVersion,
+	"--version":       runVersion,
+	"version":         runVersion,
+}
+
+// runCommand dispatches to a CLI subcommand.
+// It reports false if args do not name a subcommand, in which case the relay should start.
+func runCommand(args []string) (exitCode int, handled bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return 0, false
+	}
+	return command(args[1:]), true
+}
+
+// runSyncAllowlist copies the allowlist of another relay into the local database:
+//
+//	brove sync-allowlist --from wss://oldrelay --key <admin-nsec>
+func runSyncAllowlist(args []string) int {
+	flags := flag.NewFlagSet("sync-allowlist", flag.ContinueOnError)
+	from := flags.String("from", "", "URL of the relay to import the allowlist from")
+	key := flags.String("key", "", "admin secret key (nsec or hex) for the remote relay's management API")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *from == "" || *key == "" {
+		fmt.Fprintln(os.Stderr, "usage: brove sync-allowlist --from <relay-url> --key <admin-nsec>")
+		return 2
+	}
+
+	secretKey, err := parseSecretKey(*key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	databaseURL, err := cliDatabaseURL()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	dbManager, err := NewDBManager(databaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer dbManager.Close()
+
+	client := newManagementClient(*from, secretKey)
+	added, skipped, err := syncAllowlist(context.Background(), client, dbManager)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if added > 0 {
+			fmt.Fprintf(os.Stderr, "%d pubkeys were imported before the error\n", added)
+		}
+		return 1
+	}
+
+	fmt.Printf("imported %d pubkeys, skipped %d already allowed or invalid\n", added, skipped)
+	return 0
+}
+
+// runAllow adds a pubkey to the allowlist without going through the management API:
+//
+//	brove allow <npub or hex> [reason]
+func runAllow(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: brove allow <pubkey> [reason]")
+		return 2
+	}
+	pubkey, err := parsePublicKey(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+
+	databaseURL, err := cliDatabaseURL()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	dbManager, err := NewDBManager(databaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer dbManager.Close()
+
+	if err := dbManager.AddAllowedPubkey(pubkey, strings.Join(args[1:], " ")); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	// same as allowing through the management API
+	if entryTTL := getEnvDuration("ALLOWLIST_ENTRY_TTL", 0); entryTTL > 0 {
+		if err := dbManager.SetAllowedPubkeyExpiry(pubkey, time.Now().Add(entryTTL)); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+	}
+
+	fmt.Printf("allowed %s\n", pubkey)
+	return 0
+}
+
+// runDeny removes a pubkey from the allowlist:
+//
+//	brove deny <npub or hex>
+func runDeny(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: brove deny <pubkey>")
+		return 2
+	}
+	pubkey, err := parsePublicKey(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+
+	databaseURL, err := cliDatabaseURL()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	dbManager, err := NewDBManager(databaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer dbManager.Close()
+
+	var purge eventPurger
+	if getEnvBool("PURGE_ON_DEALLOW", false) {
+		purge = purgeStoredEvents(dbManager.db)
+	}
+	purged, err := deallowPubkey(context.Background(), pubkey, dbManager.RemoveAllowedPubkey, purge)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("removed %s\n", pubkey)
+	if purge != nil {
+		fmt.Printf("purged %d events\n", purged)
+	}
+	return 0
+}
+
+// runList prints the allowlist, one entry per line:
+//
+//	brove list
+func runList(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: brove list")
+		return 2
+	}
+
+	databaseURL, err := cliDatabaseURL()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 2
+	}
+	dbManager, err := NewDBManager(databaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer dbManager.Close()
+
+	entries, err := dbManager.GetAllowedPubkeyEntries("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PUBKEY\tEXPIRES\tLABELS\tREASON")
+	for _, entry := range entries {
+		expires := "never"
+		if entry.ExpiresAt != nil {
+			expires = entry.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Pubkey, expires, strings.Join(entry.Labels, ","), entry.Reason)
+	}
+	w.Flush()
+
+	fmt.Printf("%d allowed pubkeys\n", len(entries))
+	return 0
+}
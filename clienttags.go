@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// clientTagPolicy judges events by the app that published them, as named in their NIP-89
+// client tag. Names are compared case-insensitively.
+type clientTagPolicy struct {
+	required      []string // when set, only these clients are accepted
+	requiredNames string   // as configured, for the rejection message
+	blocked       []string
+	rejectMissing bool // also reject events without a client tag; implied by required
+}
+
+func newClientTagPolicy(required, blocked []string, rejectMissing bool) *clientTagPolicy {
+	lower := func(names []string) []string {
+		result := make([]string, len(names))
+		for i, name := range names {
+			result[i] = strings.ToLower(name)
+		}
+		return result
+	}
+	return &clientTagPolicy{
+		required:      lower(required),
+		requiredNames: strings.Join(required, ", "),
+		blocked:       lower(blocked),
+		rejectMissing: rejectMissing || len(required) > 0,
+	}
+}
+
+func (p *clientTagPolicy) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	tag := event.Tags.Find("client")
+	if tag == nil || strings.TrimSpace(tag[1]) == "" {
+		if p.rejectMissing {
+			return true, "blocked: events must name their client app in a client tag"
+		}
+		return false, ""
+	}
+
+	client := strings.ToLower(strings.TrimSpace(tag[1]))
+	if slices.Contains(p.blocked, client) {
+		return true, "blocked: events from " + tag[1] + " are not accepted here"
+	}
+	if len(p.required) > 0 && !slices.Contains(p.required, client) {
+		return true, "blocked: only events from " + p.requiredNames + " are accepted here"
+	}
+	return false, ""
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestClientTagPolicy(t *testing.T) {
+	withClient := func(name string) *nostr.Event {
+		return &nostr.Event{Kind: nostr.KindTextNote, Tags: nostr.Tags{{"client", name, "31990:" + fakeID(1) + ":app"}}}
+	}
+	without := &nostr.Event{Kind: nostr.KindTextNote, Tags: nostr.Tags{{"t", "nostr"}}}
+
+	tests := []struct {
+		name   string
+		policy *clientTagPolicy
+		event  *nostr.Event
+		reject bool
+		msg    string
+	}{
+		{"required client", newClientTagPolicy([]string{"OurApp"}, nil, false), withClient("ourapp"), false, ""},
+		{"other client", newClientTagPolicy([]string{"OurApp"}, nil, false), withClient("Damus"), true, "blocked: only events from OurApp are accepted here"},
+		{"required but absent", newClientTagPolicy([]string{"OurApp"}, nil, false), without, true, "blocked: events must name their client app in a client tag"},
+		{"blocked client", newClientTagPolicy(nil, []string{"SpamBot"}, false), withClient("spambot"), true, "blocked: events from spambot are not accepted here"},
+		{"unblocked client", newClientTagPolicy(nil, []string{"SpamBot"}, false), withClient("Amethyst"), false, ""},
+		{"absent is fine by default", newClientTagPolicy(nil, []string{"SpamBot"}, false), without, false, ""},
+		{"absent rejected on request", newClientTagPolicy(nil, nil, true), without, true, "blocked: events must name their client app in a client tag"},
+		{"empty tag counts as absent", newClientTagPolicy(nil, nil, true), withClient(" "), true, "blocked: events must name their client app in a client tag"},
+	}
+	for _, test := range tests {
+		reject, msg := test.policy.reject(context.Background(), test.event)
+		if reject != test.reject || msg != test.msg {
+			t.Errorf("%s: expected %v %q, got %v %q", test.name, test.reject, test.msg, reject, msg)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/nostr+json")
+		io.WriteString(w, `{"name":"brove relay"}`)
+	}))
+
+	t.Run("compresses when accepted", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("expected gzip encoding, got %q", rec.Header().Get("Content-Encoding"))
+		}
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("invalid gzip body: %v", err)
+		}
+		body, _ := io.ReadAll(gz)
+		if string(body) != `{"name":"brove relay"}` {
+			t.Fatalf("unexpected body %q", body)
+		}
+	})
+
+	t.Run("plain without accept-encoding", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("expected no encoding, got %q", rec.Header().Get("Content-Encoding"))
+		}
+		if rec.Body.String() != `{"name":"brove relay"}` {
+			t.Fatalf("unexpected body %q", rec.Body.String())
+		}
+	})
+
+	t.Run("websocket upgrade untouched", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Upgrade", "websocket")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("expected no encoding on websocket upgrade, got %q", rec.Header().Get("Content-Encoding"))
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lookupEnv and environ read the settings. They are the process environment, except while
+// a virtual relay is set up with its own settings (see withEnv).
+var (
+	lookupEnv = os.LookupEnv
+	environ   = os.Environ
+)
+
+// withEnv runs setup with overrides applied over the process environment. Settings are only
+// read while relays are set up, one at a time, so swapping the lookup is safe.
+func withEnv(overrides map[string]string, setup func()) {
+	previousLookup, previousEnviron := lookupEnv, environ
+	defer func() { lookupEnv, environ = previousLookup, previousEnviron }()
+
+	lookupEnv = func(key string) (string, bool) {
+		if value, ok := overrides[key]; ok {
+			return value, true
+		}
+		return previousLookup(key)
+	}
+	environ = func() []string {
+		var entries []string
+		for _, entry := range previousEnviron() {
+			key, _, _ := strings.Cut(entry, "=")
+			if _, overridden := overrides[key]; !overridden {
+				entries = append(entries, entry)
+			}
+		}
+		for key, value := range overrides {
+			entries = append(entries, key+"="+value)
+		}
+		return entries
+	}
+	setup()
+}
+
+func getEnv(key, fallback string) string {
+	if value, exists := lookupEnv(key); exists {
+		return value
+	}
+	return fallback
+}
+
+// getEnvDuration reads a duration (e.g. "90s", "72h") from the environment.
+// Invalid values are logged and the fallback is used instead.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := lookupEnv(key)
+	if !exists || value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s (%q), using default %s: %v", key, value, fallback, err)
+		return fallback
+	}
+	return duration
+}
+
+// getEnvPositiveDuration is like getEnvDuration but also rejects zero and negative values,
+// for durations that drive tickers or timeouts.
+func getEnvPositiveDuration(key string, fallback time.Duration) time.Duration {
+	duration := getEnvDuration(key, fallback)
+	if duration <= 0 {
+		log.Printf("Invalid duration for %s (%s), must be positive, using default %s", key, duration, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// getEnvBool reads a boolean flag ("true", "1", "false", ...) from the environment.
+// Invalid values are logged and the fallback is used instead.
+func getEnvBool(key string, fallback bool) bool {
+	value, exists := lookupEnv(key)
+	if !exists || value == "" {
+		return fallback
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s (%q), using default %t: %v", key, value, fallback, err)
+		return fallback
+	}
+	return enabled
+}
+
+// defaultDatabaseURL matches the postgres service in compose.yaml.
+const defaultDatabaseURL = "postgresql://postgres:postgres@db:5432/khatru-relay?sslmode=disable"
+
+// getEnvInt reads an integer from the environment.
+// Invalid values are logged and the fallback is used instead.
+func getEnvInt(key string, fallback int) int {
+	value, exists := lookupEnv(key)
+	if !exists || value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s (%q), using default %d: %v", key, value, fallback, err)
+		return fallback
+	}
+	return number
+}
+
+// getEnvList reads a comma-separated list from the environment, trimming spaces and empty items.
+func getEnvList(key string, fallback []string) []string {
+	value, exists := lookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvFloat reads a floating point number from the environment.
+// Invalid values are logged and the fallback is used instead.
+func getEnvFloat(key string, fallback float64) float64 {
+	value, exists := lookupEnv(key)
+	if !exists || value == "" {
+		return fallback
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid number for %s (%q), using default %g: %v", key, value, fallback, err)
+		return fallback
+	}
+	return number
+}
+
+// getEnvPositiveInt is like getEnvInt but also rejects zero and negative values.
+func getEnvPositiveInt(key string, fallback int) int {
+	number := getEnvInt(key, fallback)
+	if number <= 0 {
+		log.Printf("Invalid integer for %s (%d), must be positive, using default %d", key, number, fallback)
+		return fallback
+	}
+	return number
+}
+
+// getEnvKinds reads a comma-separated list of event kinds from the environment.
+// Invalid items are logged and skipped.
+func getEnvKinds(key string, fallback []int) []int {
+	items := getEnvList(key, nil)
+	if items == nil {
+		return fallback
+	}
+
+	var kinds []int
+	for _, item := range items {
+		kind, err := strconv.Atoi(item)
+		if err != nil || kind < 0 {
+			log.Printf("Ignoring %q in %s: not a kind number", item, key)
+			continue
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// getConnection is a seam so tests can fake the websocket behind a context.
+var getConnection = khatru.GetConnection
+
+// connectionState is what the tracker knows about one websocket connection.
+type connectionState struct {
+	ws          *khatru.WebSocket
+	ip          string
+	connectedAt time.Time
+	slots       chan struct{}
+
+	inFlight   atomic.Int64
+	waiting    atomic.Int64
+	eventsSent atomic.Int64
+	slowDrops  atomic.Int64
+	rejected   atomic.Int64
+	// buffer bounds the bytes of events read ahead from the store for this connection, nil
+	// when events are not read ahead
+	buffer *byteBudget
+}
+
+func (s *connectionState) releaseBuffer(size int64) {
+	if s.buffer != nil {
+		s.buffer.release(size)
+	}
+}
+
+// connectionUsage is the /admin/connections view of a connection.
+type connectionUsage struct {
+	IP              string    `json:"ip"`
+	AuthedPubkey    string    `json:"authed_pubkey,omitempty"`
+	ConnectedAt     time.Time `json:"connected_at"`
+	InFlightQueries int64     `json:"in_flight_queries"`
+	WaitingQueries  int64     `json:"waiting_queries"`
+	EventsSent      int64     `json:"events_sent"`
+	SlowDrops       int64     `json:"slow_drops"`
+	RejectedQueries int64     `json:"rejected_queries"`
+	BufferedBytes   int64     `json:"buffered_bytes"`
+}
+
+// connectionTracker keeps per-connection budgets so one heavy client can't take over the relay:
+// at most maxQueries queries run at once per connection (extra ones wait for a slot), and a query
+// whose client stops reading for sendTimeout is abandoned instead of piling up.
+//
+// With a non-negative maxWaiting, REQ queries beyond maxWaiting waiting ones are rejected, which
+// khatru reports to the client as a NOTICE before the EOSE. Queries khatru runs on its own while
+// handling an EVENT always wait, since skipping them would skip its deletion checks.
+type connectionTracker struct {
+	mu          sync.Mutex
+	conns       map[*khatru.WebSocket]*connectionState
+	maxQueries  int
+	maxWaiting  int
+	sendTimeout time.Duration
+	trusted     []*net.IPNet
+	// bufferBytes is how many bytes of query results are read ahead per connection, 0 to
+	// stream them straight from the store
+	bufferBytes int64
+}
+
+func newConnectionTracker(maxQueries, maxWaiting int, sendTimeout time.Duration, trusted []*net.IPNet) *connectionTracker {
+	return &connectionTracker{
+		conns:       make(map[*khatru.WebSocket]*connectionState),
+		maxQueries:  maxQueries,
+		maxWaiting:  maxWaiting,
+		sendTimeout: sendTimeout,
+		trusted:     trusted,
+	}
+}
+
+// state returns the state of the connection behind ctx, registering it on first use.
+// Returns nil for internal calls that have no connection.
+func (t *connectionTracker) state(ctx context.Context) *connectionState {
+	ws := getConnection(ctx)
+	if ws == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.conns[ws]
+	if !ok {
+		state = &connectionState{ws: ws, connectedAt: time.Now(), slots: make(chan struct{}, t.maxQueries)}
+		if t.bufferBytes > 0 {
+			state.buffer = newByteBudget(t.bufferBytes)
+		}
+		if ws.Request != nil {
+			state.ip = clientIP(ws.Request, t.trusted).String()
+		}
+		t.conns[ws] = state
+	}
+	return state
+}
+
+// onConnect registers a new connection.
+func (t *connectionTracker) onConnect(ctx context.Context) {
+	t.state(ctx)
+}
+
+// onDisconnect forgets a closed connection.
+func (t *connectionTracker) onDisconnect(ctx context.Context) {
+	if ws := getConnection(ctx); ws != nil {
+		t.mu.Lock()
+		delete(t.conns, ws)
+		t.mu.Unlock()
+	}
+}
+
+// wrapQuery applies the per-connection budgets to a QueryEvents function.
+func (t *connectionTracker) wrapQuery(query func(context.Context, nostr.Filter) (chan *nostr.Event, error)) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		state := t.state(ctx)
+		if state == nil {
+			return query(ctx, filter)
+		}
+
+		select {
+		case state.slots <- struct{}{}:
+		default:
+			if waiting := state.waiting.Add(1); t.maxWaiting >= 0 && waiting > int64(t.maxWaiting) && isSubscriptionQuery(ctx) {
+				state.waiting.Add(-1)
+				state.rejected.Add(1)
+				return nil, errTooManyQueries
+			}
+			select {
+			case state.slots <- struct{}{}:
+				state.waiting.Add(-1)
+			case <-ctx.Done():
+				state.waiting.Add(-1)
+				return nil, ctx.Err()
+			}
+		}
+		state.inFlight.Add(1)
+		release := func() {
+			state.inFlight.Add(-1)
+			<-state.slots
+		}
+
+		events, err := query(ctx, filter)
+		if err != nil || events == nil {
+			release()
+			return events, err
+		}
+
+		out := make(chan *nostr.Event)
+		go func() {
+			defer release()
+			defer close(out)
+			t.forward(ctx, state, t.readAhead(ctx, state, events), out)
+		}()
+		return out, nil
+	}
+}
+
+// bufferedEvent is an event read ahead from the store, with the bytes it holds of the
+// connection's buffer.
+type bufferedEvent struct {
+	event *nostr.Event
+	size  int64
+}
+
+// readAhead reads events from the store ahead of the client, as far as the connection's buffer
+// allows, so the store's cursor is freed early on fast clients and paused on slow ones instead
+// of piling everything up in memory. Without a buffer, events go through one at a time.
+func (t *connectionTracker) readAhead(ctx context.Context, state *connectionState, events chan *nostr.Event) <-chan bufferedEvent {
+	capacity := 0
+	if state.buffer != nil {
+		// the queue is bounded by the buffer's bytes, this only caps its length
+		capacity = queryBufferEvents
+	}
+	buffered := make(chan bufferedEvent, capacity)
+	go func() {
+		defer close(buffered)
+		for event := range events {
+			var size int64
+			if state.buffer != nil {
+				size = eventStorageSize(event) + eventEnvelopeOverhead
+				if !state.buffer.acquire(ctx, size) {
+					continue // drain so the store can finish its query
+				}
+			}
+			select {
+			case buffered <- bufferedEvent{event, size}:
+			case <-ctx.Done():
+				state.releaseBuffer(size)
+			}
+		}
+	}()
+	return buffered
+}
+
+// forward sends the read events to the client. A query whose client has not read the next event
+// for sendTimeout is dropped.
+func (t *connectionTracker) forward(ctx context.Context, state *connectionState, events <-chan bufferedEvent, out chan<- *nostr.Event) {
+	timer := time.NewTimer(t.sendTimeout)
+	defer timer.Stop()
+	dropped := false
+	for b := range events {
+		if dropped {
+			state.releaseBuffer(b.size)
+			continue // drain so the store can finish its query
+		}
+		timer.Reset(t.sendTimeout)
+		select {
+		case out <- b.event:
+			state.eventsSent.Add(1)
+		case <-timer.C:
+			dropped = true
+			state.slowDrops.Add(1)
+		case <-ctx.Done():
+			dropped = true
+		}
+		state.releaseBuffer(b.size)
+	}
+}
+
+var errTooManyQueries = errors.New("rate-limited: too many concurrent queries on this connection, try again later")
+
+// isSubscriptionQuery reports whether ctx belongs to a REQ, which is the only place khatru
+// sets a subscription id.
+func isSubscriptionQuery(ctx context.Context) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	khatru.GetSubscriptionID(ctx)
+	return true
+}
+
+// usage lists the current connections, oldest first.
+func (t *connectionTracker) usage() []connectionUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]connectionUsage, 0, len(t.conns))
+	for _, state := range t.conns {
+		list = append(list, connectionUsage{
+			IP:              state.ip,
+			AuthedPubkey:    state.ws.AuthedPublicKey,
+			ConnectedAt:     state.connectedAt,
+			InFlightQueries: state.inFlight.Load(),
+			WaitingQueries:  state.waiting.Load(),
+			EventsSent:      state.eventsSent.Load(),
+			SlowDrops:       state.slowDrops.Load(),
+			RejectedQueries: state.rejected.Load(),
+			BufferedBytes:   state.buffer.used(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ConnectedAt.Before(list[j].ConnectedAt) })
+	return list
+}
+
+// handleAdminConnections serves GET /admin/connections.
+func handleAdminConnections(tracker *connectionTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tracker.usage())
+	}
+}
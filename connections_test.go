@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// withConnection makes every context look like it belongs to ws.
+func withConnection(t *testing.T, ws *khatru.WebSocket) {
+	t.Helper()
+	previous := getConnection
+	getConnection = func(ctx context.Context) *khatru.WebSocket { return ws }
+	t.Cleanup(func() { getConnection = previous })
+}
+
+// queryOf returns a QueryEvents function yielding n events.
+func queryOf(n int) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		ch := make(chan *nostr.Event, n)
+		for i := 0; i < n; i++ {
+			ch <- &nostr.Event{ID: fakeID(i)}
+		}
+		close(ch)
+		return ch, nil
+	}
+}
+
+func TestConnectionTrackerLimitsConcurrentQueries(t *testing.T) {
+	withConnection(t, &khatru.WebSocket{})
+	tracker := newConnectionTracker(1, -1, time.Minute, nil)
+	query := tracker.wrapQuery(queryOf(2))
+	ctx := context.Background()
+
+	first, err := query(ctx, nostr.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	started := make(chan chan *nostr.Event)
+	go func() {
+		second, _ := query(ctx, nostr.Filter{})
+		started <- second
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("second query should wait while the first one is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if usage := tracker.usage(); len(usage) != 1 || usage[0].InFlightQueries != 1 || usage[0].WaitingQueries != 1 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	for range first {
+	}
+
+	select {
+	case second := <-started:
+		for range second {
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second query should start once the first one is done")
+	}
+	if usage := tracker.usage(); usage[0].EventsSent != 4 {
+		t.Fatalf("expected 4 events sent, got %+v", usage[0])
+	}
+}
+
+func TestConnectionTrackerDropsSlowClients(t *testing.T) {
+	withConnection(t, &khatru.WebSocket{})
+	tracker := newConnectionTracker(1, -1, 10*time.Millisecond, nil)
+
+	events, err := tracker.wrapQuery(queryOf(3))(context.Background(), nostr.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a client that doesn't read gets its query abandoned
+	time.Sleep(50 * time.Millisecond)
+	received := 0
+	for range events {
+		received++
+	}
+
+	usage := tracker.usage()[0]
+	if received != 0 || usage.SlowDrops != 1 || usage.InFlightQueries != 0 {
+		t.Fatalf("expected the query to be dropped, got %d events and %+v", received, usage)
+	}
+}
+
+func TestConnectionTrackerRejectsExcessQueuedREQs(t *testing.T) {
+	tracker := newConnectionTracker(1, 1, time.Minute, nil)
+	release := make(chan struct{})
+	relay := khatru.NewRelay()
+	relay.QueryEvents = append(relay.QueryEvents, tracker.wrapQuery(func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		// a heavy query that holds its slot until released
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return queryOf(0)(ctx, filter)
+	}))
+	conn := dialRelay(t, relay)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`["REQ","`+id+`",{"kinds":[1]}]`)); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+	}
+
+	// one query runs, one waits and the third is rejected right away
+	notice := readEnvelope(t, conn)
+	if notice[0] != "NOTICE" || notice[1] != errTooManyQueries.Error() {
+		t.Fatalf("expected a NOTICE for the excess REQ, got %v", notice)
+	}
+	if eose := readEnvelope(t, conn); eose[0] != "EOSE" {
+		t.Fatalf("expected the rejected REQ to end with EOSE, got %v", eose)
+	}
+	if usage := tracker.usage(); len(usage) != 1 || usage[0].RejectedQueries != 1 || usage[0].InFlightQueries != 1 || usage[0].WaitingQueries != 1 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if eose := readEnvelope(t, conn); eose[0] != "EOSE" {
+			t.Fatalf("expected the other REQs to complete, got %v", eose)
+		}
+	}
+}
+
+func TestConnectionTrackerBuffersBoundedReadAhead(t *testing.T) {
+	withConnection(t, &khatru.WebSocket{})
+	event := func(i int) *nostr.Event { return &nostr.Event{ID: fakeID(i), Content: "0123456789"} }
+	size := eventStorageSize(event(0)) + eventEnvelopeOverhead
+	tracker := newConnectionTracker(1, -1, time.Minute, nil)
+	tracker.bufferBytes = 3 * size
+
+	var read atomic.Int64
+	events, err := tracker.wrapQuery(func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		ch := make(chan *nostr.Event)
+		go func() {
+			defer close(ch)
+			for i := 0; i < 10; i++ {
+				ch <- event(i)
+				read.Add(1)
+			}
+		}()
+		return ch, nil
+	})(context.Background(), nostr.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a client that doesn't read only gets as much read ahead as fits into the buffer; the
+	// store is paused with the next event in hand
+	time.Sleep(50 * time.Millisecond)
+	if usage := tracker.usage()[0]; usage.BufferedBytes != 3*size || read.Load() != 4 {
+		t.Fatalf("expected 3 events buffered and the store paused, got %d bytes and %d events read", usage.BufferedBytes, read.Load())
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	if usage := tracker.usage()[0]; received != 10 || usage.BufferedBytes != 0 || usage.EventsSent != 10 {
+		t.Fatalf("expected all events sent and the buffer empty, got %d and %+v", received, usage)
+	}
+}
+
+func TestByteBudget(t *testing.T) {
+	budget := newByteBudget(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if !budget.acquire(ctx, 25) || budget.used() != 25 {
+		t.Fatal("expected an oversized event to fit into an empty budget")
+	}
+	acquired := make(chan bool)
+	go func() { acquired <- budget.acquire(ctx, 5) }()
+	select {
+	case <-acquired:
+		t.Fatal("expected to wait for room")
+	case <-time.After(20 * time.Millisecond):
+	}
+	budget.release(25)
+	if !<-acquired || budget.used() != 5 {
+		t.Fatal("expected room after the release")
+	}
+
+	go func() { acquired <- budget.acquire(ctx, 6) }()
+	cancel()
+	if <-acquired || budget.used() != 5 {
+		t.Fatal("expected a cancelled wait to take nothing")
+	}
+	if (*byteBudget)(nil).used() != 0 {
+		t.Fatal("expected a nil budget to hold nothing")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type connectionSlotKey struct{}
+
+// connectionLimiter bounds the number of open websocket connections. A connection beyond the
+// limit waits up to waitTimeout for a slot before it gets a 503. Waiters are served in the
+// order they arrived, so a flood of new connections queues up instead of racing for slots.
+type connectionLimiter struct {
+	slots       chan struct{} // nil when unlimited
+	waitTimeout time.Duration
+
+	active   atomic.Int64
+	waiting  atomic.Int64
+	rejected atomic.Int64
+}
+
+func newConnectionLimiter(max int, waitTimeout time.Duration) *connectionLimiter {
+	limiter := &connectionLimiter{waitTimeout: waitTimeout}
+	if max > 0 {
+		limiter.slots = make(chan struct{}, max)
+	}
+	return limiter
+}
+
+// connectionSlot is held by one websocket connection and released exactly once.
+type connectionSlot struct {
+	limiter *connectionLimiter
+	once    sync.Once
+}
+
+func (s *connectionSlot) release() {
+	s.once.Do(func() {
+		s.limiter.active.Add(-1)
+		if s.limiter.slots != nil {
+			<-s.limiter.slots
+		}
+	})
+}
+
+func (l *connectionLimiter) acquire(ctx context.Context) (*connectionSlot, bool) {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.waiting.Add(1)
+			timer := time.NewTimer(l.waitTimeout)
+			defer timer.Stop()
+			select {
+			case l.slots <- struct{}{}:
+				l.waiting.Add(-1)
+			case <-timer.C:
+				l.waiting.Add(-1)
+				l.rejected.Add(1)
+				return nil, false
+			case <-ctx.Done():
+				l.waiting.Add(-1)
+				return nil, false
+			}
+		}
+	}
+	l.active.Add(1)
+	return &connectionSlot{limiter: l}, true
+}
+
+// middleware holds a slot for every websocket upgrade. The slot is released by onDisconnect
+// once the connection closes, or right away if the request never became a websocket.
+func (l *connectionLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		slot, ok := l.acquire(r.Context())
+		if !ok {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "too many connections, try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		hw := &hijackTracker{ResponseWriter: w}
+		next.ServeHTTP(hw, r.WithContext(context.WithValue(r.Context(), connectionSlotKey{}, slot)))
+		if !hw.hijacked {
+			slot.release()
+		}
+	})
+}
+
+func (l *connectionLimiter) onDisconnect(ctx context.Context) {
+	if ws := getConnection(ctx); ws != nil && ws.Request != nil {
+		if slot, ok := ws.Request.Context().Value(connectionSlotKey{}).(*connectionSlot); ok {
+			slot.release()
+		}
+	}
+}
+
+// metrics reports connection utilization in the Prometheus text format.
+func (l *connectionLimiter) metrics(w *bufio.Writer) {
+	fmt.Fprintln(w, "# HELP brove_connections_active Open websocket connections.")
+	fmt.Fprintln(w, "# TYPE brove_connections_active gauge")
+	fmt.Fprintf(w, "brove_connections_active %d\n", l.active.Load())
+	fmt.Fprintln(w, "# HELP brove_connections_limit Maximum number of websocket connections, 0 if unlimited.")
+	fmt.Fprintln(w, "# TYPE brove_connections_limit gauge")
+	fmt.Fprintf(w, "brove_connections_limit %d\n", cap(l.slots))
+	fmt.Fprintln(w, "# HELP brove_connections_waiting Connections waiting for a free slot.")
+	fmt.Fprintln(w, "# TYPE brove_connections_waiting gauge")
+	fmt.Fprintf(w, "brove_connections_waiting %d\n", l.waiting.Load())
+	fmt.Fprintln(w, "# HELP brove_connections_rejected_total Connections refused after waiting for a slot.")
+	fmt.Fprintln(w, "# TYPE brove_connections_rejected_total counter")
+	fmt.Fprintf(w, "brove_connections_rejected_total %d\n", l.rejected.Load())
+}
+
+// hijackTracker records whether the wrapped handler took over the connection.
+type hijackTracker struct {
+	http.ResponseWriter
+	hijacked bool
+}
+
+func (h *hijackTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		h.hijacked = true
+	}
+	return conn, rw, err
+}
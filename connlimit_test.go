@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+)
+
+func TestConnectionLimiter(t *testing.T) {
+	relay := khatru.NewRelay()
+	limiter := newConnectionLimiter(1, 200*time.Millisecond)
+	relay.OnDisconnect = append(relay.OnDisconnect, limiter.onDisconnect)
+	relay.Router().HandleFunc("/metrics", handleMetrics(limiter.metrics))
+	server := httptest.NewServer(limiter.middleware(relay))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	first, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("first connection failed: %v", err)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 beyond the limit, got %v", err)
+	}
+
+	// a connection waiting for a slot gets it once the first one closes
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.Close()
+	}()
+	second, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("expected the waiting connection to get the freed slot: %v", err)
+	}
+	defer second.Close()
+
+	// plain HTTP requests never take a slot
+	metrics, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to get metrics: %v", err)
+	}
+	defer metrics.Body.Close()
+	raw, err := io.ReadAll(metrics.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	body := string(raw)
+	for _, expected := range []string{"brove_connections_active 1\n", "brove_connections_limit 1\n", "brove_connections_rejected_total 1\n"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in metrics:\n%s", expected, body)
+		}
+	}
+}
@@ -2,14 +2,45 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip86"
 )
 
+// Errors returned (wrapped) by DBManager methods, for callers to check with errors.Is.
+// Any other error means the database itself failed and the call may be retried.
+var (
+	// ErrPubkeyNotFound is returned when a pubkey is not in the allowed list or not a moderator.
+	ErrPubkeyNotFound = errors.New("pubkey not found")
+	// ErrPubkeyExists is returned by AddModerator when the pubkey already is a moderator.
+	ErrPubkeyExists = errors.New("pubkey already exists")
+	// ErrInvalidPubkey is returned for pubkeys that are not 64 lowercase hex characters.
+	ErrInvalidPubkey = errors.New("invalid pubkey")
+	// ErrInvalidEventID is returned for event ids that are not 64 hex characters.
+	ErrInvalidEventID = errors.New("invalid event id")
+	// ErrEventNotPinned is returned by UnpinEvent when the event is not pinned.
+	ErrEventNotPinned = errors.New("event not pinned")
+)
+
+// validatePubkey checks that pubkey looks like a hex public key before it is stored.
+func validatePubkey(pubkey string) error {
+	if len(pubkey) != 64 || !isHex(pubkey) {
+		return fmt.Errorf("%w: %q must be 64 lowercase hex characters", ErrInvalidPubkey, pubkey)
+	}
+	return nil
+}
+
 // DBManager handles the normal PostgreSQL connection for non-event data
 type DBManager struct {
 	db *sql.DB
+	// failoverRetries is how often a read that failed on a broken connection is retried,
+	// failoverDelay apart
+	failoverRetries int
+	failoverDelay   time.Duration
 }
 
 // NewDBManager creates a new database manager with the given database URL.
@@ -25,12 +56,21 @@ func NewDBManager(databaseURL string) (*DBManager, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	manager := &DBManager{db: db}
-	if err := manager.initTables(); err != nil {
+	manager, err := newDBManager(db)
+	if err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to initialize database tables: %w", err)
+		return nil, err
 	}
+	return manager, nil
+}
 
+// newDBManager creates a database manager on an open connection pool, such as the event
+// store's, and initializes its tables.
+func newDBManager(db *sql.DB) (*DBManager, error) {
+	manager := &DBManager{db: db, failoverRetries: defaultFailoverRetries, failoverDelay: defaultFailoverDelay}
+	if err := manager.initTables(); err != nil {
+		return nil, fmt.Errorf("failed to initialize database tables: %w", err)
+	}
 	return manager, nil
 }
 
@@ -48,17 +88,86 @@ func (dbm *DBManager) initTables() error {
 		return fmt.Errorf("failed to create allowed_pubkeys table: %w", err)
 	}
 
+	// columns added after the initial schema, safe to run on every startup
+	migrations := []string{
+		`ALTER TABLE allowed_pubkeys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ`,
+		`ALTER TABLE allowed_pubkeys ADD COLUMN IF NOT EXISTS expiry_notified BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE allowed_pubkeys ADD COLUMN IF NOT EXISTS labels TEXT[] NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE allowed_pubkeys ADD COLUMN IF NOT EXISTS member_sync BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE allowed_pubkeys ADD COLUMN IF NOT EXISTS contact TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE allowed_pubkeys ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT ''`,
+	}
+	for _, migration := range migrations {
+		if _, err := dbm.db.Exec(migration); err != nil {
+			return fmt.Errorf("failed to migrate allowed_pubkeys table: %w", err)
+		}
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS moderators (
+		pubkey VARCHAR(64) PRIMARY KEY,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := dbm.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create moderators table: %w", err)
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS relay_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := dbm.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create relay_settings table: %w", err)
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS pubkey_storage (
+		pubkey VARCHAR(64) PRIMARY KEY,
+		used_bytes BIGINT NOT NULL DEFAULT 0,
+		quota_bytes BIGINT
+	);`
+	if _, err := dbm.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create pubkey_storage table: %w", err)
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS policy_exemptions (
+		pubkey VARCHAR(64) NOT NULL,
+		policy TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (pubkey, policy)
+	);`
+	if _, err := dbm.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create policy_exemptions table: %w", err)
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS pinned_events (
+		event_id CHAR(64) PRIMARY KEY,
+		reason TEXT NOT NULL DEFAULT '',
+		pinned_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := dbm.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create pinned_events table: %w", err)
+	}
+
 	return nil
 }
 
 // AddAllowedPubkey adds a pubkey to the allowed list with an optional reason.
-// If the pubkey already exists, the operation is ignored (no error returned).
+// If the pubkey already exists, its reason is kept and any expiry is cleared,
+// so allowing a pubkey again restores permanent access. It also takes the pubkey out of the
+// member sync's hands, so the sync never removes it.
 func (dbm *DBManager) AddAllowedPubkey(pubkey, reason string) error {
-	if pubkey == "" {
-		return fmt.Errorf("pubkey cannot be empty")
+	if err := validatePubkey(pubkey); err != nil {
+		return err
 	}
 
-	query := `INSERT INTO allowed_pubkeys (pubkey, reason) VALUES ($1, $2) ON CONFLICT (pubkey) DO NOTHING`
+	query := `
+	INSERT INTO allowed_pubkeys (pubkey, reason) VALUES ($1, $2)
+	ON CONFLICT (pubkey) DO UPDATE SET expires_at = NULL, expiry_notified = FALSE, member_sync = FALSE`
 	if _, err := dbm.db.Exec(query, pubkey, reason); err != nil {
 		return fmt.Errorf("failed to add allowed pubkey %s: %w", pubkey, err)
 	}
@@ -67,10 +176,10 @@ func (dbm *DBManager) AddAllowedPubkey(pubkey, reason string) error {
 }
 
 // RemoveAllowedPubkey removes a pubkey from the allowed list.
-// Returns an error if the pubkey is not found in the allowed list.
+// Returns ErrPubkeyNotFound if the pubkey is not in the allowed list.
 func (dbm *DBManager) RemoveAllowedPubkey(pubkey string) error {
-	if pubkey == "" {
-		return fmt.Errorf("pubkey cannot be empty")
+	if err := validatePubkey(pubkey); err != nil {
+		return err
 	}
 
 	query := `DELETE FROM allowed_pubkeys WHERE pubkey = $1`
@@ -85,13 +194,138 @@ func (dbm *DBManager) RemoveAllowedPubkey(pubkey string) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("pubkey %s not found in allowed list", pubkey)
+		return fmt.Errorf("%w: %s is not in the allowed list", ErrPubkeyNotFound, pubkey)
 	}
 
 	return nil
 }
 
-// IsAllowedPubkey checks if a pubkey is in the allowed list.
+// ReconcileSyncedPubkeys makes the allowlist match members (pubkey -> reason), the
+// authoritative member list of an external membership system, in one transaction: members
+// that are not allowed yet are added as synced entries, and synced entries that are no longer
+// members are removed. Entries added otherwise are never removed. Returns the added and
+// removed pubkeys.
+func (dbm *DBManager) ReconcileSyncedPubkeys(members map[string]string) (added, removed []string, err error) {
+	for pubkey := range members {
+		if err := validatePubkey(pubkey); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tx, err := dbm.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin member sync: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT pubkey, member_sync FROM allowed_pubkeys FOR UPDATE`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query allowed pubkeys: %w", err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var pubkey string
+		var synced bool
+		if err := rows.Scan(&pubkey, &synced); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan allowed pubkey row: %w", err)
+		}
+		existing[pubkey] = synced
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error occurred while iterating over allowed pubkey rows: %w", err)
+	}
+
+	for pubkey, reason := range members {
+		if _, ok := existing[pubkey]; ok {
+			continue
+		}
+		query := `INSERT INTO allowed_pubkeys (pubkey, reason, member_sync) VALUES ($1, $2, TRUE)`
+		if _, err := tx.Exec(query, pubkey, reason); err != nil {
+			return nil, nil, fmt.Errorf("failed to add synced pubkey %s: %w", pubkey, err)
+		}
+		added = append(added, pubkey)
+	}
+	for pubkey, synced := range existing {
+		if _, ok := members[pubkey]; ok || !synced {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM allowed_pubkeys WHERE pubkey = $1`, pubkey); err != nil {
+			return nil, nil, fmt.Errorf("failed to remove synced pubkey %s: %w", pubkey, err)
+		}
+		removed = append(removed, pubkey)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit member sync: %w", err)
+	}
+	return added, removed, nil
+}
+
+// AllowPubkeyIfEmpty adds pubkey to the allowed list only if the list has no entries at all.
+// Returns whether it was added; the check and the insert are one statement.
+func (dbm *DBManager) AllowPubkeyIfEmpty(pubkey, reason string) (bool, error) {
+	if err := validatePubkey(pubkey); err != nil {
+		return false, err
+	}
+
+	query := `
+	INSERT INTO allowed_pubkeys (pubkey, reason)
+	SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM allowed_pubkeys)`
+	result, err := dbm.db.Exec(query, pubkey, reason)
+	if err != nil {
+		return false, fmt.Errorf("failed to bootstrap allowed pubkey %s: %w", pubkey, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected for pubkey %s: %w", pubkey, err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// AllowPubkeysIfEmpty adds all entries to the allowlist in one transaction, but only if the
+// allowlist is completely empty. Returns how many pubkeys were added, 0 if the allowlist
+// already had entries.
+func (dbm *DBManager) AllowPubkeysIfEmpty(entries []nip86.PubKeyReason) (int, error) {
+	for _, entry := range entries {
+		if err := validatePubkey(entry.PubKey); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := dbm.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin seeding the allowlist: %w", err)
+	}
+	defer tx.Rollback()
+
+	// keep other instances starting at the same time from seeding it as well
+	if _, err := tx.Exec(`LOCK TABLE allowed_pubkeys IN EXCLUSIVE MODE`); err != nil {
+		return 0, fmt.Errorf("failed to lock the allowlist: %w", err)
+	}
+	var empty bool
+	if err := tx.QueryRow(`SELECT NOT EXISTS (SELECT 1 FROM allowed_pubkeys)`).Scan(&empty); err != nil {
+		return 0, fmt.Errorf("failed to check if the allowlist is empty: %w", err)
+	}
+	if !empty {
+		return 0, nil
+	}
+
+	for _, entry := range entries {
+		query := `INSERT INTO allowed_pubkeys (pubkey, reason) VALUES ($1, $2) ON CONFLICT (pubkey) DO NOTHING`
+		if _, err := tx.Exec(query, entry.PubKey, entry.Reason); err != nil {
+			return 0, fmt.Errorf("failed to seed allowed pubkey %s: %w", entry.PubKey, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit seeding the allowlist: %w", err)
+	}
+	return len(entries), nil
+}
+
+// IsAllowedPubkey checks if a pubkey is in the allowed list and its entry has not expired.
 // Returns true if the pubkey is allowed, false otherwise.
 func (dbm *DBManager) IsAllowedPubkey(pubkey string) (bool, error) {
 	if pubkey == "" {
@@ -99,8 +333,8 @@ func (dbm *DBManager) IsAllowedPubkey(pubkey string) (bool, error) {
 	}
 
 	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM allowed_pubkeys WHERE pubkey = $1)`
-	if err := dbm.db.QueryRow(query, pubkey).Scan(&exists); err != nil {
+	query := `SELECT EXISTS(SELECT 1 FROM allowed_pubkeys WHERE pubkey = $1 AND (expires_at IS NULL OR expires_at > NOW()))`
+	if err := dbm.queryRow(query, []any{pubkey}, &exists); err != nil {
 		return false, fmt.Errorf("failed to check if pubkey %s is allowed: %w", pubkey, err)
 	}
 
@@ -111,7 +345,7 @@ func (dbm *DBManager) IsAllowedPubkey(pubkey string) (bool, error) {
 // Returns an empty slice if no pubkeys are found.
 func (dbm *DBManager) GetAllowedPubkeys() ([]string, error) {
 	query := `SELECT pubkey FROM allowed_pubkeys ORDER BY created_at`
-	rows, err := dbm.db.Query(query)
+	rows, err := dbm.query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query allowed pubkeys: %w", err)
 	}
@@ -133,6 +367,660 @@ func (dbm *DBManager) GetAllowedPubkeys() ([]string, error) {
 	return pubkeys, nil
 }
 
+// AllowedPubkey is an allowlist entry with everything stored about it. Contact and Notes are
+// the owner's own records and must only be shown to the owner.
+type AllowedPubkey struct {
+	Pubkey    string     `json:"pubkey"`
+	Reason    string     `json:"reason"`
+	Labels    []string   `json:"labels"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Contact   string     `json:"contact"`
+	Notes     string     `json:"notes"`
+}
+
+// GetAllowedPubkeyEntries returns all allowlist entries ordered by creation time.
+// If label is not empty, only entries carrying that label are returned.
+func (dbm *DBManager) GetAllowedPubkeyEntries(label string) ([]AllowedPubkey, error) {
+	query := `
+	SELECT pubkey, COALESCE(reason, ''), labels, expires_at, contact, notes FROM allowed_pubkeys
+	WHERE $1::text = '' OR $1::text = ANY(labels)
+	ORDER BY created_at`
+	rows, err := dbm.query(query, label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query allowed pubkeys: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AllowedPubkey{}
+	for rows.Next() {
+		var entry AllowedPubkey
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&entry.Pubkey, &entry.Reason, pq.Array(&entry.Labels), &expiresAt, &entry.Contact, &entry.Notes); err != nil {
+			return nil, fmt.Errorf("failed to scan allowed pubkey row: %w", err)
+		}
+		if expiresAt.Valid {
+			entry.ExpiresAt = &expiresAt.Time
+		}
+		if entry.Labels == nil {
+			entry.Labels = []string{}
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while iterating over allowed pubkey rows: %w", err)
+	}
+
+	return entries, nil
+}
+
+// AllowlistQuery filters and paginates QueryAllowedPubkeys. Empty fields match everything.
+type AllowlistQuery struct {
+	Label        string
+	PubkeyPrefix string // lowercase hex
+	Status       string // "active", "expired" or empty for both
+	Limit        int
+	Offset       int
+}
+
+// QueryAllowedPubkeys returns one page of allowlist entries matching q, ordered by creation
+// time, and the number of matching entries across all pages.
+func (dbm *DBManager) QueryAllowedPubkeys(q AllowlistQuery) ([]AllowedPubkey, int, error) {
+	where := `
+	WHERE ($1::text = '' OR $1::text = ANY(labels))
+		AND pubkey LIKE $2::text || '%'
+		AND ($3::text = ''
+			OR ($3::text = 'active' AND (expires_at IS NULL OR expires_at > NOW()))
+			OR ($3::text = 'expired' AND expires_at <= NOW()))`
+	args := []any{q.Label, q.PubkeyPrefix, q.Status}
+
+	var total int
+	if err := dbm.queryRow(`SELECT COUNT(*) FROM allowed_pubkeys`+where, args, &total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count allowed pubkeys: %w", err)
+	}
+
+	query := `SELECT pubkey, COALESCE(reason, ''), labels, expires_at, contact, notes FROM allowed_pubkeys` + where + `
+	ORDER BY created_at, pubkey LIMIT $4 OFFSET $5`
+	rows, err := dbm.query(query, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query allowed pubkeys: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AllowedPubkey{}
+	for rows.Next() {
+		var entry AllowedPubkey
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&entry.Pubkey, &entry.Reason, pq.Array(&entry.Labels), &expiresAt, &entry.Contact, &entry.Notes); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan allowed pubkey row: %w", err)
+		}
+		if expiresAt.Valid {
+			entry.ExpiresAt = &expiresAt.Time
+		}
+		if entry.Labels == nil {
+			entry.Labels = []string{}
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error occurred while iterating over allowed pubkey rows: %w", err)
+	}
+
+	return entries, total, nil
+}
+
+// GetAllowedPubkeyEntry returns the allowlist entry of pubkey.
+// Returns ErrPubkeyNotFound if the pubkey is not in the allowed list.
+func (dbm *DBManager) GetAllowedPubkeyEntry(pubkey string) (AllowedPubkey, error) {
+	entry := AllowedPubkey{Pubkey: pubkey}
+	if err := validatePubkey(pubkey); err != nil {
+		return entry, err
+	}
+
+	var expiresAt sql.NullTime
+	query := `SELECT COALESCE(reason, ''), labels, expires_at, contact, notes FROM allowed_pubkeys WHERE pubkey = $1`
+	err := dbm.queryRow(query, []any{pubkey}, &entry.Reason, pq.Array(&entry.Labels), &expiresAt, &entry.Contact, &entry.Notes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entry, fmt.Errorf("%w: %s", ErrPubkeyNotFound, pubkey)
+	}
+	if err != nil {
+		return entry, fmt.Errorf("failed to get allowlist entry of %s: %w", pubkey, err)
+	}
+	if expiresAt.Valid {
+		entry.ExpiresAt = &expiresAt.Time
+	}
+	if entry.Labels == nil {
+		entry.Labels = []string{}
+	}
+	return entry, nil
+}
+
+// SetAllowedPubkeyMetadata sets the contact and the notes the owner keeps about an allowed
+// pubkey. A nil value leaves that field as it is.
+// Returns ErrPubkeyNotFound if the pubkey is not in the allowed list.
+func (dbm *DBManager) SetAllowedPubkeyMetadata(pubkey string, contact, notes *string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+
+	query := `UPDATE allowed_pubkeys SET contact = COALESCE($2, contact), notes = COALESCE($3, notes) WHERE pubkey = $1`
+	result, err := dbm.db.Exec(query, pubkey, contact, notes)
+	if err != nil {
+		return fmt.Errorf("failed to set metadata for pubkey %s: %w", pubkey, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected for pubkey %s: %w", pubkey, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrPubkeyNotFound, pubkey)
+	}
+	return nil
+}
+
+// SetAllowedPubkeyLabels replaces the labels of an allowed pubkey.
+// Returns ErrPubkeyNotFound if the pubkey is not in the allowed list.
+func (dbm *DBManager) SetAllowedPubkeyLabels(pubkey string, labels []string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+	if labels == nil {
+		labels = []string{}
+	}
+
+	query := `UPDATE allowed_pubkeys SET labels = $2 WHERE pubkey = $1`
+	result, err := dbm.db.Exec(query, pubkey, pq.Array(labels))
+	if err != nil {
+		return fmt.Errorf("failed to set labels for pubkey %s: %w", pubkey, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected for pubkey %s: %w", pubkey, err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s is not in the allowed list", ErrPubkeyNotFound, pubkey)
+	}
+
+	return nil
+}
+
+// SetAllowedPubkeyExpiry sets the time at which an allowed pubkey loses access.
+// A zero expiresAt removes the expiry. Returns ErrPubkeyNotFound if the pubkey is not in the allowed list.
+func (dbm *DBManager) SetAllowedPubkeyExpiry(pubkey string, expiresAt time.Time) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+
+	var expiry sql.NullTime
+	if !expiresAt.IsZero() {
+		expiry = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+
+	query := `UPDATE allowed_pubkeys SET expires_at = $2, expiry_notified = FALSE WHERE pubkey = $1`
+	result, err := dbm.db.Exec(query, pubkey, expiry)
+	if err != nil {
+		return fmt.Errorf("failed to set expiry for pubkey %s: %w", pubkey, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected for pubkey %s: %w", pubkey, err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s is not in the allowed list", ErrPubkeyNotFound, pubkey)
+	}
+
+	return nil
+}
+
+// ExpiringPubkey is an allowed pubkey together with the time its access ends.
+type ExpiringPubkey struct {
+	Pubkey    string    `json:"pubkey"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GetExpiringPubkeys returns allowed pubkeys that expire within the given lead time
+// and have not been reported as expiring yet, soonest first.
+func (dbm *DBManager) GetExpiringPubkeys(lead time.Duration) ([]ExpiringPubkey, error) {
+	query := `
+	SELECT pubkey, expires_at FROM allowed_pubkeys
+	WHERE expires_at IS NOT NULL
+		AND NOT expiry_notified
+		AND expires_at > NOW()
+		AND expires_at <= NOW() + make_interval(secs => $1)
+	ORDER BY expires_at`
+	rows, err := dbm.query(query, lead.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expiring pubkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var expiring []ExpiringPubkey
+	for rows.Next() {
+		var entry ExpiringPubkey
+		if err := rows.Scan(&entry.Pubkey, &entry.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan expiring pubkey row: %w", err)
+		}
+		expiring = append(expiring, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while iterating over expiring pubkey rows: %w", err)
+	}
+
+	return expiring, nil
+}
+
+// MarkExpiryNotified records that an expiry notification was sent for the given pubkeys,
+// so they are not reported again unless their expiry is changed.
+func (dbm *DBManager) MarkExpiryNotified(pubkeys []string) error {
+	query := `UPDATE allowed_pubkeys SET expiry_notified = TRUE WHERE pubkey = ANY($1)`
+	if _, err := dbm.db.Exec(query, pq.Array(pubkeys)); err != nil {
+		return fmt.Errorf("failed to mark expiry notified: %w", err)
+	}
+
+	return nil
+}
+
+// AddModerator gives a pubkey moderator rights.
+// Returns ErrPubkeyExists if the pubkey already is a moderator, in which case nothing changes.
+func (dbm *DBManager) AddModerator(pubkey string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO moderators (pubkey) VALUES ($1) ON CONFLICT (pubkey) DO NOTHING`
+	result, err := dbm.db.Exec(query, pubkey)
+	if err != nil {
+		return fmt.Errorf("failed to add moderator %s: %w", pubkey, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected for pubkey %s: %w", pubkey, err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s already is a moderator", ErrPubkeyExists, pubkey)
+	}
+
+	return nil
+}
+
+// RemoveModerator takes moderator rights away from a pubkey.
+// Returns ErrPubkeyNotFound if the pubkey is not a moderator.
+func (dbm *DBManager) RemoveModerator(pubkey string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM moderators WHERE pubkey = $1`
+	result, err := dbm.db.Exec(query, pubkey)
+	if err != nil {
+		return fmt.Errorf("failed to remove moderator %s: %w", pubkey, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected for pubkey %s: %w", pubkey, err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s is not a moderator", ErrPubkeyNotFound, pubkey)
+	}
+
+	return nil
+}
+
+// IsModerator checks if a pubkey has moderator rights.
+func (dbm *DBManager) IsModerator(pubkey string) (bool, error) {
+	if pubkey == "" {
+		return false, nil
+	}
+
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM moderators WHERE pubkey = $1)`
+	if err := dbm.queryRow(query, []any{pubkey}, &exists); err != nil {
+		return false, fmt.Errorf("failed to check if pubkey %s is a moderator: %w", pubkey, err)
+	}
+
+	return exists, nil
+}
+
+// GetModerators returns all moderators ordered by when they were added.
+func (dbm *DBManager) GetModerators() ([]string, error) {
+	query := `SELECT pubkey FROM moderators ORDER BY created_at`
+	rows, err := dbm.query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query moderators: %w", err)
+	}
+	defer rows.Close()
+
+	moderators := []string{}
+	for rows.Next() {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return nil, fmt.Errorf("failed to scan moderator row: %w", err)
+		}
+		moderators = append(moderators, pubkey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while iterating over moderator rows: %w", err)
+	}
+
+	return moderators, nil
+}
+
+// GetSetting returns a relay setting changed at runtime.
+// The boolean is false if the setting has never been set or was deleted.
+func (dbm *DBManager) GetSetting(key string) (string, bool, error) {
+	var value string
+	query := `SELECT value FROM relay_settings WHERE key = $1`
+	err := dbm.queryRow(query, []any{key}, &value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get setting %s: %w", key, err)
+	}
+
+	return value, true, nil
+}
+
+// SetSetting stores a relay setting, replacing any previous value.
+func (dbm *DBManager) SetSetting(key, value string) error {
+	query := `
+	INSERT INTO relay_settings (key, value) VALUES ($1, $2)
+	ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()`
+	if _, err := dbm.db.Exec(query, key, value); err != nil {
+		return fmt.Errorf("failed to set setting %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// DeleteSetting removes a relay setting. Deleting a setting that is not set is a no-op.
+func (dbm *DBManager) DeleteSetting(key string) error {
+	query := `DELETE FROM relay_settings WHERE key = $1`
+	if _, err := dbm.db.Exec(query, key); err != nil {
+		return fmt.Errorf("failed to delete setting %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Moderator is a pubkey with moderator rights and when it got them.
+type Moderator struct {
+	Pubkey    string    `json:"pubkey"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// QueryModerators returns one page of moderators ordered by when they were added,
+// and the total number of moderators.
+func (dbm *DBManager) QueryModerators(limit, offset int) ([]Moderator, int, error) {
+	var total int
+	if err := dbm.queryRow(`SELECT COUNT(*) FROM moderators`, nil, &total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count moderators: %w", err)
+	}
+
+	query := `SELECT pubkey, created_at FROM moderators ORDER BY created_at, pubkey LIMIT $1 OFFSET $2`
+	rows, err := dbm.query(query, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query moderators: %w", err)
+	}
+	defer rows.Close()
+
+	moderators := []Moderator{}
+	for rows.Next() {
+		var moderator Moderator
+		if err := rows.Scan(&moderator.Pubkey, &moderator.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan moderator row: %w", err)
+		}
+		moderators = append(moderators, moderator)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error occurred while iterating over moderator rows: %w", err)
+	}
+
+	return moderators, total, nil
+}
+
+// StorageUsage is how many bytes a pubkey has stored, and its own quota if it has one.
+type StorageUsage struct {
+	Pubkey     string `json:"pubkey"`
+	UsedBytes  int64  `json:"used_bytes"`
+	QuotaBytes *int64 `json:"quota_bytes"`
+}
+
+// TrackStorageUsage installs a trigger on the event table that keeps pubkey_storage.used_bytes
+// up to date on every insert and delete, whatever deletes the event, and recounts the usage
+// of all pubkeys since events may have been stored while the trigger was not installed.
+// The event table must exist, i.e. the event store must have been initialized.
+func (dbm *DBManager) TrackStorageUsage() error {
+	tx, err := dbm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin storage usage setup: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	LOCK TABLE event IN SHARE MODE;
+
+	CREATE OR REPLACE FUNCTION event_storage_size(content TEXT, tags JSONB) RETURNS BIGINT AS $$
+		SELECT octet_length(content) + COALESCE((
+			SELECT SUM(octet_length(v.value))
+			FROM jsonb_array_elements(tags) AS t(tag), jsonb_array_elements_text(t.tag) AS v(value)
+		), 0)
+	$$ LANGUAGE SQL IMMUTABLE;
+
+	CREATE OR REPLACE FUNCTION track_pubkey_storage() RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP = 'INSERT' THEN
+			INSERT INTO pubkey_storage (pubkey, used_bytes)
+			VALUES (NEW.pubkey, event_storage_size(NEW.content, NEW.tags))
+			ON CONFLICT (pubkey) DO UPDATE SET used_bytes = pubkey_storage.used_bytes + EXCLUDED.used_bytes;
+			RETURN NEW;
+		END IF;
+		UPDATE pubkey_storage SET used_bytes = GREATEST(used_bytes - event_storage_size(OLD.content, OLD.tags), 0)
+		WHERE pubkey = OLD.pubkey;
+		RETURN OLD;
+	END
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS event_pubkey_storage ON event;
+	CREATE TRIGGER event_pubkey_storage AFTER INSERT OR DELETE ON event
+	FOR EACH ROW EXECUTE FUNCTION track_pubkey_storage();
+
+	UPDATE pubkey_storage SET used_bytes = 0;
+	INSERT INTO pubkey_storage (pubkey, used_bytes)
+	SELECT pubkey, SUM(event_storage_size(content, tags)) FROM event GROUP BY pubkey
+	ON CONFLICT (pubkey) DO UPDATE SET used_bytes = EXCLUDED.used_bytes;`
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed to set up storage usage tracking: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetStorageUsage returns the stored bytes and quota of a pubkey. Pubkeys that never stored
+// anything have zero usage and no quota of their own.
+func (dbm *DBManager) GetStorageUsage(pubkey string) (StorageUsage, error) {
+	usage := StorageUsage{Pubkey: pubkey}
+	if err := validatePubkey(pubkey); err != nil {
+		return usage, err
+	}
+
+	var quota sql.NullInt64
+	query := `SELECT used_bytes, quota_bytes FROM pubkey_storage WHERE pubkey = $1`
+	err := dbm.queryRow(query, []any{pubkey}, &usage.UsedBytes, &quota)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return usage, fmt.Errorf("failed to get storage usage of %s: %w", pubkey, err)
+	}
+	if quota.Valid {
+		usage.QuotaBytes = &quota.Int64
+	}
+	return usage, nil
+}
+
+// SetStorageQuota gives a pubkey its own storage quota in bytes, overriding the default.
+func (dbm *DBManager) SetStorageQuota(pubkey string, quotaBytes int64) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+	if quotaBytes < 0 {
+		return fmt.Errorf("storage quota of %s must not be negative", pubkey)
+	}
+
+	query := `
+	INSERT INTO pubkey_storage (pubkey, quota_bytes) VALUES ($1, $2)
+	ON CONFLICT (pubkey) DO UPDATE SET quota_bytes = EXCLUDED.quota_bytes`
+	if _, err := dbm.db.Exec(query, pubkey, quotaBytes); err != nil {
+		return fmt.Errorf("failed to set storage quota of %s: %w", pubkey, err)
+	}
+	return nil
+}
+
+// ClearStorageQuota removes the own quota of a pubkey, so the default applies again.
+func (dbm *DBManager) ClearStorageQuota(pubkey string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+
+	query := `UPDATE pubkey_storage SET quota_bytes = NULL WHERE pubkey = $1`
+	if _, err := dbm.db.Exec(query, pubkey); err != nil {
+		return fmt.Errorf("failed to clear storage quota of %s: %w", pubkey, err)
+	}
+	return nil
+}
+
+// AddPolicyExemption exempts a pubkey from the named policy. Adding an existing exemption
+// is a no-op.
+func (dbm *DBManager) AddPolicyExemption(pubkey, policy string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO policy_exemptions (pubkey, policy) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	if _, err := dbm.db.Exec(query, pubkey, policy); err != nil {
+		return fmt.Errorf("failed to exempt %s from %s: %w", pubkey, policy, err)
+	}
+	return nil
+}
+
+// RemovePolicyExemption makes the named policy apply to a pubkey again.
+// Returns ErrPubkeyNotFound if the pubkey is not exempt from it.
+func (dbm *DBManager) RemovePolicyExemption(pubkey, policy string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM policy_exemptions WHERE pubkey = $1 AND policy = $2`
+	result, err := dbm.db.Exec(query, pubkey, policy)
+	if err != nil {
+		return fmt.Errorf("failed to remove exemption of %s from %s: %w", pubkey, policy, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected for pubkey %s: %w", pubkey, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s is not exempt from %s", ErrPubkeyNotFound, pubkey, policy)
+	}
+	return nil
+}
+
+// GetPolicyExemptions returns the policies each exempted pubkey is exempt from.
+func (dbm *DBManager) GetPolicyExemptions() (map[string][]string, error) {
+	rows, err := dbm.query(`SELECT pubkey, policy FROM policy_exemptions ORDER BY pubkey, policy`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query policy exemptions: %w", err)
+	}
+	defer rows.Close()
+
+	exemptions := make(map[string][]string)
+	for rows.Next() {
+		var pubkey, policy string
+		if err := rows.Scan(&pubkey, &policy); err != nil {
+			return nil, fmt.Errorf("failed to scan policy exemption row: %w", err)
+		}
+		exemptions[pubkey] = append(exemptions[pubkey], policy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while iterating over policy exemption rows: %w", err)
+	}
+
+	return exemptions, nil
+}
+
+// PinEvent keeps an event from ever being deleted by retention. Pinning an event again
+// updates the reason.
+func (dbm *DBManager) PinEvent(id, reason string) error {
+	if !nostr.IsValid32ByteHex(id) {
+		return ErrInvalidEventID
+	}
+
+	query := `
+	INSERT INTO pinned_events (event_id, reason) VALUES ($1, $2)
+	ON CONFLICT (event_id) DO UPDATE SET reason = EXCLUDED.reason`
+	if _, err := dbm.db.Exec(query, id, reason); err != nil {
+		return fmt.Errorf("failed to pin event %s: %w", id, err)
+	}
+	return nil
+}
+
+// UnpinEvent puts an event back under the retention rules.
+// Returns ErrEventNotPinned if the event is not pinned.
+func (dbm *DBManager) UnpinEvent(id string) error {
+	if !nostr.IsValid32ByteHex(id) {
+		return ErrInvalidEventID
+	}
+
+	result, err := dbm.db.Exec(`DELETE FROM pinned_events WHERE event_id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to unpin event %s: %w", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected for event %s: %w", id, err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrEventNotPinned, id)
+	}
+	return nil
+}
+
+// GetPinnedEvents returns the pinned events with their reasons, most recently pinned first.
+func (dbm *DBManager) GetPinnedEvents() ([]nip86.IDReason, error) {
+	rows, err := dbm.query(`SELECT event_id, reason FROM pinned_events ORDER BY pinned_at DESC, event_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pinned events: %w", err)
+	}
+	defer rows.Close()
+
+	pinned := []nip86.IDReason{}
+	for rows.Next() {
+		var entry nip86.IDReason
+		if err := rows.Scan(&entry.ID, &entry.Reason); err != nil {
+			return nil, fmt.Errorf("failed to scan pinned event row: %w", err)
+		}
+		pinned = append(pinned, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while iterating over pinned event rows: %w", err)
+	}
+
+	return pinned, nil
+}
+
 // Close closes the database connection.
 // This should be called when the DBManager is no longer needed.
 func (dbm *DBManager) Close() error {
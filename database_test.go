@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+func TestDBManagerRejectsInvalidPubkeys(t *testing.T) {
+	// validation happens before the database is touched, so no connection is needed
+	dbm := &DBManager{}
+	for _, pubkey := range []string{"", "npub1xyz", strings.Repeat("A", 64), strings.Repeat("a", 63)} {
+		calls := map[string]error{
+			"AddAllowedPubkey":         dbm.AddAllowedPubkey(pubkey, ""),
+			"RemoveAllowedPubkey":      dbm.RemoveAllowedPubkey(pubkey),
+			"SetAllowedPubkeyLabels":   dbm.SetAllowedPubkeyLabels(pubkey, nil),
+			"SetAllowedPubkeyExpiry":   dbm.SetAllowedPubkeyExpiry(pubkey, time.Time{}),
+			"AddModerator":             dbm.AddModerator(pubkey),
+			"RemoveModerator":          dbm.RemoveModerator(pubkey),
+			"AddPolicyExemption":       dbm.AddPolicyExemption(pubkey, "rate_limit"),
+			"RemovePolicyExemption":    dbm.RemovePolicyExemption(pubkey, "rate_limit"),
+			"SetAllowedPubkeyMetadata": dbm.SetAllowedPubkeyMetadata(pubkey, nil, nil),
+		}
+		_, calls["AllowPubkeyIfEmpty"] = dbm.AllowPubkeyIfEmpty(pubkey, "")
+		_, calls["GetAllowedPubkeyEntry"] = dbm.GetAllowedPubkeyEntry(pubkey)
+		_, _, calls["ReconcileSyncedPubkeys"] = dbm.ReconcileSyncedPubkeys(map[string]string{pubkey: ""})
+		_, calls["AllowPubkeysIfEmpty"] = dbm.AllowPubkeysIfEmpty([]nip86.PubKeyReason{{PubKey: pubkey}})
+		for name, err := range calls {
+			if !errors.Is(err, ErrInvalidPubkey) {
+				t.Errorf("%s(%q): expected ErrInvalidPubkey, got %v", name, pubkey, err)
+			}
+		}
+	}
+}
+
+func TestDBErrorStatus(t *testing.T) {
+	cases := map[error]int{
+		validatePubkey("nope"): http.StatusBadRequest,
+		ErrPubkeyNotFound:      http.StatusNotFound,
+		ErrPubkeyExists:        http.StatusConflict,
+		errors.New("failed to remove allowed pubkey: connection refused"): http.StatusInternalServerError,
+	}
+	for err, want := range cases {
+		if got := dbErrorStatus(err); got != want {
+			t.Errorf("%v: expected %d, got %d", err, want, got)
+		}
+	}
+}
+
+// recordingConnector hands out connections that record the statements they execute.
+type recordingConnector struct{ statements []string }
+
+func (c *recordingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &recordingConn{connector: c}, nil
+}
+
+func (c *recordingConnector) Driver() driver.Driver { return nil }
+
+type recordingConn struct{ connector *recordingConnector }
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (c *recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.connector.statements = append(c.connector.statements, query)
+	return driver.RowsAffected(0), nil
+}
+
+func TestInitTablesMigratesAllowlistMetadata(t *testing.T) {
+	connector := &recordingConnector{}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	if _, err := newDBManager(db); err != nil {
+		t.Fatalf("failed to initialize: %v", err)
+	}
+
+	// existing allowlists get the columns on their next start, empty for every entry
+	for _, migration := range []string{
+		`ALTER TABLE allowed_pubkeys ADD COLUMN IF NOT EXISTS contact TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE allowed_pubkeys ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT ''`,
+	} {
+		if !slices.Contains(connector.statements, migration) {
+			t.Errorf("expected the migration %q, got %v", migration, connector.statements)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// databaseIdentity is the postgres server, database and schema a connection pool ends up on.
+type databaseIdentity struct {
+	Server   string
+	Database string
+	Schema   string
+}
+
+func (d databaseIdentity) String() string {
+	return fmt.Sprintf("%s/%s (schema %s)", d.Server, d.Database, d.Schema)
+}
+
+// databaseStatus is what the startup diagnostic found out about the database of one subsystem.
+type databaseStatus struct {
+	subsystem string
+	identity  databaseIdentity
+	err       error
+}
+
+// queryDatabaseIdentity asks the server behind db who it is.
+func queryDatabaseIdentity(ctx context.Context, db *sql.DB) (databaseIdentity, error) {
+	var identity databaseIdentity
+	query := `
+	SELECT COALESCE(host(inet_server_addr()) || ':' || inet_server_port(), 'local socket'),
+		current_database(), COALESCE(current_schema(), '')`
+	if err := db.QueryRowContext(ctx, query).Scan(&identity.Server, &identity.Database, &identity.Schema); err != nil {
+		return identity, fmt.Errorf("failed to query database identity: %w", err)
+	}
+	return identity, nil
+}
+
+// subsystemDatabase is the connection pool one subsystem keeps its data in.
+type subsystemDatabase struct {
+	name string
+	db   *sql.DB
+}
+
+// checkSubsystemDatabases looks up the database of each subsystem, giving each 5 seconds.
+func checkSubsystemDatabases(ctx context.Context, subsystems []subsystemDatabase) []databaseStatus {
+	statuses := make([]databaseStatus, 0, len(subsystems))
+	for _, subsystem := range subsystems {
+		checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		identity, err := queryDatabaseIdentity(checkCtx, subsystem.db)
+		cancel()
+		statuses = append(statuses, databaseStatus{subsystem: subsystem.name, identity: identity, err: err})
+	}
+	return statuses
+}
+
+// diagnoseDatabases returns the problems with statuses: subsystems whose database is down while
+// another one's is up, and subsystems that use a different database than the first.
+func diagnoseDatabases(statuses []databaseStatus) []string {
+	var problems []string
+	var reference *databaseStatus
+	for i, status := range statuses {
+		if status.err == nil && reference == nil {
+			reference = &statuses[i]
+		}
+	}
+	for _, status := range statuses {
+		switch {
+		case status.err != nil && reference != nil:
+			problems = append(problems, fmt.Sprintf("the %s database is unreachable while the %s database is up: %v", status.subsystem, reference.subsystem, status.err))
+		case status.err != nil:
+			problems = append(problems, fmt.Sprintf("the %s database is unreachable: %v", status.subsystem, status.err))
+		case status.identity != reference.identity:
+			problems = append(problems, fmt.Sprintf("the %s uses %s but the %s uses %s", status.subsystem, status.identity, reference.subsystem, reference.identity))
+		}
+	}
+	return problems
+}
+
+// reportDatabases logs the database of each subsystem and any divergence between them, and
+// returns an error if there is one.
+func reportDatabases(statuses []databaseStatus) error {
+	for _, status := range statuses {
+		if status.err == nil {
+			log.Printf("Database of the %s: %s", status.subsystem, status.identity)
+		}
+	}
+	problems := diagnoseDatabases(statuses)
+	for _, problem := range problems {
+		log.Printf("Database divergence: %s", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d database problems found at startup", len(problems))
+	}
+	return nil
+}
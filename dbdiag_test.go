@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiagnoseDatabases(t *testing.T) {
+	primary := databaseIdentity{Server: "10.0.0.5:5432", Database: "relay", Schema: "public"}
+	replica := databaseIdentity{Server: "10.0.0.6:5432", Database: "relay", Schema: "public"}
+	down := errors.New("connection refused")
+
+	if problems := diagnoseDatabases([]databaseStatus{
+		{subsystem: "event store", identity: primary},
+		{subsystem: "membership tables", identity: primary},
+	}); len(problems) != 0 {
+		t.Errorf("expected a shared database to be fine, got %v", problems)
+	}
+
+	problems := diagnoseDatabases([]databaseStatus{
+		{subsystem: "event store", identity: primary},
+		{subsystem: "membership tables", identity: replica},
+	})
+	if len(problems) != 1 || !strings.Contains(problems[0], "10.0.0.6:5432/relay") {
+		t.Errorf("expected the diverging databases to be reported, got %v", problems)
+	}
+
+	problems = diagnoseDatabases([]databaseStatus{
+		{subsystem: "event store", err: down},
+		{subsystem: "membership tables", identity: primary},
+	})
+	if len(problems) != 1 || !strings.Contains(problems[0], "event store database is unreachable while the membership tables database is up") {
+		t.Errorf("expected the split to be reported, got %v", problems)
+	}
+
+	problems = diagnoseDatabases([]databaseStatus{
+		{subsystem: "event store", err: down},
+		{subsystem: "membership tables", err: down},
+	})
+	if len(problems) != 2 {
+		t.Errorf("expected both unreachable databases to be reported, got %v", problems)
+	}
+	if err := reportDatabases([]databaseStatus{{subsystem: "event store", identity: primary}}); err != nil {
+		t.Errorf("expected no error for a healthy database, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// rejectBadDelegation rejects events whose NIP-26 delegation tag has an invalid token or
+// whose kind or created_at fall outside the delegation's conditions. Events without a
+// delegation tag are not affected.
+func rejectBadDelegation(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	tag := event.Tags.Find("delegation")
+	if tag == nil {
+		return false, ""
+	}
+	if err := checkDelegation(event, tag); err != nil {
+		return true, "invalid: bad delegation"
+	}
+	return false, ""
+}
+
+// checkDelegation verifies a ["delegation", <delegator>, <conditions>, <token>] tag of event.
+func checkDelegation(event *nostr.Event, tag nostr.Tag) error {
+	if len(tag) < 4 {
+		return fmt.Errorf("delegation tag has %d fields, expected 4", len(tag))
+	}
+	delegator, conditions, token := tag[1], tag[2], tag[3]
+
+	if err := checkDelegationConditions(conditions, event); err != nil {
+		return err
+	}
+
+	pubkeyBytes, err := hex.DecodeString(delegator)
+	if err != nil || !nostr.IsValidPublicKey(delegator) {
+		return fmt.Errorf("invalid delegator pubkey %q", delegator)
+	}
+	pubkey, err := schnorr.ParsePubKey(pubkeyBytes)
+	if err != nil {
+		return fmt.Errorf("invalid delegator pubkey %q: %w", delegator, err)
+	}
+	tokenBytes, err := hex.DecodeString(token)
+	if err != nil {
+		return fmt.Errorf("delegation token is not hex: %w", err)
+	}
+	signature, err := schnorr.ParseSignature(tokenBytes)
+	if err != nil {
+		return fmt.Errorf("invalid delegation token: %w", err)
+	}
+
+	hash := sha256.Sum256([]byte("nostr:delegation:" + event.PubKey + ":" + conditions))
+	if !signature.Verify(hash[:], pubkey) {
+		return fmt.Errorf("delegation token does not match")
+	}
+	return nil
+}
+
+// checkDelegationConditions checks an event against a query string such as
+// "kind=1&created_at>1674834236&created_at<1677426236". Several kind= clauses allow any of
+// those kinds; an unknown clause makes the delegation invalid.
+func checkDelegationConditions(conditions string, event *nostr.Event) error {
+	var kinds []int
+	for _, clause := range strings.Split(conditions, "&") {
+		switch {
+		case strings.HasPrefix(clause, "kind="):
+			kind, err := strconv.Atoi(strings.TrimPrefix(clause, "kind="))
+			if err != nil {
+				return fmt.Errorf("invalid condition %q", clause)
+			}
+			kinds = append(kinds, kind)
+		case strings.HasPrefix(clause, "created_at>"):
+			after, err := strconv.ParseInt(strings.TrimPrefix(clause, "created_at>"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid condition %q", clause)
+			}
+			if int64(event.CreatedAt) <= after {
+				return fmt.Errorf("event is older than the delegation allows")
+			}
+		case strings.HasPrefix(clause, "created_at<"):
+			before, err := strconv.ParseInt(strings.TrimPrefix(clause, "created_at<"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid condition %q", clause)
+			}
+			if int64(event.CreatedAt) >= before {
+				return fmt.Errorf("event is newer than the delegation allows")
+			}
+		default:
+			return fmt.Errorf("unknown condition %q", clause)
+		}
+	}
+
+	if len(kinds) > 0 {
+		for _, kind := range kinds {
+			if kind == event.Kind {
+				return nil
+			}
+		}
+		return fmt.Errorf("kind %d is not delegated", event.Kind)
+	}
+	return nil
+}
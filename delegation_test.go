@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// delegationToken signs the NIP-26 delegation string for delegatee with the delegator's key.
+func delegationToken(t *testing.T, delegatorSK, delegatee, conditions string) string {
+	t.Helper()
+	skBytes, _ := hex.DecodeString(delegatorSK)
+	sk, _ := btcec.PrivKeyFromBytes(skBytes)
+	hash := sha256.Sum256([]byte("nostr:delegation:" + delegatee + ":" + conditions))
+	signature, err := schnorr.Sign(sk, hash[:])
+	if err != nil {
+		t.Fatalf("failed to sign delegation: %v", err)
+	}
+	return hex.EncodeToString(signature.Serialize())
+}
+
+func TestRejectBadDelegation(t *testing.T) {
+	delegatorSK, delegator := newKeypair(t)
+	delegateeSK, delegatee := newKeypair(t)
+	conditions := "kind=1&created_at>1000&created_at<2000"
+	token := delegationToken(t, delegatorSK, delegatee, conditions)
+
+	delegated := func(kind int, createdAt nostr.Timestamp, tag nostr.Tag) *nostr.Event {
+		event := &nostr.Event{Kind: kind, CreatedAt: createdAt, Tags: nostr.Tags{tag}, Content: "delegated"}
+		if err := event.Sign(delegateeSK); err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return event
+	}
+
+	tampered := []byte(token)
+	tampered[10] ^= 1
+	otherSK, _ := newKeypair(t)
+
+	cases := []struct {
+		name   string
+		event  *nostr.Event
+		reject bool
+	}{
+		{"valid", delegated(1, 1500, nostr.Tag{"delegation", delegator, conditions, token}), false},
+		{"without delegation", signedEvent(t, delegateeSK), false},
+		{"tampered token", delegated(1, 1500, nostr.Tag{"delegation", delegator, conditions, string(tampered)}), true},
+		{"tampered conditions", delegated(7, 1500, nostr.Tag{"delegation", delegator, "kind=7&created_at>1000&created_at<2000", token}), true},
+		{"signed by someone else", delegated(1, 1500, nostr.Tag{"delegation", delegator, conditions, delegationToken(t, otherSK, delegatee, conditions)}), true},
+		{"wrong kind", delegated(7, 1500, nostr.Tag{"delegation", delegator, conditions, token}), true},
+		{"too early", delegated(1, 1000, nostr.Tag{"delegation", delegator, conditions, token}), true},
+		{"too late", delegated(1, 2000, nostr.Tag{"delegation", delegator, conditions, token}), true},
+		{"truncated tag", delegated(1, 1500, nostr.Tag{"delegation", delegator, conditions}), true},
+	}
+	for _, tc := range cases {
+		reject, msg := rejectBadDelegation(context.Background(), tc.event)
+		if reject != tc.reject {
+			t.Errorf("%s: expected reject=%t, got %t (%q)", tc.name, tc.reject, reject, msg)
+		}
+		if reject && msg != "invalid: bad delegation" {
+			t.Errorf("%s: unexpected message %q", tc.name, msg)
+		}
+	}
+}
+
+func TestDelegationConditions(t *testing.T) {
+	event := &nostr.Event{Kind: 7, CreatedAt: 1500}
+	if err := checkDelegationConditions("kind=1&kind=7", event); err != nil {
+		t.Fatalf("expected any listed kind to be allowed, got %v", err)
+	}
+	if err := checkDelegationConditions("created_at>1000", event); err != nil {
+		t.Fatalf("expected conditions without kinds to allow any kind, got %v", err)
+	}
+	if err := checkDelegationConditions("kind=1&lang=en", event); err == nil {
+		t.Fatal("expected unknown conditions to be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// dryRunPolicies lets named event policies run without enforcing them: a policy listed in
+// DRY_RUN_POLICIES still evaluates every event, but a would-be rejection is only logged and
+// counted, and the event goes on to the next policy.
+type dryRunPolicies struct {
+	names map[string]bool
+	// exemptions, if set, let exempted pubkeys through each named policy
+	exemptions *policyExemptions
+	// decisions, if set, keeps the would-be rejections for /admin/dryrun
+	decisions *decisionLog
+
+	mu      sync.Mutex
+	known   []string
+	rejects map[string]int64
+}
+
+func newDryRunPolicies(names []string) *dryRunPolicies {
+	d := &dryRunPolicies{names: make(map[string]bool), rejects: make(map[string]int64)}
+	for _, name := range names {
+		d.names[name] = true
+	}
+	return d
+}
+
+// wrap names a policy and returns it unchanged unless it is in dry-run mode or pubkeys may be
+// exempt from it. Every named policy is registered here, which makes it the shared place to
+// check the per-pubkey exemptions too.
+func (d *dryRunPolicies) wrap(name string, policy func(context.Context, *nostr.Event) (bool, string)) func(context.Context, *nostr.Event) (bool, string) {
+	d.mu.Lock()
+	d.known = append(d.known, name)
+	d.mu.Unlock()
+
+	if d.exemptions != nil {
+		policy = d.exemptions.wrap(name, policy)
+	}
+
+	if !d.names[name] {
+		return policy
+	}
+
+	log.Printf("Policy %s runs in dry-run mode: rejections are logged, not enforced", name)
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if reject, msg := policy(ctx, event); reject {
+			d.mu.Lock()
+			d.rejects[name]++
+			d.mu.Unlock()
+			if d.decisions != nil {
+				d.decisions.add(dryRunDecision{At: time.Now(), Policy: name, Pubkey: event.PubKey, Kind: event.Kind, EventID: event.ID, Reason: msg})
+			}
+			log.Printf("[dry-run] policy %s would reject event %s (kind %d, pubkey %s): %s", name, event.ID, event.Kind, event.PubKey, msg)
+		}
+		return false, ""
+	}
+}
+
+// enforces reports whether the policy registered under name rejects events for real.
+func (d *dryRunPolicies) enforces(name string) bool {
+	return !d.names[name]
+}
+
+// warnUnknown logs dry-run names that no policy was registered under, which are most likely typos.
+// Call it once every policy is set up.
+func (d *dryRunPolicies) warnUnknown() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.names {
+		if !slices.Contains(d.known, name) {
+			log.Printf("DRY_RUN_POLICIES names %q, but no such policy is enabled (enabled: %v)", name, d.known)
+		}
+	}
+}
+
+// metrics reports the would-be rejections of each dry-run policy.
+func (d *dryRunPolicies) metrics(w *bufio.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP brove_dry_run_rejections_total Events a dry-run policy would have rejected.")
+	fmt.Fprintln(w, "# TYPE brove_dry_run_rejections_total counter")
+	names := make([]string, 0, len(d.names))
+	for name := range d.names {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "brove_dry_run_rejections_total{policy=%q} %d\n", name, d.rejects[name])
+	}
+}
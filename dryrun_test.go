@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDryRunPolicies(t *testing.T) {
+	rejectAll := func(ctx context.Context, event *nostr.Event) (bool, string) {
+		return true, "blocked: no"
+	}
+	dryRun := newDryRunPolicies([]string{"strict"})
+	strict := dryRun.wrap("strict", rejectAll)
+	enforced := dryRun.wrap("enforced", rejectAll)
+	event := &nostr.Event{Kind: nostr.KindTextNote}
+
+	for range 2 {
+		if reject, msg := strict(context.Background(), event); reject {
+			t.Errorf("a dry-run policy must not reject, got %q", msg)
+		}
+	}
+	if reject, msg := enforced(context.Background(), event); !reject || msg != "blocked: no" {
+		t.Errorf("a policy not in dry-run mode must still reject, got (%t, %q)", reject, msg)
+	}
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	dryRun.metrics(w)
+	w.Flush()
+	if !strings.Contains(out.String(), `brove_dry_run_rejections_total{policy="strict"} 2`) {
+		t.Errorf("expected 2 counted dry-run rejections, got:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "enforced") {
+		t.Errorf("enforced policies should not be reported:\n%s", out.String())
+	}
+}
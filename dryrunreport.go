@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// dryRunDecision is one event a dry-run policy would have rejected.
+type dryRunDecision struct {
+	At      time.Time `json:"at"`
+	Policy  string    `json:"policy"`
+	Pubkey  string    `json:"pubkey"`
+	Kind    int       `json:"kind"`
+	EventID string    `json:"event_id"`
+	Reason  string    `json:"reason"`
+}
+
+// decisionLog keeps the latest dry-run decisions in a ring buffer of fixed size, so a busy
+// relay keeps a bounded window of them instead of all.
+type decisionLog struct {
+	mu        sync.Mutex
+	decisions []dryRunDecision
+	// next is where the next decision goes; once full, it is also the oldest one
+	next int
+	full bool
+}
+
+func newDecisionLog(size int) *decisionLog {
+	return &decisionLog{decisions: make([]dryRunDecision, size)}
+}
+
+func (l *decisionLog) add(decision dryRunDecision) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decisions[l.next] = decision
+	l.next = (l.next + 1) % len(l.decisions)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// between returns the kept decisions from since up to until, oldest first. A zero time leaves
+// that end open; an empty policy matches all.
+func (l *decisionLog) between(since, until time.Time, policy string) []dryRunDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ordered := l.decisions[:l.next]
+	if l.full {
+		ordered = append(append([]dryRunDecision{}, l.decisions[l.next:]...), l.decisions[:l.next]...)
+	}
+	result := []dryRunDecision{}
+	for _, decision := range ordered {
+		if (!since.IsZero() && decision.At.Before(since)) || (!until.IsZero() && decision.At.After(until)) {
+			continue
+		}
+		if policy != "" && decision.Policy != policy {
+			continue
+		}
+		result = append(result, decision)
+	}
+	return result
+}
+
+// writeDecisionsCSV writes decisions as CSV with a header row, timestamps in RFC 3339.
+func writeDecisionsCSV(w http.ResponseWriter, decisions []dryRunDecision) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="dry-run.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"at", "policy", "pubkey", "kind", "event_id", "reason"})
+	for _, d := range decisions {
+		out.Write([]string{d.At.UTC().Format(time.RFC3339), d.Policy, d.Pubkey, strconv.Itoa(d.Kind), d.EventID, d.Reason})
+	}
+	out.Flush()
+}
+
+// handleAdminDryRun serves GET /admin/dryrun: the kept would-reject decisions of the dry-run
+// policies, as JSON or, with ?format=csv, CSV. ?since= and ?until= (unix timestamps) narrow
+// down the window and ?policy= the policy.
+func handleAdminDryRun(kept *decisionLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		var window [2]time.Time
+		for i, name := range []string{"since", "until"} {
+			value := r.URL.Query().Get(name)
+			if value == "" {
+				continue
+			}
+			timestamp, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid " + name + ", must be a unix timestamp"})
+				return
+			}
+			window[i] = time.Unix(timestamp, 0)
+		}
+		decisions := kept.between(window[0], window[1], r.URL.Query().Get("policy"))
+
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			writeJSON(w, http.StatusOK, decisions)
+		case "csv":
+			writeDecisionsCSV(w, decisions)
+		default:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid format, must be json or csv"})
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDecisionLogKeepsTheLatest(t *testing.T) {
+	kept := newDecisionLog(3)
+	start := time.Unix(1000, 0)
+	for i := range 5 {
+		kept.add(dryRunDecision{At: start.Add(time.Duration(i) * time.Second), Policy: []string{"a", "b"}[i%2], Kind: i})
+	}
+
+	kinds := func(decisions []dryRunDecision) []int {
+		var kinds []int
+		for _, d := range decisions {
+			kinds = append(kinds, d.Kind)
+		}
+		return kinds
+	}
+	if got := kinds(kept.between(time.Time{}, time.Time{}, "")); len(got) != 3 || got[0] != 2 || got[2] != 4 {
+		t.Errorf("expected the 3 latest decisions oldest first, got %v", got)
+	}
+	if got := kinds(kept.between(start.Add(3*time.Second), time.Time{}, "")); len(got) != 2 || got[0] != 3 {
+		t.Errorf("expected decisions since the 4th, got %v", got)
+	}
+	if got := kinds(kept.between(time.Time{}, start.Add(3*time.Second), "a")); len(got) != 1 || got[0] != 2 {
+		t.Errorf("expected policy a until the 4th, got %v", got)
+	}
+}
+
+func TestAdminDryRunExport(t *testing.T) {
+	dryRun := newDryRunPolicies([]string{"strict"})
+	dryRun.decisions = newDecisionLog(10)
+	strict := dryRun.wrap("strict", func(ctx context.Context, event *nostr.Event) (bool, string) {
+		return true, `blocked: "quoted", with comma`
+	})
+	before := time.Now().Add(-time.Second).Unix()
+	strict(context.Background(), &nostr.Event{ID: fakeID(1), PubKey: fakeID(2), Kind: 7})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleAdminDryRun(dryRun.decisions)(rec, httptest.NewRequest(http.MethodGet, "/admin/dryrun"+query, nil))
+		return rec
+	}
+
+	var decisions []dryRunDecision
+	if err := json.NewDecoder(get("?since=" + strconv.FormatInt(before, 10)).Body).Decode(&decisions); err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 1 || decisions[0].Policy != "strict" || decisions[0].EventID != fakeID(1) || decisions[0].Pubkey != fakeID(2) || decisions[0].Kind != 7 {
+		t.Fatalf("unexpected decisions %+v", decisions)
+	}
+
+	rec := get("?format=csv")
+	if rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || len(rows[0]) != 6 || rows[0][0] != "at" || rows[1][1] != "strict" || rows[1][3] != "7" || rows[1][5] != `blocked: "quoted", with comma` {
+		t.Fatalf("unexpected csv %q", rows)
+	}
+	if _, err := time.Parse(time.RFC3339, rows[1][0]); err != nil {
+		t.Errorf("expected an RFC 3339 timestamp, got %q", rows[1][0])
+	}
+
+	var empty []dryRunDecision
+	if err := json.NewDecoder(get("?until=" + strconv.FormatInt(before, 10)).Body).Decode(&empty); err != nil || empty == nil || len(empty) != 0 {
+		t.Errorf("expected an empty list before the decision, got %v %v", empty, err)
+	}
+	for _, query := range []string{"?since=yesterday", "?format=xml"} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
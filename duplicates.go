@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// duplicateEntry counts the copies of one content hash seen in the current window.
+type duplicateEntry struct {
+	key         [32]byte
+	count       int
+	windowStart time.Time
+}
+
+// duplicateContent rejects content that was posted more than max times within window,
+// either by anyone or, with perPubkey, by the same author. Content is normalized before
+// hashing so small variations in case, spacing and punctuation do not make a copy new.
+// Only the cacheSize most recently seen hashes are remembered.
+type duplicateContent struct {
+	max       int
+	window    time.Duration
+	perPubkey bool
+	minLength int
+	cacheSize int
+
+	mu      sync.Mutex
+	entries map[[32]byte]*list.Element
+	recent  *list.List // most recently seen first
+}
+
+func newDuplicateContent(max int, window time.Duration, perPubkey bool, minLength, cacheSize int) *duplicateContent {
+	return &duplicateContent{
+		max:       max,
+		window:    window,
+		perPubkey: perPubkey,
+		minLength: minLength,
+		cacheSize: cacheSize,
+		entries:   make(map[[32]byte]*list.Element),
+		recent:    list.New(),
+	}
+}
+
+// normalizeContent lowercases content and keeps only its letters and digits.
+func normalizeContent(content string) string {
+	var b strings.Builder
+	for _, r := range content {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+func (d *duplicateContent) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	normalized := normalizeContent(event.Content)
+	if len(normalized) < d.minLength {
+		// short content like "+" reactions or "gm" repeats legitimately
+		return false, ""
+	}
+
+	hash := sha256.New()
+	if d.perPubkey {
+		hash.Write([]byte(event.PubKey))
+	}
+	hash.Write([]byte(normalized))
+	var key [32]byte
+	copy(key[:], hash.Sum(nil))
+
+	if d.seen(key, time.Now()) > d.max {
+		return true, "blocked: duplicate content spam"
+	}
+	return false, ""
+}
+
+// seen records one more copy of key and returns how many were seen in the current window.
+func (d *duplicateContent) seen(key [32]byte, now time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if element, ok := d.entries[key]; ok {
+		d.recent.MoveToFront(element)
+		entry := element.Value.(*duplicateEntry)
+		if now.Sub(entry.windowStart) > d.window {
+			entry.count, entry.windowStart = 0, now
+		}
+		entry.count++
+		return entry.count
+	}
+
+	d.entries[key] = d.recent.PushFront(&duplicateEntry{key: key, count: 1, windowStart: now})
+	if d.recent.Len() > d.cacheSize {
+		oldest := d.recent.Back()
+		d.recent.Remove(oldest)
+		delete(d.entries, oldest.Value.(*duplicateEntry).key)
+	}
+	return 1
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDuplicateContent(t *testing.T) {
+	spam := "Buy cheap sats now at example dot com!!"
+	post := func(d *duplicateContent, pubkey, content string) bool {
+		reject, _ := d.reject(context.Background(), &nostr.Event{PubKey: pubkey, Content: content})
+		return reject
+	}
+
+	t.Run("across pubkeys", func(t *testing.T) {
+		d := newDuplicateContent(2, time.Hour, false, 20, 100)
+		if post(d, "a", spam) || post(d, "b", "buy CHEAP sats now,  at example DOT com") {
+			t.Fatal("the first two copies must be accepted")
+		}
+		if !post(d, "c", "  BUY cheap sats now at example dot com ") {
+			t.Error("expected a normalized copy beyond the limit to be rejected")
+		}
+		if post(d, "d", "gm") || post(d, "d", "gm") || post(d, "d", "gm") {
+			t.Error("short content must never be counted")
+		}
+	})
+
+	t.Run("per pubkey", func(t *testing.T) {
+		d := newDuplicateContent(1, time.Hour, true, 20, 100)
+		if post(d, "a", spam) || post(d, "b", spam) {
+			t.Fatal("different authors must be counted separately")
+		}
+		if !post(d, "a", spam) {
+			t.Error("expected the second copy by the same author to be rejected")
+		}
+	})
+
+	t.Run("window and eviction", func(t *testing.T) {
+		d := newDuplicateContent(1, time.Minute, false, 0, 2)
+		now := time.Now()
+		key := [32]byte{1}
+		d.seen(key, now)
+		if count := d.seen(key, now.Add(2*time.Minute)); count != 1 {
+			t.Errorf("expected the count to restart after the window, got %d", count)
+		}
+		d.seen([32]byte{2}, now)
+		d.seen([32]byte{3}, now)
+		if count := d.seen(key, now.Add(2*time.Minute)); count != 1 {
+			t.Errorf("expected the least recently seen hash to be evicted, got count %d", count)
+		}
+	})
+}
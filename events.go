@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventCursor marks the last event of a page. Events are ordered newest first with the id
+// breaking ties, so a cursor identifies a position even among events sharing a created_at.
+type eventCursor struct {
+	CreatedAt nostr.Timestamp
+	ID        string
+}
+
+func (c eventCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.CreatedAt, c.ID)))
+}
+
+func decodeCursor(encoded string) (eventCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return eventCursor{}, fmt.Errorf("invalid cursor")
+	}
+	createdAt, id, ok := strings.Cut(string(raw), ":")
+	timestamp, err := strconv.ParseInt(createdAt, 10, 64)
+	if !ok || err != nil || !nostr.IsValid32ByteHex(id) {
+		return eventCursor{}, fmt.Errorf("invalid cursor")
+	}
+	return eventCursor{CreatedAt: nostr.Timestamp(timestamp), ID: id}, nil
+}
+
+// precedes reports whether event comes after the cursor in the (created_at DESC, id DESC) order.
+func (c eventCursor) precedes(event *nostr.Event) bool {
+	return event.CreatedAt < c.CreatedAt || event.CreatedAt == c.CreatedAt && event.ID < c.ID
+}
+
+// eventQuery is a page request of the HTTP event query API.
+type eventQuery struct {
+	Kinds   []int
+	Authors []string
+	Since   *nostr.Timestamp
+	Until   *nostr.Timestamp
+	After   *eventCursor
+	Limit   int
+}
+
+// storedEventPage runs an eventQuery against the event table. Gift wraps are never returned,
+// since the HTTP API does not know who their recipient is.
+func storedEventPage(db *sql.DB) func(ctx context.Context, q eventQuery) ([]*nostr.Event, error) {
+	return func(ctx context.Context, q eventQuery) ([]*nostr.Event, error) {
+		conditions := []string{fmt.Sprintf("kind <> %d", nostr.KindGiftWrap)}
+		var params []any
+		param := func(value any) string {
+			params = append(params, value)
+			return "$" + strconv.Itoa(len(params))
+		}
+		if len(q.Kinds) > 0 {
+			conditions = append(conditions, "kind = ANY("+param(pq.Array(q.Kinds))+")")
+		}
+		if len(q.Authors) > 0 {
+			conditions = append(conditions, "pubkey = ANY("+param(pq.Array(q.Authors))+")")
+		}
+		if q.Since != nil {
+			conditions = append(conditions, "created_at >= "+param(int64(*q.Since)))
+		}
+		if q.Until != nil {
+			conditions = append(conditions, "created_at <= "+param(int64(*q.Until)))
+		}
+		if q.After != nil {
+			conditions = append(conditions, "(created_at, id) < ("+param(int64(q.After.CreatedAt))+", "+param(q.After.ID)+")")
+		}
+		query := `SELECT id, pubkey, created_at, kind, tags, content, sig FROM event WHERE ` +
+			strings.Join(conditions, " AND ") +
+			` ORDER BY created_at DESC, id DESC LIMIT ` + param(q.Limit)
+
+		rows, err := db.QueryContext(ctx, query, params...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query events: %w", err)
+		}
+		defer rows.Close()
+
+		events := []*nostr.Event{}
+		for rows.Next() {
+			var event nostr.Event
+			var tags []byte
+			if err := rows.Scan(&event.ID, &event.PubKey, &event.CreatedAt, &event.Kind, &tags, &event.Content, &event.Sig); err != nil {
+				return nil, fmt.Errorf("failed to scan event row: %w", err)
+			}
+			if err := json.Unmarshal(tags, &event.Tags); err != nil {
+				return nil, fmt.Errorf("failed to decode tags of %s: %w", event.ID, err)
+			}
+			events = append(events, &event)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error occurred while iterating over event rows: %w", err)
+		}
+		return events, nil
+	}
+}
+
+// eventPage is the JSON response of GET /api/events.
+type eventPage struct {
+	Events     []*nostr.Event `json:"events"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// handleEvents serves GET /api/events with optional ?kinds=, ?authors= (comma-separated),
+// ?since=, ?until=, ?limit= and ?cursor=. Pages are ordered newest first; pass next_cursor
+// back as ?cursor= to get the next one, which is missing on the last page.
+func handleEvents(queryPage func(ctx context.Context, q eventQuery) ([]*nostr.Event, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		q, err := parseEventQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// one extra event tells whether there is another page
+		limit := q.Limit
+		q.Limit++
+		events, err := queryPage(r.Context(), q)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to query events"})
+			return
+		}
+
+		result := eventPage{Events: events}
+		if len(events) > limit {
+			result.Events = events[:limit]
+			last := result.Events[limit-1]
+			result.NextCursor = eventCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func parseEventQuery(r *http.Request) (eventQuery, error) {
+	params := r.URL.Query()
+	limit, _, err := parsePage(r)
+	if err != nil {
+		return eventQuery{}, err
+	}
+	q := eventQuery{Limit: limit}
+
+	for _, item := range strings.Split(params.Get("kinds"), ",") {
+		if item == "" {
+			continue
+		}
+		kind, err := strconv.Atoi(item)
+		if err != nil {
+			return eventQuery{}, fmt.Errorf("invalid kind %q", item)
+		}
+		q.Kinds = append(q.Kinds, kind)
+	}
+	for _, author := range strings.Split(params.Get("authors"), ",") {
+		if author == "" {
+			continue
+		}
+		if !nostr.IsValidPublicKey(author) {
+			return eventQuery{}, fmt.Errorf("invalid author %q", author)
+		}
+		q.Authors = append(q.Authors, author)
+	}
+	for name, target := range map[string]**nostr.Timestamp{"since": &q.Since, "until": &q.Until} {
+		if value := params.Get(name); value != "" {
+			timestamp, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return eventQuery{}, fmt.Errorf("invalid %s", name)
+			}
+			ts := nostr.Timestamp(timestamp)
+			*target = &ts
+		}
+	}
+	if value := params.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return eventQuery{}, err
+		}
+		q.After = &cursor
+	}
+	return q, nil
+}
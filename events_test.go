@@ -0,0 +1,95 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryEventPage answers event queries from a slice, in the order the event table uses.
+func memoryEventPage(stored []*nostr.Event) func(ctx context.Context, q eventQuery) ([]*nostr.Event, error) {
+	sorted := slices.Clone(stored)
+	slices.SortFunc(sorted, func(a, b *nostr.Event) int {
+		if a.CreatedAt != b.CreatedAt {
+			return cmp.Compare(b.CreatedAt, a.CreatedAt)
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return func(ctx context.Context, q eventQuery) ([]*nostr.Event, error) {
+		var page []*nostr.Event
+		for _, event := range sorted {
+			if len(q.Kinds) > 0 && !slices.Contains(q.Kinds, event.Kind) {
+				continue
+			}
+			if q.After != nil && !q.After.precedes(event) {
+				continue
+			}
+			if len(page) < q.Limit {
+				page = append(page, event)
+			}
+		}
+		return page, nil
+	}
+}
+
+func TestEventsPaginationWithTies(t *testing.T) {
+	// seven events, five of them sharing the same created_at
+	var stored []*nostr.Event
+	for i, createdAt := range []nostr.Timestamp{100, 200, 200, 200, 200, 200, 300} {
+		stored = append(stored, &nostr.Event{ID: fakeID(i), Kind: 1, CreatedAt: createdAt})
+	}
+	handler := handleEvents(memoryEventPage(stored))
+
+	var seen []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/api/events?kinds=1&limit=2&cursor="+cursor, nil))
+		if rec.Code != 200 {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var page struct {
+			Events     []nostr.Event `json:"events"`
+			NextCursor string        `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		for _, event := range page.Events {
+			seen = append(seen, event.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	want := []string{fakeID(6), fakeID(5), fakeID(4), fakeID(3), fakeID(2), fakeID(1), fakeID(0)}
+	if !slices.Equal(seen, want) {
+		t.Fatalf("expected every event exactly once, newest first, got %v", seen)
+	}
+}
+
+func TestEventsRejectsBadParameters(t *testing.T) {
+	handler := handleEvents(memoryEventPage(nil))
+	for _, query := range []string{"cursor=not-a-cursor", "kinds=one", "authors=abc", "since=yesterday", "limit=0"} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/api/events?"+query, nil))
+		if rec.Code != 400 {
+			t.Errorf("expected 400 for %s, got %d", query, rec.Code)
+		}
+	}
+
+	cursor := eventCursor{CreatedAt: 1700000000, ID: fakeID(1)}
+	if decoded, err := decodeCursor(cursor.encode()); err != nil || decoded != cursor {
+		t.Fatalf("expected the cursor to round-trip, got %+v, %v", decoded, err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// exemptionStore keeps which pubkeys are exempt from which policies.
+type exemptionStore interface {
+	AddPolicyExemption(pubkey, policy string) error
+	RemovePolicyExemption(pubkey, policy string) error
+	GetPolicyExemptions() (map[string][]string, error)
+}
+
+// policyExemptions exempts trusted pubkeys from named policies, e.g. the owner's bots from
+// rate_limit. Policies are named as in DRY_RUN_POLICIES. The exemptions are kept in memory and
+// written through to the store, so checking them costs no query.
+type policyExemptions struct {
+	store exemptionStore
+
+	mu     sync.RWMutex
+	exempt map[string]map[string]bool
+}
+
+// loadPolicyExemptions reads the stored exemptions.
+func loadPolicyExemptions(store exemptionStore) (*policyExemptions, error) {
+	stored, err := store.GetPolicyExemptions()
+	if err != nil {
+		return nil, err
+	}
+	e := &policyExemptions{store: store, exempt: make(map[string]map[string]bool)}
+	for pubkey, policies := range stored {
+		for _, policy := range policies {
+			e.set(pubkey, policy, true)
+		}
+	}
+	return e, nil
+}
+
+// exempts reports whether pubkey is exempt from the named policy.
+func (e *policyExemptions) exempts(pubkey, policy string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.exempt[pubkey][policy]
+}
+
+func (e *policyExemptions) set(pubkey, policy string, exempt bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !exempt {
+		delete(e.exempt[pubkey], policy)
+		if len(e.exempt[pubkey]) == 0 {
+			delete(e.exempt, pubkey)
+		}
+		return
+	}
+	if e.exempt[pubkey] == nil {
+		e.exempt[pubkey] = make(map[string]bool)
+	}
+	e.exempt[pubkey][policy] = true
+}
+
+// wrap makes the named policy let events of exempted authors through.
+func (e *policyExemptions) wrap(name string, policy func(context.Context, *nostr.Event) (bool, string)) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if e.exempts(event.PubKey, name) {
+			return false, ""
+		}
+		return policy(ctx, event)
+	}
+}
+
+// handleAdminExemptions serves /admin/exemptions: GET lists the exemptions by pubkey,
+// POST {"pubkey": "<hex>", "policy": "<name>"} adds one and DELETE with the same body removes it.
+func handleAdminExemptions(e *policyExemptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			exemptions, err := e.store.GetPolicyExemptions()
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, exemptions)
+			return
+		}
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		var body struct {
+			Pubkey string `json:"pubkey"`
+			Policy string `json:"policy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json body"})
+			return
+		}
+		if err := checkDryRunPolicy(body.Policy); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if err := e.store.AddPolicyExemption(body.Pubkey, body.Policy); err != nil {
+				writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			e.set(body.Pubkey, body.Policy, true)
+		} else {
+			err := e.store.RemovePolicyExemption(body.Pubkey, body.Policy)
+			if err != nil && !errors.Is(err, ErrPubkeyNotFound) {
+				writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			e.set(body.Pubkey, body.Policy, false)
+			if err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"pubkey": body.Pubkey, "policy": body.Policy})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryExemptions is an in-memory exemptionStore.
+type memoryExemptions map[string][]string
+
+func (m memoryExemptions) AddPolicyExemption(pubkey, policy string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+	if !slices.Contains(m[pubkey], policy) {
+		m[pubkey] = append(m[pubkey], policy)
+	}
+	return nil
+}
+
+func (m memoryExemptions) RemovePolicyExemption(pubkey, policy string) error {
+	i := slices.Index(m[pubkey], policy)
+	if i < 0 {
+		return ErrPubkeyNotFound
+	}
+	m[pubkey] = slices.Delete(m[pubkey], i, i+1)
+	return nil
+}
+
+func (m memoryExemptions) GetPolicyExemptions() (map[string][]string, error) {
+	return m, nil
+}
+
+func TestPolicyExemptions(t *testing.T) {
+	_, bot := newKeypair(t)
+	_, member := newKeypair(t)
+	exemptions, err := loadPolicyExemptions(memoryExemptions{bot: {"rate_limit"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dryRun := newDryRunPolicies([]string{"min_followers"})
+	dryRun.exemptions = exemptions
+	refuse := func(context.Context, *nostr.Event) (bool, string) { return true, "rate-limited: slow down" }
+	rateLimit := dryRun.wrap("rate_limit", refuse)
+	maxTags := dryRun.wrap("max_event_tags", refuse)
+
+	if reject, _ := rateLimit(context.Background(), &nostr.Event{PubKey: bot}); reject {
+		t.Error("expected the exempted bot to skip the rate limit")
+	}
+	if reject, _ := rateLimit(context.Background(), &nostr.Event{PubKey: member}); !reject {
+		t.Error("expected everyone else to be rate limited")
+	}
+	if reject, _ := maxTags(context.Background(), &nostr.Event{PubKey: bot}); !reject {
+		t.Error("expected the bot to stay subject to the policies it is not exempt from")
+	}
+}
+
+func TestHandleAdminExemptions(t *testing.T) {
+	_, bot := newKeypair(t)
+	store := memoryExemptions{}
+	exemptions, _ := loadPolicyExemptions(store)
+	handler := handleAdminExemptions(exemptions)
+	send := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(method, "/admin/exemptions", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := send(http.MethodPost, `{"pubkey":"`+bot+`","policy":"rate_limit"}`); rec.Code != http.StatusOK || !exemptions.exempts(bot, "rate_limit") {
+		t.Fatalf("expected the exemption to be added, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(http.MethodGet, ""); !strings.Contains(rec.Body.String(), `"rate_limit"`) {
+		t.Errorf("expected the exemption to be listed, got %s", rec.Body.String())
+	}
+	if rec := send(http.MethodPost, `{"pubkey":"`+bot+`","policy":"no_such_policy"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown policies to be refused, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, `{"pubkey":"npub1xyz","policy":"rate_limit"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid pubkeys to be refused, got %d", rec.Code)
+	}
+
+	if rec := send(http.MethodDelete, `{"pubkey":"`+bot+`","policy":"rate_limit"}`); rec.Code != http.StatusOK || exemptions.exempts(bot, "rate_limit") {
+		t.Fatalf("expected the exemption to be removed, got %d", rec.Code)
+	}
+	if rec := send(http.MethodDelete, `{"pubkey":"`+bot+`","policy":"rate_limit"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("expected removing a missing exemption to be a 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// expiryNotification is the webhook payload listing allowlist entries that are about to expire.
+type expiryNotification struct {
+	Type    string           `json:"type"`
+	Pubkeys []ExpiringPubkey `json:"pubkeys"`
+}
+
+// startExpiryNotifier checks every interval for allowed pubkeys expiring within leadTime
+// and reports each of them once, through the webhook if configured and always in the log.
+// If sendDM is not nil, the affected users are also sent a reminder.
+func startExpiryNotifier(ctx context.Context, dbManager *DBManager, leadTime, interval time.Duration, webhookURL string, sendDM func(context.Context, ExpiringPubkey) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			notifyExpiringPubkeys(ctx, dbManager, leadTime, webhookURL, sendDM)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func notifyExpiringPubkeys(ctx context.Context, dbManager *DBManager, leadTime time.Duration, webhookURL string, sendDM func(context.Context, ExpiringPubkey) error) {
+	expiring, err := dbManager.GetExpiringPubkeys(leadTime)
+	if err != nil {
+		log.Printf("Error checking for expiring pubkeys: %v", err)
+		return
+	}
+	if len(expiring) == 0 {
+		return
+	}
+
+	pubkeys := make([]string, 0, len(expiring))
+	for _, entry := range expiring {
+		log.Printf("Warning: access for pubkey %s expires at %s", entry.Pubkey, entry.ExpiresAt.Format(time.RFC3339))
+		pubkeys = append(pubkeys, entry.Pubkey)
+	}
+
+	if webhookURL != "" {
+		notification := expiryNotification{Type: "allowlist_expiring", Pubkeys: expiring}
+		if err := postWebhook(webhookURL, notification); err != nil {
+			// leave the entries unmarked so the next check tries again
+			log.Printf("Error sending expiry notification webhook: %v", err)
+			return
+		}
+	}
+
+	if sendDM != nil {
+		for _, entry := range expiring {
+			// a failed reminder is only logged, retrying would spam everyone else in the batch
+			if err := sendDM(ctx, entry); err != nil {
+				log.Printf("Error sending expiry reminder: %v", err)
+			}
+		}
+	}
+
+	if err := dbManager.MarkExpiryNotified(pubkeys); err != nil {
+		log.Printf("Error marking expiry notifications as sent: %v", err)
+	}
+}
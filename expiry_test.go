@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// allowlistRow is a row of the allowed_pubkeys table.
+type allowlistRow struct {
+	created        int
+	reason         string
+	expiresAt      *time.Time
+	expiryNotified bool
+}
+
+// memoryAllowlist plays the allowed_pubkeys table for the allowlist and expiry statements, applying their
+// conditions the way postgres would with now as NOW().
+type memoryAllowlist struct {
+	mu   sync.Mutex
+	now  time.Time
+	rows map[string]*allowlistRow
+}
+
+func (m *memoryAllowlist) Connect(ctx context.Context) (driver.Conn, error) {
+	return &memoryAllowlistConn{table: m}, nil
+}
+
+func (m *memoryAllowlist) Driver() driver.Driver { return nil }
+
+func (m *memoryAllowlist) dbManager() *DBManager {
+	return &DBManager{db: sql.OpenDB(m)}
+}
+
+type memoryAllowlistConn struct{ table *memoryAllowlist }
+
+func (c *memoryAllowlistConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (c *memoryAllowlistConn) Close() error              { return nil }
+func (c *memoryAllowlistConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("not supported") }
+
+func (c *memoryAllowlistConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	m := c.table
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO allowed_pubkeys (pubkey, reason) VALUES ($1, $2) ON CONFLICT (pubkey) DO UPDATE SET expires_at = NULL, expiry_notified = FALSE"):
+		pubkey := args[0].Value.(string)
+		if row, ok := m.rows[pubkey]; ok {
+			row.expiresAt, row.expiryNotified = nil, false
+		} else {
+			m.rows[pubkey] = &allowlistRow{created: len(m.rows), reason: args[1].Value.(string)}
+		}
+		return driver.RowsAffected(1), nil
+	case query == "UPDATE allowed_pubkeys SET expires_at = $2, expiry_notified = FALSE WHERE pubkey = $1":
+		row, ok := m.rows[args[0].Value.(string)]
+		if !ok {
+			return driver.RowsAffected(0), nil
+		}
+		row.expiresAt, row.expiryNotified = nil, false
+		if expiresAt, ok := args[1].Value.(time.Time); ok {
+			row.expiresAt = &expiresAt
+		}
+		return driver.RowsAffected(1), nil
+	case query == "UPDATE allowed_pubkeys SET expiry_notified = TRUE WHERE pubkey = ANY($1)":
+		var updated int64
+		for _, pubkey := range strings.Split(strings.Trim(args[0].Value.(string), "{}"), ",") {
+			if row, ok := m.rows[strings.Trim(pubkey, `"`)]; ok {
+				row.expiryNotified = true
+				updated++
+			}
+		}
+		return driver.RowsAffected(updated), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %q", query)
+}
+
+func (c *memoryAllowlistConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	m := c.table
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case query == "SELECT pubkey FROM allowed_pubkeys ORDER BY created_at":
+		pubkeys := slices.Collect(maps.Keys(m.rows))
+		slices.SortFunc(pubkeys, func(a, b string) int { return m.rows[a].created - m.rows[b].created })
+		rows := &memoryRows{columns: []string{"pubkey"}}
+		for _, pubkey := range pubkeys {
+			rows.values = append(rows.values, []driver.Value{pubkey})
+		}
+		return rows, nil
+	case strings.HasPrefix(query, "SELECT EXISTS(SELECT 1 FROM allowed_pubkeys WHERE pubkey = $1 AND (expires_at IS NULL OR expires_at > NOW()))"):
+		row, ok := m.rows[args[0].Value.(string)]
+		allowed := ok && (row.expiresAt == nil || row.expiresAt.After(m.now))
+		return &memoryRows{columns: []string{"exists"}, values: [][]driver.Value{{allowed}}}, nil
+	case strings.HasPrefix(query, "SELECT pubkey, expires_at FROM allowed_pubkeys WHERE expires_at IS NOT NULL AND NOT expiry_notified"):
+		lead := time.Duration(args[0].Value.(float64) * float64(time.Second))
+		var expiring []ExpiringPubkey
+		for pubkey, row := range m.rows {
+			if row.expiresAt == nil || row.expiryNotified || !row.expiresAt.After(m.now) || row.expiresAt.After(m.now.Add(lead)) {
+				continue
+			}
+			expiring = append(expiring, ExpiringPubkey{Pubkey: pubkey, ExpiresAt: *row.expiresAt})
+		}
+		slices.SortFunc(expiring, func(a, b ExpiringPubkey) int { return a.ExpiresAt.Compare(b.ExpiresAt) })
+		rows := &memoryRows{columns: []string{"pubkey", "expires_at"}}
+		for _, entry := range expiring {
+			rows.values = append(rows.values, []driver.Value{entry.Pubkey, entry.ExpiresAt})
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+// memoryRows are the rows of a query answered in memory.
+type memoryRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *memoryRows) Columns() []string { return r.columns }
+func (r *memoryRows) Close() error      { return nil }
+
+func (r *memoryRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestAllowlistEntryExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0).UTC()
+	expired, renewed := now.Add(-time.Minute), now.Add(-time.Hour)
+	later := now.Add(time.Hour)
+	table := &memoryAllowlist{now: now, rows: map[string]*allowlistRow{
+		fakeID(1): {},
+		fakeID(2): {expiresAt: &later},
+		fakeID(3): {expiresAt: &expired},
+		fakeID(4): {expiresAt: &renewed, expiryNotified: true},
+	}}
+	dbm := table.dbManager()
+
+	for pubkey, want := range map[string]bool{fakeID(1): true, fakeID(2): true, fakeID(3): false, fakeID(4): false, fakeID(5): false} {
+		if allowed, err := dbm.IsAllowedPubkey(pubkey); err != nil || allowed != want {
+			t.Errorf("%s: expected allowed %v, got %v, %v", pubkey, want, allowed, err)
+		}
+	}
+
+	// allowing an expired pubkey again renews its access for good
+	if err := dbm.AddAllowedPubkey(fakeID(4), "renewed"); err != nil {
+		t.Fatalf("failed to allow again: %v", err)
+	}
+	if row := table.rows[fakeID(4)]; row.expiresAt != nil || row.expiryNotified {
+		t.Errorf("expected the renewal to clear the expiry, got %+v", row)
+	}
+	if allowed, _ := dbm.IsAllowedPubkey(fakeID(4)); !allowed {
+		t.Error("expected the renewed pubkey to be allowed")
+	}
+}
+
+func TestNotifyExpiringPubkeys(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0).UTC()
+	soon, sooner, later, expired := now.Add(20*time.Hour), now.Add(2*time.Hour), now.Add(48*time.Hour), now.Add(-time.Hour)
+	table := &memoryAllowlist{now: now, rows: map[string]*allowlistRow{
+		fakeID(1): {expiresAt: &soon},
+		fakeID(2): {expiresAt: &sooner},
+		fakeID(3): {expiresAt: &later},
+		fakeID(4): {expiresAt: &expired},
+		fakeID(5): {},
+	}}
+	dbm := table.dbManager()
+
+	var (
+		mu       sync.Mutex
+		status   = http.StatusOK
+		payloads []expiryNotification
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var payload expiryNotification
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			t.Errorf("expected a JSON payload, got %s", r.Header.Get("Content-Type"))
+		}
+		payloads = append(payloads, payload)
+		w.WriteHeader(status)
+	}))
+	defer webhook.Close()
+
+	var reminded []string
+	sendDM := func(ctx context.Context, entry ExpiringPubkey) error {
+		reminded = append(reminded, entry.Pubkey)
+		return fmt.Errorf("no relays for %s", entry.Pubkey)
+	}
+	notify := func() { notifyExpiringPubkeys(context.Background(), dbm, 24*time.Hour, webhook.URL, sendDM) }
+
+	// a failing webhook leaves the entries to be reported by the next check
+	status = http.StatusBadGateway
+	notify()
+	if len(payloads) != 1 || len(reminded) != 0 || table.rows[fakeID(1)].expiryNotified {
+		t.Fatalf("expected nothing to be marked after the webhook failed, got %d payloads, reminded %v", len(payloads), reminded)
+	}
+
+	status = http.StatusOK
+	notify()
+	want := []ExpiringPubkey{{Pubkey: fakeID(2), ExpiresAt: sooner}, {Pubkey: fakeID(1), ExpiresAt: soon}}
+	if len(payloads) != 2 || payloads[1].Type != "allowlist_expiring" || !slices.EqualFunc(payloads[1].Pubkeys, want, func(a, b ExpiringPubkey) bool {
+		return a.Pubkey == b.Pubkey && a.ExpiresAt.Equal(b.ExpiresAt)
+	}) {
+		t.Fatalf("expected the entries within the lead time, soonest first, got %+v", payloads)
+	}
+	// failed reminders are only logged, the entries are still marked
+	if !slices.Equal(reminded, []string{fakeID(2), fakeID(1)}) || !table.rows[fakeID(1)].expiryNotified || !table.rows[fakeID(2)].expiryNotified {
+		t.Fatalf("expected both entries to be reminded and marked, got %v", reminded)
+	}
+	if table.rows[fakeID(3)].expiryNotified || table.rows[fakeID(4)].expiryNotified {
+		t.Error("expected entries outside the lead time to stay unmarked")
+	}
+
+	// every entry is reported once
+	notify()
+	if len(payloads) != 2 || len(reminded) != 2 {
+		t.Errorf("expected no second notification, got %d payloads", len(payloads))
+	}
+
+	// a new expiry is reported again
+	if err := dbm.SetAllowedPubkeyExpiry(fakeID(1), now.Add(time.Hour)); err != nil {
+		t.Fatalf("failed to set expiry: %v", err)
+	}
+	notify()
+	if len(payloads) != 3 || len(payloads[2].Pubkeys) != 1 || payloads[2].Pubkeys[0].Pubkey != fakeID(1) {
+		t.Errorf("expected the changed expiry to be reported, got %+v", payloads)
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(body) != `{"type":"test"}` {
+			t.Errorf("expected the payload to be posted, got %s %s", r.Method, body)
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	payload := map[string]string{"type": "test"}
+	if err := postWebhook(server.URL, payload); err != nil {
+		t.Errorf("expected the webhook to succeed, got %v", err)
+	}
+	if err := postWebhook(server.URL+"/fail", payload); err == nil || err.Error() != "webhook returned status 500" {
+		t.Errorf("expected the status to be reported, got %v", err)
+	}
+	if err := postWebhook(server.URL, func() {}); err == nil || !strings.Contains(err.Error(), "failed to encode") {
+		t.Errorf("expected unencodable payloads to fail, got %v", err)
+	}
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	if err := postWebhook(unreachable.URL, payload); err == nil || !strings.Contains(err.Error(), "failed to call webhook") {
+		t.Errorf("expected an unreachable webhook to fail, got %v", err)
+	}
+}
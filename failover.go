@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The failover retries of a DBManager unless configured otherwise: a short pause gives a
+// promoted standby or a proxy in front of it time to take connections.
+const (
+	defaultFailoverRetries = 2
+	defaultFailoverDelay   = 500 * time.Millisecond
+)
+
+// poolMaxIdleConns is the number of idle connections the pool keeps, database/sql's default,
+// restored after a reset.
+const poolMaxIdleConns = 2
+
+// isConnectionError reports whether err means the connection to postgres broke rather than
+// the statement failing, as happens to every pooled connection when postgres fails over.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", // admin_shutdown, the server is going away
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now, e.g. still starting up after a promotion
+			return true
+		}
+		// connection_exception and its subclasses
+		return pqErr.Code.Class() == "08"
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr)
+}
+
+// withFailover runs a read, and when it fails on a broken connection resets the pool and
+// runs it again, up to failoverRetries times, failoverDelay apart. After a failover all idle
+// connections point at the old primary, so the reset makes the retry open a new one instead
+// of failing on the next stale connection. Writes are not retried, since a write may have
+// been committed before its connection broke.
+func (dbm *DBManager) withFailover(read func() error) error {
+	err := read()
+	for attempt := 1; attempt <= dbm.failoverRetries && isConnectionError(err); attempt++ {
+		log.Printf("Database connection lost (%v), resetting the pool and retrying (%d/%d)", err, attempt, dbm.failoverRetries)
+		dbm.resetPool()
+		time.Sleep(dbm.failoverDelay)
+		err = read()
+	}
+	return err
+}
+
+// resetPool closes the idle connections of the pool.
+func (dbm *DBManager) resetPool() {
+	dbm.db.SetMaxIdleConns(0)
+	dbm.db.SetMaxIdleConns(poolMaxIdleConns)
+}
+
+// query runs a query with the failover retries. Only errors running it are retried, not
+// those while reading the rows.
+func (dbm *DBManager) query(query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := dbm.withFailover(func() error {
+		var err error
+		rows, err = dbm.db.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// queryRow runs a single-row query with the failover retries and scans it into dest.
+func (dbm *DBManager) queryRow(query string, args []any, dest ...any) error {
+	return dbm.withFailover(func() error {
+		return dbm.db.QueryRow(query, args...).Scan(dest...)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// flakyConnector hands out connections that fail queries with err while failures are left,
+// like the pooled connections of a postgres that just failed over.
+type flakyConnector struct {
+	mu       sync.Mutex
+	err      error
+	failures int
+	queries  int
+}
+
+func (c *flakyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &flakyConn{connector: c}, nil
+}
+
+func (c *flakyConnector) Driver() driver.Driver { return nil }
+
+type flakyConn struct {
+	connector *flakyConnector
+	broken    bool
+}
+
+func (c *flakyConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *flakyConn) Close() error              { return nil }
+func (c *flakyConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+// IsValid keeps the pool from reusing a connection that broke.
+func (c *flakyConn) IsValid() bool { return !c.broken }
+
+func (c *flakyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.connector.mu.Lock()
+	defer c.connector.mu.Unlock()
+	c.connector.queries++
+	if c.connector.failures > 0 {
+		c.connector.failures--
+		c.broken = true
+		return nil, c.connector.err
+	}
+	return &existsRows{}, nil
+}
+
+// existsRows is the result of a SELECT EXISTS(...) that found a row.
+type existsRows struct{ done bool }
+
+func (r *existsRows) Columns() []string { return []string{"exists"} }
+func (r *existsRows) Close() error      { return nil }
+
+func (r *existsRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = true
+	return nil
+}
+
+func TestDBManagerRetriesDroppedConnection(t *testing.T) {
+	// the connection is dropped while the query runs, after it was sent
+	dropped := fmt.Errorf("read tcp 10.0.0.2:5432: %w", io.ErrUnexpectedEOF)
+
+	cases := []struct {
+		name     string
+		err      error
+		failures int
+		allowed  bool
+		queries  int
+	}{
+		{"one stale connection", dropped, 1, true, 2},
+		{"every retry on a stale connection", dropped, 2, true, 3},
+		{"failover takes longer than the retries", dropped, 3, false, 3},
+		{"server shutting down", &pq.Error{Code: "57P01", Message: "terminating connection due to administrator command"}, 1, true, 2},
+		{"statement error", &pq.Error{Code: "42P01", Message: `relation "allowed_pubkeys" does not exist`}, 1, false, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			connector := &flakyConnector{err: c.err, failures: c.failures}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+			dbm := &DBManager{db: db, failoverRetries: 2, failoverDelay: time.Millisecond}
+
+			allowed, err := dbm.IsAllowedPubkey(fakeID(1))
+			if allowed != c.allowed || (err == nil) != c.allowed {
+				t.Errorf("expected allowed %v, got %v, %v", c.allowed, allowed, err)
+			}
+			if connector.queries != c.queries {
+				t.Errorf("expected %d queries, got %d", c.queries, connector.queries)
+			}
+		})
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	cases := map[error]bool{
+		driver.ErrBadConn: true,
+		fmt.Errorf("failed to check: %w", io.ErrUnexpectedEOF):                  true,
+		&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}:           true,
+		fmt.Errorf("failed: %w", &pq.Error{Code: "08006"}):                      true,
+		&pq.Error{Code: "57P03", Message: "the database system is starting up"}: true,
+		&pq.Error{Code: "23505", Message: "duplicate key value"}:                false,
+		sql.ErrNoRows: false,
+		nil:           false,
+	}
+	for err, want := range cases {
+		if got := isConnectionError(err); got != want {
+			t.Errorf("%v: expected %v, got %v", err, want, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// federationForwardAttempts is how often an allowlist change is sent to a peer before it is
+// given up.
+const federationForwardAttempts = 5
+
+// federation links this relay to a cluster of sibling relays. The peers are listed in the
+// NIP-11 document for clients to discover, and allowlist changes made through the management
+// API can be forwarded to them. Changes that come from a peer are applied but not forwarded
+// again, so peers that forward to each other don't loop.
+type federation struct {
+	peers []string
+	// trusted are the pubkeys of peer relays that may allow and ban pubkeys here
+	trusted []string
+	owner   string
+
+	// forward calls a management method on a peer; nil when changes are not forwarded
+	forward func(ctx context.Context, peer, method string, params []any) error
+	// ctx bounds the forwarding, which outlives the management call that caused it
+	ctx     context.Context
+	backoff time.Duration
+}
+
+// managementForwarder forwards through the NIP-86 API of the peers, signed with secretKey.
+func managementForwarder(secretKey string) func(ctx context.Context, peer, method string, params []any) error {
+	return func(ctx context.Context, peer, method string, params []any) error {
+		return newManagementClient(peer, secretKey).call(ctx, method, params, nil)
+	}
+}
+
+func (f *federation) nip11(r *http.Request, doc map[string]any) {
+	if len(f.peers) > 0 {
+		doc["federation_peers"] = f.peers
+	}
+}
+
+// fromPeer reports whether a management call was made by a trusted peer relay.
+func (f *federation) fromPeer(ctx context.Context) bool {
+	if f == nil {
+		return false
+	}
+	caller := getAuthed(ctx)
+	return caller != "" && slices.Contains(f.trusted, caller)
+}
+
+// access lets trusted peers allow and ban pubkeys, except the owner, and leaves every other
+// call to next.
+func (f *federation) access(next func(context.Context, nip86.MethodParams) (bool, string)) func(context.Context, nip86.MethodParams) (bool, string) {
+	return func(ctx context.Context, mp nip86.MethodParams) (reject bool, msg string) {
+		if !f.fromPeer(ctx) {
+			return next(ctx, mp)
+		}
+		switch params := mp.(type) {
+		case nip86.AllowPubKey:
+			return false, ""
+		case nip86.BanPubKey:
+			if params.PubKey == f.owner {
+				return true, "peers cannot ban the relay owner"
+			}
+			return false, ""
+		}
+		return next(ctx, mp)
+	}
+}
+
+// wrapAllow forwards pubkeys allowed here to the peers. With approvals, a pubkey is only
+// forwarded once it is actually allowed.
+func (f *federation) wrapAllow(allow func(ctx context.Context, pubkey, reason string) error, isAllowed func(pubkey string) (bool, error)) func(ctx context.Context, pubkey, reason string) error {
+	return func(ctx context.Context, pubkey, reason string) error {
+		if err := allow(ctx, pubkey, reason); err != nil || f.fromPeer(ctx) {
+			return err
+		}
+		if allowed, err := isAllowed(pubkey); err != nil || !allowed {
+			return nil
+		}
+		f.send("allowpubkey", pubkey, reason)
+		return nil
+	}
+}
+
+// wrapBan forwards pubkeys banned here to the peers.
+func (f *federation) wrapBan(ban func(ctx context.Context, pubkey, reason string) error) func(ctx context.Context, pubkey, reason string) error {
+	return func(ctx context.Context, pubkey, reason string) error {
+		if err := ban(ctx, pubkey, reason); err != nil || f.fromPeer(ctx) {
+			return err
+		}
+		f.send("banpubkey", pubkey, reason)
+		return nil
+	}
+}
+
+// send calls method on every peer in the background, retrying with exponential backoff.
+// Pending retries are not kept across restarts.
+func (f *federation) send(method, pubkey, reason string) {
+	for _, peer := range f.peers {
+		go func() {
+			delay := f.backoff
+			for attempt := 1; ; attempt++ {
+				ctx, cancel := context.WithTimeout(f.ctx, mirrorPublishTimeout)
+				err := f.forward(ctx, peer, method, []any{pubkey, reason})
+				cancel()
+				if err == nil {
+					log.Printf("Federation: forwarded %s %s to %s", method, pubkey, peer)
+					return
+				}
+				if attempt == federationForwardAttempts {
+					log.Printf("Federation: failed to forward %s %s to %s after %d attempts: %v", method, pubkey, peer, attempt, err)
+					return
+				}
+				select {
+				case <-f.ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				delay *= 2
+			}
+		}()
+	}
+}
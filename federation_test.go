@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+func TestFederationAccess(t *testing.T) {
+	fed := &federation{owner: "owner", trusted: []string{"peer"}}
+	access := fed.access(func(ctx context.Context, mp nip86.MethodParams) (bool, string) {
+		return true, "go away, intruder"
+	})
+
+	tests := []struct {
+		caller string
+		call   nip86.MethodParams
+		reject bool
+		msg    string
+	}{
+		{"peer", nip86.AllowPubKey{PubKey: "alice"}, false, ""},
+		{"peer", nip86.BanPubKey{PubKey: "alice"}, false, ""},
+		{"peer", nip86.BanPubKey{PubKey: "owner"}, true, "peers cannot ban the relay owner"},
+		{"peer", nip86.ChangeRelayName{Name: "mine"}, true, "go away, intruder"},
+		{"stranger", nip86.AllowPubKey{PubKey: "alice"}, true, "go away, intruder"},
+	}
+	for _, tt := range tests {
+		withAuthed(t, tt.caller)
+		if reject, msg := access(context.Background(), tt.call); reject != tt.reject || msg != tt.msg {
+			t.Errorf("%s calling %s: expected (%t, %q), got (%t, %q)", tt.caller, tt.call.MethodName(), tt.reject, tt.msg, reject, msg)
+		}
+	}
+
+	doc := map[string]any{}
+	(&federation{peers: []string{"wss://eu.example.com"}}).nip11(httptest.NewRequest("GET", "/", nil), doc)
+	if peers, ok := doc["federation_peers"].([]string); !ok || len(peers) != 1 || peers[0] != "wss://eu.example.com" {
+		t.Errorf("expected the peers in NIP-11, got %v", doc)
+	}
+}
+
+// forwardedCall is one management call sent to a peer.
+type forwardedCall struct {
+	peer, method, pubkey string
+}
+
+func TestFederationForwardsAllowlistChanges(t *testing.T) {
+	var mu sync.Mutex
+	var calls []forwardedCall
+	failures := map[string]int{"wss://flaky.example.com": 2}
+	done := make(chan struct{}, 10)
+	fed := &federation{
+		peers:   []string{"wss://eu.example.com", "wss://flaky.example.com"},
+		trusted: []string{"peer"},
+		ctx:     context.Background(),
+		backoff: time.Millisecond,
+		forward: func(ctx context.Context, peer, method string, params []any) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if failures[peer] > 0 {
+				failures[peer]--
+				return errors.New("connection refused")
+			}
+			calls = append(calls, forwardedCall{peer, method, params[0].(string)})
+			done <- struct{}{}
+			return nil
+		},
+	}
+	allowed := map[string]bool{}
+	allow := fed.wrapAllow(func(ctx context.Context, pubkey, reason string) error {
+		if pubkey != "pending" {
+			allowed[pubkey] = true
+		}
+		return nil
+	}, func(pubkey string) (bool, error) { return allowed[pubkey], nil })
+	ban := fed.wrapBan(func(ctx context.Context, pubkey, reason string) error { return nil })
+
+	// changes coming from a peer and pubkeys still waiting for approvals are not forwarded
+	withAuthed(t, "peer")
+	allow(context.Background(), "carol", "")
+	ban(context.Background(), "carol", "")
+	withAuthed(t, "owner")
+	allow(context.Background(), "pending", "")
+
+	allow(context.Background(), "alice", "friend")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected the change to be forwarded to both peers")
+		}
+	}
+	ban(context.Background(), "bob", "spam")
+	for i := 0; i < 2; i++ {
+		<-done
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 4 {
+		t.Fatalf("expected 4 forwarded calls, got %v", calls)
+	}
+	counts := map[forwardedCall]int{}
+	for _, call := range calls {
+		counts[call]++
+	}
+	for _, peer := range fed.peers {
+		if counts[forwardedCall{peer, "allowpubkey", "alice"}] != 1 || counts[forwardedCall{peer, "banpubkey", "bob"}] != 1 {
+			t.Errorf("expected alice allowed and bob banned on %s, got %v", peer, calls)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// followerCountLookup returns how many stored contact lists (kind 3) include pubkey.
+type followerCountLookup func(ctx context.Context, pubkey string) (int, error)
+
+// storedFollowerCount counts followers in the event store. Contact lists are replaceable,
+// so each follower has at most one stored list and no DISTINCT is needed.
+func storedFollowerCount(db *sql.DB) followerCountLookup {
+	return func(ctx context.Context, pubkey string) (int, error) {
+		var count int
+		query := `SELECT COUNT(*) FROM event WHERE kind = 3 AND tagvalues @> ARRAY[$1]`
+		if err := db.QueryRowContext(ctx, query, pubkey).Scan(&count); err != nil {
+			return 0, fmt.Errorf("failed to count followers of %s: %w", pubkey, err)
+		}
+		return count, nil
+	}
+}
+
+type followerCountEntry struct {
+	count     int
+	checkedAt time.Time
+}
+
+// followerGate rejects writers with fewer than min followers among the contact lists stored
+// on this relay. Counts are cached for ttl and bumped as new contact lists are saved, so
+// they are approximate between lookups.
+type followerGate struct {
+	lookup    followerCountLookup
+	min       int
+	ttl       time.Duration
+	isAllowed func(pubkey string) (bool, error)
+
+	mu    sync.Mutex
+	cache map[string]followerCountEntry
+}
+
+func newFollowerGate(lookup followerCountLookup, min int, ttl time.Duration, isAllowed func(pubkey string) (bool, error)) *followerGate {
+	return &followerGate{
+		lookup:    lookup,
+		min:       min,
+		ttl:       ttl,
+		isAllowed: isAllowed,
+		cache:     make(map[string]followerCountEntry),
+	}
+}
+
+func (g *followerGate) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	allowed, err := g.isAllowed(event.PubKey)
+	if err != nil {
+		log.Printf("Error checking if pubkey is allowed: %v", err)
+		return true, "error checking authorization"
+	}
+	if allowed {
+		return false, ""
+	}
+
+	now := time.Now()
+	g.mu.Lock()
+	entry, ok := g.cache[event.PubKey]
+	g.mu.Unlock()
+
+	if !ok || now.Sub(entry.checkedAt) > g.ttl {
+		count, err := g.lookup(ctx, event.PubKey)
+		if err != nil {
+			log.Printf("Error looking up follower count: %v", err)
+			return true, "error: could not verify reputation, try again later"
+		}
+		entry = followerCountEntry{count: count, checkedAt: now}
+		g.mu.Lock()
+		g.cache[event.PubKey] = entry
+		g.mu.Unlock()
+	}
+
+	if entry.count < g.min {
+		return true, "blocked: insufficient reputation"
+	}
+	return false, ""
+}
+
+// onSaved counts a new follower for every cached pubkey in a saved contact list.
+func (g *followerGate) onSaved(ctx context.Context, event *nostr.Event) {
+	if event.Kind != nostr.KindFollowList {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		if entry, ok := g.cache[tag[1]]; ok {
+			entry.count++
+			g.cache[tag[1]] = entry
+		}
+	}
+}
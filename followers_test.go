@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestFollowerGate(t *testing.T) {
+	counts := map[string]int{"popular": 5, "newcomer": 1}
+	lookups := 0
+	lookup := func(ctx context.Context, pubkey string) (int, error) {
+		lookups++
+		if pubkey == "broken" {
+			return 0, errors.New("db down")
+		}
+		return counts[pubkey], nil
+	}
+	isAllowed := func(pubkey string) (bool, error) { return pubkey == "member", nil }
+	gate := newFollowerGate(lookup, 2, time.Hour, isAllowed)
+
+	tests := []struct {
+		pubkey string
+		reject bool
+		msg    string
+	}{
+		{"member", false, ""},
+		{"popular", false, ""},
+		{"newcomer", true, "blocked: insufficient reputation"},
+		{"unknown", true, "blocked: insufficient reputation"},
+		{"broken", true, "error: could not verify reputation, try again later"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pubkey, func(t *testing.T) {
+			reject, msg := gate.reject(context.Background(), &nostr.Event{PubKey: tt.pubkey})
+			if reject != tt.reject || msg != tt.msg {
+				t.Errorf("expected (%t, %q), got (%t, %q)", tt.reject, tt.msg, reject, msg)
+			}
+		})
+	}
+
+	// a newly saved contact list following the newcomer lifts them over the threshold
+	before := lookups
+	gate.onSaved(context.Background(), &nostr.Event{Kind: nostr.KindFollowList, Tags: nostr.Tags{{"p", "newcomer"}}})
+	if reject, _ := gate.reject(context.Background(), &nostr.Event{PubKey: "newcomer"}); reject {
+		t.Error("expected the new follower to be counted")
+	}
+	if lookups != before {
+		t.Errorf("expected the cached count to be used, got %d more lookups", lookups-before)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// genesisAllowlist is the default allowlist baked into the binary. Replace
+// genesis/allowlist.txt before building to ship a preconfigured relay.
+//
+//go:embed genesis/allowlist.txt
+var genesisAllowlist []byte
+
+// parseGenesisAllowlist reads one hex pubkey per line, optionally followed by a reason. Blank
+// lines and lines starting with # are skipped. Any invalid pubkey fails the whole list, so a
+// broken build does not seed half a community.
+func parseGenesisAllowlist(data []byte) ([]nip86.PubKeyReason, error) {
+	var entries []nip86.PubKeyReason
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pubkey, reason, _ := strings.Cut(text, " ")
+		if err := validatePubkey(pubkey); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if seen[pubkey] {
+			continue
+		}
+		seen[pubkey] = true
+		entries = append(entries, nip86.PubKeyReason{PubKey: pubkey, Reason: strings.TrimSpace(reason)})
+	}
+	return entries, scanner.Err()
+}
+
+// importGenesisAllowlist seeds an empty allowlist with the embedded default list. Like
+// bootstrapOwner it only ever runs on a fresh database: once the allowlist has any entry, it
+// does nothing.
+func importGenesisAllowlist(data []byte, allowIfEmpty func(entries []nip86.PubKeyReason) (int, error)) error {
+	entries, err := parseGenesisAllowlist(data)
+	if err != nil {
+		return fmt.Errorf("invalid genesis allowlist: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	added, err := allowIfEmpty(entries)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		log.Printf("Seeded the empty allowlist with %d pubkeys from the genesis allowlist", added)
+	}
+	return nil
+}
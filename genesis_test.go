@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+func TestImportGenesisAllowlist(t *testing.T) {
+	alice, bob := fakeID(1), fakeID(2)
+	var allowed []nip86.PubKeyReason
+	allowIfEmpty := func(entries []nip86.PubKeyReason) (int, error) {
+		if len(allowed) > 0 {
+			return 0, nil
+		}
+		allowed = append(allowed, entries...)
+		return len(entries), nil
+	}
+
+	list := "# founders\n\n" + alice + "  founding member \n" + bob + "\n" + alice + " again\n"
+	if err := importGenesisAllowlist([]byte(list), allowIfEmpty); err != nil {
+		t.Fatal(err)
+	}
+	want := []nip86.PubKeyReason{{PubKey: alice, Reason: "founding member"}, {PubKey: bob}}
+	if len(allowed) != 2 || allowed[0] != want[0] || allowed[1] != want[1] {
+		t.Fatalf("expected %v, got %v", want, allowed)
+	}
+
+	// a no-op once the allowlist has entries
+	if err := importGenesisAllowlist([]byte(fakeID(3)), allowIfEmpty); err != nil || len(allowed) != 2 {
+		t.Fatalf("expected no import into a non-empty allowlist, got %v %v", allowed, err)
+	}
+
+	// a broken list is not imported at all
+	allowed = nil
+	err := importGenesisAllowlist([]byte(alice+"\nnpub1xyz\n"), allowIfEmpty)
+	if err == nil || !strings.Contains(err.Error(), "line 2") || allowed != nil {
+		t.Fatalf("expected the invalid line to be reported and nothing imported, got %v %v", allowed, err)
+	}
+}
+
+func TestEmbeddedGenesisAllowlist(t *testing.T) {
+	if _, err := parseGenesisAllowlist(genesisAllowlist); err != nil {
+		t.Fatalf("the embedded genesis allowlist must parse: %v", err)
+	}
+}
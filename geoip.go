@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoBlocker rejects clients by the country or autonomous system their IP belongs to.
+// Either lookup may be nil when its database is not configured.
+type geoBlocker struct {
+	country   func(ip net.IP) (string, error)
+	asn       func(ip net.IP) (uint, error)
+	countries map[string]bool
+	asns      map[uint]bool
+}
+
+// blocked reports whether ip is in a blocked country or ASN. Lookup failures let the client through.
+func (g *geoBlocker) blocked(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if g.country != nil && len(g.countries) > 0 {
+		if code, err := g.country(ip); err == nil && g.countries[code] {
+			return true
+		}
+	}
+	if g.asn != nil && len(g.asns) > 0 {
+		if number, err := g.asn(ip); err == nil && g.asns[number] {
+			return true
+		}
+	}
+	return false
+}
+
+// geoIPMiddleware refuses requests, websocket upgrades included, from blocked countries or ASNs.
+func geoIPMiddleware(blocker *geoBlocker, trusted []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocker.blocked(clientIP(r, trusted)) {
+			http.Error(w, "blocked: connections from your network are not accepted", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadGeoBlocker builds a geoBlocker from GEOIP_COUNTRY_DB, GEOIP_ASN_DB, BLOCKED_COUNTRIES and
+// BLOCKED_ASNS. A database that can't be opened is logged and its check skipped.
+// Returns nil if there is nothing to check.
+func loadGeoBlocker() *geoBlocker {
+	blocker := &geoBlocker{countries: make(map[string]bool), asns: make(map[uint]bool)}
+
+	for _, code := range getEnvList("BLOCKED_COUNTRIES", nil) {
+		blocker.countries[strings.ToUpper(code)] = true
+	}
+	for _, entry := range getEnvList("BLOCKED_ASNS", nil) {
+		number, err := parseASN(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid ASN %q in BLOCKED_ASNS", entry)
+			continue
+		}
+		blocker.asns[number] = true
+	}
+
+	if len(blocker.countries) > 0 {
+		if db, err := openGeoIPDatabase("GEOIP_COUNTRY_DB"); err != nil {
+			log.Printf("Country blocking disabled: %v", err)
+		} else {
+			blocker.country = func(ip net.IP) (string, error) {
+				var record struct {
+					Country struct {
+						ISOCode string `maxminddb:"iso_code"`
+					} `maxminddb:"country"`
+				}
+				err := db.Lookup(ip, &record)
+				return record.Country.ISOCode, err
+			}
+		}
+	}
+
+	if len(blocker.asns) > 0 {
+		if db, err := openGeoIPDatabase("GEOIP_ASN_DB"); err != nil {
+			log.Printf("ASN blocking disabled: %v", err)
+		} else {
+			blocker.asn = func(ip net.IP) (uint, error) {
+				var record struct {
+					ASN uint `maxminddb:"autonomous_system_number"`
+				}
+				err := db.Lookup(ip, &record)
+				return record.ASN, err
+			}
+		}
+	}
+
+	if blocker.country == nil && blocker.asn == nil {
+		return nil
+	}
+	return blocker
+}
+
+// parseASN parses an autonomous system number, with or without the "AS" prefix.
+func parseASN(entry string) (uint, error) {
+	number, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(entry), "AS"), 10, 32)
+	return uint(number), err
+}
+
+func openGeoIPDatabase(key string) (*maxminddb.Reader, error) {
+	path := getEnv(key, "")
+	if path == "" {
+		return nil, fmt.Errorf("%s is not set", key)
+	}
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return db, nil
+}
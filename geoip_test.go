@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGeoIPMiddleware(t *testing.T) {
+	blocker := &geoBlocker{
+		country: func(ip net.IP) (string, error) {
+			if ip.Equal(net.ParseIP("198.51.100.1")) {
+				return "XX", nil
+			}
+			return "DE", nil
+		},
+		asn: func(ip net.IP) (uint, error) {
+			if ip.Equal(net.ParseIP("198.51.100.2")) {
+				return 64500, nil
+			}
+			return 64501, nil
+		},
+		countries: map[string]bool{"XX": true},
+		asns:      map[uint]bool{64500: true},
+	}
+	handler := geoIPMiddleware(blocker, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		remoteAddr string
+		status     int
+	}{
+		{"198.51.100.1:1000", http.StatusForbidden},
+		{"198.51.100.2:1000", http.StatusForbidden},
+		{"198.51.100.3:1000", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: expected status %d, got %d", tt.remoteAddr, tt.status, rec.Code)
+		}
+	}
+}
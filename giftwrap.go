@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-59 gift wraps are signed by a random one-off key and carry a randomized created_at,
+// so rules that look at the author or the timestamp of an event don't apply to them.
+// What identifies a gift wrap is its recipient, the first "p" tag.
+
+func isGiftWrap(event *nostr.Event) bool {
+	return event.Kind == nostr.KindGiftWrap
+}
+
+// giftWrapRecipient returns the pubkey a gift wrap is addressed to, or "" if it has none.
+func giftWrapRecipient(event *nostr.Event) string {
+	if tag := event.Tags.Find("p"); tag != nil {
+		return tag[1]
+	}
+	return ""
+}
+
+// skipGiftWraps lets gift wraps bypass a policy that judges events by their author or timestamp.
+func skipGiftWraps(policy func(context.Context, *nostr.Event) (bool, string)) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if isGiftWrap(event) {
+			return false, ""
+		}
+		return policy(ctx, event)
+	}
+}
+
+// onlyRecipientGiftWraps wraps a query so gift wraps are only returned to the authenticated
+// pubkey they are addressed to, whatever filter was used to ask for them.
+func onlyRecipientGiftWraps(query func(context.Context, nostr.Filter) (chan *nostr.Event, error)) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events, err := query(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		authed := getAuthed(ctx)
+		filtered := make(chan *nostr.Event)
+		go func() {
+			defer close(filtered)
+			for event := range events {
+				if isGiftWrap(event) && (authed == "" || giftWrapRecipient(event) != authed) {
+					continue
+				}
+				select {
+				case filtered <- event:
+				case <-ctx.Done():
+					// keep draining so the underlying query can finish
+				}
+			}
+		}()
+		return filtered, nil
+	}
+}
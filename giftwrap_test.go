@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestOnlyRecipientGiftWraps(t *testing.T) {
+	_, recipient := newKeypair(t)
+	_, stranger := newKeypair(t)
+
+	note := &nostr.Event{ID: "note", Kind: nostr.KindTextNote}
+	wrap := &nostr.Event{ID: "wrap", Kind: nostr.KindGiftWrap, Tags: nostr.Tags{{"p", recipient}}}
+	query := onlyRecipientGiftWraps(func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		ch := make(chan *nostr.Event, 2)
+		ch <- note
+		ch <- wrap
+		close(ch)
+		return ch, nil
+	})
+
+	tests := []struct {
+		name   string
+		authed string
+		want   []string
+	}{
+		{"recipient", recipient, []string{"note", "wrap"}},
+		{"someone else", stranger, []string{"note"}},
+		{"unauthenticated", "", []string{"note"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAuthed(t, tt.authed)
+			events, err := query(context.Background(), nostr.Filter{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []string
+			for event := range events {
+				got = append(got, event.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
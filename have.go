@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventExists checks the event table's primary key index without loading the event.
+func eventExists(db *sql.DB) func(ctx context.Context, id string) (bool, error) {
+	return func(ctx context.Context, id string) (bool, error) {
+		var exists bool
+		if err := db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM event WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return false, fmt.Errorf("failed to check if event %s exists: %w", id, err)
+		}
+		return exists, nil
+	}
+}
+
+// handleHave serves GET /api/have?id=<id>, answering 200 if the event is stored and 404 if not.
+func handleHave(exists func(ctx context.Context, id string) (bool, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if !nostr.IsValid32ByteHex(id) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id must be a 64-character lowercase hex event id"})
+			return
+		}
+
+		found, err := exists(r.Context(), id)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check event"})
+			return
+		}
+		if !found {
+			writeJSON(w, http.StatusNotFound, map[string]any{"id": id, "have": false})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "have": true})
+	}
+}
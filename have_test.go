@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleHave(t *testing.T) {
+	stored := strings.Repeat("ab", 32)
+	handler := handleHave(func(ctx context.Context, id string) (bool, error) {
+		return id == stored, nil
+	})
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"stored event", stored, http.StatusOK},
+		{"unknown event", strings.Repeat("cd", 32), http.StatusNotFound},
+		{"invalid id", "not-an-id", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest("GET", "/api/have?id="+tt.id, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "net/http"
+
+// handleHealth serves GET /health, a liveness check for load balancers and orchestrators. It
+// only says the process is serving HTTP; /admin/selftest checks the database as well.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// httpRouteGroups are the groups of plain HTTP routes that are rate limited separately, each
+// configured with HTTP_RATE_LIMIT_<GROUP>.
+var httpRouteGroups = []string{"nip11", "management", "api", "admin", "metrics", "other"}
+
+// httpRouteGroup returns the group r belongs to, or "" for requests that are never limited:
+// websocket upgrades, which have their own limits, and /health, so monitoring keeps working
+// while a client is being throttled.
+func httpRouteGroup(r *http.Request) string {
+	switch {
+	case strings.EqualFold(r.Header.Get("Upgrade"), "websocket"), r.URL.Path == "/health":
+		return ""
+	case r.Header.Get("Content-Type") == "application/nostr+json+rpc":
+		return "management"
+	case r.Header.Get("Accept") == "application/nostr+json":
+		return "nip11"
+	case strings.HasPrefix(r.URL.Path, "/api/"):
+		return "api"
+	case strings.HasPrefix(r.URL.Path, "/admin/"):
+		return "admin"
+	case r.URL.Path == "/metrics":
+		return "metrics"
+	default:
+		return "other"
+	}
+}
+
+// httpRateLimiter is a soft per-IP limit on the plain HTTP routes. A client over the limit of a
+// route group gets a 429 with a Retry-After header saying when the group has room again.
+type httpRateLimiter struct {
+	limiters map[string]*tokenBucketLimiter // by route group, missing for unlimited groups
+	trusted  []*net.IPNet
+	limited  map[string]*atomic.Int64
+}
+
+func newHTTPRateLimiter(limits map[string]rateLimit, trusted []*net.IPNet) *httpRateLimiter {
+	l := &httpRateLimiter{limiters: make(map[string]*tokenBucketLimiter), trusted: trusted, limited: make(map[string]*atomic.Int64)}
+	for group, limit := range limits {
+		l.limiters[group] = newTokenBucketLimiter(limit)
+		l.limited[group] = &atomic.Int64{}
+	}
+	return l
+}
+
+// loadHTTPRateLimits reads HTTP_RATE_LIMIT_<GROUP> for every route group, using
+// HTTP_RATE_LIMIT_DEFAULT for groups without their own. Invalid values are logged and ignored.
+func loadHTTPRateLimits() map[string]rateLimit {
+	load := func(key string) (rateLimit, bool) {
+		value := getEnv(key, "")
+		if value == "" {
+			return rateLimit{}, false
+		}
+		limit, err := parseRateLimit(value)
+		if err != nil {
+			log.Printf("Ignoring %s: %v", key, err)
+			return rateLimit{}, false
+		}
+		return limit, true
+	}
+
+	fallback, hasFallback := load("HTTP_RATE_LIMIT_DEFAULT")
+	limits := make(map[string]rateLimit)
+	for _, group := range httpRouteGroups {
+		if limit, ok := load("HTTP_RATE_LIMIT_" + strings.ToUpper(group)); ok {
+			limits[group] = limit
+		} else if hasFallback {
+			limits[group] = fallback
+		}
+	}
+	return limits
+}
+
+func (l *httpRateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := httpRouteGroup(r)
+		limiter, ok := l.limiters[group]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowed, wait := limiter.take(clientIP(r, l.trusted).String(), time.Now()); !allowed {
+			l.limited[group].Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+			http.Error(w, "too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// metrics reports the requests refused per route group in the Prometheus text format.
+func (l *httpRateLimiter) metrics(w *bufio.Writer) {
+	fmt.Fprintln(w, "# HELP brove_http_rate_limited_total HTTP requests refused with a 429, by route group.")
+	fmt.Fprintln(w, "# TYPE brove_http_rate_limited_total counter")
+	for _, group := range httpRouteGroups {
+		if limited, ok := l.limited[group]; ok {
+			fmt.Fprintf(w, "brove_http_rate_limited_total{group=%q} %d\n", group, limited.Load())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPRouteGroup(t *testing.T) {
+	tests := []struct {
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{"/", map[string]string{"Upgrade": "websocket"}, ""},
+		{"/health", nil, ""},
+		{"/", map[string]string{"Accept": "application/nostr+json"}, "nip11"},
+		{"/", map[string]string{"Content-Type": "application/nostr+json+rpc"}, "management"},
+		{"/api/events", nil, "api"},
+		{"/admin/connections", nil, "admin"},
+		{"/metrics", nil, "metrics"},
+		{"/robots.txt", nil, "other"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		if got := httpRouteGroup(req); got != tt.want {
+			t.Errorf("%s %v: expected %q, got %q", tt.path, tt.headers, tt.want, got)
+		}
+	}
+}
+
+func TestHTTPRateLimiterMiddleware(t *testing.T) {
+	trusted := parseTrustedProxies([]string{"10.0.0.1"})
+	limiter := newHTTPRateLimiter(map[string]rateLimit{"api": {events: 2, per: time.Minute}}, trusted)
+	handler := limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(path, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := request("/api/events", "203.0.113.1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+	rec := request("/api/events", "203.0.113.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the third request to get a 429, got %d", rec.Code)
+	}
+	if retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retryAfter < 1 || retryAfter > 30 {
+		t.Errorf("expected a Retry-After of up to 30 seconds, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	// the client behind the trusted proxy is limited, not the proxy
+	if rec := request("/api/events", "203.0.113.2"); rec.Code != http.StatusOK {
+		t.Errorf("expected another client to have its own budget, got %d", rec.Code)
+	}
+	// groups without a limit and /health are not limited
+	if rec := request("/admin/connections", "203.0.113.1"); rec.Code != http.StatusOK {
+		t.Errorf("expected an unlimited group to pass, got %d", rec.Code)
+	}
+	if rec := request("/health", "203.0.113.1"); rec.Code != http.StatusOK {
+		t.Errorf("expected /health to pass, got %d", rec.Code)
+	}
+
+	var metrics bytes.Buffer
+	w := bufio.NewWriter(&metrics)
+	limiter.metrics(w)
+	w.Flush()
+	if !strings.Contains(metrics.String(), `brove_http_rate_limited_total{group="api"} 1`) {
+		t.Errorf("expected the refusal to be counted, got:\n%s", metrics.String())
+	}
+}
+
+func TestLoadHTTPRateLimits(t *testing.T) {
+	t.Setenv("HTTP_RATE_LIMIT_DEFAULT", "100/min")
+	t.Setenv("HTTP_RATE_LIMIT_API", "10/s")
+	t.Setenv("HTTP_RATE_LIMIT_ADMIN", "lots")
+
+	limits := loadHTTPRateLimits()
+	if limits["api"] != (rateLimit{10, time.Second}) {
+		t.Errorf("expected the api group to use its own limit, got %+v", limits["api"])
+	}
+	for _, group := range []string{"nip11", "admin", "other"} {
+		if limits[group] != (rateLimit{100, time.Minute}) {
+			t.Errorf("expected %s to fall back to the default, got %+v", group, limits[group])
+		}
+	}
+}
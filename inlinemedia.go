@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// encryptedContentKinds carry an encrypted payload as their whole content, which is base64 by
+// design: NIP-04 direct messages, NIP-59 seals and NIP-46 remote signing requests.
+// Gift wraps are skipped where the policy is registered.
+var encryptedContentKinds = []int{nostr.KindEncryptedDirectMessage, 13, nostr.KindNostrConnect}
+
+// isBase64Byte reports whether b belongs to the standard or the URL-safe base64 alphabet.
+func isBase64Byte(b byte) bool {
+	return b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '+' || b == '/' || b == '-' || b == '_'
+}
+
+// dataURIEnd reports whether b ends the payload of a data: URI embedded in text.
+func dataURIEnd(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '"' || b == '\'' || b == ')' || b == '<' || b == '>'
+}
+
+// inlineMedia reports whether content embeds a data: URI with a payload of more than maxBytes,
+// or a run of more than maxBytes base64 characters.
+func inlineMedia(content string, maxBytes int) bool {
+	lower := strings.ToLower(content)
+	for offset := 0; ; {
+		start := strings.Index(lower[offset:], "data:")
+		if start < 0 {
+			break
+		}
+		start += offset
+		comma := strings.IndexByte(content[start:], ',')
+		if comma < 0 {
+			break
+		}
+		header := content[start+len("data:") : start+comma]
+		payload := start + comma + 1
+		end := payload
+		for end < len(content) && !dataURIEnd(content[end]) {
+			end++
+		}
+		// the media type and parameters never contain whitespace
+		if !strings.ContainsAny(header, " \n\r\t") && end-payload > maxBytes {
+			return true
+		}
+		offset = start + len("data:")
+	}
+
+	run := 0
+	for i := 0; i < len(content); i++ {
+		if !isBase64Byte(content[i]) {
+			run = 0
+			continue
+		}
+		if run++; run > maxBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectInlineMedia rejects events that embed media in their content instead of linking it,
+// so a text relay can't be used as media storage. The owner is exempt.
+func rejectInlineMedia(maxBytes int, ownerPubKey string) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if event.PubKey == ownerPubKey || slices.Contains(encryptedContentKinds, event.Kind) {
+			return false, ""
+		}
+		if inlineMedia(event.Content, maxBytes) {
+			return true, "blocked: inline media not allowed"
+		}
+		return false, ""
+	}
+}
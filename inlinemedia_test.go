@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRejectInlineMedia(t *testing.T) {
+	_, owner := newKeypair(t)
+	_, author := newKeypair(t)
+	policy := rejectInlineMedia(1024, owner)
+
+	// a 1x1 transparent PNG
+	small := "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+	large := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(make([]byte, 4096))
+	blob := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("binary", 500)))
+	svg := "data:image/svg+xml," + strings.Repeat("%3Csvg%3E%3C/svg%3E", 100)
+
+	for _, tc := range []struct {
+		name    string
+		kind    int
+		pubkey  string
+		content string
+		reject  bool
+	}{
+		{"text", nostr.KindTextNote, author, "gm, see https://example.com/a/very/long/path?and=a&query=string nostr:npub1sg6plzptd64u62a878hep2kev88swjh3tw00gjsfl8f237lmu63q0uf63m", false},
+		{"small inline image", nostr.KindTextNote, author, "look at this " + small + " tiny pixel", false},
+		{"large inline image", nostr.KindTextNote, author, "look at this " + large, true},
+		{"quoted large image", nostr.KindTextNote, author, `<img src="` + large + `">`, true},
+		{"url-encoded data uri", nostr.KindTextNote, author, svg, true},
+		{"base64 blob", nostr.KindTextNote, author, "here you go:\n" + blob, true},
+		{"owner", nostr.KindTextNote, owner, large, false},
+		{"encrypted direct message", nostr.KindEncryptedDirectMessage, author, blob + "?iv=" + small[22:46], false},
+	} {
+		event := &nostr.Event{Kind: tc.kind, PubKey: tc.pubkey, Content: tc.content}
+		reject, msg := policy(context.Background(), event)
+		if reject != tc.reject {
+			t.Errorf("%s: expected reject=%v, got %v %q", tc.name, tc.reject, reject, msg)
+		}
+		if reject && msg != "blocked: inline media not allowed" {
+			t.Errorf("%s: unexpected message %q", tc.name, msg)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+)
+
+// noKeepalive stands in for "never" where khatru needs a duration: its ping ticker cannot be
+// stopped, and its read deadline is only extended by pongs.
+const noKeepalive = 100 * 365 * 24 * time.Hour
+
+// configureKeepalive makes khatru ping every connection each interval and drop connections
+// that have not answered with a pong within pongTimeout; every pong extends the read deadline
+// by pongTimeout again. An interval of 0 disables both, so idle connections are never reaped.
+func configureKeepalive(relay *khatru.Relay, interval, pongTimeout time.Duration) {
+	if interval == 0 {
+		relay.PingPeriod = noKeepalive
+		relay.PongWait = noKeepalive
+		return
+	}
+	if pongTimeout <= interval {
+		log.Printf("WS_PONG_TIMEOUT %s must be longer than WS_PING_INTERVAL %s, using %s", pongTimeout, interval, 2*interval)
+		pongTimeout = 2 * interval
+	}
+	relay.PingPeriod = interval
+	relay.PongWait = pongTimeout
+}
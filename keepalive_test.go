@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+)
+
+func TestKeepaliveReapsUnresponsiveConnections(t *testing.T) {
+	relay := khatru.NewRelay()
+	configureKeepalive(relay, 20*time.Millisecond, 100*time.Millisecond)
+
+	// a client that keeps reading answers pings automatically and stays connected
+	alive := dialRelay(t, relay)
+	pings := make(chan struct{}, 100)
+	alive.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return alive.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// a client that never reads never answers
+	dead := dialRelay(t, relay)
+
+	time.Sleep(300 * time.Millisecond)
+	if len(pings) < 3 {
+		t.Fatalf("expected regular pings, got %d", len(pings))
+	}
+	if err := alive.WriteMessage(websocket.TextMessage, []byte(`["REQ","still-here",{"kinds":[1]}]`)); err != nil {
+		t.Fatalf("expected the responsive connection to stay open, got %v", err)
+	}
+
+	dead.SetPingHandler(func(string) error { return nil })
+	dead.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := dead.ReadMessage()
+	if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+		t.Fatalf("expected the unresponsive connection to be closed by the relay, got %v", err)
+	}
+}
+
+func TestConfigureKeepalive(t *testing.T) {
+	relay := khatru.NewRelay()
+	configureKeepalive(relay, time.Minute, 30*time.Second)
+	if relay.PingPeriod != time.Minute || relay.PongWait != 2*time.Minute {
+		t.Fatalf("expected the pong timeout to be raised above the interval, got %s/%s", relay.PingPeriod, relay.PongWait)
+	}
+
+	configureKeepalive(relay, 0, time.Minute)
+	if relay.PingPeriod != noKeepalive || relay.PongWait != noKeepalive {
+		t.Fatalf("expected keepalive to be disabled, got %s/%s", relay.PingPeriod, relay.PongWait)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// profileChange is one stored replacement of a watched kind.
+type profileChange struct {
+	at   time.Time
+	kind int
+}
+
+// compromiseFlag is what the owner sees about a flagged pubkey.
+type compromiseFlag struct {
+	Pubkey string `json:"pubkey"`
+	// Changes counts the replacements in the window when the pubkey was flagged, and after
+	Changes   int       `json:"changes"`
+	Kinds     []int     `json:"kinds"`
+	FlaggedAt time.Time `json:"flagged_at"`
+	Paused    bool      `json:"paused"`
+}
+
+// compromiseDetector is experimental moderation tooling: it flags pubkeys whose watched
+// replaceable events, by default the kind 0 profile and the kind 3 follow list, are replaced
+// more than max times within window, the pattern of a stolen key taking over an account.
+// Flagged pubkeys are listed for the owner's review and, with pause, their writes are refused
+// until the owner clears the flag. Flags are kept in memory, a restart clears them.
+type compromiseDetector struct {
+	kinds  []int
+	max    int
+	window time.Duration
+	pause  bool
+	now    func() time.Time
+
+	mu        sync.Mutex
+	changes   map[string][]profileChange // by pubkey, oldest first
+	flagged   map[string]*compromiseFlag
+	lastSweep time.Time
+}
+
+func newCompromiseDetector(kinds []int, max int, window time.Duration, pause bool, now func() time.Time) *compromiseDetector {
+	return &compromiseDetector{
+		kinds:     kinds,
+		max:       max,
+		window:    window,
+		pause:     pause,
+		now:       now,
+		changes:   make(map[string][]profileChange),
+		flagged:   make(map[string]*compromiseFlag),
+		lastSweep: now(),
+	}
+}
+
+// onSaved counts a stored event of a watched kind and flags its author once the changes
+// within the window go over the limit.
+func (d *compromiseDetector) onSaved(ctx context.Context, event *nostr.Event) {
+	if !slices.Contains(d.kinds, event.Kind) {
+		return
+	}
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+
+	changes := append(d.recent(event.PubKey, now), profileChange{at: now, kind: event.Kind})
+	d.changes[event.PubKey] = changes
+	if len(changes) <= d.max {
+		return
+	}
+
+	var kinds []int
+	for _, change := range changes {
+		if !slices.Contains(kinds, change.kind) {
+			kinds = append(kinds, change.kind)
+		}
+	}
+	slices.Sort(kinds)
+	if flag, ok := d.flagged[event.PubKey]; ok {
+		flag.Changes, flag.Kinds = len(changes), kinds
+		return
+	}
+	d.flagged[event.PubKey] = &compromiseFlag{Pubkey: event.PubKey, Changes: len(changes), Kinds: kinds, FlaggedAt: now, Paused: d.pause}
+	if d.pause {
+		log.Printf("Key compromise: %s replaced kinds %v %d times within %s, writes paused until reviewed", event.PubKey, kinds, len(changes), d.window)
+	} else {
+		log.Printf("Key compromise: %s replaced kinds %v %d times within %s, flagged for review", event.PubKey, kinds, len(changes), d.window)
+	}
+}
+
+// recent returns the changes of pubkey still within the window.
+func (d *compromiseDetector) recent(pubkey string, now time.Time) []profileChange {
+	changes := d.changes[pubkey]
+	cutoff := now.Add(-d.window)
+	for len(changes) > 0 && !changes[0].at.After(cutoff) {
+		changes = changes[1:]
+	}
+	return changes
+}
+
+// sweep forgets pubkeys without changes in the window, at most once per window.
+func (d *compromiseDetector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.window {
+		return
+	}
+	d.lastSweep = now
+	for pubkey := range d.changes {
+		if len(d.recent(pubkey, now)) == 0 {
+			delete(d.changes, pubkey)
+		}
+	}
+}
+
+// reject refuses the writes of paused pubkeys.
+func (d *compromiseDetector) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	d.mu.Lock()
+	flag, ok := d.flagged[event.PubKey]
+	d.mu.Unlock()
+	if ok && flag.Paused {
+		return true, "blocked: writes from this key are paused until the relay owner reviews it, it may be compromised"
+	}
+	return false, ""
+}
+
+// clear removes the flag of a reviewed pubkey, resuming its writes, and forgets its changes.
+func (d *compromiseDetector) clear(pubkey string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.flagged[pubkey]
+	delete(d.flagged, pubkey)
+	delete(d.changes, pubkey)
+	return ok
+}
+
+func (d *compromiseDetector) list() []compromiseFlag {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	flags := make([]compromiseFlag, 0, len(d.flagged))
+	for _, flag := range d.flagged {
+		flags = append(flags, *flag)
+	}
+	slices.SortFunc(flags, func(a, b compromiseFlag) int { return a.FlaggedAt.Compare(b.FlaggedAt) })
+	return flags
+}
+
+// handleAdminKeyCompromise serves the owner's review of flagged pubkeys: GET lists them,
+// oldest flag first, and DELETE ?pubkey=... clears a reviewed one.
+func handleAdminKeyCompromise(d *compromiseDetector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, d.list())
+		case http.MethodDelete:
+			pubkey := strings.ToLower(r.URL.Query().Get("pubkey"))
+			if err := validatePubkey(pubkey); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if !d.clear(pubkey) {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "pubkey is not flagged"})
+				return
+			}
+			log.Printf("Key compromise: flag of %s cleared", pubkey)
+			writeJSON(w, http.StatusOK, map[string]string{"cleared": pubkey})
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCompromiseDetectorFrequency(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	detector := newCompromiseDetector([]int{0, 3}, 3, 10*time.Minute, false, func() time.Time { return now })
+	ctx := context.Background()
+	save := func(pubkey string, kind int) {
+		detector.onSaved(ctx, &nostr.Event{PubKey: pubkey, Kind: kind})
+		now = now.Add(time.Minute)
+	}
+	flagged := func(pubkey string) bool {
+		for _, flag := range detector.list() {
+			if flag.Pubkey == pubkey {
+				return true
+			}
+		}
+		return false
+	}
+
+	// spread out, the changes leave the window before they add up
+	for range 6 {
+		save("slow", 0)
+		now = now.Add(5 * time.Minute)
+	}
+	// other kinds don't count
+	for range 10 {
+		save("poster", 1)
+	}
+	if flags := detector.list(); len(flags) != 0 {
+		t.Fatalf("expected nobody to be flagged, got %+v", flags)
+	}
+
+	save("taken", 0)
+	save("taken", 3)
+	save("taken", 0)
+	if flagged("taken") {
+		t.Fatal("expected the limit itself not to trigger a flag")
+	}
+	save("taken", 3)
+	flags := detector.list()
+	if len(flags) != 1 || flags[0].Pubkey != "taken" || flags[0].Changes != 4 || len(flags[0].Kinds) != 2 || flags[0].Paused {
+		t.Fatalf("expected the fourth change to flag the pubkey, got %+v", flags)
+	}
+
+	// flagging alone lets writes through
+	if reject, msg := detector.reject(ctx, &nostr.Event{PubKey: "taken", Kind: 1}); reject {
+		t.Errorf("expected a flag not to pause writes, got %s", msg)
+	}
+}
+
+func TestCompromiseDetectorPause(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	detector := newCompromiseDetector([]int{0, 3}, 1, time.Hour, true, func() time.Time { return now })
+	ctx := context.Background()
+	pubkey := fakeID(7)
+
+	detector.onSaved(ctx, &nostr.Event{PubKey: pubkey, Kind: 0})
+	detector.onSaved(ctx, &nostr.Event{PubKey: pubkey, Kind: 0})
+	if reject, msg := detector.reject(ctx, &nostr.Event{PubKey: pubkey, Kind: 1}); !reject || msg != "blocked: writes from this key are paused until the relay owner reviews it, it may be compromised" {
+		t.Fatalf("expected writes to be paused, got %v %q", reject, msg)
+	}
+	if reject, _ := detector.reject(ctx, &nostr.Event{PubKey: fakeID(8), Kind: 1}); reject {
+		t.Error("expected other pubkeys to keep writing")
+	}
+
+	handler := handleAdminKeyCompromise(detector)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/admin/keycompromise", nil))
+	var flags []compromiseFlag
+	if err := json.Unmarshal(rec.Body.Bytes(), &flags); err != nil || len(flags) != 1 || flags[0].Pubkey != pubkey || !flags[0].Paused {
+		t.Fatalf("expected the paused pubkey to be listed, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodDelete, "/admin/keycompromise?pubkey="+pubkey, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the flag to be cleared, got %d %s", rec.Code, rec.Body.String())
+	}
+	if reject, msg := detector.reject(ctx, &nostr.Event{PubKey: pubkey, Kind: 1}); reject {
+		t.Errorf("expected writes to resume after the review, got %s", msg)
+	}
+	// the count starts over
+	detector.onSaved(ctx, &nostr.Event{PubKey: pubkey, Kind: 0})
+	if flags := detector.list(); len(flags) != 0 {
+		t.Errorf("expected a cleared pubkey to start a new count, got %+v", flags)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodDelete, "/admin/keycompromise?pubkey="+pubkey, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected clearing an unflagged pubkey to be a 404, got %d", rec.Code)
+	}
+}
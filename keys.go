@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// parseSecretKey accepts a secret key as nsec or 64-char hex and returns it as hex.
+func parseSecretKey(input string) (string, error) {
+	if nostr.IsValid32ByteHex(input) {
+		return input, nil
+	}
+
+	prefix, value, err := nip19.Decode(input)
+	if err != nil || prefix != "nsec" {
+		return "", fmt.Errorf("invalid secret key: expected nsec or hex")
+	}
+	return value.(string), nil
+}
+
+// parsePublicKey accepts a public key as npub or 64-char hex and returns it as lowercase hex.
+func parsePublicKey(input string) (string, error) {
+	if lower := strings.ToLower(input); nostr.IsValidPublicKey(lower) {
+		return lower, nil
+	}
+
+	prefix, value, err := nip19.Decode(input)
+	if err != nil || prefix != "npub" {
+		return "", fmt.Errorf("invalid public key: expected npub or hex")
+	}
+	return value.(string), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestParsePublicKey(t *testing.T) {
+	_, pk := newKeypair(t)
+	npub, _ := nip19.EncodePublicKey(pk)
+
+	for _, input := range []string{pk, strings.ToUpper(pk), npub} {
+		got, err := parsePublicKey(input)
+		if err != nil {
+			t.Fatalf("expected %q to parse, got %v", input, err)
+		}
+		if got != pk {
+			t.Fatalf("expected %s for %q, got %s", pk, input, got)
+		}
+	}
+
+	nsec, _ := nip19.EncodePrivateKey(pk)
+	for _, input := range []string{"", "abc", nsec, pk + "00"} {
+		if _, err := parsePublicKey(input); err == nil {
+			t.Fatalf("expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestAllowlistCommandsRejectBadArguments(t *testing.T) {
+	for _, args := range [][]string{
+		{"allow"},
+		{"allow", "not-a-key"},
+		{"deny"},
+		{"deny", "not-a-key"},
+		{"list", "extra"},
+	} {
+		if code, handled := runCommand(args); !handled || code != 2 {
+			t.Fatalf("expected exit code 2 for %v, got %d", args, code)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxEventTags rejects events with more than max tags.
+func maxEventTags(max int) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if len(event.Tags) > max {
+			return true, "invalid: too many tags"
+		}
+		return false, ""
+	}
+}
+
+// maxMentions rejects events with more than maxE e tags or maxP p tags; zero disables a limit.
+// Replaceable and addressable events such as follow lists and NIP-51 lists are exempt, since
+// they legitimately reference thousands of pubkeys and events.
+func maxMentions(maxE, maxP int) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if nostr.IsReplaceableKind(event.Kind) || nostr.IsAddressableKind(event.Kind) {
+			return false, ""
+		}
+		var eTags, pTags int
+		for _, tag := range event.Tags {
+			if len(tag) == 0 {
+				continue
+			}
+			switch tag[0] {
+			case "e":
+				eTags++
+			case "p":
+				pTags++
+			}
+		}
+		if maxE > 0 && eTags > maxE || maxP > 0 && pTags > maxP {
+			return true, "blocked: too many mentions/references"
+		}
+		return false, ""
+	}
+}
+
+// createdAtLimits advertises the accepted created_at window in NIP-11. As NIP-11 defines them,
+// both limits are offsets in seconds relative to the current time; zero means unlimited.
+func createdAtLimits(maxPast, maxFuture time.Duration) nip11Extension {
+	return func(r *http.Request, doc map[string]any) {
+		limitation := nip11Limitation(doc)
+		if maxPast > 0 {
+			limitation["created_at_lower_limit"] = int64(maxPast.Seconds())
+		}
+		if maxFuture > 0 {
+			limitation["created_at_upper_limit"] = int64(maxFuture.Seconds())
+		}
+	}
+}
+
+// createdAtDrift is how far created_at may be from the current time; zero means unlimited.
+type createdAtDrift struct {
+	past, future time.Duration
+}
+
+// createdAtWindow limits created_at, with per-kind overrides of the default drift. Gift wraps
+// randomize their created_at, so they are only checked when their kind has an override.
+type createdAtWindow struct {
+	defaults createdAtDrift
+	kinds    map[int]createdAtDrift
+	now      func() nostr.Timestamp
+}
+
+// parseCreatedAtKindLimits parses entries like "1:1h:5m" (kind, max past, max future; 0 for
+// unlimited). Invalid entries are logged and skipped.
+func parseCreatedAtKindLimits(entries []string) map[int]createdAtDrift {
+	kinds := make(map[int]createdAtDrift)
+	for _, entry := range entries {
+		kind, drift, err := parseCreatedAtKindLimit(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid created_at kind limit %q: %v", entry, err)
+			continue
+		}
+		kinds[kind] = drift
+	}
+	return kinds
+}
+
+func parseCreatedAtKindLimit(entry string) (int, createdAtDrift, error) {
+	parts := strings.Split(entry, ":")
+	if len(parts) != 3 {
+		return 0, createdAtDrift{}, fmt.Errorf("expected kind:past:future")
+	}
+	kind, err := strconv.Atoi(parts[0])
+	if err != nil || kind < 0 {
+		return 0, createdAtDrift{}, fmt.Errorf("bad kind")
+	}
+	past, pastErr := parseDriftDuration(parts[1])
+	future, futureErr := parseDriftDuration(parts[2])
+	if pastErr != nil || futureErr != nil {
+		return 0, createdAtDrift{}, fmt.Errorf("bad duration")
+	}
+	return kind, createdAtDrift{past: past, future: future}, nil
+}
+
+// parseDriftDuration parses a duration that must not be negative, accepting a bare "0".
+func parseDriftDuration(value string) (time.Duration, error) {
+	if value == "0" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err == nil && duration < 0 {
+		err = fmt.Errorf("negative duration")
+	}
+	return duration, err
+}
+
+func (w createdAtWindow) drift(event *nostr.Event) (createdAtDrift, bool) {
+	if drift, ok := w.kinds[event.Kind]; ok {
+		return drift, true
+	}
+	if isGiftWrap(event) {
+		return createdAtDrift{}, false
+	}
+	return w.defaults, true
+}
+
+// limitsPast reports whether any kind has a limit on how old created_at may be.
+func (w createdAtWindow) limitsPast() bool {
+	if w.defaults.past > 0 {
+		return true
+	}
+	for _, drift := range w.kinds {
+		if drift.past > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// limitsFuture reports whether any kind has a limit on how far ahead created_at may be.
+func (w createdAtWindow) limitsFuture() bool {
+	if w.defaults.future > 0 {
+		return true
+	}
+	for _, drift := range w.kinds {
+		if drift.future > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (w createdAtWindow) rejectPast(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	drift, ok := w.drift(event)
+	if ok && drift.past > 0 && w.now()-event.CreatedAt > nostr.Timestamp(drift.past.Seconds()) {
+		return true, "event too old"
+	}
+	return false, ""
+}
+
+func (w createdAtWindow) rejectFuture(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	drift, ok := w.drift(event)
+	if ok && drift.future > 0 && event.CreatedAt-w.now() > nostr.Timestamp(drift.future.Seconds()) {
+		return true, "event too much in the future"
+	}
+	return false, ""
+}
+
+// filterLimits bounds the subscription ids and the individual filter values (ids, authors,
+// tag values and search) clients may send; zero disables a limit.
+type filterLimits struct {
+	maxSubID, maxValue int
+}
+
+// oversized returns why filter, sent under subscription id subID, is too large, or "".
+func (l filterLimits) oversized(subID string, filter nostr.Filter) string {
+	if l.maxSubID > 0 && len(subID) > l.maxSubID {
+		return fmt.Sprintf("subscription id longer than %d characters", l.maxSubID)
+	}
+	if l.maxValue <= 0 {
+		return ""
+	}
+	tooLong := func(values []string) bool {
+		return slices.ContainsFunc(values, func(value string) bool { return len(value) > l.maxValue })
+	}
+	if tooLong(filter.IDs) || tooLong(filter.Authors) || len(filter.Search) > l.maxValue {
+		return fmt.Sprintf("filter value longer than %d characters", l.maxValue)
+	}
+	for _, values := range filter.Tags {
+		if tooLong(values) {
+			return fmt.Sprintf("filter value longer than %d characters", l.maxValue)
+		}
+	}
+	return ""
+}
+
+// overwriteFilter makes sure oversized filters reach rejectFilter: khatru skips the reject hooks
+// for limit:0 filters.
+func (l filterLimits) overwriteFilter(ctx context.Context, filter *nostr.Filter) {
+	if l.oversized(khatru.GetSubscriptionID(ctx), *filter) != "" {
+		filter.LimitZero = false
+	}
+}
+
+// rejectFilter refuses oversized REQs with a NOTICE, and closes them.
+func (l filterLimits) rejectFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	problem := l.oversized(khatru.GetSubscriptionID(ctx), filter)
+	if problem == "" {
+		return false, ""
+	}
+	if ws := getConnection(ctx); ws != nil {
+		ws.WriteJSON(nostr.NoticeEnvelope("invalid: " + problem))
+	}
+	return true, "invalid: " + problem
+}
+
+// rejectCountFilter refuses oversized COUNT filters; khatru answers them with a NOTICE.
+func (l filterLimits) rejectCountFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	if problem := l.oversized("", filter); problem != "" {
+		return true, "invalid: " + problem
+	}
+	return false, ""
+}
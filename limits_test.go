@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMaxMentions(t *testing.T) {
+	reject := maxMentions(3, 2)
+	withTags := func(kind, eTags, pTags int) *nostr.Event {
+		event := &nostr.Event{Kind: kind}
+		for i := 0; i < eTags; i++ {
+			event.Tags = append(event.Tags, nostr.Tag{"e", fakeID(i)})
+		}
+		for i := 0; i < pTags; i++ {
+			event.Tags = append(event.Tags, nostr.Tag{"p", fakeID(i)})
+		}
+		event.Tags = append(event.Tags, nostr.Tag{"t", "unrelated"}, nostr.Tag{})
+		return event
+	}
+
+	cases := []struct {
+		name         string
+		kind         int
+		eTags, pTags int
+		reject       bool
+	}{
+		{"below the limits", 1, 2, 1, false},
+		{"at the limits", 1, 3, 2, false},
+		{"above the e limit", 1, 4, 0, true},
+		{"above the p limit", 1, 0, 3, true},
+		{"follow list", nostr.KindFollowList, 0, 5000, false},
+		{"addressable list", 30000, 10, 10, false},
+	}
+	for _, tc := range cases {
+		rejected, msg := reject(context.Background(), withTags(tc.kind, tc.eTags, tc.pTags))
+		if rejected != tc.reject {
+			t.Errorf("%s: expected reject=%t, got %t", tc.name, tc.reject, rejected)
+		}
+		if rejected && msg != "blocked: too many mentions/references" {
+			t.Errorf("%s: unexpected message %q", tc.name, msg)
+		}
+	}
+
+	// zero disables a limit
+	if rejected, _ := maxMentions(0, 2)(context.Background(), withTags(1, 100, 0)); rejected {
+		t.Fatal("expected MAX_E_TAGS=0 to disable the e tag limit")
+	}
+}
+
+func TestCreatedAtWindowPerKind(t *testing.T) {
+	now := nostr.Timestamp(1700000000)
+	window := createdAtWindow{
+		defaults: createdAtDrift{past: 24 * time.Hour, future: 15 * time.Minute},
+		kinds:    parseCreatedAtKindLimits([]string{"1:1h:1m", "1059:72h:0", "7:0:0", "bogus", "3:1h", "4:-1h:0"}),
+		now:      func() nostr.Timestamp { return now },
+	}
+	if len(window.kinds) != 3 {
+		t.Fatalf("expected the invalid entries to be skipped, got %v", window.kinds)
+	}
+
+	tests := []struct {
+		name      string
+		kind      int
+		createdAt nostr.Timestamp
+		msg       string
+	}{
+		{"note within its strict window", 1, now - 1800, ""},
+		{"note older than its override", 1, now - 7200, "event too old"},
+		{"note ahead of its override", 1, now + 120, "event too much in the future"},
+		{"other kind uses the defaults", 30023, now - 7200, ""},
+		{"other kind too old", 30023, now - 25*3600, "event too old"},
+		{"other kind too far ahead", 30023, now + 3600, "event too much in the future"},
+		{"gift wrap within its relaxed window", 1059, now - 48*3600, ""},
+		{"gift wrap older than its override", 1059, now - 73*3600, "event too old"},
+		{"gift wrap without a future limit", 1059, now + 48*3600, ""},
+		{"reactions unlimited", 7, now - 365*24*3600, ""},
+	}
+	for _, test := range tests {
+		event := &nostr.Event{Kind: test.kind, CreatedAt: test.createdAt}
+		msg := ""
+		if reject, reason := window.rejectPast(context.Background(), event); reject {
+			msg = reason
+		}
+		if reject, reason := window.rejectFuture(context.Background(), event); reject {
+			msg = reason
+		}
+		if msg != test.msg {
+			t.Errorf("%s: expected %q, got %q", test.name, test.msg, msg)
+		}
+	}
+
+	// without an override gift wraps are not checked at all
+	window.kinds = nil
+	if reject, _ := window.rejectPast(context.Background(), &nostr.Event{Kind: 1059, CreatedAt: now - 30*24*3600}); reject {
+		t.Error("expected gift wraps to be exempt without an override")
+	}
+	if !(createdAtWindow{kinds: map[int]createdAtDrift{1: {past: time.Hour}}}).limitsPast() || (createdAtWindow{kinds: map[int]createdAtDrift{1: {past: time.Hour}}}).limitsFuture() {
+		t.Error("expected an override alone to enable only its own limit")
+	}
+}
+
+func TestFilterLimits(t *testing.T) {
+	limits := filterLimits{maxSubID: 16, maxValue: 64}
+	relay := khatru.NewRelay()
+	relay.OverwriteFilter = append(relay.OverwriteFilter, limits.overwriteFilter)
+	relay.RejectFilter = append(relay.RejectFilter, limits.rejectFilter)
+	relay.QueryEvents = append(relay.QueryEvents, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events := make(chan *nostr.Event)
+		close(events)
+		return events, nil
+	})
+	conn := dialRelay(t, relay)
+
+	if reply := sendReq(t, conn, "feed", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected a short subscription id to be served, got %v", reply)
+	}
+
+	long := strings.Repeat("x", 17)
+	for _, filter := range []string{`{"kinds":[1]}`, `{"kinds":[1],"limit":0}`} {
+		if reply := sendReq(t, conn, long, filter); reply[0] != "NOTICE" || reply[1] != "invalid: subscription id longer than 16 characters" {
+			t.Fatalf("expected a NOTICE for an oversized subscription id, got %v", reply)
+		}
+		if reply := readEnvelope(t, conn); reply[0] != "CLOSED" || reply[1] != long {
+			t.Fatalf("expected the oversized subscription to be closed, got %v", reply)
+		}
+	}
+
+	value := strings.Repeat("a", 65)
+	if reply := sendReq(t, conn, "tags", `{"#t":["`+value+`"]}`); reply[0] != "NOTICE" || reply[1] != "invalid: filter value longer than 64 characters" {
+		t.Fatalf("expected a NOTICE for an oversized tag value, got %v", reply)
+	}
+	readEnvelope(t, conn)
+
+	for _, filter := range []nostr.Filter{{IDs: []string{value}}, {Authors: []string{value}}, {Search: value}} {
+		if reject, _ := limits.rejectCountFilter(context.Background(), filter); !reject {
+			t.Errorf("expected %v to be refused", filter)
+		}
+	}
+	if reject, _ := limits.rejectCountFilter(context.Background(), nostr.Filter{Authors: []string{value[:64]}}); reject {
+		t.Error("expected values at the limit to be accepted")
+	}
+	if (filterLimits{}).oversized(long, nostr.Filter{Search: value}) != "" {
+		t.Error("expected zero limits to be disabled")
+	}
+}
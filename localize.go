@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// messageCatalog translates the human-readable part of reject messages, by language and the
+// English text, e.g. {"de": {"zap required": "Zap erforderlich"}}.
+type messageCatalog map[string]map[string]string
+
+// loadMessageCatalog reads a JSON catalog from path. Language codes are matched
+// case-insensitively.
+func loadMessageCatalog(path string) (messageCatalog, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var parsed messageCatalog
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid message catalog: %w", err)
+	}
+	catalog := make(messageCatalog, len(parsed))
+	for language, messages := range parsed {
+		catalog[strings.ToLower(language)] = messages
+	}
+	return catalog, nil
+}
+
+// preferredLanguages returns the languages a client hints at, most preferred first: the lang
+// query parameter of the websocket URL, for clients that cannot set headers, then the
+// Accept-Language header by quality.
+func preferredLanguages(r *http.Request) []string {
+	var languages []string
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		languages = append(languages, strings.ToLower(lang))
+	}
+
+	type weighted struct {
+		language string
+		q        float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		language, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if language == "" || language == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted = append(accepted, weighted{strings.ToLower(language), q})
+	}
+	slices.SortStableFunc(accepted, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	for _, a := range accepted {
+		languages = append(languages, a.language)
+	}
+	return languages
+}
+
+// messages returns the catalog of the first language the client prefers that has one, trying
+// each language before its base language (pt-br, then pt). nil means English.
+func (c messageCatalog) messages(languages []string) map[string]string {
+	for _, language := range languages {
+		if messages, ok := c[language]; ok {
+			return messages
+		}
+		if base, _, ok := strings.Cut(language, "-"); ok {
+			if messages, ok := c[base]; ok {
+				return messages
+			}
+		}
+	}
+	return nil
+}
+
+// localize translates msg for the client behind ctx. A machine-readable prefix such as
+// "blocked:" is kept as is and only the rest is translated; messages without a translation
+// stay in English.
+func (c messageCatalog) localize(ctx context.Context, msg string) string {
+	ws := getConnection(ctx)
+	if ws == nil || ws.Request == nil {
+		return msg
+	}
+	messages := c.messages(preferredLanguages(ws.Request))
+	if messages == nil {
+		return msg
+	}
+
+	prefix, text := "", msg
+	if before, after, ok := strings.Cut(msg, ": "); ok && isMachineReadablePrefix(before) {
+		prefix, text = before+": ", after
+	}
+	if translated, ok := messages[text]; ok {
+		return prefix + translated
+	}
+	return msg
+}
+
+// isMachineReadablePrefix reports whether s looks like a NIP-01 prefix, e.g. auth-required.
+func isMachineReadablePrefix(s string) bool {
+	return s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyz-") == ""
+}
+
+// localizeRejections wraps event or filter policies so their reject messages are translated.
+// It has to be applied after the last policy is added.
+func localizeRejections[T any](c messageCatalog, policies []func(context.Context, T) (bool, string)) []func(context.Context, T) (bool, string) {
+	wrapped := make([]func(context.Context, T) (bool, string), len(policies))
+	for i, policy := range policies {
+		wrapped[i] = func(ctx context.Context, value T) (bool, string) {
+			reject, msg := policy(ctx, value)
+			if reject {
+				msg = c.localize(ctx, msg)
+			}
+			return reject, msg
+		}
+	}
+	return wrapped
+}
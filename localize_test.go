@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPreferredLanguages(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?lang=fr", nil)
+	r.Header.Set("Accept-Language", "en;q=0.5, de-AT, *;q=0.1, pt-BR;q=0.8")
+	want := []string{"fr", "de-at", "pt-br", "en"}
+	if got := preferredLanguages(r); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestLocalizeRejections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.json")
+	os.WriteFile(path, []byte(`{"DE": {"zap required": "Zap erforderlich", "this is a private relay, only authorized users can write here": "privates Relay"}}`), 0o600)
+	catalog, err := loadMessageCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies := localizeRejections(catalog, []func(context.Context, *nostr.Event) (bool, string){
+		func(ctx context.Context, event *nostr.Event) (bool, string) {
+			return event.Content != "", event.Content
+		},
+	})
+	reject := policies[0]
+
+	german := httptest.NewRequest("GET", "/", nil)
+	german.Header.Set("Accept-Language", "de-CH, en;q=0.5")
+	withConnection(t, &khatru.WebSocket{Request: german})
+	for msg, want := range map[string]string{
+		"blocked: zap required": "blocked: Zap erforderlich",
+		"this is a private relay, only authorized users can write here": "privates Relay",
+		"invalid: too many tags": "invalid: too many tags",
+		"":                       "",
+	} {
+		if _, got := reject(context.Background(), &nostr.Event{Content: msg}); got != want {
+			t.Errorf("%q: expected %q, got %q", msg, want, got)
+		}
+	}
+
+	english := httptest.NewRequest("GET", "/", nil)
+	english.Header.Set("Accept-Language", "en-US")
+	withConnection(t, &khatru.WebSocket{Request: english})
+	if _, got := reject(context.Background(), &nostr.Event{Content: "blocked: zap required"}); got != "blocked: zap required" {
+		t.Errorf("expected English to be kept, got %q", got)
+	}
+
+	if _, err := loadMessageCatalog(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected a missing catalog to fail")
+	}
+}
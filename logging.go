@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimitedWriter passes through at most maxLines writes per interval and drops the rest,
+// reporting how many lines were dropped once the next interval starts.
+// A maxLines of zero or less disables the limit.
+// It keeps routine client noise (malformed messages, abrupt disconnects) from flooding the log.
+type rateLimitedWriter struct {
+	mu          sync.Mutex
+	out         io.Writer
+	maxLines    int
+	interval    time.Duration
+	windowStart time.Time
+	written     int
+	dropped     int
+}
+
+func newRateLimitedWriter(out io.Writer, maxLines int, interval time.Duration) *rateLimitedWriter {
+	return &rateLimitedWriter{out: out, maxLines: maxLines, interval: interval}
+}
+
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	if w.maxLines <= 0 {
+		return w.out.Write(p)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(w.windowStart) >= w.interval {
+		if w.dropped > 0 {
+			fmt.Fprintf(w.out, "(dropped %d log lines in the last %s)\n", w.dropped, w.interval)
+		}
+		w.windowStart = now
+		w.written = 0
+		w.dropped = 0
+	}
+
+	if w.written >= w.maxLines {
+		w.dropped++
+		// report success so the logger does not treat dropping as an error
+		return len(p), nil
+	}
+	w.written++
+	return w.out.Write(p)
+}
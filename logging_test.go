@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+)
+
+func TestRateLimitedWriterDropsExcessLines(t *testing.T) {
+	var out bytes.Buffer
+	writer := newRateLimitedWriter(&out, 2, time.Hour)
+
+	for i := 0; i < 5; i++ {
+		writer.Write([]byte("line\n"))
+	}
+	if got := strings.Count(out.String(), "line\n"); got != 2 {
+		t.Fatalf("expected 2 lines to pass, got %d", got)
+	}
+	if writer.dropped != 3 {
+		t.Fatalf("expected 3 dropped lines, got %d", writer.dropped)
+	}
+}
+
+// dialRelay opens a websocket connection to a test server running relay.
+func dialRelay(t *testing.T, relay *khatru.Relay) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readEnvelope reads the next message from conn as a JSON array.
+func readEnvelope(t *testing.T, conn *websocket.Conn) []any {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	var message []any
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatalf("relay sent invalid JSON %q: %v", data, err)
+	}
+	return message
+}
+
+func TestBrokenJSONGetsNotice(t *testing.T) {
+	relay := khatru.NewRelay()
+	var logged bytes.Buffer
+	relay.Log = log.New(newRateLimitedWriter(&logged, 60, time.Minute), "", 0)
+	gate := &messageGate{maxMessage: relay.MaxMessageSize, checks: []messageCheck{unparsableMessage}}
+	relay.OnConnect = append(relay.OnConnect, gate.onConnect)
+	server := httptest.NewServer(gate.middleware(relay))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	for _, broken := range []string{`["EVENT", {"id": "abc"`, `not json at all`, `["REQ", "sub",`} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(broken)); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+		if message := readEnvelope(t, conn); len(message) != 2 || message[0] != "NOTICE" || message[1] != "invalid: could not parse message" {
+			t.Fatalf("expected an invalid NOTICE for %q, got %v", broken, message)
+		}
+	}
+
+	// the connection must still be usable after the garbage
+	conn.WriteMessage(websocket.TextMessage, []byte(`["REQ","sub",{"kinds":[1]}]`))
+	if message := readEnvelope(t, conn); len(message) < 1 || message[0] != "EOSE" {
+		t.Fatalf("expected EOSE after a valid REQ, got %v", message)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("expected client garbage not to be logged, got %q", logged.String())
+	}
+}
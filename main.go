@@ -3,54 +3,470 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/fiatjaf/eventstore/postgresql"
 	"github.com/fiatjaf/khatru"
 	"github.com/fiatjaf/khatru/policies"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
 	"github.com/nbd-wtf/go-nostr/nip86"
 )
 
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+func main() {
+	// subcommands such as sync-allowlist run instead of the relay
+	if exitCode, handled := runCommand(os.Args[1:]); handled {
+		os.Exit(exitCode)
+	}
+
+	// cancelled on SIGINT/SIGTERM so background jobs and the server can stop cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	handler, closeRelay := setupRelay(ctx)
+	defer closeRelay()
+
+	// optionally host more relays in this process, each with its own settings, picked by the
+	// Host header of the request; other hosts get the relay configured above
+	if entries := getEnvList("VIRTUAL_RELAYS", nil); len(entries) > 0 {
+		hosts := newVirtualHosts(handler)
+		for _, virtual := range parseVirtualRelays(entries) {
+			overrides, err := readEnvFileAt(virtual.envFile)
+			if err != nil {
+				panic(fmt.Sprintf("Failed to read the settings of virtual relay %s: %v", virtual.host, err))
+			}
+			log.Printf("Setting up virtual relay %s from %s", virtual.host, virtual.envFile)
+			var virtualHandler http.Handler
+			var closeVirtual func()
+			withEnv(overrides, func() { virtualHandler, closeVirtual = setupRelay(ctx) })
+			defer closeVirtual()
+			hosts.add(virtual.host, virtualHandler)
+		}
+		handler = hosts
+	}
+
+	// start the server
+	server := &http.Server{Addr: ":3334", Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	fmt.Println("running on :3334")
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Server error: %v", err)
 	}
-	return fallback
 }
 
-func main() {
+// setupRelay builds a relay from the settings, starting its background jobs, and returns its
+// HTTP handler and a function that closes its database connections.
+func setupRelay(ctx context.Context) (http.Handler, func()) {
 	// create the relay instance
 	relay := khatru.NewRelay()
 
+	// khatru logs misbehaving clients (bad frames, abrupt disconnects); keep that from flooding the log
+	relay.Log = log.New(newRateLimitedWriter(os.Stderr, getEnvInt("RELAY_LOG_MAX_LINES_PER_MINUTE", 60), time.Minute), "[khatru-relay] ", log.LstdFlags)
+
 	// set up some basic properties (will be returned on the NIP-11 endpoint)
 	relay.Info.Name = getEnv("RELAY_NAME", "brove relay")
 	relay.Info.PubKey = getEnv("RELAY_PUBKEY", "82c1b69ddb84fb9a8cc68616118a9a1c794dfeb29c8d2ea2cec59af21f9df804")
 	relay.Info.Description = getEnv("RELAY_DESCRIPTION", "this is my custom and private relay")
 	relay.Info.Icon = getEnv("RELAY_ICON", "https://external-content.duckduckgo.com/iu/?u=https%3A%2F%2Fliquipedia.net%2Fcommons%2Fimages%2F3%2F35%2FSCProbe.jpg&f=1&nofb=1&ipt=0cbbfef25bce41da63d910e86c3c343e6c3b9d63194ca9755351bb7c2efa3359&ipo=images")
-	relay.Info.Version = "0.1.0"
-	relay.Info.Software = "https://github.com/mroxso/brove"
+	relay.Info.Version = buildVersion()
+	relay.Info.Software = buildSoftware()
+	// NIP-77 negentropy sync; sessions go through the same filter policies as REQs, so only
+	// authenticated readers can reconcile
+	relay.Negentropy = getEnvBool("NEGENTROPY", true)
+
+	relay.Info.Limitation = &nip11.RelayLimitationDocument{
+		AuthRequired:     true, // reading requires NIP-42 auth
+		RestrictedWrites: true, // only allowed pubkeys can write
+	}
+
+	// reverse proxies whose X-Forwarded-For header is believed when determining client IPs
+	trustedProxies := parseTrustedProxies(getEnvList("TRUSTED_PROXIES", nil))
+
+	databaseURL := getEnv("DATABASE_URL", defaultDatabaseURL)
+
+	// optionally keep this relay's tables in their own schema, e.g. for virtual relays sharing
+	// a database
+	if schema := getEnv("DATABASE_SCHEMA", ""); schema != "" {
+		if err := ensureSchema(databaseURL, schema); err != nil {
+			panic(fmt.Sprintf("Failed to create database schema %s: %v", schema, err))
+		}
+		withSchema, err := databaseURLWithSchema(databaseURL, schema)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to use database schema %s: %v", schema, err))
+		}
+		databaseURL = withSchema
+	}
 
 	// Initialize the event store database
-	db := postgresql.PostgresBackend{DatabaseURL: "postgresql://postgres:postgres@db:5432/khatru-relay?sslmode=disable"}
+	db := postgresql.PostgresBackend{DatabaseURL: databaseURL}
 	if err := db.Init(); err != nil {
 		panic(err)
 	}
+	if maxConns := getEnvInt("DATABASE_MAX_CONNECTIONS", 0); maxConns > 0 {
+		db.DB.SetMaxOpenConns(maxConns)
+	}
 
-	// Initialize the normal database manager for other data
-	dbManager, err := NewDBManager("postgresql://postgres:postgres@db:5432/khatru-relay?sslmode=disable")
+	// Initialize the normal database manager for other data, sharing the event store's pool
+	dbManager, err := newDBManager(db.DB.DB)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to initialize database manager: %v", err))
 	}
-	defer dbManager.Close()
+	// reads that fail on a connection broken by a postgres failover are retried on a fresh one
+	if retries := getEnvInt("DATABASE_FAILOVER_RETRIES", defaultFailoverRetries); retries >= 0 {
+		dbManager.failoverRetries = retries
+	} else {
+		log.Printf("Invalid DATABASE_FAILOVER_RETRIES %d, must not be negative, using %d", retries, defaultFailoverRetries)
+	}
+	dbManager.failoverDelay = getEnvPositiveDuration("DATABASE_FAILOVER_RETRY_DELAY", defaultFailoverDelay)
+
+	// report which database the events and the membership tables live in, and catch a setup
+	// where they diverge or one of them is unreachable before it shows up as rejected writes
+	divergence := getEnv("DATABASE_DIVERGENCE", "warn")
+	if divergence != "warn" && divergence != "fail" {
+		log.Printf("Invalid DATABASE_DIVERGENCE %q, must be warn or fail, using warn", divergence)
+		divergence = "warn"
+	}
+	statuses := checkSubsystemDatabases(ctx, []subsystemDatabase{
+		{name: "event store", db: db.DB.DB},
+		{name: "membership tables", db: dbManager.db},
+	})
+	if err := reportDatabases(statuses); err != nil && divergence == "fail" {
+		panic(fmt.Sprintf("Refusing to start with DATABASE_DIVERGENCE=fail: %v", err))
+	}
+
+	// seed a fresh, empty allowlist with the genesis allowlist baked into the binary
+	if getEnvBool("GENESIS_ALLOWLIST", true) {
+		if err := importGenesisAllowlist(genesisAllowlist, dbManager.AllowPubkeysIfEmpty); err != nil {
+			log.Printf("Failed to import the genesis allowlist: %v", err)
+		}
+	}
+
+	// optionally put the owner into a fresh, empty allowlist
+	if getEnvBool("BOOTSTRAP_OWNER", false) {
+		if err := bootstrapOwner(getEnv("RELAY_PUBKEY", ""), dbManager.AllowPubkeyIfEmpty); err != nil {
+			log.Printf("Failed to bootstrap the owner: %v", err)
+		}
+	}
+
+	// optionally cache recent allowlist decisions instead of querying for every check
+	isAllowedPubkey := dbManager.IsAllowedPubkey
+	var allowed *allowCache
+	cacheSize := getEnvInt("ALLOWLIST_CACHE_SIZE", 0)
+	if cacheSize < 0 {
+		log.Printf("Invalid ALLOWLIST_CACHE_SIZE %d, must not be negative, not caching allowlist decisions", cacheSize)
+	} else if cacheSize > 0 {
+		allowed = newAllowCache(dbManager.IsAllowedPubkey, cacheSize, getEnvPositiveDuration("ALLOWLIST_CACHE_TTL", time.Minute))
+		isAllowedPubkey = allowed.isAllowed
+	}
+
+	// when set, pubkeys allowed through the management API only get access for this long
+	entryTTL := getEnvDuration("ALLOWLIST_ENTRY_TTL", 0)
+
+	// optionally group inserts under write bursts; each client still waits for its own batch to commit
+	saveEvent := db.SaveEvent
+	if batchSize := getEnvInt("BATCH_SIZE", 0); batchSize > 1 {
+		batchInterval := getEnvPositiveDuration("BATCH_INTERVAL", 20*time.Millisecond)
+		saveEvent = newBatchWriter(ctx, batchSize, batchInterval, flushEvents(db.DB.DB)).SaveEvent
+	}
+
+	relay.StoreEvent = append(relay.StoreEvent, saveEvent)
+	// ping connections so proxies keep idle ones open, and reap those that stop answering
+	pingInterval := getEnvDuration("WS_PING_INTERVAL", 30*time.Second)
+	if pingInterval < 0 {
+		log.Printf("Invalid WS_PING_INTERVAL %s, must not be negative, using 30s", pingInterval)
+		pingInterval = 30 * time.Second
+	}
+	configureKeepalive(relay, pingInterval, getEnvPositiveDuration("WS_PONG_TIMEOUT", 60*time.Second))
+
+	// per-connection budgets: a bounded number of concurrent queries, and queries to clients that
+	// stop reading are abandoned
+	maxQueuedQueries := getEnvInt("MAX_QUEUED_QUERIES_PER_CONNECTION", -1)
+	if maxQueuedQueries < -1 {
+		log.Printf("Invalid MAX_QUEUED_QUERIES_PER_CONNECTION %d, using -1 (no limit)", maxQueuedQueries)
+		maxQueuedQueries = -1
+	}
+	connections := newConnectionTracker(
+		getEnvPositiveInt("MAX_CONCURRENT_QUERIES_PER_CONNECTION", 8),
+		maxQueuedQueries,
+		getEnvPositiveDuration("SLOW_CLIENT_TIMEOUT", 10*time.Second),
+		trustedProxies,
+	)
+	// read query results ahead of the client up to QUERY_BUFFER_BYTES per connection, pausing
+	// the store's cursor when a slow client has not drained them
+	if bufferBytes := getEnvInt("QUERY_BUFFER_BYTES", 1<<20); bufferBytes < 0 {
+		log.Printf("Invalid QUERY_BUFFER_BYTES %d, must not be negative, streaming results without a buffer", bufferBytes)
+	} else {
+		connections.bufferBytes = int64(bufferBytes)
+	}
+	relay.OnConnect = append(relay.OnConnect, connections.onConnect)
+	relay.OnDisconnect = append(relay.OnDisconnect, connections.onDisconnect)
+
+	// a global cap on websocket connections; connections beyond it queue for a slot
+	limiter := newConnectionLimiter(getEnvInt("MAX_CONNECTIONS", 0), getEnvPositiveDuration("CONNECTION_WAIT_TIMEOUT", 5*time.Second))
+	relay.OnDisconnect = append(relay.OnDisconnect, limiter.onDisconnect)
+
+	// check messages as they come off the websocket, before they are parsed and their signature
+	// is verified: answer garbage with a clear NOTICE and optionally drop oversized EVENTs
+	messages := &messageGate{maxMessage: relay.MaxMessageSize, checks: []messageCheck{unparsableMessage}}
+	if maxBytes := getEnvInt("MAX_EVENT_MESSAGE_BYTES", 0); maxBytes < 0 {
+		log.Printf("Invalid MAX_EVENT_MESSAGE_BYTES %d, must not be negative, not limiting EVENT messages", maxBytes)
+	} else {
+		messages.maxEvent = int64(maxBytes)
+	}
+	// optionally refuse events with top-level fields outside NIP-01, which only the raw message
+	// still has
+	if getEnvBool("STRICT_EVENT_SCHEMA", false) {
+		messages.checks = append(messages.checks, strictEventSchema)
+	}
+	// optionally tell clients that send uppercase hex why their events are refused
+	if getEnvBool("REJECT_UPPERCASE_HEX", false) {
+		messages.checks = append(messages.checks, uppercaseHex)
+	}
+	relay.OnConnect = append(relay.OnConnect, messages.onConnect)
+
+	// soft per-IP limits on the plain HTTP routes, per route group
+	httpLimiter := newHTTPRateLimiter(loadHTTPRateLimits(), trustedProxies)
+
+	// bound subscription ids and filter values before any other hook sees them
+	filterLimits := filterLimits{maxSubID: getEnvInt("MAX_SUBID_LENGTH", 256), maxValue: getEnvInt("MAX_FILTER_VALUE_LENGTH", 1024)}
+	if filterLimits.maxSubID < 0 {
+		log.Printf("Invalid MAX_SUBID_LENGTH %d, must not be negative, using 256", filterLimits.maxSubID)
+		filterLimits.maxSubID = 256
+	}
+	if filterLimits.maxValue < 0 {
+		log.Printf("Invalid MAX_FILTER_VALUE_LENGTH %d, must not be negative, using 1024", filterLimits.maxValue)
+		filterLimits.maxValue = 1024
+	}
+	relay.OverwriteFilter = append(relay.OverwriteFilter, filterLimits.overwriteFilter)
+	relay.RejectFilter = append(relay.RejectFilter, filterLimits.rejectFilter)
+	relay.RejectCountFilter = append(relay.RejectCountFilter, filterLimits.rejectCountFilter)
+	relay.Info.Limitation.MaxSubidLength = filterLimits.maxSubID
+
+	// a REQ reusing an open subscription id replaces that subscription (NIP-01), unless that is
+	// turned off
+	subscriptions := newSubscriptionTracker(relay, getEnvBool("REPLACE_DUPLICATE_SUBSCRIPTIONS", true), getEnvInt("MAX_SUBSCRIPTION_REPLACEMENTS_PER_MINUTE", 0))
+	relay.OverwriteFilter = append(relay.OverwriteFilter, subscriptions.overwriteFilter)
+	relay.RejectFilter = append(relay.RejectFilter, subscriptions.rejectFilter)
+	relay.OnDisconnect = append(relay.OnDisconnect, subscriptions.onDisconnect)
+
+	// maintenance mode pauses every write; it is persisted so it survives a restart
+	maintenance, err := loadMaintenanceMode(dbManager)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load maintenance mode: %v", err))
+	}
+	relay.RejectEvent = append(relay.RejectEvent, maintenance.rejectEvent)
+	relay.Router().HandleFunc("/admin/maintenance", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminMaintenance(maintenance)))
+
+	// only accept standard schnorr signatures over secp256k1
+	if getEnvBool("STRICT_SIGNATURE_FORMAT", true) {
+		relay.RejectEvent = append(relay.RejectEvent, rejectUnsupportedSignature)
+	}
+
+	// optionally refuse to even look at events from connections that have not authenticated
+	if getEnvBool("REQUIRE_AUTH_BEFORE_EVENT", false) {
+		relay.RejectEvent = append(relay.RejectEvent, requireAuthBeforeEvent)
+	}
+
+	// optionally refuse events on authenticated connections that were created before the
+	// connection authenticated, so old signed events can't be replayed through a new session
+	if getEnvBool("ENFORCE_POST_AUTH_TIMESTAMP", false) {
+		authed := newAuthTimes()
+		relay.OnConnect = append(relay.OnConnect, authed.onConnect)
+		relay.OnDisconnect = append(relay.OnDisconnect, authed.onDisconnect)
+		relay.RejectEvent = append(relay.RejectEvent, skipGiftWraps(authed.rejectEvent))
+		relay.RejectFilter = append(relay.RejectFilter, authed.rejectFilter)
+	}
+
+	// optionally make NIP-42 authentication expire, so clients have to AUTH again after
+	// AUTH_SESSION_MAX_AGE; registered before the other policies so that expired sessions
+	// are unauthenticated before anything checks them
+	if maxAge := getEnvDuration("AUTH_SESSION_MAX_AGE", 0); maxAge < 0 {
+		log.Printf("Invalid AUTH_SESSION_MAX_AGE %s, must not be negative, not expiring sessions", maxAge)
+	} else if maxAge > 0 {
+		expiry := newSessionExpiry(relay, maxAge)
+		relay.OnConnect = append(relay.OnConnect, expiry.onConnect)
+		relay.OnDisconnect = append(relay.OnDisconnect, expiry.onDisconnect)
+		relay.OverwriteFilter = append(relay.OverwriteFilter, expiry.overwriteFilter)
+		relay.RejectEvent = append(relay.RejectEvent, expiry.rejectEvent)
+		relay.RejectFilter = append(relay.RejectFilter, expiry.rejectFilter)
+		relay.RejectCountFilter = append(relay.RejectCountFilter, expiry.rejectFilter)
+	}
+
+	// optionally allow one authenticated connection per pubkey; registered before the other
+	// policies so that every authenticated action is seen
+	if getEnvBool("SINGLE_SESSION_PER_PUBKEY", false) {
+		sessions := newSessionTracker()
+		relay.RejectEvent = append(relay.RejectEvent, sessions.rejectEvent)
+		relay.RejectFilter = append(relay.RejectFilter, sessions.rejectFilter)
+		relay.RejectCountFilter = append(relay.RejectCountFilter, sessions.rejectFilter)
+		relay.OverwriteFilter = append(relay.OverwriteFilter, sessions.overwriteFilter)
+		relay.OnDisconnect = append(relay.OnDisconnect, sessions.onDisconnect)
+	}
 
-	relay.StoreEvent = append(relay.StoreEvent, db.SaveEvent)
-	relay.QueryEvents = append(relay.QueryEvents, db.QueryEvents)
+	// close the connections of pubkeys as soon as they are removed from the allowlist;
+	// registered before the other policies so that every authenticated action is seen
+	revoker := newAccessRevoker()
+	relay.RejectEvent = append(relay.RejectEvent, revoker.rejectEvent)
+	relay.OverwriteFilter = append(relay.OverwriteFilter, revoker.overwriteFilter)
+	relay.OnDisconnect = append(relay.OnDisconnect, revoker.onDisconnect)
+
+	queryEvents := onlyRecipientGiftWraps(db.QueryEvents)
+	// optionally serve NIP-50 search from a normalized copy of the content
+	if getEnvBool("SEARCH_INDEX", false) {
+		rules, unknown := parseSearchRules(getEnvList("SEARCH_NORMALIZE", defaultSearchRules))
+		if len(unknown) > 0 {
+			log.Printf("Ignoring unknown SEARCH_NORMALIZE rules %v", unknown)
+		}
+		index, err := newSearchIndex(db.DB.DB, rules, getEnvKinds("SEARCH_KINDS", []int{nostr.KindTextNote, nostr.KindComment, nostr.KindArticle}))
+		if err != nil {
+			panic(fmt.Sprintf("Failed to set up the search index: %v", err))
+		}
+		queryEvents = index.wrapQuery(queryEvents)
+		relay.OnEventSaved = append(relay.OnEventSaved, index.onSaved)
+		relay.DeleteEvent = append(relay.DeleteEvent, index.onDeleted)
+		relay.Info.AddSupportedNIP(50)
+		go index.backfill(ctx)
+	}
+	// optionally hold back events with a future publish_at tag until their time has come
+	var scheduled *scheduler
+	if getEnvBool("SCHEDULED_EVENTS", false) {
+		pending, err := newStoredPendingEvents(db.DB.DB)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to set up scheduled events: %v", err))
+		}
+		scheduled = newScheduler(relay, pending, getEnvPositiveDuration("SCHEDULE_MAX_DELAY", 30*24*time.Hour))
+		relay.RejectEvent = append(relay.RejectEvent, scheduled.rejectEvent)
+		relay.StoreEvent = append([]func(context.Context, *nostr.Event) error{scheduled.storeEvent}, relay.StoreEvent...)
+		relay.ReplaceEvent = append(relay.ReplaceEvent, scheduled.storeEvent)
+		queryEvents = scheduled.wrapQuery(getEnv("RELAY_PUBKEY", ""), queryEvents)
+	}
+	// optionally collect NIP-56 reports for the owner and escalate much-reported targets
+	if getEnvBool("REPORTS", false) {
+		store, err := newStoredReports(db.DB.DB)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to set up reports: %v", err))
+		}
+		threshold := getEnvInt("REPORT_THRESHOLD", 3)
+		if threshold < 0 {
+			log.Printf("Invalid REPORT_THRESHOLD %d, must not be negative, using 0 (never escalate)", threshold)
+			threshold = 0
+		}
+		action := getEnv("REPORT_ACTION", "flag")
+		if action != "flag" && action != "hide" {
+			log.Printf("Invalid REPORT_ACTION %q, must be flag or hide, using flag", action)
+			action = "flag"
+		}
+		reports, err := loadReportModeration(ctx, store, threshold, action == "hide", getEnv("RELAY_PUBKEY", ""))
+		if err != nil {
+			panic(fmt.Sprintf("Failed to load reports: %v", err))
+		}
+		relay.OnEventSaved = append(relay.OnEventSaved, reports.onSaved)
+		if reports.hide {
+			queryEvents = reports.wrapQuery(queryEvents)
+		}
+		relay.Router().HandleFunc("/admin/reports", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminReports(reports)))
+	}
+	// optionally send stored results of chat-like kinds oldest first
+	if oldestFirst := loadOldestFirstKinds(); len(oldestFirst) > 0 {
+		queryEvents = oldestFirst.wrapQuery(queryEvents)
+	}
+	// optionally strip tags such as client metadata from the events served to everyone but the owner
+	queryPage := storedEventPage(db.DB.DB)
+	if names := getEnvList("OUTPUT_STRIP_TAGS", nil); len(names) > 0 {
+		stripper := tagStripper{names: names, owner: getEnv("RELAY_PUBKEY", "")}
+		queryEvents = stripper.wrapQuery(queryEvents)
+		queryPage = stripper.wrapPage(queryPage)
+		relay.PreventBroadcast = append(relay.PreventBroadcast, stripper.preventBroadcast)
+	}
+	relay.QueryEvents = append(relay.QueryEvents, connections.wrapQuery(queryEvents))
 	relay.CountEvents = append(relay.CountEvents, db.CountEvents)
 	relay.DeleteEvent = append(relay.DeleteEvent, db.DeleteEvent)
-	relay.ReplaceEvent = append(relay.ReplaceEvent, db.ReplaceEvent)
+	// replace replaceable events in a transaction, so queries never see a torn replacement
+	relay.ReplaceEvent = append(relay.ReplaceEvent, newStoredReplaceables(db.DB.DB, loadReplaceableIsolation()).replaceEvent)
+
+	// optionally let anyone reply to members, so conversations on the relay are not one-sided
+	var replies *memberReplies
+	if getEnvBool("ALLOW_REPLIES_TO_MEMBERS", false) {
+		replies = newMemberReplies(&db, func(pubkey string) (bool, error) {
+			if pubkey == getEnv("RELAY_PUBKEY", "") {
+				return true, nil
+			}
+			return isAllowedPubkey(pubkey)
+		})
+	}
+
+	// the optional policies below are named, so any of them can be listed in DRY_RUN_POLICIES
+	// to log what it would reject without enforcing it
+	dryRun := newDryRunPolicies(getEnvList("DRY_RUN_POLICIES", nil))
+	// and trusted pubkeys can be exempted from any of them through /admin/exemptions
+	exemptions, err := loadPolicyExemptions(dbManager)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load policy exemptions: %v", err))
+	}
+	dryRun.exemptions = exemptions
+	relay.Router().HandleFunc("/admin/exemptions", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminExemptions(exemptions)))
+	// and what the dry-run policies would have rejected is kept for /admin/dryrun
+	if len(dryRun.names) > 0 {
+		dryRun.decisions = newDecisionLog(getEnvPositiveInt("DRY_RUN_REPORT_SIZE", 10000))
+		relay.Router().HandleFunc("/admin/dryrun", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminDryRun(dryRun.decisions)))
+	}
+
+	// optional limits on tag count, advertised in NIP-11 so clients can pre-validate; they only
+	// look at the event, so they run ahead of the allowlist lookup and every other database check
+	var nip11Extensions []nip11Extension
+	if maxTags := getEnvInt("MAX_EVENT_TAGS", 0); maxTags > 0 {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("max_event_tags", maxEventTags(maxTags)))
+		relay.Info.Limitation.MaxEventTags = maxTags
+	}
+	maxETags, maxPTags := getEnvInt("MAX_E_TAGS", 500), getEnvInt("MAX_P_TAGS", 500)
+	if maxETags < 0 {
+		log.Printf("Invalid MAX_E_TAGS %d, must not be negative, using 500", maxETags)
+		maxETags = 500
+	}
+	if maxPTags < 0 {
+		log.Printf("Invalid MAX_P_TAGS %d, must not be negative, using 500", maxPTags)
+		maxPTags = 500
+	}
+	if maxETags > 0 || maxPTags > 0 {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("max_mentions", maxMentions(maxETags, maxPTags)))
+	}
+
+	// optionally limit how far created_at may be from now, with overrides per kind; like the tag
+	// limits this only looks at the event
+	maxPast, maxFuture := getEnvDuration("CREATED_AT_MAX_PAST", 0), getEnvDuration("CREATED_AT_MAX_FUTURE", 0)
+	if maxPast < 0 {
+		log.Printf("Ignoring CREATED_AT_MAX_PAST %s: the limit must not be negative", maxPast)
+		maxPast = 0
+	}
+	if maxFuture < 0 {
+		log.Printf("Ignoring CREATED_AT_MAX_FUTURE %s: the limit must not be negative", maxFuture)
+		maxFuture = 0
+	}
+	window := createdAtWindow{
+		defaults: createdAtDrift{past: maxPast, future: maxFuture},
+		kinds:    parseCreatedAtKindLimits(getEnvList("CREATED_AT_KIND_LIMITS", nil)),
+		now:      nostr.Now,
+	}
+	if window.limitsPast() {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("created_at_past", window.rejectPast))
+	}
+	if window.limitsFuture() {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("created_at_future", window.rejectFuture))
+	}
+	if maxPast > 0 || maxFuture > 0 {
+		// NIP-11 has no per-kind limits, so only the defaults are advertised
+		nip11Extensions = append(nip11Extensions, createdAtLimits(maxPast, maxFuture))
+	}
 
 	relay.RejectEvent = append(relay.RejectEvent,
 		// built-in policies
@@ -59,20 +475,243 @@ func main() {
 
 		func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
 			ownerPubKey := getEnv("RELAY_PUBKEY", "")
+			// gift wraps are signed by a throwaway key, so they are accepted for allowed recipients instead
+			pubkey := event.PubKey
+			if isGiftWrap(event) {
+				pubkey = giftWrapRecipient(event)
+			}
+
 			// Check if the pubkey is allowed in the database
-			isAllowed, err := dbManager.IsAllowedPubkey(event.PubKey)
+			isAllowed, err := isAllowedPubkey(pubkey)
 			if err != nil {
 				log.Printf("Error checking if pubkey is allowed: %v", err)
 				return true, "error checking authorization"
 			}
 
-			if isAllowed || event.PubKey == ownerPubKey {
+			if isAllowed || pubkey == ownerPubKey {
 				return false, "" // allowed pubkey or owner can write
 			}
+			if replies != nil && replies.accepts(ctx, event) {
+				return false, "" // reply to a member
+			}
 			return true, "this is a private relay, only authorized users can write here"
 		},
 	)
 
+	// per-kind publishing budgets, e.g. RATE_LIMIT_KIND_7=60/min
+	if limits, fallback := loadKindRateLimits(); len(limits) > 0 || fallback.events > 0 {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("rate_limit", perKindRateLimiter(limits, fallback)))
+	}
+
+	// optionally cap the bytes each pubkey stores: its own quota if it has one, otherwise
+	// STORAGE_QUOTA_DEFAULT
+	if getEnvBool("STORAGE_QUOTAS", false) {
+		if err := dbManager.TrackStorageUsage(); err != nil {
+			panic(fmt.Sprintf("Failed to set up storage usage tracking: %v", err))
+		}
+		fallback := getEnvInt("STORAGE_QUOTA_DEFAULT", 0)
+		if fallback < 0 {
+			log.Printf("Invalid STORAGE_QUOTA_DEFAULT %d, must not be negative, using 0 (unlimited)", fallback)
+			fallback = 0
+		}
+		quota := storageQuota{usage: dbManager.GetStorageUsage, fallback: int64(fallback)}
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("storage_quota", skipGiftWraps(quota.reject)))
+		relay.Router().HandleFunc("/admin/storage", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminStorage(dbManager)))
+	}
+
+	// optionally require writers to have a profile older than MIN_ACCOUNT_AGE on the wider network
+	if minAge := getEnvDuration("MIN_ACCOUNT_AGE", 0); minAge > 0 {
+		up := newUpstream(ctx, getEnvList("BOOTSTRAP_RELAYS", defaultBootstrapRelays))
+		lookup := upstreamProfileLookup(up, getEnvPositiveDuration("UPSTREAM_TIMEOUT", 5*time.Second))
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("min_account_age", skipGiftWraps(minAccountAge(lookup, minAge, time.Hour, getEnv("RELAY_PUBKEY", "")))))
+	}
+
+	// optionally require owner events to come from an authenticated owner session
+	if getEnvBool("ENFORCE_OWNER_AUTH", false) {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("owner_auth", enforceOwnerAuth(getEnv("RELAY_PUBKEY", ""))))
+	}
+
+	// optionally pause writes from everyone but the owner during a daily window, e.g. for backups
+	var quietWindow *quietHours
+	if window := getEnv("QUIET_HOURS", ""); window != "" {
+		location, err := time.LoadLocation(getEnv("QUIET_HOURS_TIMEZONE", "UTC"))
+		if err != nil {
+			log.Printf("Invalid QUIET_HOURS_TIMEZONE, using UTC: %v", err)
+			location = time.UTC
+		}
+		if quiet, err := parseQuietHours(window, location); err != nil {
+			log.Printf("Ignoring QUIET_HOURS: %v", err)
+		} else {
+			relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("quiet_hours", rejectDuringQuietHours(quiet, getEnv("RELAY_PUBKEY", ""), time.Now)))
+			if dryRun.enforces("quiet_hours") {
+				quietWindow = &quiet
+			}
+		}
+	}
+
+	// optionally keep threads self-contained by rejecting replies to events not stored here
+	if getEnvBool("REQUIRE_STORED_PARENT", false) {
+		parents := newStoredParents(eventExists(db.DB.DB), getEnv("RELAY_PUBKEY", ""))
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("stored_parent", parents.reject))
+	}
+
+	// optionally reject copy-paste spam: the same normalized content more than
+	// DUPLICATE_CONTENT_MAX times within DUPLICATE_CONTENT_WINDOW
+	if maxCopies := getEnvInt("DUPLICATE_CONTENT_MAX", 0); maxCopies > 0 {
+		duplicates := newDuplicateContent(maxCopies,
+			getEnvPositiveDuration("DUPLICATE_CONTENT_WINDOW", time.Hour),
+			getEnvBool("DUPLICATE_CONTENT_PER_PUBKEY", false),
+			getEnvInt("DUPLICATE_CONTENT_MIN_LENGTH", 20),
+			getEnvPositiveInt("DUPLICATE_CONTENT_CACHE_SIZE", 10000))
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("duplicate_content", skipGiftWraps(duplicates.reject)))
+	}
+
+	// optionally require writers that are not allowed (e.g. repliers) to be followed by
+	// MIN_FOLLOWERS of the contact lists stored here
+	if minFollowers := getEnvInt("MIN_FOLLOWERS", 0); minFollowers > 0 {
+		gate := newFollowerGate(storedFollowerCount(db.DB.DB), minFollowers,
+			getEnvPositiveDuration("FOLLOWER_COUNT_TTL", 10*time.Minute),
+			func(pubkey string) (bool, error) {
+				if pubkey == getEnv("RELAY_PUBKEY", "") {
+					return true, nil
+				}
+				return isAllowedPubkey(pubkey)
+			})
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("min_followers", skipGiftWraps(gate.reject)))
+		relay.OnEventSaved = append(relay.OnEventSaved, gate.onSaved)
+	}
+
+	// optionally require writers that are not allowed to be followed by MIN_WOT_SCORE allowed
+	// pubkeys, scored from the contact lists stored here
+	if minScore := getEnvInt("MIN_WOT_SCORE", 0); minScore > 0 {
+		graph := newTrustGraph(func(pubkey string) (bool, error) {
+			if pubkey == getEnv("RELAY_PUBKEY", "") {
+				return true, nil
+			}
+			return isAllowedPubkey(pubkey)
+		})
+		if err := graph.loadContactLists(ctx, db.DB.DB); err != nil {
+			panic(fmt.Sprintf("Failed to build the web of trust: %v", err))
+		}
+		gate := wotGate{graph: graph, min: minScore}
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("min_wot_score", skipGiftWraps(gate.reject)))
+		relay.OnEventSaved = append(relay.OnEventSaved, graph.onSaved)
+		relay.Router().HandleFunc("/admin/wot", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminWoT(gate)))
+	}
+
+	// optionally gate writing on zaps to the owner, counted from the zap receipts stored here
+	var zapped *zapGate
+	if minSats := getEnvInt("ZAP_REQUIRED_SATS", 0); minSats > 0 {
+		if owner := getEnv("RELAY_PUBKEY", ""); owner == "" {
+			log.Printf("Not requiring zaps: ZAP_REQUIRED_SATS needs RELAY_PUBKEY to know whose zaps count")
+		} else {
+			issuers := getEnvList("ZAP_RECEIPT_PUBKEYS", nil)
+			gate := newZapGate(storedZapTotal(db.DB.DB, owner, issuers), owner, issuers, int64(minSats)*1000,
+				getEnvPositiveDuration("ZAP_PERIOD", 30*24*time.Hour),
+				getEnvPositiveDuration("ZAP_TOTAL_TTL", 10*time.Minute))
+			relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("zap_required", skipGiftWraps(gate.reject)))
+			relay.OnEventSaved = append(relay.OnEventSaved, gate.onSaved)
+			if dryRun.enforces("zap_required") {
+				zapped = gate
+			}
+		}
+	}
+	// advertise payment_required consistently with the gates above
+	paymentAdvertisement{
+		setting:     getEnv("PAYMENT_REQUIRED", "auto"),
+		paymentsURL: getEnv("PAYMENTS_URL", ""),
+		zap:         zapped,
+	}.apply(relay.Info)
+
+	// optionally reject events backdated to before the author's newest stored event
+	if getEnvBool("REJECT_BACKDATED_EVENTS", false) {
+		slack := getEnvDuration("BACKDATE_SLACK", time.Hour)
+		if slack < 0 {
+			log.Printf("Invalid BACKDATE_SLACK %s, must not be negative, using 1h", slack)
+			slack = time.Hour
+		}
+		guard := newBackdatingGuard(storedLatestEvent(db.DB.DB), slack, getEnv("RELAY_PUBKEY", ""))
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("backdated", guard.reject))
+		relay.OnEventSaved = append(relay.OnEventSaved, guard.onSaved)
+	}
+
+	// optionally check NIP-26 delegation tokens, so delegated posts can be accepted safely
+	if getEnvBool("VALIDATE_DELEGATION", false) {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("delegation", rejectBadDelegation))
+	}
+
+	// optionally keep profiles clean: json only checks that kind 0 content parses, fields also
+	// checks the known metadata fields
+	profileValidation := getEnv("PROFILE_VALIDATION", "off")
+	if profileValidation != "off" && profileValidation != "json" && profileValidation != "fields" {
+		log.Printf("Invalid PROFILE_VALIDATION %q, must be off, json or fields, using off", profileValidation)
+		profileValidation = "off"
+	}
+	if profileValidation != "off" {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("profile", rejectInvalidProfiles(profileValidation == "fields")))
+	}
+
+	// optionally flag pubkeys that replace their profile or follow list in quick succession,
+	// which may mean their key was stolen, and pause their writes until the owner reviews them
+	if maxChanges := getEnvInt("KEY_COMPROMISE_CHANGES", 0); maxChanges > 0 {
+		action := getEnv("KEY_COMPROMISE_ACTION", "flag")
+		if action != "flag" && action != "pause" {
+			log.Printf("Invalid KEY_COMPROMISE_ACTION %q, must be flag or pause, using flag", action)
+			action = "flag"
+		}
+		detector := newCompromiseDetector(getEnvKinds("KEY_COMPROMISE_KINDS", []int{nostr.KindProfileMetadata, nostr.KindFollowList}),
+			maxChanges, getEnvPositiveDuration("KEY_COMPROMISE_WINDOW", 10*time.Minute), action == "pause", time.Now)
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("key_compromise", detector.reject))
+		relay.OnEventSaved = append(relay.OnEventSaved, detector.onSaved)
+		relay.Router().HandleFunc("/admin/keycompromise", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminKeyCompromise(detector)))
+	}
+
+	// reject addressable events with a d tag that could give them more than one address
+	dValidation := getEnv("D_TAG_VALIDATION", "lenient")
+	if dValidation != "off" && dValidation != "lenient" && dValidation != "strict" {
+		log.Printf("Invalid D_TAG_VALIDATION %q, must be off, lenient or strict, using lenient", dValidation)
+		dValidation = "lenient"
+	}
+	if dValidation != "off" {
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("d_tag", rejectMalformedDTags(dValidation == "strict")))
+	}
+
+	// optionally keep a text relay text-only by refusing media embedded in the content
+	if getEnvBool("REJECT_INLINE_MEDIA", false) {
+		maxBytes := getEnvPositiveInt("INLINE_MEDIA_MAX_BYTES", 1024)
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("inline_media", skipGiftWraps(rejectInlineMedia(maxBytes, getEnv("RELAY_PUBKEY", "")))))
+	}
+
+	// optionally only accept, or refuse, events published by certain client apps (NIP-89)
+	requiredClients, blockedClients := getEnvList("REQUIRED_CLIENT_TAGS", nil), getEnvList("BLOCKED_CLIENT_TAGS", nil)
+	rejectMissingClient := getEnvBool("REJECT_MISSING_CLIENT_TAG", false)
+	if len(requiredClients) > 0 || len(blockedClients) > 0 || rejectMissingClient {
+		clients := newClientTagPolicy(requiredClients, blockedClients, rejectMissingClient)
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("client_tags", skipGiftWraps(clients.reject)))
+	}
+
+	// optionally curate events by the URLs in their r tags
+	if allowedRefs, blockedRefs := getEnvList("R_TAG_ALLOW", nil), getEnvList("R_TAG_BLOCK", nil); len(allowedRefs) > 0 || len(blockedRefs) > 0 {
+		references := referencePolicy{allowed: parseReferenceRules(allowedRefs), blocked: parseReferenceRules(blockedRefs)}
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("r_tags", skipGiftWraps(references.reject)))
+	}
+
+	// optionally let an external service accept or reject events; added last so it only sees
+	// events that passed every local check
+	if policyURL := getEnv("POLICY_WEBHOOK_URL", ""); policyURL != "" {
+		cacheTTL := getEnvDuration("POLICY_WEBHOOK_CACHE_TTL", 30*time.Second)
+		if cacheTTL < 0 {
+			log.Printf("Invalid POLICY_WEBHOOK_CACHE_TTL %s, must not be negative, using 30s", cacheTTL)
+			cacheTTL = 30 * time.Second
+		}
+		webhook := newPolicyWebhook(policyURL,
+			getEnvPositiveDuration("POLICY_WEBHOOK_TIMEOUT", 2*time.Second),
+			getEnvBool("POLICY_WEBHOOK_FAIL_OPEN", false),
+			cacheTTL)
+		relay.RejectEvent = append(relay.RejectEvent, dryRun.wrap("policy_webhook", webhook.reject))
+	}
+	dryRun.warnUnknown()
+
 	// you can request auth by rejecting an event or a request with the prefix "auth-required: "
 	relay.RejectFilter = append(relay.RejectFilter,
 		// built-in policies
@@ -84,7 +723,7 @@ func main() {
 			if pubkey := khatru.GetAuthed(ctx); pubkey != "" {
 				log.Printf("request from %s\n", pubkey)
 				// Check if the authenticated pubkey is allowed in the database
-				isAllowed, err := dbManager.IsAllowedPubkey(pubkey)
+				isAllowed, err := isAllowedPubkey(pubkey)
 				if err != nil {
 					log.Printf("Error checking if pubkey is allowed: %v", err)
 					return true, "error checking authorization"
@@ -101,47 +740,362 @@ func main() {
 		},
 	)
 
-	// management endpoints
-	relay.ManagementAPI.RejectAPICall = append(relay.ManagementAPI.RejectAPICall,
-		func(ctx context.Context, mp nip86.MethodParams) (reject bool, msg string) {
-			user := khatru.GetAuthed(ctx)
-			ownerPubKey := getEnv("RELAY_PUBKEY", "")
-			if user != ownerPubKey {
-				return true, "go away, intruder"
+	// optionally require APPROVAL_QUORUM distinct admins to approve a pubkey before it is
+	// allowed; moderators may then call allowpubkey, which records their approval
+	var approvals *approvalQuorum
+	var approvalMethods []string
+	quorum := getEnvInt("APPROVAL_QUORUM", 1)
+	if quorum < 1 {
+		log.Printf("Invalid APPROVAL_QUORUM %d, must be at least 1, using 1", quorum)
+		quorum = 1
+	}
+	if quorum > 1 {
+		store, err := newStoredApprovals(db.DB.DB)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to set up allowlist approvals: %v", err))
+		}
+		ownerPubKey := getEnv("RELAY_PUBKEY", "")
+		approvals = &approvalQuorum{store: store, quorum: quorum, isAdmin: func(pubkey string) (bool, error) {
+			if pubkey == ownerPubKey {
+				return true, nil
 			}
-			return false, ""
-		})
+			return dbManager.IsModerator(pubkey)
+		}}
+		approvalMethods = append(approvalMethods, "allowpubkey")
+		relay.Router().HandleFunc("/admin/approvals", requireOwner(ownerPubKey, handleAdminApprovals(approvals)))
+	}
+
+	// optionally advertise sibling relays in NIP-11, take allowlist changes from them and
+	// forward the changes made here to them
+	var federated *federation
+	if peers := getEnvList("FEDERATION_PEERS", nil); len(peers) > 0 {
+		federated = &federation{owner: getEnv("RELAY_PUBKEY", ""), trusted: getEnvList("FEDERATION_TRUSTED_PUBKEYS", nil), ctx: ctx, backoff: 30 * time.Second}
+		for _, peer := range peers {
+			federated.peers = append(federated.peers, nostr.NormalizeURL(peer))
+		}
+		nip11Extensions = append(nip11Extensions, federated.nip11)
+		if getEnvBool("FEDERATION_SYNC_ALLOWLIST", false) {
+			if secretKey, err := parseSecretKey(getEnv("RELAY_SECRET_KEY", "")); err != nil {
+				log.Printf("Not forwarding allowlist changes to the federation peers, RELAY_SECRET_KEY: %v", err)
+			} else {
+				federated.forward = managementForwarder(secretKey)
+			}
+		}
+	}
+
+	// management endpoints
+	// the owner can call every method, moderators only a limited set (see moderatorMethods),
+	// and trusted federation peers can allow and ban pubkeys
+	access := managementAccess(getEnv("RELAY_PUBKEY", ""), dbManager.IsModerator, approvalMethods...)
+	if federated != nil {
+		access = federated.access(access)
+	}
+	relay.ManagementAPI.RejectAPICall = append(relay.ManagementAPI.RejectAPICall, access)
 
 	relay.ManagementAPI.AllowPubKey = func(ctx context.Context, pubkey string, reason string) error {
-		return dbManager.AddAllowedPubkey(pubkey, reason)
+		// a change forwarded by a peer was already approved there
+		if approvals != nil && !federated.fromPeer(ctx) {
+			approved, count, err := approvals.approve(ctx, pubkey, reason)
+			if err != nil {
+				return err
+			}
+			if !approved {
+				log.Printf("Approval %d of %d to allow %s, by %s", count, approvals.quorum, pubkey, getAuthed(ctx))
+				return nil
+			}
+		}
+		if err := dbManager.AddAllowedPubkey(pubkey, reason); err != nil {
+			return err
+		}
+		if allowed != nil {
+			allowed.forget(pubkey)
+		}
+		if approvals != nil {
+			if err := approvals.store.clear(ctx, pubkey); err != nil {
+				log.Printf("Error clearing the approvals of %s: %v", pubkey, err)
+			}
+		}
+		if entryTTL > 0 {
+			// allowing an existing pubkey again renews its access for another full TTL
+			return dbManager.SetAllowedPubkeyExpiry(pubkey, time.Now().Add(entryTTL))
+		}
+		return nil
 	}
 
+	// optionally delete the stored events of pubkeys removed from the allowlist
+	var purge eventPurger
+	if getEnvBool("PURGE_ON_DEALLOW", false) {
+		purge = purgeStoredEvents(db.DB.DB)
+	}
 	relay.ManagementAPI.BanPubKey = func(ctx context.Context, pubkey string, reason string) error {
-		return dbManager.RemoveAllowedPubkey(pubkey)
+		purged, err := deallowPubkey(ctx, pubkey, dbManager.RemoveAllowedPubkey, purge)
+		if allowed != nil {
+			allowed.forget(pubkey)
+		}
+		if approvals != nil {
+			// banning also rejects a pending request, whether or not the pubkey was allowed
+			if err := approvals.store.clear(ctx, pubkey); err != nil {
+				log.Printf("Error clearing the approvals of %s: %v", pubkey, err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		revoker.revoke(pubkey)
+		if purge != nil {
+			log.Printf("Purged %d events of %s after removing it from the allowlist", purged, pubkey)
+		}
+		return nil
 	}
 
-	relay.ManagementAPI.ListAllowedPubKeys = func(ctx context.Context) ([]nip86.PubKeyReason, error) {
-		pubkeys, err := dbManager.GetAllowedPubkeys()
-		if err != nil {
-			return nil, err
+	if federated != nil && federated.forward != nil {
+		relay.ManagementAPI.AllowPubKey = federated.wrapAllow(relay.ManagementAPI.AllowPubKey, dbManager.IsAllowedPubkey)
+		relay.ManagementAPI.BanPubKey = federated.wrapBan(relay.ManagementAPI.BanPubKey)
+	}
+
+	relay.ManagementAPI.BanEvent = deleteEventByID(&db)
+
+	// optionally pull the member list from an external membership system and reconcile it into
+	// the allowlist; removed members lose their open sessions like banned ones
+	if syncURL := getEnv("MEMBER_SYNC_URL", ""); syncURL != "" {
+		members := &memberSync{
+			url:    syncURL,
+			auth:   getEnv("MEMBER_SYNC_AUTH_HEADER", ""),
+			client: http.DefaultClient,
+			store:  dbManager,
+			changed: func(pubkey string) {
+				if allowed != nil {
+					allowed.forget(pubkey)
+				}
+			},
+			removed: func(pubkey string) { revoker.revoke(pubkey) },
 		}
+		members.start(ctx, getEnvPositiveDuration("MEMBER_SYNC_INTERVAL", 15*time.Minute))
+	}
 
-		var result []nip86.PubKeyReason
-		for _, pubkey := range pubkeys {
-			result = append(result, nip86.PubKeyReason{
-				PubKey: pubkey,
-				Reason: "", // If you have a reason stored, use it here
-			})
+	// optionally ship periodic snapshots of the allowlist tables and events to S3-compatible
+	// storage, keeping the latest BACKUP_KEEP
+	if backupURL := getEnv("BACKUP_S3_URL", ""); backupURL != "" {
+		endpoint, bucket, prefix, err := parseS3URL(backupURL)
+		if err != nil {
+			log.Printf("Invalid BACKUP_S3_URL, not taking backups: %v", err)
+		} else {
+			backups := &backupJob{
+				snapshot: func(ctx context.Context, w io.Writer) error { return writeSnapshot(ctx, db.DB.DB, w) },
+				store: &s3Client{
+					endpoint:  endpoint,
+					bucket:    bucket,
+					region:    getEnv("BACKUP_S3_REGION", "us-east-1"),
+					accessKey: getEnv("BACKUP_S3_ACCESS_KEY_ID", ""),
+					secretKey: getEnv("BACKUP_S3_SECRET_ACCESS_KEY", ""),
+					client:    http.DefaultClient,
+				},
+				prefix:     prefix,
+				keep:       getEnvPositiveInt("BACKUP_KEEP", 7),
+				retryDelay: 30 * time.Second,
+			}
+			backups.start(ctx, getEnvPositiveDuration("BACKUP_SCHEDULE", 24*time.Hour))
 		}
-		return result, nil
 	}
 
+	// with PRIVATE_ALLOWLIST, only the owner gets to see who else is allowed
+	relay.ManagementAPI.ListAllowedPubKeys = listAllowedPubKeys(dbManager.GetAllowedPubkeys, getEnv("RELAY_PUBKEY", ""), getEnvBool("PRIVATE_ALLOWLIST", false))
+
 	relay.ManagementAPI.ListBannedPubKeys = func(ctx context.Context) ([]nip86.PubKeyReason, error) {
 		// We do not ban here since this is an allow only relay
 		// Our ban is simply not allowing the pubkey to write
 		return nil, nil
 	}
 
+	// optionally cap the unanswered AUTH challenges per IP; this wraps every event and filter
+	// policy, so it has to come after the last one is added
+	maxPendingAuth := getEnvInt("MAX_PENDING_AUTH_PER_IP", 0)
+	if maxPendingAuth < 0 {
+		log.Printf("Invalid MAX_PENDING_AUTH_PER_IP %d, must not be negative, not limiting AUTH challenges", maxPendingAuth)
+	} else if maxPendingAuth > 0 {
+		challenges := newAuthChallengeLimiter(maxPendingAuth, trustedProxies)
+		relay.RejectEvent = limitAuthChallenges(challenges, relay.RejectEvent)
+		relay.RejectFilter = limitAuthChallenges(challenges, relay.RejectFilter)
+		relay.RejectCountFilter = limitAuthChallenges(challenges, relay.RejectCountFilter)
+		relay.OnDisconnect = append(relay.OnDisconnect, challenges.onDisconnect)
+	}
+
+	// log a sample of rejected events (LOG_REJECT_SAMPLE_RATE=0.1 keeps about 10%);
+	// this wraps every event policy, so it has to come after the last one is added
+	rejectSampleRate := getEnvFloat("LOG_REJECT_SAMPLE_RATE", 1)
+	if rejectSampleRate < 0 || rejectSampleRate > 1 {
+		log.Printf("Invalid LOG_REJECT_SAMPLE_RATE %g, must be between 0 and 1, logging all rejections", rejectSampleRate)
+		rejectSampleRate = 1
+	}
+	if rejectSampleRate > 0 {
+		relay.RejectEvent = newRejectionLogger(rejectSampleRate, trustedProxies).wrap(relay.RejectEvent)
+	}
+
+	// ARCHIVE_MODE makes this a read-only mirror for the lifetime of the process
+	archiveMode := getEnvBool("ARCHIVE_MODE", false)
+	if archiveMode {
+		enableArchiveMode(relay)
+		log.Printf("Archive mode: all writes and management mutations are disabled")
+	}
+
+	// tell clients in NIP-11 whether writes are open, paused or gone for good
+	if getEnvBool("NIP11_RELAY_STATE", true) {
+		state := &relayState{archive: archiveMode, maintenance: maintenance, quiet: quietWindow, now: time.Now}
+		nip11Extensions = append(nip11Extensions, state.nip11)
+	}
+
+	// warn ahead of time about allowlist entries that are about to expire
+	// (started once the event policies are in place, since reminders are published through them)
+	if leadTime := getEnvDuration("ALLOWLIST_EXPIRY_LEAD_TIME", 72*time.Hour); leadTime > 0 {
+		interval := getEnvPositiveDuration("ALLOWLIST_EXPIRY_CHECK_INTERVAL", time.Hour)
+
+		var sendDM func(context.Context, ExpiringPubkey) error
+		if getEnvBool("EXPIRY_DM", false) {
+			sendDM, err = loadExpiryDMSender(relay)
+			if err != nil {
+				log.Printf("Expiry reminder DMs disabled: %v", err)
+			}
+		}
+		startExpiryNotifier(ctx, dbManager, leadTime, interval, getEnv("WEBHOOK_URL", ""), sendDM)
+	}
+
+	// optionally keep only the newest MAX_EVENTS_PER_PUBKEY regular events of each author
+	if maxEvents := getEnvInt("MAX_EVENTS_PER_PUBKEY", 0); maxEvents > 0 && !archiveMode {
+		pruner := newPubkeyPruner(ctx, pruneBeyondLimit(db.DB.DB, maxEvents))
+		relay.OnEventSaved = append(relay.OnEventSaved, pruner.onSaved)
+	}
+
+	// delete events past their retention period and advertise the same rules in NIP-11
+	if retention := loadRetentionPolicy(); retention.enabled() && !archiveMode {
+		relay.Info.Retention = retention.nip11()
+		startRetentionJob(ctx, db.DB.DB, retention, getEnvPositiveDuration("RETENTION_CHECK_INTERVAL", time.Hour))
+	}
+
+	// optionally republish every stored event to mirror relays, through a persistent queue that
+	// retries failed broadcasts with backoff
+	var mirrored *mirror
+	if mirrorRelays := getEnvList("MIRROR_RELAYS", nil); len(mirrorRelays) > 0 && !archiveMode {
+		queue, err := newStoredBroadcasts(db.DB.DB)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to set up the broadcast queue: %v", err))
+		}
+		mirrored = newMirror(queue, mirrorRelays, poolPublisher(nostr.NewSimplePool(ctx)),
+			getEnvPositiveInt("MIRROR_MAX_ATTEMPTS", 10),
+			getEnvPositiveDuration("MIRROR_RETRY_BACKOFF", 30*time.Second),
+			getEnvPositiveDuration("MIRROR_RETRY_MAX_BACKOFF", time.Hour))
+		relay.OnEventSaved = append(relay.OnEventSaved, mirrored.onSaved)
+		mirrored.start(ctx, getEnvPositiveDuration("MIRROR_CHECK_INTERVAL", 5*time.Second))
+	}
+
+	// publish scheduled events once their time has come (started once every store and
+	// OnEventSaved hook is in place, since they are published through them)
+	if scheduled != nil && !archiveMode {
+		scheduled.start(ctx, getEnvPositiveDuration("SCHEDULE_CHECK_INTERVAL", 30*time.Second))
+	}
+
+	// keep search engine crawlers off the HTTP endpoints unless configured otherwise
+	relay.Router().HandleFunc("/robots.txt", handleRobots(loadRobotsTxt()))
+
+	// unauthenticated liveness check, never rate limited
+	relay.Router().HandleFunc("/health", handleHealth)
+
+	// lightweight existence check for sync clients, open to everyone who may read
+	relay.Router().HandleFunc("/api/have", requireReader(isAllowedPubkey, getEnv("RELAY_PUBKEY", ""), handleHave(eventExists(db.DB.DB))))
+
+	// paged event queries over HTTP, with the same read access as the websocket
+	relay.Router().HandleFunc("/api/events", requireReader(isAllowedPubkey, getEnv("RELAY_PUBKEY", ""), handleEvents(queryPage)))
+
+	// optionally translate reject messages into the language the client hints at; this wraps
+	// every event and filter policy, so it has to come after the last one is added
+	if path := getEnv("REJECT_MESSAGES_FILE", ""); path != "" {
+		if catalog, err := loadMessageCatalog(path); err != nil {
+			log.Printf("Not localizing reject messages: %v", err)
+		} else {
+			relay.RejectEvent = localizeRejections(catalog, relay.RejectEvent)
+			relay.RejectFilter = localizeRejections(catalog, relay.RejectFilter)
+			relay.RejectCountFilter = localizeRejections(catalog, relay.RejectCountFilter)
+		}
+	}
+
+	// core activity, connection utilization and dry-run policy counts for Prometheus-compatible
+	// scrapers; counting rejections wraps every event policy, so it comes after the last one
+	counters := &relayCounters{}
+	relay.OnConnect = append(relay.OnConnect, counters.onConnect)
+	relay.OnEventSaved = append(relay.OnEventSaved, counters.onSaved)
+	relay.OverwriteFilter = append(relay.OverwriteFilter, counters.overwriteFilter)
+	relay.RejectEvent = counters.wrap(relay.RejectEvent)
+	metrics := []metricsSource{counters.metrics, limiter.metrics, httpLimiter.metrics, dryRun.metrics}
+	if allowed != nil {
+		metrics = append(metrics, allowed.metrics)
+	}
+	if mirrored != nil {
+		metrics = append(metrics, mirrored.metrics)
+	}
+	if messages.maxEvent > 0 {
+		metrics = append(metrics, messages.metrics)
+	}
+	relay.Router().HandleFunc("/metrics", handleMetrics(metrics...))
+
+	// optionally push the same metrics to StatsD or an OpenTelemetry collector as well
+	pushInterval := getEnvPositiveDuration("METRICS_PUSH_INTERVAL", 10*time.Second)
+	switch backend := getEnv("METRICS_BACKEND", "prometheus"); backend {
+	case "prometheus":
+	case "statsd":
+		statsd, err := newStatsdExporter(getEnv("STATSD_ADDR", "localhost:8125"))
+		if err != nil {
+			log.Printf("Not exporting metrics to StatsD: %v", err)
+			break
+		}
+		startMetricsExporter(ctx, statsd, metrics, pushInterval)
+	case "otel":
+		startMetricsExporter(ctx, newOTLPExporter(getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")), metrics, pushInterval)
+	default:
+		log.Printf("Invalid METRICS_BACKEND %q, must be prometheus, statsd or otel, only serving /metrics", backend)
+	}
+
+	// owner-only view of open connections and their resource usage
+	relay.Router().HandleFunc("/admin/connections", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminConnections(connections)))
+
+	// owner-only allowlist listing with labels, filterable with ?label=
+	relay.Router().HandleFunc("/admin/allowlist", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminAllowlist(dbManager)))
+
+	// relay-wide announcement, set by the owner at runtime and shown to every connecting client
+	notice, err := loadAnnouncement(dbManager)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load announcement: %v", err))
+	}
+	relay.OnConnect = append(relay.OnConnect, notice.onConnect)
+	nip11Extensions = append(nip11Extensions, notice.nip11)
+	relay.Router().HandleFunc("/admin/announcement", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminAnnouncement(notice)))
+
+	// owner-only paginated, filterable reads of the relay's own tables
+	relay.Router().HandleFunc("/admin/query/allowlist", requireOwner(getEnv("RELAY_PUBKEY", ""), handleQueryAllowlist(dbManager.QueryAllowedPubkeys)))
+	relay.Router().HandleFunc("/admin/query/moderators", requireOwner(getEnv("RELAY_PUBKEY", ""), handleQueryModerators(dbManager.QueryModerators)))
+
+	// owner-only management of the moderator team
+	relay.Router().HandleFunc("/admin/moderators", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminModerators(dbManager)))
+
+	if !archiveMode {
+		// owner-only health check that exercises the whole storage path
+		relay.Router().HandleFunc("/admin/selftest", requireOwner(getEnv("RELAY_PUBKEY", ""), handleSelftest(relay)))
+		relay.Router().HandleFunc("/admin/allowlist/labels", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminAllowlistLabels(dbManager)))
+	}
+
+	// owner-only contact and notes kept about allowed pubkeys; an archive can only read them
+	setAllowlistMetadata := dbManager.SetAllowedPubkeyMetadata
+	if archiveMode {
+		setAllowlistMetadata = nil
+	}
+	relay.Router().HandleFunc("/admin/allowlist/metadata", requireOwner(getEnv("RELAY_PUBKEY", ""), handleAdminAllowlistMetadata(dbManager.GetAllowedPubkeyEntry, setAllowlistMetadata)))
+
+	// owner-only NIP-86 methods to pin events, which retention never deletes; an archive can
+	// only list them
+	pinned := &ownerManagementMethods{owner: getEnv("RELAY_PUBKEY", ""), methods: pinManagementMethods(dbManager)}
+	if archiveMode {
+		delete(pinned.methods, "pinevent")
+		delete(pinned.methods, "unpinevent")
+	}
+
 	// mux := relay.Router()
 	// set up other http handlers
 	// mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -170,7 +1124,17 @@ func main() {
 	// 	http.ServeFile(w, r, indexPath)
 	// })
 
-	// start the server
-	fmt.Println("running on :3334")
-	http.ListenAndServe(":3334", relay)
+	// wrap the relay in the HTTP middlewares
+	var handler http.Handler = managementMiddleware(relay, pinned, nip11Middleware(nip11Extensions, relay))
+	if getEnvBool("HTTP_GZIP", true) {
+		handler = gzipMiddleware(handler)
+	}
+	handler = messages.middleware(handler)
+	handler = limiter.middleware(handler)
+	handler = httpLimiter.middleware(handler)
+	// optionally refuse clients from blocked countries or ASNs before anything else runs
+	if blocker := loadGeoBlocker(); blocker != nil {
+		handler = geoIPMiddleware(blocker, trustedProxies, handler)
+	}
+	return handler, func() { dbManager.Close() }
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maintenanceSetting is the relay_settings key maintenance mode is persisted under; its value
+// is the reason given when it was turned on.
+const maintenanceSetting = "maintenance"
+
+// maintenanceMode pauses all writes while the owner works on the relay. It is persisted, so a
+// restart or a crash during maintenance does not reopen writes; only the owner clears it.
+type maintenanceMode struct {
+	settings settingsStore
+
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+}
+
+// loadMaintenanceMode reads the persisted state and logs loudly if the relay comes back up
+// still in maintenance.
+func loadMaintenanceMode(settings settingsStore) (*maintenanceMode, error) {
+	reason, enabled, err := settings.GetSetting(maintenanceSetting)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		log.Printf("*** MAINTENANCE MODE IS ON (%s): writes stay paused until it is cleared through DELETE /admin/maintenance ***", describeMaintenance(reason))
+	}
+	return &maintenanceMode{settings: settings, enabled: enabled, reason: reason}, nil
+}
+
+func describeMaintenance(reason string) string {
+	if reason == "" {
+		return "no reason given"
+	}
+	return reason
+}
+
+func (m *maintenanceMode) get() (enabled bool, reason string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled, m.reason
+}
+
+// set turns maintenance mode on with the given reason, or off.
+func (m *maintenanceMode) set(enabled bool, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var err error
+	if enabled {
+		err = m.settings.SetSetting(maintenanceSetting, reason)
+	} else {
+		err = m.settings.DeleteSetting(maintenanceSetting)
+		reason = ""
+	}
+	if err != nil {
+		return err
+	}
+	m.enabled, m.reason = enabled, reason
+	return nil
+}
+
+func (m *maintenanceMode) rejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	enabled, reason := m.get()
+	if !enabled {
+		return false, ""
+	}
+	if reason != "" {
+		return true, "blocked: the relay is in maintenance, writes are paused: " + reason
+	}
+	return true, "blocked: the relay is in maintenance, writes are paused"
+}
+
+// handleAdminMaintenance serves the owner-only maintenance toggle: GET returns the state,
+// PUT {"reason": "..."} pauses writes and DELETE resumes them.
+func handleAdminMaintenance(m *maintenanceMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct {
+				Reason string `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json body"})
+				return
+			}
+			reason := strings.TrimSpace(body.Reason)
+			if err := m.set(true, reason); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			log.Printf("Maintenance mode turned on (%s), writes are paused", describeMaintenance(reason))
+		case http.MethodDelete:
+			if err := m.set(false, ""); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			log.Printf("Maintenance mode cleared, writes are open again")
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		enabled, reason := m.get()
+		writeJSON(w, http.StatusOK, map[string]any{"enabled": enabled, "reason": reason})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMaintenanceModeSurvivesRestart(t *testing.T) {
+	settings := memorySettings{}
+	maintenance, err := loadMaintenanceMode(settings)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if reject, msg := maintenance.rejectEvent(context.Background(), &nostr.Event{}); reject {
+		t.Fatalf("expected writes to be open, got %s", msg)
+	}
+
+	handler := handleAdminMaintenance(maintenance)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPut, "/admin/maintenance", strings.NewReader(`{"reason":" database upgrade "}`)))
+	if rec.Code != http.StatusOK || settings[maintenanceSetting] != "database upgrade" {
+		t.Fatalf("expected maintenance to be persisted, got %d %v", rec.Code, settings)
+	}
+
+	// a restart picks the persisted state up again
+	restarted, err := loadMaintenanceMode(settings)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if reject, msg := restarted.rejectEvent(context.Background(), &nostr.Event{}); !reject || msg != "blocked: the relay is in maintenance, writes are paused: database upgrade" {
+		t.Fatalf("expected writes to stay paused after a restart, got %v %q", reject, msg)
+	}
+
+	rec = httptest.NewRecorder()
+	handleAdminMaintenance(restarted)(rec, httptest.NewRequest(http.MethodDelete, "/admin/maintenance", nil))
+	if _, ok := settings[maintenanceSetting]; rec.Code != http.StatusOK || ok {
+		t.Fatalf("expected maintenance to be cleared, got %d %v", rec.Code, settings)
+	}
+	if reject, msg := restarted.rejectEvent(context.Background(), &nostr.Event{}); reject {
+		t.Fatalf("expected writes to be open again, got %s", msg)
+	}
+
+	// no reason is fine too
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/admin/maintenance", strings.NewReader(`{}`)))
+	if reject, msg := maintenance.rejectEvent(context.Background(), &nostr.Event{}); !reject || msg != "blocked: the relay is in maintenance, writes are paused" {
+		t.Fatalf("expected writes to be paused, got %v %q", reject, msg)
+	}
+}
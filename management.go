@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// supportedManagementMethods lists the NIP-86 methods that have a handler configured on the relay.
+// Method names are the lowercased ManagementAPI field names, as NIP-86 defines them.
+func supportedManagementMethods(relay *khatru.Relay) []string {
+	methods := []string{"supportedmethods"}
+
+	api := reflect.ValueOf(relay.ManagementAPI)
+	for i := 0; i < api.NumField(); i++ {
+		field := api.Type().Field(i)
+		if field.Type.Kind() != reflect.Func || field.Name == "Generic" || api.Field(i).IsNil() {
+			continue
+		}
+		methods = append(methods, strings.ToLower(field.Name))
+	}
+
+	return methods
+}
+
+// ownerManagementMethod is a NIP-86 method khatru has no handler field for, such as
+// pinevent. It is answered by managementMiddleware itself, for the relay owner only.
+type ownerManagementMethod func(ctx context.Context, params []any) (any, error)
+
+// ownerManagementMethods are the extra NIP-86 methods by name, with the owner who may call them.
+type ownerManagementMethods struct {
+	owner   string
+	methods map[string]ownerManagementMethod
+}
+
+// serve authenticates a call to one of the methods and answers it.
+func (m *ownerManagementMethods) serve(w http.ResponseWriter, r *http.Request, payload []byte, req nip86.Request) {
+	pubkey, err := verifyNIP86(r, payload, nostr.Now())
+	if err != nil {
+		writeManagementResponse(w, nip86.Response{Error: err.Error()})
+		return
+	}
+	if m.owner == "" || pubkey != m.owner {
+		writeManagementResponse(w, nip86.Response{Error: "only the relay owner can call " + req.Method})
+		return
+	}
+	result, err := m.methods[req.Method](r.Context(), req.Params)
+	if err != nil {
+		writeManagementResponse(w, nip86.Response{Error: err.Error()})
+		return
+	}
+	writeManagementResponse(w, nip86.Response{Result: result})
+}
+
+// managementMiddleware answers NIP-86 requests that khatru cannot handle well by itself:
+// "supportedmethods" is answered from the configured handlers, unknown or unimplemented
+// methods get a "method not supported" error, and a panicking handler produces an error
+// response instead of an empty reply. The extra methods, if any, are answered here too.
+// Everything else is passed through to the relay.
+func managementMiddleware(relay *khatru.Relay, extra *ownerManagementMethods, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/nostr+json+rpc" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeManagementResponse(w, nip86.Response{Error: "empty request"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(payload))
+
+		var req nip86.Request
+		if err := json.Unmarshal(payload, &req); err != nil {
+			writeManagementResponse(w, nip86.Response{Error: "invalid json body"})
+			return
+		}
+
+		methods := supportedManagementMethods(relay)
+		var extraMethod bool
+		if extra != nil {
+			methods = append(methods, slices.Sorted(maps.Keys(extra.methods))...)
+			_, extraMethod = extra.methods[req.Method]
+		}
+		switch {
+		case req.Method == "supportedmethods":
+			writeManagementResponse(w, nip86.Response{Result: methods})
+			return
+		case !slices.Contains(methods, req.Method):
+			writeManagementResponse(w, nip86.Response{Error: "method not supported"})
+			return
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("Panic while handling management method %s: %v", req.Method, p)
+				writeManagementResponse(w, nip86.Response{Error: "internal error"})
+			}
+		}()
+		if extraMethod {
+			extra.serve(w, r, payload, req)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeManagementResponse(w http.ResponseWriter, resp nip86.Response) {
+	w.Header().Set("Content-Type", "application/nostr+json+rpc")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// callManagement sends a raw NIP-86 request body through the middleware and decodes the reply.
+func callManagement(t *testing.T, handler http.Handler, body string) nip86.Response {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/nostr+json+rpc")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var resp nip86.Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func newManagementRelay() *khatru.Relay {
+	relay := khatru.NewRelay()
+	relay.ManagementAPI.AllowPubKey = func(ctx context.Context, pubkey string, reason string) error { return nil }
+	relay.ManagementAPI.ListAllowedPubKeys = func(ctx context.Context) ([]nip86.PubKeyReason, error) { return nil, nil }
+	return relay
+}
+
+func TestManagementUnknownMethod(t *testing.T) {
+	relay := newManagementRelay()
+	passedThrough := false
+	handler := managementMiddleware(relay, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passedThrough = true
+	}))
+
+	for _, method := range []string{"bogusmethod", "banevent"} {
+		resp := callManagement(t, handler, `{"method":"`+method+`","params":[]}`)
+		if resp.Error != "method not supported" {
+			t.Errorf("%s: expected \"method not supported\", got %+v", method, resp)
+		}
+	}
+	if passedThrough {
+		t.Error("unsupported methods must not reach the relay handler")
+	}
+}
+
+func TestManagementSupportedMethods(t *testing.T) {
+	relay := newManagementRelay()
+	handler := managementMiddleware(relay, nil, http.NotFoundHandler())
+
+	resp := callManagement(t, handler, `{"method":"supportedmethods","params":[]}`)
+	raw, _ := json.Marshal(resp.Result)
+	var methods []string
+	if err := json.Unmarshal(raw, &methods); err != nil {
+		t.Fatalf("expected a list of methods, got %s", raw)
+	}
+
+	for _, expected := range []string{"supportedmethods", "allowpubkey", "listallowedpubkeys"} {
+		if !slices.Contains(methods, expected) {
+			t.Errorf("expected %s in %v", expected, methods)
+		}
+	}
+	if slices.Contains(methods, "banpubkey") {
+		t.Errorf("banpubkey has no handler but was listed: %v", methods)
+	}
+}
+
+func TestManagementPanicBecomesError(t *testing.T) {
+	relay := newManagementRelay()
+	handler := managementMiddleware(relay, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	resp := callManagement(t, handler, `{"method":"allowpubkey","params":[]}`)
+	if resp.Error != "internal error" {
+		t.Fatalf("expected internal error, got %+v", resp)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// memberSyncTimeout bounds one request to the membership API.
+const memberSyncTimeout = 30 * time.Second
+
+// memberReconciler makes the synced part of the allowlist match an authoritative member list.
+type memberReconciler interface {
+	ReconcileSyncedPubkeys(members map[string]string) (added, removed []string, err error)
+}
+
+// memberSync periodically pulls the member list from an external membership system
+// (MEMBER_SYNC_URL) and reconciles it into the allowlist. Only pubkeys the sync added are ever
+// removed by it, so entries added by hand or through NIP-86 stay.
+type memberSync struct {
+	url    string
+	auth   string
+	client *http.Client
+	store  memberReconciler
+	// changed is called for every pubkey added or removed, removed for the removed ones
+	changed func(pubkey string)
+	removed func(pubkey string)
+}
+
+// fetch returns the member list, pubkey -> reason. The API answers with a JSON array of hex
+// pubkeys, or of {"pubkey": ..., "reason": ...} objects. Invalid entries are logged and
+// skipped; an empty list is an error, since it would remove every synced member.
+func (s *memberSync) fetch(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, memberSyncTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.auth != "" {
+		req.Header.Set("Authorization", s.auth)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch members: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("membership API answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read members: %w", err)
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, fmt.Errorf("membership API did not answer with a JSON array: %w", err)
+	}
+	members := make(map[string]string, len(entries))
+	for _, raw := range entries {
+		var entry struct {
+			Pubkey string `json:"pubkey"`
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal(raw, &entry.Pubkey); err != nil {
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				log.Printf("Member sync: skipping invalid entry %s", raw)
+				continue
+			}
+		}
+		if err := validatePubkey(entry.Pubkey); err != nil {
+			log.Printf("Member sync: skipping entry: %v", err)
+			continue
+		}
+		members[entry.Pubkey] = entry.Reason
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("membership API returned no members, keeping the allowlist as it is")
+	}
+	return members, nil
+}
+
+// run syncs once. A failing API leaves the allowlist untouched.
+func (s *memberSync) run(ctx context.Context) error {
+	members, err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	added, removed, err := s.store.ReconcileSyncedPubkeys(members)
+	if err != nil {
+		return err
+	}
+
+	for _, pubkey := range added {
+		log.Printf("Member sync: allowed %s", pubkey)
+		s.changed(pubkey)
+	}
+	for _, pubkey := range removed {
+		log.Printf("Member sync: removed %s", pubkey)
+		s.changed(pubkey)
+		s.removed(pubkey)
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		log.Printf("Member sync: %d members, %d allowed, %d removed", len(members), len(added), len(removed))
+	}
+	return nil
+}
+
+// start syncs every interval until ctx is done.
+func (s *memberSync) start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.run(ctx); err != nil {
+				log.Printf("Member sync failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// memoryMembers is an in-memory memberReconciler: allowed pubkeys and whether the sync added them.
+type memoryMembers struct {
+	allowed map[string]bool
+	calls   int
+}
+
+func (m *memoryMembers) ReconcileSyncedPubkeys(members map[string]string) (added, removed []string, err error) {
+	m.calls++
+	for pubkey := range members {
+		if _, ok := m.allowed[pubkey]; !ok {
+			m.allowed[pubkey] = true
+			added = append(added, pubkey)
+		}
+	}
+	for pubkey, synced := range m.allowed {
+		if _, ok := members[pubkey]; !ok && synced {
+			delete(m.allowed, pubkey)
+			removed = append(removed, pubkey)
+		}
+	}
+	return added, removed, nil
+}
+
+func TestMemberSync(t *testing.T) {
+	_, owner := newKeypair(t)
+	_, leaving := newKeypair(t)
+	_, staying := newKeypair(t)
+	_, joining := newKeypair(t)
+
+	status, body := http.StatusOK, ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	store := &memoryMembers{allowed: map[string]bool{owner: false, leaving: true, staying: true}}
+	var changed, revoked []string
+	sync := &memberSync{
+		url:     server.URL,
+		auth:    "Bearer secret",
+		client:  server.Client(),
+		store:   store,
+		changed: func(pubkey string) { changed = append(changed, pubkey) },
+		removed: func(pubkey string) { revoked = append(revoked, pubkey) },
+	}
+
+	body = `["` + staying + `", {"pubkey": "` + joining + `", "reason": "paid"}, "npub1notahexkey", 42]`
+	if err := sync.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !store.allowed[joining] || store.allowed[leaving] || !store.allowed[staying] {
+		t.Errorf("expected the allowlist to follow the member list, got %v", store.allowed)
+	}
+	if _, ok := store.allowed[owner]; !ok {
+		t.Error("expected entries the sync did not add to stay")
+	}
+	if !slices.Contains(changed, joining) || !slices.Equal(revoked, []string{leaving}) {
+		t.Errorf("expected caches to be updated and removed members revoked, got %v %v", changed, revoked)
+	}
+
+	// API errors leave the allowlist untouched
+	for _, tc := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusInternalServerError, "oops"},
+		{http.StatusOK, `{"members": []}`},
+		{http.StatusOK, `[]`},
+		{http.StatusOK, `["npub1notahexkey"]`},
+	} {
+		status, body = tc.status, tc.body
+		if err := sync.run(context.Background()); err == nil {
+			t.Errorf("%d %s: expected an error", tc.status, tc.body)
+		}
+	}
+	sync.auth = ""
+	if err := sync.run(context.Background()); err == nil {
+		t.Error("expected a rejected request to fail")
+	}
+	if store.calls != 1 || !store.allowed[joining] {
+		t.Errorf("expected failed syncs not to touch the allowlist, got %d reconciliations", store.calls)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type messageGateKey struct{}
+
+// messageGate looks at the messages of websocket clients straight off the wire, before khatru
+// parses the JSON and verifies the id and signature, which is where nearly all the CPU time of
+// a rejected message goes. It reads the frame headers of the incoming stream:
+//
+//   - with maxEvent set, a text frame over it whose payload starts with ["EVENT" is skipped
+//     unread and answered with a NOTICE, since without parsing there is no event id for an OK.
+//     Sizes are checked per frame, which for the unfragmented messages clients send is the
+//     message size.
+//   - every other unfragmented text message up to maxMessage bytes, khatru's own read limit, is
+//     read in full and handed to the checks, the first of which to answer it drops it.
+//
+// Every other frame is passed through untouched, and khatru's read limit still closes the
+// connection for anything bigger.
+type messageGate struct {
+	maxEvent   int64
+	maxMessage int64
+	checks     []messageCheck
+	rejected   atomic.Int64
+}
+
+// messageCheck looks at a complete text message and returns the reply to send instead of
+// passing it on, or nil to let khatru handle it.
+type messageCheck func(message []byte) any
+
+// unparsableMessage answers messages that are not JSON with a NOTICE, rather than khatru's
+// parser error. Nothing is logged, such garbage is routine.
+func unparsableMessage(message []byte) any {
+	if json.Valid(message) {
+		return nil
+	}
+	return nostr.NoticeEnvelope("invalid: could not parse message")
+}
+
+// eventMessage returns the event of an EVENT message as it was sent, or nil for any other
+// message and for messages the checks should leave to khatru.
+func eventMessage(message []byte) json.RawMessage {
+	var envelope []json.RawMessage
+	if json.Unmarshal(message, &envelope) != nil || len(envelope) != 2 {
+		return nil
+	}
+	var label string
+	if json.Unmarshal(envelope[0], &label) != nil || label != "EVENT" {
+		return nil
+	}
+	return envelope[1]
+}
+
+// messageGateConn is the state of one connection: the network connection the gate took over,
+// and the websocket to send replies on, known once khatru has set it up.
+type messageGateConn struct {
+	gate   *messageGate
+	filter atomic.Pointer[frameFilter]
+	ws     atomic.Pointer[khatru.WebSocket]
+}
+
+// gateConnection returns the gate's state of the connection behind ws, or nil if it did not come
+// through a messageGate.
+func gateConnection(ws *khatru.WebSocket) *messageGateConn {
+	if ws == nil || ws.Request == nil {
+		return nil
+	}
+	conn, _ := ws.Request.Context().Value(messageGateKey{}).(*messageGateConn)
+	return conn
+}
+
+// closeConnection closes the network connection under ws, which makes khatru's read fail and
+// run its disconnect cleanup; khatru has no exported way to close a connection. It reports
+// false if ws did not come through a messageGate.
+func closeConnection(ws *khatru.WebSocket) bool {
+	conn := gateConnection(ws)
+	if conn == nil {
+		return false
+	}
+	filter := conn.filter.Load()
+	if filter == nil {
+		return false
+	}
+	filter.Close()
+	return true
+}
+
+func (c *messageGateConn) reply(reply any) {
+	if ws := c.ws.Load(); ws != nil {
+		// not on the reading goroutine, a slow client must not stall the reads
+		go ws.WriteJSON(reply)
+	}
+}
+
+func (c *messageGateConn) rejectSize(size int64) {
+	c.gate.rejected.Add(1)
+	c.reply(nostr.NoticeEnvelope(fmt.Sprintf("invalid: event message too large (%d bytes, limit %d)", size, c.gate.maxEvent)))
+}
+
+// inspect runs the checks on a message and reports whether one of them answered it.
+func (c *messageGateConn) inspect(message []byte) bool {
+	for _, check := range c.gate.checks {
+		if reply := check(message); reply != nil {
+			c.reply(reply)
+			return true
+		}
+	}
+	return false
+}
+
+// middleware filters the websocket connections upgraded by next.
+func (g *messageGate) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		conn := &messageGateConn{gate: g}
+		next.ServeHTTP(&messageGateHijacker{ResponseWriter: w, conn: conn}, r.WithContext(context.WithValue(r.Context(), messageGateKey{}, conn)))
+	})
+}
+
+// onConnect hands the websocket of a new connection to its filter, for the replies.
+func (g *messageGate) onConnect(ctx context.Context) {
+	ws := getConnection(ctx)
+	if conn := gateConnection(ws); conn != nil {
+		conn.ws.Store(ws)
+	}
+}
+
+// metrics reports the dropped messages in the Prometheus text format.
+func (g *messageGate) metrics(w *bufio.Writer) {
+	fmt.Fprintln(w, "# HELP brove_event_messages_too_large_total EVENT messages dropped for their size before parsing.")
+	fmt.Fprintln(w, "# TYPE brove_event_messages_too_large_total counter")
+	fmt.Fprintf(w, "brove_event_messages_too_large_total %d\n", g.rejected.Load())
+}
+
+// messageGateHijacker wraps the connection the websocket upgrade takes over in a frameFilter.
+type messageGateHijacker struct {
+	http.ResponseWriter
+	conn *messageGateConn
+}
+
+func (h *messageGateHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+	// data the client sent right after the handshake may already be buffered in rw, it is read
+	// first
+	var source io.Reader = conn
+	if rw.Reader.Buffered() > 0 {
+		buffered, _ := rw.Reader.Peek(rw.Reader.Buffered())
+		source = io.MultiReader(bytes.NewReader(bytes.Clone(buffered)), conn)
+	}
+	gate := h.conn.gate
+	filtered := &frameFilter{
+		Conn:       conn,
+		r:          bufio.NewReaderSize(source, 4096),
+		max:        gate.maxEvent,
+		reject:     h.conn.rejectSize,
+		inspectMax: gate.maxMessage,
+	}
+	if len(gate.checks) > 0 {
+		filtered.inspect = h.conn.inspect
+	}
+	h.conn.filter.Store(filtered)
+	rw.Reader.Reset(filtered)
+	return filtered, rw, nil
+}
+
+// frameFilter reads client-to-server websocket frames (RFC 6455 section 5.2), dropping EVENT
+// text frames over max bytes, if max is set, along with their continuation frames, and the
+// unfragmented text messages up to inspectMax bytes that inspect reports as answered.
+type frameFilter struct {
+	net.Conn
+	r          *bufio.Reader
+	max        int64
+	reject     func(size int64)
+	inspect    func(message []byte) bool
+	inspectMax int64
+
+	// pending is a frame read ahead for inspect, forwarded before anything else
+	pending []byte
+
+	// remaining bytes of the current frame, forwarded or, if dropping, skipped
+	remaining int64
+	dropping  bool
+	// droppingMessage is set while the continuation frames of a dropped message are due
+	droppingMessage bool
+}
+
+func (f *frameFilter) Read(p []byte) (int, error) {
+	for {
+		if len(f.pending) > 0 {
+			n := copy(p, f.pending)
+			f.pending = f.pending[n:]
+			return n, nil
+		}
+		if f.remaining > 0 && !f.dropping {
+			n, err := f.r.Read(p[:min(int64(len(p)), f.remaining)])
+			f.remaining -= int64(n)
+			return n, err
+		}
+		if f.remaining > 0 {
+			n, err := f.r.Discard(int(min(f.remaining, 1<<30)))
+			f.remaining -= int64(n)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		header, payloadLen, err := f.peekHeader()
+		if err != nil {
+			return 0, err
+		}
+		headerLen := len(header)
+		final, opcode := header[0]&0x80 != 0, header[0]&0x0f
+
+		drop := false
+		switch {
+		case opcode == 0x0 && f.droppingMessage:
+			drop = true
+			f.droppingMessage = !final
+		case opcode == 0x1 && f.max > 0 && payloadLen > f.max && f.isEvent(headerLen, payloadLen):
+			drop = true
+			f.droppingMessage = !final
+			f.reject(payloadLen)
+		}
+		if drop {
+			if _, err := f.r.Discard(headerLen); err != nil {
+				return 0, err
+			}
+			f.remaining, f.dropping = payloadLen, true
+			continue
+		}
+		if f.inspect != nil && opcode == 0x1 && final && payloadLen <= f.inspectMax {
+			frame := make([]byte, int64(headerLen)+payloadLen)
+			if _, err := io.ReadFull(f.r, frame); err != nil {
+				return 0, err
+			}
+			if !f.inspect(unmaskPayload(frame, headerLen)) {
+				f.pending = frame
+			}
+			continue
+		}
+		f.remaining, f.dropping = int64(headerLen)+payloadLen, false
+	}
+}
+
+// unmaskPayload returns a copy of the payload of a complete frame, unmasked.
+func unmaskPayload(frame []byte, headerLen int) []byte {
+	payload := bytes.Clone(frame[headerLen:])
+	if frame[1]&0x80 != 0 {
+		mask := frame[headerLen-4 : headerLen]
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return payload
+}
+
+// peekHeader reads ahead the header of the next frame, valid until the next read.
+func (f *frameFilter) peekHeader() (header []byte, payloadLen int64, err error) {
+	b, err := f.r.Peek(2)
+	if err != nil {
+		return nil, 0, err
+	}
+	masked, length := b[1]&0x80 != 0, b[1]&0x7f
+	headerLen := 2
+	switch length {
+	case 126:
+		headerLen += 2
+	case 127:
+		headerLen += 8
+	}
+	if masked {
+		headerLen += 4
+	}
+	if b, err = f.r.Peek(headerLen); err != nil {
+		return nil, 0, err
+	}
+	switch length {
+	case 126:
+		payloadLen = int64(binary.BigEndian.Uint16(b[2:4]))
+	case 127:
+		payloadLen = int64(binary.BigEndian.Uint64(b[2:10]) & (1<<63 - 1))
+	default:
+		payloadLen = int64(length)
+	}
+	return b, payloadLen, nil
+}
+
+// isEvent unmasks the first bytes of the frame's payload to see whether it is an EVENT.
+func (f *frameFilter) isEvent(headerLen int, payloadLen int64) bool {
+	n := int(min(payloadLen, 32))
+	b, err := f.r.Peek(headerLen + n)
+	if err != nil {
+		return false
+	}
+	payload := make([]byte, n)
+	copy(payload, b[headerLen:])
+	if b[1]&0x80 != 0 {
+		mask := b[headerLen-4 : headerLen]
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	const whitespace = " \t\r\n"
+	rest, ok := bytes.CutPrefix(bytes.TrimLeft(payload, whitespace), []byte("["))
+	return ok && bytes.HasPrefix(bytes.TrimLeft(rest, whitespace), []byte(`"EVENT"`))
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// clientFrame encodes a masked client-to-server websocket frame.
+func clientFrame(opcode byte, final bool, payload []byte) []byte {
+	var frame bytes.Buffer
+	first := opcode
+	if final {
+		first |= 0x80
+	}
+	frame.WriteByte(first)
+	switch {
+	case len(payload) < 126:
+		frame.WriteByte(0x80 | byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame.WriteByte(0x80 | 126)
+		binary.Write(&frame, binary.BigEndian, uint16(len(payload)))
+	default:
+		frame.WriteByte(0x80 | 127)
+		binary.Write(&frame, binary.BigEndian, uint64(len(payload)))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame.Write(mask)
+	for i, b := range payload {
+		frame.WriteByte(b ^ mask[i%4])
+	}
+	return frame.Bytes()
+}
+
+func TestFrameFilterDropsOversizedEvents(t *testing.T) {
+	big := strings.Repeat("x", 300)
+	smallEvent := clientFrame(0x1, true, []byte(`["EVENT",{"content":"hi"}]`))
+	bigReq := clientFrame(0x1, true, []byte(`["REQ","sub",{"search":"`+big+`"}]`))
+	ping := clientFrame(0x9, true, nil)
+	closing := clientFrame(0x8, true, nil)
+
+	var input, want bytes.Buffer
+	input.Write(smallEvent)
+	want.Write(smallEvent)
+	// an oversized event, with whitespace before the label
+	input.Write(clientFrame(0x1, true, []byte(` [ "EVENT",{"content":"`+big+`"}]`)))
+	// a fragmented one, with a ping between the fragments that must still get through
+	input.Write(clientFrame(0x1, false, []byte(`["EVENT",{"content":"`+big)))
+	input.Write(ping)
+	want.Write(ping)
+	input.Write(clientFrame(0x0, true, []byte(`"}]`)))
+	input.Write(bigReq)
+	want.Write(bigReq)
+	input.Write(closing)
+	want.Write(closing)
+
+	var rejected []int64
+	filter := &frameFilter{r: bufio.NewReaderSize(&input, 64), max: 200, reject: func(size int64) { rejected = append(rejected, size) }}
+	got, err := io.ReadAll(iotest.OneByteReader(filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("expected only the small event, the ping, the REQ and the close to get through")
+	}
+	if len(rejected) != 2 || rejected[0] != 326 || rejected[1] != 321 {
+		t.Errorf("expected two rejections of 326 and 321 bytes, got %v", rejected)
+	}
+}
+
+func TestFrameFilterInspectsMessages(t *testing.T) {
+	keep := clientFrame(0x1, true, []byte(`["REQ","sub",{}]`))
+	answered := clientFrame(0x1, true, []byte(`["REQ","answered"]`))
+	tooLarge := clientFrame(0x1, true, []byte(`["REQ","`+strings.Repeat("x", 100)+`"]`))
+	fragment := clientFrame(0x1, false, []byte(`["REQ","answered"`))
+	continuation := clientFrame(0x0, true, []byte(`]`))
+	binary := clientFrame(0x2, true, []byte(`["REQ","answered"]`))
+
+	var input, want bytes.Buffer
+	for _, frame := range [][]byte{keep, answered, tooLarge, fragment, continuation, binary, keep} {
+		input.Write(frame)
+		if !bytes.Equal(frame, answered) {
+			want.Write(frame)
+		}
+	}
+
+	var inspected []string
+	filter := &frameFilter{r: bufio.NewReaderSize(&input, 64), inspectMax: 64, inspect: func(message []byte) bool {
+		inspected = append(inspected, string(message))
+		return strings.Contains(string(message), "answered")
+	}}
+	got, err := io.ReadAll(iotest.OneByteReader(filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("expected everything but the answered message to get through unchanged")
+	}
+	// fragmented, binary and oversized messages are passed on uninspected
+	if len(inspected) != 3 || inspected[0] != `["REQ","sub",{}]` || inspected[1] != `["REQ","answered"]` {
+		t.Errorf("expected the complete text messages to be inspected, unmasked, got %q", inspected)
+	}
+}
+
+func TestEventSizeGate(t *testing.T) {
+	relay := khatru.NewRelay()
+	gate := &messageGate{maxEvent: 1000}
+	relay.OnConnect = append(relay.OnConnect, gate.onConnect)
+	var seen []string
+	relay.RejectEvent = append(relay.RejectEvent, func(ctx context.Context, event *nostr.Event) (bool, string) {
+		seen = append(seen, event.Content)
+		return true, "blocked: test relay"
+	})
+	server := httptest.NewServer(gate.middleware(relay))
+	defer server.Close()
+	dialer := websocket.Dialer{WriteBufferSize: 64 << 10}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sk, _ := newKeypair(t)
+	publish := func(content string) {
+		event := nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Content: content}
+		if err := event.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteJSON(nostr.EventEnvelope{Event: event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish(strings.Repeat("x", 2000))
+	if reply := readEnvelope(t, conn); reply[0] != "NOTICE" || !strings.HasPrefix(reply[1].(string), "invalid: event message too large") {
+		t.Fatalf("expected a NOTICE for the oversized event, got %v", reply)
+	}
+	publish("small")
+	if reply := readEnvelope(t, conn); reply[0] != "OK" || reply[3] != "blocked: test relay" {
+		t.Fatalf("expected the small event to reach the policies, got %v", reply)
+	}
+	if len(seen) != 1 || seen[0] != "small" {
+		t.Errorf("expected only the small event to be parsed, got %d events", len(seen))
+	}
+	if gate.rejected.Load() != 1 {
+		t.Errorf("expected one rejection to be counted, got %d", gate.rejected.Load())
+	}
+}
+
+// oversizedEventMessage is a signed 400 KB EVENT message, just below khatru's read limit.
+func oversizedEventMessage(b *testing.B) []byte {
+	event := nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Content: strings.Repeat("x", 400_000)}
+	for i := 0; i < 2000; i++ {
+		event.Tags = append(event.Tags, nostr.Tag{"t", fmt.Sprintf("tag%d", i)})
+	}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		b.Fatal(err)
+	}
+	message, _ := nostr.EventEnvelope{Event: event}.MarshalJSON()
+	return message
+}
+
+// BenchmarkOversizedEvent compares what an oversized EVENT costs when it is parsed and verified
+// before a policy rejects it with what it costs when the frame filter drops it unparsed.
+func BenchmarkOversizedEvent(b *testing.B) {
+	message := oversizedEventMessage(b)
+
+	b.Run("parse-and-verify", func(b *testing.B) {
+		b.SetBytes(int64(len(message)))
+		for i := 0; i < b.N; i++ {
+			envelope, err := nostr.NewMessageParser().ParseMessage(string(message))
+			if err != nil {
+				b.Fatal(err)
+			}
+			event := envelope.(*nostr.EventEnvelope).Event
+			if !event.CheckID() {
+				b.Fatal("invalid id")
+			}
+			if ok, _ := event.CheckSignature(); !ok {
+				b.Fatal("invalid signature")
+			}
+		}
+	})
+
+	b.Run("frame-filter", func(b *testing.B) {
+		frame := clientFrame(0x1, true, message)
+		b.SetBytes(int64(len(message)))
+		for i := 0; i < b.N; i++ {
+			filter := &frameFilter{r: bufio.NewReader(bytes.NewReader(frame)), max: 100_000, reject: func(int64) {}}
+			if n, _ := io.Copy(io.Discard, filter); n != 0 {
+				b.Fatal("expected the event to be dropped")
+			}
+		}
+	})
+}
+
+// dialGatedRelay connects to relay behind a messageGate without checks, which is how brove
+// serves it and what closing connections relies on. The test only ends once khatru has run
+// the disconnect hooks of the connection, so they don't run into the next test.
+func dialGatedRelay(t *testing.T, relay *khatru.Relay) *websocket.Conn {
+	t.Helper()
+	gate := &messageGate{maxMessage: relay.MaxMessageSize}
+	disconnected := make(chan struct{})
+	var runs atomic.Int32
+	relay.OnConnect = append(relay.OnConnect, gate.onConnect)
+	relay.OnDisconnect = append(relay.OnDisconnect, func(ctx context.Context) {
+		// khatru runs the hooks of a connection twice, once as each of its goroutines ends
+		if conn := gateConnection(khatru.GetConnection(ctx)); conn != nil && conn.gate == gate && runs.Add(1) == 2 {
+			close(disconnected)
+		}
+	})
+	server := httptest.NewServer(gate.middleware(relay))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		select {
+		case <-disconnected:
+		case <-time.After(5 * time.Second):
+			t.Error("expected the connection to be cleaned up")
+		}
+	})
+	return conn
+}
+
+func TestCloseConnection(t *testing.T) {
+	relay := khatru.NewRelay()
+	connected := make(chan *khatru.WebSocket, 2)
+	disconnected := make(chan struct{}, 2)
+	relay.OnConnect = append(relay.OnConnect, func(ctx context.Context) { connected <- khatru.GetConnection(ctx) })
+	relay.OnDisconnect = append(relay.OnDisconnect, func(ctx context.Context) { disconnected <- struct{}{} })
+
+	conn := dialGatedRelay(t, relay)
+	ws := <-connected
+	if !closeConnection(ws) {
+		t.Fatal("expected the gated connection to be closed")
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected khatru to run its disconnect cleanup")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("expected the client to see the connection end")
+	}
+
+	// connections that bypass the gate can't be closed, which must not panic
+	dialRelay(t, relay)
+	if closeConnection(<-connected) {
+		t.Error("expected a connection without the gate not to be closable")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// metricsSource writes its metrics in the Prometheus text exposition format.
+type metricsSource func(w *bufio.Writer)
+
+// handleMetrics serves GET /metrics for Prometheus-compatible scrapers.
+func handleMetrics(sources ...metricsSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buffered := bufio.NewWriter(w)
+		for _, source := range sources {
+			source(buffered)
+		}
+		buffered.Flush()
+	}
+}
+
+// relayCounters counts the relay's core activity, for every metrics backend.
+type relayCounters struct {
+	eventsStored   atomic.Int64
+	eventsRejected atomic.Int64
+	queries        atomic.Int64
+	connections    atomic.Int64
+}
+
+func (c *relayCounters) onConnect(ctx context.Context) {
+	c.connections.Add(1)
+}
+
+func (c *relayCounters) onSaved(ctx context.Context, event *nostr.Event) {
+	c.eventsStored.Add(1)
+}
+
+// overwriteFilter counts every filter of a REQ; khatru runs these hooks for all of them,
+// including the limit:0 ones that skip the reject hooks.
+func (c *relayCounters) overwriteFilter(ctx context.Context, filter *nostr.Filter) {
+	c.queries.Add(1)
+}
+
+// wrap makes every event policy count its rejections.
+func (c *relayCounters) wrap(policies []func(context.Context, *nostr.Event) (bool, string)) []func(context.Context, *nostr.Event) (bool, string) {
+	wrapped := make([]func(context.Context, *nostr.Event) (bool, string), len(policies))
+	for i, policy := range policies {
+		wrapped[i] = func(ctx context.Context, event *nostr.Event) (bool, string) {
+			reject, msg := policy(ctx, event)
+			if reject {
+				c.eventsRejected.Add(1)
+			}
+			return reject, msg
+		}
+	}
+	return wrapped
+}
+
+func (c *relayCounters) metrics(w *bufio.Writer) {
+	fmt.Fprintln(w, "# HELP brove_events_stored_total Events stored.")
+	fmt.Fprintln(w, "# TYPE brove_events_stored_total counter")
+	fmt.Fprintf(w, "brove_events_stored_total %d\n", c.eventsStored.Load())
+	fmt.Fprintln(w, "# HELP brove_events_rejected_total Events rejected by a policy.")
+	fmt.Fprintln(w, "# TYPE brove_events_rejected_total counter")
+	fmt.Fprintf(w, "brove_events_rejected_total %d\n", c.eventsRejected.Load())
+	fmt.Fprintln(w, "# HELP brove_queries_total Filters received in REQs.")
+	fmt.Fprintln(w, "# TYPE brove_queries_total counter")
+	fmt.Fprintf(w, "brove_queries_total %d\n", c.queries.Load())
+	fmt.Fprintln(w, "# HELP brove_connections_total Websocket connections opened.")
+	fmt.Fprintln(w, "# TYPE brove_connections_total counter")
+	fmt.Fprintf(w, "brove_connections_total %d\n", c.connections.Load())
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricLabel is one label of a metric sample.
+type metricLabel struct {
+	key, value string
+}
+
+// metricSample is one value of a metric, as read back from the Prometheus text format so every
+// backend ships the same names and labels as /metrics.
+type metricSample struct {
+	name    string
+	labels  []metricLabel
+	value   float64
+	counter bool
+}
+
+// collectMetrics renders sources in the Prometheus text format and parses the samples back.
+func collectMetrics(sources []metricsSource) ([]metricSample, error) {
+	var text bytes.Buffer
+	buffered := bufio.NewWriter(&text)
+	for _, source := range sources {
+		source(buffered)
+	}
+	buffered.Flush()
+	return parseMetrics(text.String())
+}
+
+// parseMetrics parses the Prometheus text format as written by the metrics sources: samples
+// are "name value" or "name{key="value",...} value", and "# TYPE" lines mark the counters.
+func parseMetrics(text string) ([]metricSample, error) {
+	var samples []metricSample
+	counters := make(map[string]bool)
+	for _, line := range strings.Split(text, "\n") {
+		if fields := strings.Fields(line); len(fields) == 4 && fields[0] == "#" && fields[1] == "TYPE" {
+			counters[fields[2]] = fields[3] == "counter"
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		cut := strings.LastIndexByte(line, ' ')
+		if cut < 0 {
+			return nil, fmt.Errorf("invalid metric line %q", line)
+		}
+		value, err := strconv.ParseFloat(line[cut+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in metric line %q", line)
+		}
+		sample := metricSample{name: line[:cut], value: value}
+		if name, labels, ok := strings.Cut(sample.name, "{"); ok {
+			sample.name = name
+			if sample.labels, err = parseMetricLabels(strings.TrimSuffix(labels, "}")); err != nil {
+				return nil, fmt.Errorf("invalid labels in metric line %q: %w", line, err)
+			}
+		}
+		sample.counter = counters[sample.name]
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// parseMetricLabels parses `key="value",key2="value2"`.
+func parseMetricLabels(text string) ([]metricLabel, error) {
+	var labels []metricLabel
+	for text != "" {
+		key, rest, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("missing value for %q", text)
+		}
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, err
+		}
+		value, _ := strconv.Unquote(quoted)
+		labels = append(labels, metricLabel{key: key, value: value})
+		text = strings.TrimPrefix(rest[len(quoted):], ",")
+	}
+	return labels, nil
+}
+
+// metricsExporter ships samples to a metrics backend other than Prometheus.
+type metricsExporter interface {
+	export(ctx context.Context, samples []metricSample) error
+}
+
+// startMetricsExporter pushes the metrics of sources to exporter every interval until ctx is
+// cancelled. Failed pushes are logged and retried with the next interval.
+func startMetricsExporter(ctx context.Context, exporter metricsExporter, sources []metricsSource, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			samples, err := collectMetrics(sources)
+			if err == nil {
+				err = exporter.export(ctx, samples)
+			}
+			if err != nil {
+				log.Printf("Failed to export metrics: %v", err)
+			}
+		}
+	}()
+}
+
+// statsdExporter sends samples to a StatsD server over UDP. Labels become DogStatsD-style tags,
+// gauges are sent as gauges and counters as the increase since the previous push.
+type statsdExporter struct {
+	conn net.Conn
+	last map[string]float64
+}
+
+func newStatsdExporter(addr string) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to StatsD at %s: %w", addr, err)
+	}
+	return &statsdExporter{conn: conn, last: make(map[string]float64)}, nil
+}
+
+// statsdMaxPacket keeps packets below the usual MTU, so they are not fragmented.
+const statsdMaxPacket = 1400
+
+func (s *statsdExporter) export(ctx context.Context, samples []metricSample) error {
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+
+	for _, sample := range samples {
+		line := sample.name + ":"
+		if sample.counter {
+			key := sample.name + fmt.Sprint(sample.labels)
+			delta := sample.value - s.last[key]
+			s.last[key] = sample.value
+			if delta < 0 {
+				// restarted counters start over
+				delta = sample.value
+			}
+			line += strconv.FormatFloat(delta, 'f', -1, 64) + "|c"
+		} else {
+			line += strconv.FormatFloat(sample.value, 'f', -1, 64) + "|g"
+		}
+		if len(sample.labels) > 0 {
+			tags := make([]string, len(sample.labels))
+			for i, label := range sample.labels {
+				tags[i] = label.key + ":" + label.value
+			}
+			line += "|#" + strings.Join(tags, ",")
+		}
+
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
+
+// otlpExporter posts samples to an OpenTelemetry collector with OTLP/HTTP in its JSON encoding.
+// Counters become cumulative monotonic sums, everything else gauges.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+	start    time.Time
+	now      func() time.Time
+}
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	return &otlpExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/metrics",
+		client:   &http.Client{Timeout: 10 * time.Second},
+		start:    time.Now(),
+		now:      time.Now,
+	}
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpSum struct {
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+}
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpCumulative = 2
+
+func otlpAttributes(labels []metricLabel) []otlpAttribute {
+	attributes := make([]otlpAttribute, len(labels))
+	for i, label := range labels {
+		attributes[i].Key = label.key
+		attributes[i].Value.StringValue = label.value
+	}
+	return attributes
+}
+
+// request builds the OTLP body for samples, with one metric per name.
+func (o *otlpExporter) request(samples []metricSample) map[string]any {
+	now := strconv.FormatInt(o.now().UnixNano(), 10)
+	start := strconv.FormatInt(o.start.UnixNano(), 10)
+
+	var metrics []*otlpMetric
+	byName := make(map[string]*otlpMetric)
+	for _, sample := range samples {
+		metric, ok := byName[sample.name]
+		if !ok {
+			metric = &otlpMetric{Name: sample.name}
+			if sample.counter {
+				metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			} else {
+				metric.Gauge = &otlpGauge{}
+			}
+			byName[sample.name] = metric
+			metrics = append(metrics, metric)
+		}
+
+		point := otlpDataPoint{Attributes: otlpAttributes(sample.labels), TimeUnixNano: now, AsDouble: sample.value}
+		if metric.Sum != nil {
+			point.StartTimeUnixNano = start
+			metric.Sum.DataPoints = append(metric.Sum.DataPoints, point)
+		} else {
+			metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, point)
+		}
+	}
+
+	return map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]metricLabel{{key: "service.name", value: "brove"}}),
+			},
+			"scopeMetrics": []any{map[string]any{
+				"scope":   map[string]any{"name": "brove", "version": buildVersion()},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+func (o *otlpExporter) export(ctx context.Context, samples []metricSample) error {
+	body, err := json.Marshal(o.request(samples))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post metrics to %s: %w", o.endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP collector at %s returned %s", o.endpoint, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseMetrics(t *testing.T) {
+	dryRun := newDryRunPolicies([]string{"rate_limit"})
+	dryRun.rejects["rate_limit"] = 3
+	counters := &relayCounters{}
+	counters.onSaved(context.Background(), &nostr.Event{})
+
+	samples, err := collectMetrics([]metricsSource{counters.metrics, dryRun.metrics, func(w *bufio.Writer) {
+		w.WriteString("# TYPE test_ratio gauge\ntest_ratio{name=\"a \\\"quoted\\\", value\",other=\"b\"} 0.5\n")
+	}})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	want := map[string]metricSample{
+		"brove_events_stored_total":      {name: "brove_events_stored_total", value: 1, counter: true},
+		"brove_events_rejected_total":    {name: "brove_events_rejected_total", counter: true},
+		"brove_dry_run_rejections_total": {name: "brove_dry_run_rejections_total", labels: []metricLabel{{"policy", "rate_limit"}}, value: 3, counter: true},
+		"test_ratio":                     {name: "test_ratio", labels: []metricLabel{{"name", `a "quoted", value`}, {"other", "b"}}, value: 0.5},
+	}
+	for _, sample := range samples {
+		expected, ok := want[sample.name]
+		if !ok {
+			continue
+		}
+		delete(want, sample.name)
+		if sample.value != expected.value || sample.counter != expected.counter || !slices.Equal(sample.labels, expected.labels) {
+			t.Errorf("expected %+v, got %+v", expected, sample)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("missing samples %v", want)
+	}
+}
+
+func TestStatsdExporter(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer server.Close()
+	exporter, err := newStatsdExporter(server.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	receive := func() []string {
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buffer := make([]byte, statsdMaxPacket)
+		n, _, err := server.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("failed to receive: %v", err)
+		}
+		return strings.Split(string(buffer[:n]), "\n")
+	}
+
+	samples := []metricSample{
+		{name: "brove_events_stored_total", value: 5, counter: true},
+		{name: "brove_connections_active", value: 2},
+		{name: "brove_dry_run_rejections_total", labels: []metricLabel{{"policy", "rate_limit"}}, value: 1, counter: true},
+	}
+	if err := exporter.export(context.Background(), samples); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	want := []string{"brove_events_stored_total:5|c", "brove_connections_active:2|g", "brove_dry_run_rejections_total:1|c|#policy:rate_limit"}
+	if lines := receive(); !slices.Equal(lines, want) {
+		t.Errorf("expected %q, got %q", want, lines)
+	}
+
+	// counters are sent as the increase since the previous push
+	samples[0].value = 8
+	exporter.export(context.Background(), samples)
+	if lines := receive(); lines[0] != "brove_events_stored_total:3|c" {
+		t.Errorf("expected the increase, got %q", lines[0])
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []otlpMetric `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	exporter := newOTLPExporter(server.URL + "/")
+	samples := []metricSample{
+		{name: "brove_events_stored_total", value: 5, counter: true},
+		{name: "brove_dry_run_rejections_total", labels: []metricLabel{{"policy", "a"}}, value: 1, counter: true},
+		{name: "brove_dry_run_rejections_total", labels: []metricLabel{{"policy", "b"}}, value: 2, counter: true},
+		{name: "brove_connections_active", value: 2},
+	}
+	if err := exporter.export(context.Background(), samples); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	metrics := body.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 3 {
+		t.Fatalf("expected one metric per name, got %+v", metrics)
+	}
+	if sum := metrics[0].Sum; sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != otlpCumulative || sum.DataPoints[0].AsDouble != 5 {
+		t.Errorf("expected a cumulative sum for the counter, got %+v", metrics[0])
+	}
+	if points := metrics[1].Sum.DataPoints; len(points) != 2 || points[1].Attributes[0].Key != "policy" || points[1].Attributes[0].Value.StringValue != "b" {
+		t.Errorf("expected labels as attributes, got %+v", points)
+	}
+	if metrics[2].Name != "brove_connections_active" || metrics[2].Gauge == nil || metrics[2].Gauge.DataPoints[0].AsDouble != 2 {
+		t.Errorf("expected a gauge, got %+v", metrics[2])
+	}
+
+	server.Close()
+	if err := exporter.export(context.Background(), samples); err == nil {
+		t.Errorf("expected an error when the collector is down")
+	}
+}
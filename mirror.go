@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// pendingBroadcast is an event waiting to be published to one mirror relay.
+type pendingBroadcast struct {
+	id       int64
+	relay    string
+	event    *nostr.Event
+	attempts int
+}
+
+// broadcastQueue holds the broadcasts to mirror relays that have not succeeded yet.
+type broadcastQueue interface {
+	enqueue(ctx context.Context, relays []string, event *nostr.Event) error
+	// claim returns up to limit broadcasts due at now, earliest first, and hides them from
+	// other claims until lease, so a crash while publishing only delays them
+	claim(ctx context.Context, now, lease time.Time, limit int) ([]pendingBroadcast, error)
+	done(ctx context.Context, id int64) error
+	retry(ctx context.Context, id int64, attempts int, next time.Time) error
+	depth(ctx context.Context) (int, error)
+}
+
+// storedBroadcasts keeps the queue in the broadcast_queue table, so pending broadcasts survive
+// a restart and are shared by relay instances on the same database.
+type storedBroadcasts struct {
+	db *sql.DB
+}
+
+func newStoredBroadcasts(db *sql.DB) (*storedBroadcasts, error) {
+	query := `
+	CREATE TABLE IF NOT EXISTS broadcast_queue (
+		id BIGSERIAL PRIMARY KEY,
+		relay TEXT NOT NULL,
+		event JSONB NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS broadcast_queue_next_attempt_at ON broadcast_queue (next_attempt_at);`
+	if _, err := db.Exec(query); err != nil {
+		return nil, fmt.Errorf("failed to create broadcast_queue table: %w", err)
+	}
+	return &storedBroadcasts{db: db}, nil
+}
+
+func (s *storedBroadcasts) enqueue(ctx context.Context, relays []string, event *nostr.Event) error {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", event.ID, err)
+	}
+	for _, relay := range relays {
+		if _, err := s.db.ExecContext(ctx, `INSERT INTO broadcast_queue (relay, event) VALUES ($1, $2)`, relay, raw); err != nil {
+			return fmt.Errorf("failed to queue %s for %s: %w", event.ID, relay, err)
+		}
+	}
+	return nil
+}
+
+func (s *storedBroadcasts) claim(ctx context.Context, now, lease time.Time, limit int) ([]pendingBroadcast, error) {
+	query := `
+	UPDATE broadcast_queue SET next_attempt_at = $2
+	WHERE id IN (
+		SELECT id FROM broadcast_queue WHERE next_attempt_at <= $1
+		ORDER BY next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, relay, event, attempts`
+	rows, err := s.db.QueryContext(ctx, query, now.UTC(), lease.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim queued broadcasts: %w", err)
+	}
+	defer rows.Close()
+
+	var broadcasts []pendingBroadcast
+	for rows.Next() {
+		var broadcast pendingBroadcast
+		var raw []byte
+		if err := rows.Scan(&broadcast.id, &broadcast.relay, &raw, &broadcast.attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan queued broadcast: %w", err)
+		}
+		broadcast.event = &nostr.Event{}
+		if err := json.Unmarshal(raw, broadcast.event); err != nil {
+			return nil, fmt.Errorf("failed to decode queued broadcast %d: %w", broadcast.id, err)
+		}
+		broadcasts = append(broadcasts, broadcast)
+	}
+	return broadcasts, rows.Err()
+}
+
+func (s *storedBroadcasts) done(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM broadcast_queue WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to remove queued broadcast %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *storedBroadcasts) retry(ctx context.Context, id int64, attempts int, next time.Time) error {
+	query := `UPDATE broadcast_queue SET attempts = $2, next_attempt_at = $3 WHERE id = $1`
+	if _, err := s.db.ExecContext(ctx, query, id, attempts, next.UTC()); err != nil {
+		return fmt.Errorf("failed to reschedule queued broadcast %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *storedBroadcasts) depth(ctx context.Context) (int, error) {
+	var depth int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM broadcast_queue`).Scan(&depth); err != nil {
+		return 0, fmt.Errorf("failed to count queued broadcasts: %w", err)
+	}
+	return depth, nil
+}
+
+// mirrorPublishTimeout bounds a single publication to a mirror relay.
+const mirrorPublishTimeout = 10 * time.Second
+
+// mirrorBatchSize is how many queued broadcasts are claimed per round.
+const mirrorBatchSize = 100
+
+// mirror republishes every stored event to the MIRROR_RELAYS. Each broadcast goes through the
+// queue: failed ones are retried with exponential backoff, starting at backoff and capped at
+// maxBackoff, and dropped after maxAttempts.
+type mirror struct {
+	queue       broadcastQueue
+	relays      []string
+	publish     func(ctx context.Context, url string, event *nostr.Event) error
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	now         func() time.Time
+	queued      atomic.Int64
+}
+
+func newMirror(queue broadcastQueue, relays []string, publish func(context.Context, string, *nostr.Event) error, maxAttempts int, backoff, maxBackoff time.Duration) *mirror {
+	return &mirror{queue: queue, relays: relays, publish: publish, maxAttempts: maxAttempts, backoff: backoff, maxBackoff: maxBackoff, now: time.Now}
+}
+
+// poolPublisher publishes through a connection pool, reconnecting to relays as needed.
+func poolPublisher(pool *nostr.SimplePool) func(context.Context, string, *nostr.Event) error {
+	return func(ctx context.Context, url string, event *nostr.Event) error {
+		relay, err := pool.EnsureRelay(url)
+		if err != nil {
+			return err
+		}
+		return relay.Publish(ctx, *event)
+	}
+}
+
+// onSaved queues a stored event for every mirror relay.
+func (m *mirror) onSaved(ctx context.Context, event *nostr.Event) {
+	// the request context may end before the queue is written
+	if err := m.queue.enqueue(context.WithoutCancel(ctx), m.relays, event); err != nil {
+		log.Printf("Mirror: %v", err)
+		return
+	}
+	m.queued.Add(int64(len(m.relays)))
+}
+
+// delay is how long to wait before the next attempt after attempts failed ones.
+func (m *mirror) delay(attempts int) time.Duration {
+	delay := m.backoff
+	for i := 1; i < attempts && delay < m.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, m.maxBackoff)
+}
+
+// process publishes the due broadcasts once.
+func (m *mirror) process(ctx context.Context) {
+	now := m.now()
+	broadcasts, err := m.queue.claim(ctx, now, now.Add(2*mirrorPublishTimeout), mirrorBatchSize)
+	if err != nil {
+		log.Printf("Mirror: %v", err)
+		return
+	}
+
+	for _, broadcast := range broadcasts {
+		publishCtx, cancel := context.WithTimeout(ctx, mirrorPublishTimeout)
+		err := m.publish(publishCtx, broadcast.relay, broadcast.event)
+		cancel()
+
+		attempts := broadcast.attempts + 1
+		switch {
+		case err == nil:
+			err = m.queue.done(ctx, broadcast.id)
+		case attempts >= m.maxAttempts:
+			log.Printf("Mirror: dropping %s for %s after %d attempts: %v", broadcast.event.ID, broadcast.relay, attempts, err)
+			err = m.queue.done(ctx, broadcast.id)
+		default:
+			err = m.queue.retry(ctx, broadcast.id, attempts, m.now().Add(m.delay(attempts)))
+		}
+		if err != nil {
+			log.Printf("Mirror: %v", err)
+		}
+	}
+
+	if depth, err := m.queue.depth(ctx); err == nil {
+		m.queued.Store(int64(depth))
+	}
+}
+
+// start processes the queue every interval until ctx is done.
+func (m *mirror) start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			m.process(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (m *mirror) metrics(w *bufio.Writer) {
+	fmt.Fprintln(w, "# HELP brove_broadcast_queue_depth Broadcasts to mirror relays waiting to be published or retried.")
+	fmt.Fprintln(w, "# TYPE brove_broadcast_queue_depth gauge")
+	fmt.Fprintf(w, "brove_broadcast_queue_depth %d\n", m.queued.Load())
+}
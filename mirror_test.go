@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryBroadcasts is an in-memory broadcastQueue.
+type memoryBroadcasts struct {
+	nextID  int64
+	pending map[int64]*queuedBroadcast
+}
+
+type queuedBroadcast struct {
+	pendingBroadcast
+	next time.Time
+}
+
+func newMemoryBroadcasts() *memoryBroadcasts {
+	return &memoryBroadcasts{pending: make(map[int64]*queuedBroadcast)}
+}
+
+func (m *memoryBroadcasts) enqueue(ctx context.Context, relays []string, event *nostr.Event) error {
+	for _, relay := range relays {
+		m.nextID++
+		m.pending[m.nextID] = &queuedBroadcast{pendingBroadcast: pendingBroadcast{id: m.nextID, relay: relay, event: event}}
+	}
+	return nil
+}
+
+func (m *memoryBroadcasts) claim(ctx context.Context, now, lease time.Time, limit int) ([]pendingBroadcast, error) {
+	var due []pendingBroadcast
+	for _, queued := range m.pending {
+		if !queued.next.After(now) && len(due) < limit {
+			queued.next = lease
+			due = append(due, queued.pendingBroadcast)
+		}
+	}
+	return due, nil
+}
+
+func (m *memoryBroadcasts) done(ctx context.Context, id int64) error {
+	delete(m.pending, id)
+	return nil
+}
+
+func (m *memoryBroadcasts) retry(ctx context.Context, id int64, attempts int, next time.Time) error {
+	m.pending[id].attempts = attempts
+	m.pending[id].next = next
+	return nil
+}
+
+func (m *memoryBroadcasts) depth(ctx context.Context) (int, error) {
+	return len(m.pending), nil
+}
+
+func TestMirrorRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	down := map[string]bool{"wss://down.example": true}
+	published := make(map[string]int)
+
+	queue := newMemoryBroadcasts()
+	m := newMirror(queue, []string{"wss://up.example", "wss://down.example"}, func(ctx context.Context, url string, event *nostr.Event) error {
+		if down[url] {
+			return errors.New("connection refused")
+		}
+		published[url]++
+		return nil
+	}, 3, time.Minute, 90*time.Second)
+	m.now = func() time.Time { return now }
+
+	m.onSaved(ctx, &nostr.Event{ID: fakeID(1)})
+	m.process(ctx)
+	if published["wss://up.example"] != 1 || len(queue.pending) != 1 || m.queued.Load() != 1 {
+		t.Fatalf("expected the broadcast to the reachable relay to succeed and the other to be queued, got %v %d", published, len(queue.pending))
+	}
+
+	// not due before the backoff has passed
+	now = now.Add(59 * time.Second)
+	m.process(ctx)
+	for _, queued := range queue.pending {
+		if queued.attempts != 1 {
+			t.Fatalf("expected no attempt before the backoff, got %d", queued.attempts)
+		}
+	}
+
+	// the backoff doubles but is capped at the maximum
+	now = now.Add(time.Second)
+	m.process(ctx)
+	for _, queued := range queue.pending {
+		if queued.attempts != 2 || queued.next != now.Add(90*time.Second) {
+			t.Fatalf("expected a second attempt and a capped backoff, got %d, next at %v", queued.attempts, queued.next.Sub(now))
+		}
+	}
+
+	// the relay comes back after a restart: the queue still has the broadcast
+	delete(down, "wss://down.example")
+	now = now.Add(90 * time.Second)
+	m.process(ctx)
+	if published["wss://down.example"] != 1 || len(queue.pending) != 0 || m.queued.Load() != 0 {
+		t.Fatalf("expected the queued broadcast to be delivered, got %v %d", published, len(queue.pending))
+	}
+
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	m.metrics(w)
+	w.Flush()
+	if !strings.Contains(out.String(), "brove_broadcast_queue_depth 0\n") {
+		t.Errorf("expected the queue depth metric, got %s", out.String())
+	}
+}
+
+func TestMirrorDropsAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	queue := newMemoryBroadcasts()
+	m := newMirror(queue, []string{"wss://down.example"}, func(ctx context.Context, url string, event *nostr.Event) error {
+		return errors.New("connection refused")
+	}, 3, time.Second, time.Hour)
+	m.now = func() time.Time { return now }
+
+	m.onSaved(ctx, &nostr.Event{ID: fakeID(1)})
+	for range 3 {
+		m.process(ctx)
+		now = now.Add(time.Hour)
+	}
+	if len(queue.pending) != 0 {
+		t.Fatalf("expected the broadcast to be dropped after 3 attempts, got %v", queue.pending)
+	}
+}
+
+func TestMirrorDelay(t *testing.T) {
+	m := newMirror(nil, nil, nil, 10, 30*time.Second, time.Hour)
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 8: time.Hour, 100: time.Hour} {
+		if got := m.delay(attempts); got != want {
+			t.Errorf("after %d attempts expected %v, got %v", attempts, want, got)
+		}
+	}
+}
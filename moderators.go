@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// moderatorMethods are the NIP-86 methods moderators may call; everything else is owner-only.
+var moderatorMethods = []string{
+	"supportedmethods",
+	"banpubkey",
+	"banevent",
+	"listallowedpubkeys",
+	"listbannedpubkeys",
+}
+
+// managementAccess decides who may call a NIP-86 method: the owner may call anything,
+// moderators only the moderatorMethods and extraMethods, and they cannot ban the owner or
+// another moderator.
+func managementAccess(ownerPubKey string, isModerator func(pubkey string) (bool, error), extraMethods ...string) func(context.Context, nip86.MethodParams) (bool, string) {
+	return func(ctx context.Context, mp nip86.MethodParams) (reject bool, msg string) {
+		user := getAuthed(ctx)
+		if user != "" && user == ownerPubKey {
+			return false, ""
+		}
+
+		moderator, err := isModerator(user)
+		if err != nil {
+			log.Printf("Error checking if pubkey is a moderator: %v", err)
+			return true, "error checking authorization"
+		}
+		if !moderator {
+			return true, "go away, intruder"
+		}
+		if !slices.Contains(moderatorMethods, mp.MethodName()) && !slices.Contains(extraMethods, mp.MethodName()) {
+			return true, "only the relay owner can call " + mp.MethodName()
+		}
+
+		if ban, ok := mp.(nip86.BanPubKey); ok {
+			if ban.PubKey == ownerPubKey {
+				return true, "moderators cannot ban the relay owner"
+			}
+			if target, err := isModerator(ban.PubKey); err != nil || target {
+				return true, "moderators cannot ban other moderators"
+			}
+		}
+		return false, ""
+	}
+}
+
+// deleteEventByID returns a NIP-86 banevent handler that removes the event from the store.
+func deleteEventByID(store eventstore.Store) func(ctx context.Context, id string, reason string) error {
+	return func(ctx context.Context, id string, reason string) error {
+		if !nostr.IsValid32ByteHex(id) {
+			return fmt.Errorf("invalid event id")
+		}
+
+		events, err := store.QueryEvents(ctx, nostr.Filter{IDs: []string{id}})
+		if err != nil {
+			return fmt.Errorf("failed to query event %s: %w", id, err)
+		}
+		found := false
+		for event := range events {
+			found = true
+			if err := store.DeleteEvent(ctx, event); err != nil {
+				return fmt.Errorf("failed to delete event %s: %w", id, err)
+			}
+		}
+		if !found {
+			return fmt.Errorf("event %s not found", id)
+		}
+
+		log.Printf("Event %s deleted through the management API: %s", id, reason)
+		return nil
+	}
+}
+
+// handleAdminModerators serves the owner-only moderator list: GET lists moderators,
+// POST {"pubkey": "<hex>"} adds one and DELETE {"pubkey": "<hex>"} removes one.
+func handleAdminModerators(dbManager *DBManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			moderators, err := dbManager.GetModerators()
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, moderators)
+			return
+		}
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		var body struct {
+			Pubkey string `json:"pubkey"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json body"})
+			return
+		}
+		if !nostr.IsValidPublicKey(body.Pubkey) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid pubkey"})
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			// adding an existing moderator is a no-op
+			if err := dbManager.AddModerator(body.Pubkey); err != nil && !errors.Is(err, ErrPubkeyExists) {
+				writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+		} else if err := dbManager.RemoveModerator(body.Pubkey); err != nil {
+			writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"pubkey": body.Pubkey})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fiatjaf/eventstore/slicestore"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+func TestManagementAccess(t *testing.T) {
+	_, owner := newKeypair(t)
+	_, moderator := newKeypair(t)
+	_, otherModerator := newKeypair(t)
+	_, member := newKeypair(t)
+	isModerator := func(pubkey string) (bool, error) {
+		return pubkey == moderator || pubkey == otherModerator, nil
+	}
+	access := managementAccess(owner, isModerator)
+
+	tests := []struct {
+		name   string
+		user   string
+		params nip86.MethodParams
+		reject bool
+	}{
+		{"owner allows", owner, nip86.AllowPubKey{PubKey: member}, false},
+		{"owner bans moderator", owner, nip86.BanPubKey{PubKey: moderator}, false},
+		{"moderator bans member", moderator, nip86.BanPubKey{PubKey: member}, false},
+		{"moderator bans event", moderator, nip86.BanEvent{ID: "abc"}, false},
+		{"moderator lists", moderator, nip86.ListAllowedPubKeys{}, false},
+		{"moderator allows", moderator, nip86.AllowPubKey{PubKey: member}, true},
+		{"moderator renames relay", moderator, nip86.ChangeRelayName{Name: "mine"}, true},
+		{"moderator bans owner", moderator, nip86.BanPubKey{PubKey: owner}, true},
+		{"moderator bans moderator", moderator, nip86.BanPubKey{PubKey: otherModerator}, true},
+		{"member", member, nip86.ListAllowedPubKeys{}, true},
+		{"unauthenticated", "", nip86.SupportedMethods{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAuthed(t, tt.user)
+			if reject, msg := access(context.Background(), tt.params); reject != tt.reject {
+				t.Errorf("expected reject=%t, got %t (%q)", tt.reject, reject, msg)
+			}
+		})
+	}
+}
+
+func TestDeleteEventByID(t *testing.T) {
+	store := &slicestore.SliceStore{}
+	store.Init()
+	sk, _ := newKeypair(t)
+	event := signedEvent(t, sk)
+	if err := store.SaveEvent(context.Background(), event); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	ban := deleteEventByID(store)
+	if err := ban(context.Background(), event.ID, "spam"); err != nil {
+		t.Fatalf("expected the event to be deleted, got %v", err)
+	}
+	if count, _ := store.CountEvents(context.Background(), nostr.Filter{IDs: []string{event.ID}}); count != 0 {
+		t.Errorf("expected the event to be gone, %d left", count)
+	}
+	if err := ban(context.Background(), event.ID, "spam"); err == nil {
+		t.Error("expected an error for an event that is not stored")
+	}
+	if err := ban(context.Background(), "not-an-id", "spam"); err == nil {
+		t.Error("expected an error for an invalid id")
+	}
+}
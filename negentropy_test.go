@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy/storage/vector"
+)
+
+// fakeID returns a valid-looking event id made from n.
+func fakeID(n int) string {
+	return fmt.Sprintf("%064x", n)
+}
+
+func TestNegentropyReconciliation(t *testing.T) {
+	serverEvents := []*nostr.Event{
+		{ID: fakeID(1), CreatedAt: 1000},
+		{ID: fakeID(2), CreatedAt: 1001},
+		{ID: fakeID(3), CreatedAt: 1002},
+	}
+	relay := khatru.NewRelay()
+	relay.Negentropy = true
+	relay.QueryEvents = append(relay.QueryEvents, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		ch := make(chan *nostr.Event, len(serverEvents))
+		for _, event := range serverEvents {
+			ch <- event
+		}
+		close(ch)
+		return ch, nil
+	})
+
+	// the client has 2 and 3 in common with the relay, lacks 1 and has 4 the relay lacks
+	local := vector.New()
+	local.Insert(1001, fakeID(2))
+	local.Insert(1002, fakeID(3))
+	local.Insert(1003, fakeID(4))
+	local.Seal()
+	neg := negentropy.New(local, 0)
+
+	conn := dialRelay(t, relay)
+	open, _ := json.Marshal([]any{"NEG-OPEN", "sync", nostr.Filter{}, neg.Start()})
+	if err := conn.WriteMessage(websocket.TextMessage, open); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	var haves, haveNots []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for id := range neg.Haves {
+			haves = append(haves, id)
+		}
+	}()
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for id := range neg.HaveNots {
+			haveNots = append(haveNots, id)
+		}
+	}()
+
+	for round := 0; ; round++ {
+		if round > 10 {
+			t.Fatal("reconciliation did not finish")
+		}
+		message := readEnvelope(t, conn)
+		if message[0] != "NEG-MSG" {
+			t.Fatalf("expected NEG-MSG, got %v", message)
+		}
+		next, err := neg.Reconcile(message[2].(string))
+		if err != nil {
+			t.Fatalf("failed to reconcile: %v", err)
+		}
+		if next == "" {
+			break
+		}
+		reply, _ := json.Marshal([]any{"NEG-MSG", "sync", next})
+		if err := conn.WriteMessage(websocket.TextMessage, reply); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+	}
+	<-done
+	<-collected
+
+	if !slices.Equal(haves, []string{fakeID(4)}) {
+		t.Errorf("expected the client to have only event 4 extra, got %v", haves)
+	}
+	if !slices.Equal(haveNots, []string{fakeID(1)}) {
+		t.Errorf("expected the client to miss only event 1, got %v", haveNots)
+	}
+}
+
+func TestNegentropyRequiresAuth(t *testing.T) {
+	relay := khatru.NewRelay()
+	relay.Negentropy = true
+	relay.RejectFilter = append(relay.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if khatru.GetAuthed(ctx) == "" {
+			return true, "auth-required: only authenticated users can read from this relay"
+		}
+		return false, ""
+	})
+
+	local := vector.New()
+	local.Seal()
+	conn := dialRelay(t, relay)
+
+	open, _ := json.Marshal([]any{"NEG-OPEN", "sync", nostr.Filter{}, negentropy.New(local, 0).Start()})
+	if err := conn.WriteMessage(websocket.TextMessage, open); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	// the rejection also triggers an AUTH challenge, which may arrive first
+	message := readEnvelope(t, conn)
+	if message[0] == "AUTH" {
+		message = readEnvelope(t, conn)
+	}
+	if message[0] != "NEG-ERROR" || !strings.HasPrefix(message[2].(string), "auth-required:") {
+		t.Fatalf("expected NEG-ERROR auth-required, got %v", message)
+	}
+}
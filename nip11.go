@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// nip11Extension adds fields the nip11 package has no room for to the relay information document.
+type nip11Extension func(r *http.Request, doc map[string]any)
+
+// nip11Middleware lets khatru build the NIP-11 document, applies the extensions to it and
+// serves it with an ETag so clients can revalidate with If-None-Match. The ETag is a hash of
+// the document itself, so it changes whenever relay.Info or an extension's output does.
+//
+// The document is served for GET and HEAD, a plain OPTIONS is answered with the allowed
+// methods, and any other method gets a 405.
+func nip11Middleware(extensions []nip11Extension, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/nostr+json" ||
+			strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+			r.Header.Get("Content-Type") == "application/nostr+json+rpc" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodOptions:
+			if r.Header.Get("Access-Control-Request-Method") != "" {
+				// a CORS preflight, answered by khatru
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Allow", nip11Methods)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", nip11Methods)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		buffered := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buffered, r)
+
+		var doc map[string]any
+		if buffered.status != http.StatusOK || json.Unmarshal(buffered.body.Bytes(), &doc) != nil {
+			// not a document we understand, pass it on unchanged
+			w.WriteHeader(buffered.status)
+			w.Write(buffered.body.Bytes())
+			return
+		}
+
+		for _, extend := range extensions {
+			extend(r, doc)
+		}
+		body, err := json.Marshal(doc)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		body = append(body, '\n')
+
+		sum := sha256.Sum256(body)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/nostr+json")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(body)
+	})
+}
+
+const nip11Methods = "GET, HEAD, OPTIONS"
+
+// etagMatches reports whether an If-None-Match header matches etag, accepting "*",
+// lists of tags and weak tags.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// nip11Limitation returns the "limitation" object of a NIP-11 document, creating it if needed.
+func nip11Limitation(doc map[string]any) map[string]any {
+	limitation, ok := doc["limitation"].(map[string]any)
+	if !ok {
+		limitation = make(map[string]any)
+		doc["limitation"] = limitation
+	}
+	return limitation
+}
+
+// bufferedResponse collects a response so it can be rewritten before being sent.
+// Headers are shared with the real writer.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
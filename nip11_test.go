@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+func TestNIP11Limits(t *testing.T) {
+	relay := khatru.NewRelay()
+	relay.Info.Limitation = &nip11.RelayLimitationDocument{MaxEventTags: 50}
+	handler := nip11Middleware([]nip11Extension{createdAtLimits(24*time.Hour, 15*time.Minute)}, relay)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/nostr+json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var doc struct {
+		Limitation map[string]any `json:"limitation"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid NIP-11 document %q: %v", rec.Body.String(), err)
+	}
+
+	want := map[string]float64{
+		"max_event_tags":         50,
+		"created_at_lower_limit": 86400,
+		"created_at_upper_limit": 900,
+	}
+	for field, value := range want {
+		if doc.Limitation[field] != value {
+			t.Errorf("expected %s = %v, got %v", field, value, doc.Limitation[field])
+		}
+	}
+}
+
+func TestNIP11MethodsAndETag(t *testing.T) {
+	relay := khatru.NewRelay()
+	relay.Info.Name = "first"
+	handler := nip11Middleware(nil, relay)
+
+	request := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Accept", "application/nostr+json")
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	get := request("GET", nil)
+	etag := get.Header().Get("ETag")
+	if get.Code != 200 || etag == "" || get.Header().Get("Content-Type") != "application/nostr+json" {
+		t.Fatalf("expected the document with an ETag, got %d %v", get.Code, get.Header())
+	}
+
+	head := request("HEAD", nil)
+	if head.Code != 200 || head.Header().Get("ETag") != etag || head.Body.Len() != 0 {
+		t.Fatalf("expected HEAD to return the headers only, got %d %v %q", head.Code, head.Header(), head.Body.String())
+	}
+
+	if rec := request("GET", map[string]string{"If-None-Match": etag}); rec.Code != 304 || rec.Body.Len() != 0 {
+		t.Fatalf("expected 304 for a matching ETag, got %d", rec.Code)
+	}
+	if rec := request("GET", map[string]string{"If-None-Match": `"other", W/` + etag}); rec.Code != 304 {
+		t.Fatalf("expected 304 for a matching weak ETag in a list, got %d", rec.Code)
+	}
+
+	relay.Info.Name = "second"
+	changed := request("GET", map[string]string{"If-None-Match": etag})
+	if changed.Code != 200 || changed.Header().Get("ETag") == etag {
+		t.Fatalf("expected a new document and ETag after relay.Info changed, got %d %v", changed.Code, changed.Header())
+	}
+
+	if rec := request("OPTIONS", nil); rec.Code != 204 || rec.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Fatalf("expected OPTIONS to list the allowed methods, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := request("OPTIONS", map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "GET"}); rec.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Fatalf("expected CORS preflights to still be answered, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := request("DELETE", nil); rec.Code != 405 || rec.Header().Get("Allow") == "" {
+		t.Fatalf("expected 405 for DELETE, got %d %v", rec.Code, rec.Header())
+	}
+}
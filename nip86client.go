@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// managementClient calls the NIP-86 management API of a remote relay,
+// authenticating every request with a NIP-98 event signed by secretKey.
+type managementClient struct {
+	url       string
+	secretKey string
+	client    *http.Client
+}
+
+// newManagementClient creates a client for the relay at relayURL (ws(s):// or http(s)://).
+func newManagementClient(relayURL, secretKey string) *managementClient {
+	url := strings.Replace(nostr.NormalizeURL(relayURL), "ws", "http", 1)
+	return &managementClient{
+		url:       url,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call invokes a management method and decodes its result into result.
+func (mc *managementClient) call(ctx context.Context, method string, params []any, result any) error {
+	if params == nil {
+		params = []any{}
+	}
+	payload, err := json.Marshal(nip86.Request{Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	auth, err := mc.authHeader(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, mc.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/nostr+json+rpc")
+	req.Header.Set("Authorization", auth)
+
+	resp, err := mc.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", mc.url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response from %s: %w", mc.url, err)
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+	decodeErr := json.Unmarshal(body, &response)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%s refused the credentials (status %d%s), check that the key is the relay owner's", mc.url, resp.StatusCode, remoteReason(response.Error))
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s returned status %d%s", mc.url, resp.StatusCode, remoteReason(response.Error))
+	case decodeErr != nil:
+		return fmt.Errorf("invalid management response from %s, is it a relay with NIP-86 enabled? %w", mc.url, decodeErr)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("relay rejected %s: %s", method, response.Error)
+	}
+	if result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("unexpected result for %s: %w", method, err)
+		}
+	}
+
+	return nil
+}
+
+// remoteReason formats the error a relay gave along with a failed status, if any.
+func remoteReason(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return ": " + reason
+}
+
+// authHeader builds the NIP-98 Authorization header for a request with the given payload.
+func (mc *managementClient) authHeader(payload []byte) (string, error) {
+	return signNIP98(mc.secretKey, mc.url, http.MethodPost, payload)
+}
+
+// signNIP98 returns a NIP-98 Authorization header for a request to url, signed by secretKey.
+// The payload is hashed into the event when not nil.
+func signNIP98(secretKey, url, method string, payload []byte) (string, error) {
+	event := nostr.Event{
+		Kind:      27235,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"u", url}, {"method", method}},
+	}
+	if payload != nil {
+		payloadHash := sha256.Sum256(payload)
+		event.Tags = append(event.Tags, nostr.Tag{"payload", hex.EncodeToString(payloadHash[:])})
+	}
+	if err := event.Sign(secretKey); err != nil {
+		return "", fmt.Errorf("failed to sign auth event: %w", err)
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode auth event: %w", err)
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(eventJSON), nil
+}
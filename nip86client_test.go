@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+func TestManagementClientSignsRequests(t *testing.T) {
+	sk, pk := newKeypair(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// checks the u, method and payload tags against the request
+		body, _ := io.ReadAll(r.Body)
+		if pubkey, err := verifyNIP86(r, body, nostr.Now()); err != nil || pubkey != pk {
+			t.Errorf("expected a NIP-98 header signed by the key, got %s, %v", pubkey, err)
+		}
+		if r.Header.Get("Content-Type") != "application/nostr+json+rpc" {
+			t.Errorf("expected the NIP-86 content type, got %q", r.Header.Get("Content-Type"))
+		}
+		json.NewEncoder(w).Encode(nip86.Response{Result: []string{"listallowedpubkeys"}})
+	}))
+	defer server.Close()
+
+	client := newManagementClient(strings.Replace(server.URL, "http", "ws", 1), sk)
+	if client.url != server.URL {
+		t.Fatalf("expected the websocket URL to become %s, got %s", server.URL, client.url)
+	}
+	var methods []string
+	if err := client.call(context.Background(), "supportedmethods", nil, &methods); err != nil || len(methods) != 1 {
+		t.Fatalf("expected the call to succeed, got %v %v", methods, err)
+	}
+}
+
+func TestManagementClientErrors(t *testing.T) {
+	sk, _ := newKeypair(t)
+	cases := []struct {
+		name    string
+		status  int
+		body    string
+		message string
+	}{
+		{"wrong key", http.StatusUnauthorized, `{"error":"missing auth"}`, "refused the credentials (status 401: missing auth), check that the key is the relay owner's"},
+		{"not the owner", http.StatusOK, `{"error":"not authorized"}`, "relay rejected listallowedpubkeys: not authorized"},
+		{"management disabled", http.StatusNotFound, "404 page not found", "returned status 404"},
+		{"not a relay", http.StatusOK, "<html>hello</html>", "is it a relay with NIP-86 enabled?"},
+		{"unexpected result", http.StatusOK, `{"result":"everyone"}`, "unexpected result for listallowedpubkeys"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+				w.Write([]byte(c.body))
+			}))
+			defer server.Close()
+
+			var remote []nip86.PubKeyReason
+			err := newManagementClient(server.URL, sk).call(context.Background(), "listallowedpubkeys", nil, &remote)
+			if err == nil || !strings.Contains(err.Error(), c.message) {
+				t.Errorf("expected an error containing %q, got %v", c.message, err)
+			}
+		})
+	}
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	err := newManagementClient(unreachable.URL, sk).call(context.Background(), "supportedmethods", nil, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to reach "+unreachable.URL) {
+		t.Errorf("expected an unreachable relay to be reported, got %v", err)
+	}
+}
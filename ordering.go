@@ -0,0 +1,68 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// oldestFirstKinds are the kinds whose stored results are sent oldest first, e.g. chat
+// messages that clients display chronologically. The store still selects the newest events
+// up to the filter's limit, only the order in which they are sent changes.
+type oldestFirstKinds map[int]bool
+
+// loadOldestFirstKinds reads OLDEST_FIRST_KINDS, a comma-separated list of kind numbers.
+func loadOldestFirstKinds() oldestFirstKinds {
+	kinds := make(oldestFirstKinds)
+	for _, kind := range getEnvKinds("OLDEST_FIRST_KINDS", nil) {
+		kinds[kind] = true
+	}
+	return kinds
+}
+
+// applies reports whether every kind of the filter is sent oldest first. Filters without
+// kinds or mixing orders keep the NIP-01 newest-first order.
+func (o oldestFirstKinds) applies(filter nostr.Filter) bool {
+	if len(filter.Kinds) == 0 {
+		return false
+	}
+	for _, kind := range filter.Kinds {
+		if !o[kind] {
+			return false
+		}
+	}
+	return true
+}
+
+// wrapQuery sorts the results of queries for oldest-first kinds by created_at, ascending.
+// The results are buffered, which is bounded by the filter's limit and the store's query limit.
+func (o oldestFirstKinds) wrapQuery(query func(context.Context, nostr.Filter) (chan *nostr.Event, error)) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events, err := query(ctx, filter)
+		if err != nil || !o.applies(filter) {
+			return events, err
+		}
+
+		ordered := make(chan *nostr.Event)
+		go func() {
+			defer close(ordered)
+			var buffered []*nostr.Event
+			for event := range events {
+				buffered = append(buffered, event)
+			}
+			slices.SortStableFunc(buffered, func(a, b *nostr.Event) int {
+				return cmp.Compare(a.CreatedAt, b.CreatedAt)
+			})
+			for _, event := range buffered {
+				select {
+				case ordered <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ordered, nil
+	}
+}
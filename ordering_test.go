@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestOldestFirstKinds(t *testing.T) {
+	stored := []*nostr.Event{
+		{ID: fakeID(3), Kind: 42, CreatedAt: 300},
+		{ID: fakeID(2), Kind: 42, CreatedAt: 200},
+		{ID: fakeID(1), Kind: 42, CreatedAt: 100},
+	}
+	query := oldestFirstKinds{42: true}.wrapQuery(func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events := make(chan *nostr.Event, len(stored))
+		for _, event := range stored {
+			events <- event
+		}
+		close(events)
+		return events, nil
+	})
+
+	collect := func(filter nostr.Filter) []nostr.Timestamp {
+		events, err := query(context.Background(), filter)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		var order []nostr.Timestamp
+		for event := range events {
+			order = append(order, event.CreatedAt)
+		}
+		return order
+	}
+
+	if got := collect(nostr.Filter{Kinds: []int{42}}); got[0] != 100 || got[2] != 300 {
+		t.Fatalf("expected oldest first for kind 42, got %v", got)
+	}
+	if got := collect(nostr.Filter{Kinds: []int{42, 1}}); got[0] != 300 {
+		t.Fatalf("expected newest first when kinds are mixed, got %v", got)
+	}
+	if got := collect(nostr.Filter{}); got[0] != 300 {
+		t.Fatalf("expected newest first without kinds, got %v", got)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// tagStripper removes tags by name from the events served to everyone but the owner, e.g. to
+// keep client or location metadata private. Only the copies sent out are changed; storage and
+// the owner keep the canonical event. The id and signature are sent as stored, so a client that
+// recomputes the id from a stripped event gets a different one and its signature no longer
+// verifies.
+type tagStripper struct {
+	names []string
+	owner string
+}
+
+// strips reports whether event carries a tag that is removed.
+func (s tagStripper) strips(event *nostr.Event) bool {
+	for _, tag := range event.Tags {
+		if len(tag) > 0 && slices.Contains(s.names, tag[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// strip returns a copy of event without the removed tags, or event itself if it has none.
+func (s tagStripper) strip(event *nostr.Event) *nostr.Event {
+	if !s.strips(event) {
+		return event
+	}
+	stripped := *event
+	stripped.Tags = make(nostr.Tags, 0, len(event.Tags))
+	for _, tag := range event.Tags {
+		if len(tag) == 0 || !slices.Contains(s.names, tag[0]) {
+			stripped.Tags = append(stripped.Tags, tag)
+		}
+	}
+	return &stripped
+}
+
+func (s tagStripper) isOwner(pubkey string) bool {
+	return s.owner != "" && pubkey == s.owner
+}
+
+// wrapQuery strips the query results served to everyone but the owner.
+func (s tagStripper) wrapQuery(query func(context.Context, nostr.Filter) (chan *nostr.Event, error)) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events, err := query(ctx, filter)
+		if err != nil || s.isOwner(getAuthed(ctx)) {
+			return events, err
+		}
+
+		stripped := make(chan *nostr.Event)
+		go func() {
+			defer close(stripped)
+			for event := range events {
+				select {
+				case stripped <- s.strip(event):
+				case <-ctx.Done():
+					// keep draining so the underlying query can finish
+				}
+			}
+		}()
+		return stripped, nil
+	}
+}
+
+// wrapPage strips the /api/events pages served to every reader but the owner.
+func (s tagStripper) wrapPage(queryPage func(context.Context, eventQuery) ([]*nostr.Event, error)) func(context.Context, eventQuery) ([]*nostr.Event, error) {
+	return func(ctx context.Context, q eventQuery) ([]*nostr.Event, error) {
+		events, err := queryPage(ctx, q)
+		if err != nil || s.isOwner(readerPubkey(ctx)) {
+			return events, err
+		}
+		stripped := make([]*nostr.Event, len(events))
+		for i, event := range events {
+			stripped[i] = s.strip(event)
+		}
+		return stripped, nil
+	}
+}
+
+// preventBroadcast keeps live events that would be stripped from everyone but the owner: khatru
+// sends the same event to every listener, so they cannot be stripped on the way. Such events
+// are still served, stripped, by queries.
+func (s tagStripper) preventBroadcast(ws *khatru.WebSocket, event *nostr.Event) bool {
+	return s.strips(event) && !s.isOwner(ws.AuthedPublicKey)
+}
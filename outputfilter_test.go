@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestTagStripper(t *testing.T) {
+	sk, owner := newKeypair(t)
+	event := &nostr.Event{Kind: nostr.KindTextNote, CreatedAt: 1, Content: "hi", Tags: nostr.Tags{{"client", "app"}, {"e", fakeID(1)}, {"g", "u4pruy"}, {}}}
+	if err := event.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	plain := &nostr.Event{Kind: nostr.KindTextNote, Tags: nostr.Tags{{"p", owner}}}
+	stripper := tagStripper{names: []string{"client", "g"}, owner: owner}
+	query := stripper.wrapQuery(func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		ch := make(chan *nostr.Event, 2)
+		ch <- event
+		ch <- plain
+		close(ch)
+		return ch, nil
+	})
+	collect := func() []*nostr.Event {
+		events, err := query(context.Background(), nostr.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*nostr.Event
+		for e := range events {
+			got = append(got, e)
+		}
+		return got
+	}
+
+	withAuthed(t, "")
+	got := collect()
+	if len(got) != 2 || len(got[0].Tags) != 2 || got[0].Tags[0][0] != "e" || got[1] != plain {
+		t.Fatalf("expected the client and g tags to be stripped, got %v", got)
+	}
+	if got[0].ID != event.ID || got[0].Sig != event.Sig {
+		t.Error("expected the stored id and signature to be sent")
+	}
+	if len(event.Tags) != 4 {
+		t.Error("expected the stored event to be left alone")
+	}
+	if ok, _ := event.CheckSignature(); !ok {
+		t.Error("expected the stored event to still verify")
+	}
+
+	withAuthed(t, owner)
+	if got := collect(); got[0] != event {
+		t.Error("expected the owner to be served the canonical event")
+	}
+
+	if !stripper.preventBroadcast(&khatru.WebSocket{}, event) || stripper.preventBroadcast(&khatru.WebSocket{}, plain) {
+		t.Error("expected only events with stripped tags to be kept from live subscribers")
+	}
+	if stripper.preventBroadcast(&khatru.WebSocket{AuthedPublicKey: owner}, event) {
+		t.Error("expected the owner to receive live events unchanged")
+	}
+}
+
+func TestTagStripperEventPages(t *testing.T) {
+	ownerSK, owner := newKeypair(t)
+	readerSK, _ := newKeypair(t)
+	event := &nostr.Event{ID: fakeID(1), Kind: nostr.KindTextNote, CreatedAt: 1, Tags: nostr.Tags{{"client", "app"}, {"e", fakeID(2)}}}
+	stripper := tagStripper{names: []string{"client"}, owner: owner}
+	allowed := func(pubkey string) (bool, error) { return true, nil }
+	handler := requireReader(allowed, owner, handleEvents(stripper.wrapPage(memoryEventPage([]*nostr.Event{event}))))
+
+	page := func(sk string) []nostr.Event {
+		rec := httptest.NewRecorder()
+		handler(rec, nip98Request(t, sk, "GET", "http://example.com/api/events", nostr.Now()))
+		if rec.Code != 200 {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var result struct {
+			Events []nostr.Event `json:"events"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || len(result.Events) != 1 {
+			t.Fatalf("expected one event, got %s", rec.Body.String())
+		}
+		return result.Events
+	}
+
+	if got := page(readerSK); len(got[0].Tags) != 1 || got[0].Tags[0][0] != "e" || got[0].ID != event.ID {
+		t.Errorf("expected the client tag to be stripped for readers, got %v", got[0].Tags)
+	}
+	if got := page(ownerSK); len(got[0].Tags) != 2 {
+		t.Errorf("expected the owner to be served the canonical event, got %v", got[0].Tags)
+	}
+	if len(event.Tags) != 2 {
+		t.Error("expected the stored event to be left alone")
+	}
+}
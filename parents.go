@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// storedParents rejects replies whose parent is not stored on this relay, keeping threads
+// self-contained. A reply is a text note with NIP-10 marked e tags; its parent is the "reply"
+// event, or the "root" for direct replies to the root. Parents found are cached, since they
+// stay stored; missing ones are not, as they may still arrive.
+type storedParents struct {
+	exists      func(ctx context.Context, id string) (bool, error)
+	ownerPubKey string
+
+	mu    sync.Mutex
+	found map[string]struct{}
+}
+
+func newStoredParents(exists func(ctx context.Context, id string) (bool, error), ownerPubKey string) *storedParents {
+	return &storedParents{exists: exists, ownerPubKey: ownerPubKey, found: make(map[string]struct{})}
+}
+
+// replyParent returns the id of the event a text note replies to, or "" if it is not a reply.
+func replyParent(event *nostr.Event) string {
+	if event.Kind != nostr.KindTextNote {
+		return ""
+	}
+	var root string
+	for _, tag := range event.Tags {
+		if len(tag) < 4 || tag[0] != "e" {
+			continue
+		}
+		switch tag[3] {
+		case "reply":
+			return tag[1]
+		case "root":
+			root = tag[1]
+		}
+	}
+	return root
+}
+
+func (p *storedParents) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if event.PubKey == p.ownerPubKey {
+		return false, ""
+	}
+	parent := replyParent(event)
+	if parent == "" {
+		return false, ""
+	}
+	if !nostr.IsValid32ByteHex(parent) {
+		return true, "invalid: malformed e tag"
+	}
+
+	p.mu.Lock()
+	_, ok := p.found[parent]
+	p.mu.Unlock()
+	if ok {
+		return false, ""
+	}
+
+	exists, err := p.exists(ctx, parent)
+	if err != nil {
+		log.Printf("Error looking up parent event %s: %v", parent, err)
+		return true, "error: could not look up the parent event, try again later"
+	}
+	if !exists {
+		return true, "blocked: parent event not found here"
+	}
+
+	p.mu.Lock()
+	if len(p.found) >= 10000 {
+		clear(p.found)
+	}
+	p.found[parent] = struct{}{}
+	p.mu.Unlock()
+	return false, ""
+}
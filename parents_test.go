@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestStoredParents(t *testing.T) {
+	stored := map[string]bool{fakeID(1): true}
+	lookups := 0
+	parents := newStoredParents(func(ctx context.Context, id string) (bool, error) {
+		lookups++
+		return stored[id], nil
+	}, "owner")
+
+	reply := func(pubkey string, tags ...nostr.Tag) *nostr.Event {
+		return &nostr.Event{PubKey: pubkey, Kind: nostr.KindTextNote, Tags: tags}
+	}
+	cases := []struct {
+		name   string
+		event  *nostr.Event
+		reject bool
+	}{
+		{"not a reply", reply("alice"), false},
+		{"reply to a stored parent", reply("alice", nostr.Tag{"e", fakeID(9), "", "root"}, nostr.Tag{"e", fakeID(1), "", "reply"}), false},
+		{"direct reply to a stored root", reply("alice", nostr.Tag{"e", fakeID(1), "", "root"}), false},
+		{"orphan reply", reply("alice", nostr.Tag{"e", fakeID(1), "", "root"}, nostr.Tag{"e", fakeID(2), "", "reply"}), true},
+		{"owner orphan reply", reply("owner", nostr.Tag{"e", fakeID(2), "", "reply"}), false},
+		{"unmarked e tag", reply("alice", nostr.Tag{"e", fakeID(2)}), false},
+		{"reaction", &nostr.Event{PubKey: "alice", Kind: nostr.KindReaction, Tags: nostr.Tags{{"e", fakeID(2), "", "reply"}}}, false},
+	}
+	for _, tc := range cases {
+		reject, msg := parents.reject(context.Background(), tc.event)
+		if reject != tc.reject {
+			t.Errorf("%s: expected reject=%t, got %t (%q)", tc.name, tc.reject, reject, msg)
+		}
+		if reject && msg != "blocked: parent event not found here" {
+			t.Errorf("%s: unexpected message %q", tc.name, msg)
+		}
+	}
+
+	if lookups != 2 {
+		t.Fatalf("expected the stored parent to be looked up once and the orphan once, got %d lookups", lookups)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// paymentAdvertisement is what NIP-11 tells clients about paying for this relay, so they can
+// prompt users to pay before they try to publish.
+type paymentAdvertisement struct {
+	// setting is PAYMENT_REQUIRED: auto follows the enforced gates, true also covers paid
+	// admission to the allowlist handled elsewhere, e.g. by a payment service calling NIP-86
+	setting     string
+	paymentsURL string
+	// zap is the enforced zap gate, nil if there is none or it runs in dry-run mode
+	zap *zapGate
+}
+
+// apply sets payment_required, payments_url and fees in info. payment_required is never
+// cleared while a gate that makes writers pay is enforced.
+func (p paymentAdvertisement) apply(info *nip11.RelayInformationDocument) {
+	enforced := p.zap != nil
+	required := enforced
+	switch p.setting {
+	case "auto":
+	case "true":
+		required = true
+		if !enforced && p.paymentsURL == "" {
+			log.Printf("PAYMENT_REQUIRED is set, but without a zap gate or PAYMENTS_URL clients are not told how to pay")
+		}
+	case "false":
+		if enforced {
+			log.Printf("PAYMENT_REQUIRED is false, but ZAP_REQUIRED_SATS is enforced: advertising payment_required anyway")
+		}
+	default:
+		log.Printf("Invalid PAYMENT_REQUIRED %q, must be auto, true or false, using auto", p.setting)
+	}
+
+	info.Limitation.PaymentRequired = required
+	info.PaymentsURL = p.paymentsURL
+	if p.zap != nil {
+		info.Fees = &nip11.RelayFeesDocument{}
+		info.Fees.Subscription = append(info.Fees.Subscription, struct {
+			Amount int    `json:"amount"`
+			Unit   string `json:"unit"`
+			Period int    `json:"period"`
+		}{Amount: int(p.zap.minMsats), Unit: "msats", Period: int(p.zap.period.Seconds())})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+func TestPaymentAdvertisement(t *testing.T) {
+	gate := newZapGate(nil, "", nil, 2_100_000, 30*24*time.Hour, time.Minute)
+
+	for _, tc := range []struct {
+		name     string
+		setting  string
+		zap      *zapGate
+		required bool
+	}{
+		{"auto without a gate", "auto", nil, false},
+		{"auto with a zap gate", "auto", gate, true},
+		{"paid admission handled elsewhere", "true", nil, true},
+		{"false with a zap gate", "false", gate, true},
+		{"false without a gate", "false", nil, false},
+		{"invalid", "sometimes", gate, true},
+	} {
+		info := &nip11.RelayInformationDocument{Limitation: &nip11.RelayLimitationDocument{}}
+		paymentAdvertisement{setting: tc.setting, paymentsURL: "https://relay.example/pay", zap: tc.zap}.apply(info)
+		if info.Limitation.PaymentRequired != tc.required {
+			t.Errorf("%s: expected payment_required=%v", tc.name, tc.required)
+		}
+	}
+
+	info := &nip11.RelayInformationDocument{Limitation: &nip11.RelayLimitationDocument{}}
+	paymentAdvertisement{setting: "auto", paymentsURL: "https://relay.example/pay", zap: gate}.apply(info)
+	encoded, _ := json.Marshal(info)
+	for _, want := range []string{
+		`"payments_url":"https://relay.example/pay"`,
+		`"fees":{"subscription":[{"amount":2100000,"unit":"msats","period":2592000}]}`,
+		`"payment_required":true`,
+	} {
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("expected %s in %s", want, encoded)
+		}
+	}
+}
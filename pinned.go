@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// pinStore keeps the pinned events, which the retention job never deletes.
+type pinStore interface {
+	PinEvent(id, reason string) error
+	UnpinEvent(id string) error
+	GetPinnedEvents() ([]nip86.IDReason, error)
+}
+
+// pinManagementMethods are the owner's NIP-86 methods for pinned events: pinevent with an
+// event id and an optional reason, unpinevent with an event id, and listpinnedevents, which
+// returns the same id/reason objects as listbannedevents.
+func pinManagementMethods(store pinStore) map[string]ownerManagementMethod {
+	return map[string]ownerManagementMethod{
+		"pinevent": func(ctx context.Context, params []any) (any, error) {
+			id, reason, err := pinParams(params)
+			if err != nil {
+				return nil, err
+			}
+			if err := store.PinEvent(id, reason); err != nil {
+				return nil, err
+			}
+			log.Printf("Event %s pinned, retention will keep it: %s", id, reason)
+			return true, nil
+		},
+		"unpinevent": func(ctx context.Context, params []any) (any, error) {
+			id, _, err := pinParams(params)
+			if err != nil {
+				return nil, err
+			}
+			if err := store.UnpinEvent(id); err != nil {
+				return nil, err
+			}
+			log.Printf("Event %s unpinned", id)
+			return true, nil
+		},
+		"listpinnedevents": func(ctx context.Context, params []any) (any, error) {
+			return store.GetPinnedEvents()
+		},
+	}
+}
+
+// pinParams reads the [id, reason] params of pinevent and unpinevent; the reason is optional.
+func pinParams(params []any) (id, reason string, err error) {
+	if len(params) == 0 {
+		return "", "", fmt.Errorf("invalid params: missing event id")
+	}
+	id, ok := params[0].(string)
+	if !ok {
+		return "", "", fmt.Errorf("invalid params: event id must be a string")
+	}
+	if len(params) > 1 {
+		if reason, ok = params[1].(string); !ok {
+			return "", "", fmt.Errorf("invalid params: reason must be a string")
+		}
+	}
+	return id, reason, nil
+}
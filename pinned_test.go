@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr/nip86"
+)
+
+// memoryEventTable plays the event and pinned_events tables for the retention statements,
+// applying their conditions the way postgres would.
+type memoryEventTable struct {
+	events  map[string]struct{ kind, createdAt int64 }
+	pinned  map[string]bool
+	queries []string
+}
+
+func (m *memoryEventTable) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	m.queries = append(m.queries, query)
+	var matches func(kind int64) bool
+	switch {
+	case strings.HasPrefix(query, "DELETE FROM event WHERE kind = $1"):
+		matches = func(kind int64) bool { return kind == int64(args[0].(int)) }
+	case strings.HasPrefix(query, "DELETE FROM event WHERE NOT (kind = ANY($1))"):
+		listed := *args[0].(*pq.Int64Array)
+		matches = func(kind int64) bool { return !slices.Contains(listed, kind) }
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+
+	var deleted int64
+	for id, event := range m.events {
+		if !matches(event.kind) || event.createdAt >= args[1].(int64) {
+			continue
+		}
+		if strings.Contains(query, keepPinned) && m.pinned[id] {
+			continue
+		}
+		delete(m.events, id)
+		deleted++
+	}
+	return driverResult(deleted), nil
+}
+
+type driverResult int64
+
+func (r driverResult) LastInsertId() (int64, error) { return 0, nil }
+func (r driverResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func TestRetentionKeepsPinnedEvents(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	old := now.Add(-400 * 24 * time.Hour).Unix()
+	table := &memoryEventTable{
+		events: map[string]struct{ kind, createdAt int64 }{
+			fakeID(1): {1, old},
+			fakeID(2): {1, old},
+			fakeID(3): {30023, old},
+			fakeID(4): {30023, old},
+			fakeID(5): {1, now.Unix()},
+		},
+		pinned: map[string]bool{fakeID(1): true, fakeID(3): true},
+	}
+	policy := retentionPolicy{kinds: map[int]time.Duration{1: 24 * time.Hour}, fallback: 365 * 24 * time.Hour}
+
+	pruneExpiredEvents(context.Background(), table, policy, now)
+
+	for _, query := range table.queries {
+		if !strings.Contains(query, keepPinned) {
+			t.Errorf("expected every retention statement to keep pinned events, got %q", query)
+		}
+	}
+	for _, id := range []string{fakeID(1), fakeID(3), fakeID(5)} {
+		if _, ok := table.events[id]; !ok {
+			t.Errorf("expected %s to survive retention", id)
+		}
+	}
+	for _, id := range []string{fakeID(2), fakeID(4)} {
+		if _, ok := table.events[id]; ok {
+			t.Errorf("expected unpinned expired event %s to be deleted", id)
+		}
+	}
+}
+
+// memoryPins is a pinStore in memory.
+type memoryPins map[string]string
+
+func (m memoryPins) PinEvent(id, reason string) error { m[id] = reason; return nil }
+
+func (m memoryPins) UnpinEvent(id string) error {
+	if _, ok := m[id]; !ok {
+		return ErrEventNotPinned
+	}
+	delete(m, id)
+	return nil
+}
+
+func (m memoryPins) GetPinnedEvents() ([]nip86.IDReason, error) {
+	pinned := []nip86.IDReason{}
+	for id, reason := range m {
+		pinned = append(pinned, nip86.IDReason{ID: id, Reason: reason})
+	}
+	return pinned, nil
+}
+
+func TestPinManagementMethods(t *testing.T) {
+	ownerSK, ownerPK := newKeypair(t)
+	otherSK, _ := newKeypair(t)
+	pins := memoryPins{}
+	handler := managementMiddleware(khatru.NewRelay(), &ownerManagementMethods{owner: ownerPK, methods: pinManagementMethods(pins)}, http.NotFoundHandler())
+
+	const url = "http://relay.example.com/"
+	call := func(sk string, tamper bool, method string, params ...any) nip86.Response {
+		t.Helper()
+		payload, _ := json.Marshal(nip86.Request{Method: method, Params: params})
+		auth, err := signNIP98(sk, url, http.MethodPost, payload)
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		if tamper {
+			payload, _ = json.Marshal(nip86.Request{Method: method, Params: append(params, "extra")})
+		}
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/nostr+json+rpc")
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp nip86.Response
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+		}
+		return resp
+	}
+
+	resp := call(ownerSK, false, "supportedmethods")
+	for _, method := range []string{"pinevent", "unpinevent", "listpinnedevents"} {
+		if !slices.Contains(resp.Result.([]any), any(method)) {
+			t.Errorf("expected %s in the supported methods, got %v", method, resp.Result)
+		}
+	}
+
+	if resp := call(ownerSK, false, "pinevent", fakeID(1), "welcome post"); resp.Error != "" || resp.Result != true || pins[fakeID(1)] != "welcome post" {
+		t.Fatalf("expected the owner to pin the event, got %+v %v", resp, pins)
+	}
+	if resp := call(otherSK, false, "pinevent", fakeID(2)); resp.Error != "only the relay owner can call pinevent" || len(pins) != 1 {
+		t.Errorf("expected others to be refused, got %+v", resp)
+	}
+	if resp := call(ownerSK, true, "unpinevent", fakeID(1)); !strings.Contains(resp.Error, "payload") || len(pins) != 1 {
+		t.Errorf("expected a body not matching the auth event to be refused, got %+v", resp)
+	}
+	if resp := call(ownerSK, false, "pinevent"); resp.Error != "invalid params: missing event id" {
+		t.Errorf("expected missing params to be refused, got %+v", resp)
+	}
+
+	resp = call(ownerSK, false, "listpinnedevents")
+	if raw, _ := json.Marshal(resp.Result); string(raw) != `[{"id":"`+fakeID(1)+`","reason":"welcome post"}]` {
+		t.Errorf("expected the pinned event to be listed, got %s", raw)
+	}
+
+	if resp := call(ownerSK, false, "unpinevent", fakeID(1)); resp.Error != "" || len(pins) != 0 {
+		t.Errorf("expected the event to be unpinned, got %+v %v", resp, pins)
+	}
+	if resp := call(ownerSK, false, "unpinevent", fakeID(1)); resp.Error != ErrEventNotPinned.Error() {
+		t.Errorf("expected unpinning twice to fail, got %+v", resp)
+	}
+}
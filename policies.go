@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// getAuthed returns the pubkey the current connection authenticated as (NIP-42 or NIP-98).
+// It is a variable so tests can simulate authenticated connections.
+var getAuthed = khatru.GetAuthed
+
+// enforceOwnerAuth rejects events signed by the owner unless the connection is authenticated
+// as the owner, so cached or replayed owner events cannot be pushed by anyone else.
+func enforceOwnerAuth(ownerPubKey string) func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if ownerPubKey == "" || event.PubKey != ownerPubKey {
+			return false, ""
+		}
+		if getAuthed(ctx) == ownerPubKey {
+			return false, ""
+		}
+		return true, "auth-required: events from the relay owner must be published by the authenticated owner"
+	}
+}
+
+// requireAuthBeforeEvent rejects every event from a connection that has not authenticated, so
+// khatru answers with an AUTH challenge before any other policy runs. Events published by the
+// relay itself have no connection and pass.
+func requireAuthBeforeEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if getConnection(ctx) == nil || getAuthed(ctx) != "" {
+		return false, ""
+	}
+	return true, "auth-required: authenticate before publishing events"
+}
+
+// uppercaseHex answers EVENT messages whose id, pubkey or sig is in uppercase hex with a failed
+// OK saying so. NIP-01 hex is lowercase, and the fields can't be lowercased for the client: the
+// id is the hash of the event with its pubkey as sent, and the signature covers the id. khatru
+// refuses an uppercase id as "computed incorrectly" before any policy runs, so this is a
+// messageGate check on the raw message.
+func uppercaseHex(message []byte) any {
+	raw := eventMessage(message)
+	if raw == nil {
+		return nil
+	}
+	var event struct {
+		ID     string `json:"id"`
+		PubKey string `json:"pubkey"`
+		Sig    string `json:"sig"`
+	}
+	if json.Unmarshal(raw, &event) != nil {
+		return nil
+	}
+	for _, field := range []string{event.ID, event.PubKey, event.Sig} {
+		if strings.ContainsAny(field, "ABCDEF") {
+			return nostr.OKEnvelope{EventID: event.ID, OK: false, Reason: "invalid: id, pubkey and sig must be lowercase hex"}
+		}
+	}
+	return nil
+}
+
+// rejectUnsupportedSignature only accepts BIP-340 schnorr signatures over secp256k1 as NIP-01
+// defines them: a 32-byte x-only pubkey and a 64-byte signature, both in lowercase hex.
+//
+// khatru verifies the signature of websocket events before any policy runs, but it answers a
+// malformed one with a generic error and events added by the relay itself skip that check.
+func rejectUnsupportedSignature(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if len(event.PubKey) != 64 || !isHex(event.PubKey) || len(event.Sig) != 128 || !isHex(event.Sig) {
+		return true, "invalid: unsupported signature format"
+	}
+	pubkey, _ := hex.DecodeString(event.PubKey)
+	sig, _ := hex.DecodeString(event.Sig)
+	if _, err := schnorr.ParsePubKey(pubkey); err != nil {
+		return true, "invalid: unsupported signature format"
+	}
+	if _, err := schnorr.ParseSignature(sig); err != nil {
+		return true, "invalid: unsupported signature format"
+	}
+	return false, ""
+}
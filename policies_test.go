@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// withAuthed makes getAuthed report the given pubkey for the duration of the test.
+func withAuthed(t *testing.T, pubkey string) {
+	t.Helper()
+	previous := getAuthed
+	getAuthed = func(ctx context.Context) string { return pubkey }
+	t.Cleanup(func() { getAuthed = previous })
+}
+
+// newKeypair returns a fresh secret key and its hex pubkey.
+func newKeypair(t *testing.T) (string, string) {
+	t.Helper()
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatalf("failed to derive pubkey: %v", err)
+	}
+	return sk, pk
+}
+
+// signedEvent returns a kind 1 note signed with sk.
+func signedEvent(t *testing.T, sk string) *nostr.Event {
+	t.Helper()
+	event := &nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Content: "hello"}
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+	return event
+}
+
+func TestEnforceOwnerAuth(t *testing.T) {
+	ownerSK, ownerPK := newKeypair(t)
+	otherSK, otherPK := newKeypair(t)
+	policy := enforceOwnerAuth(ownerPK)
+
+	tests := []struct {
+		name   string
+		event  *nostr.Event
+		authed string
+		reject bool
+	}{
+		{"owner event from owner session", signedEvent(t, ownerSK), ownerPK, false},
+		{"owner event from anonymous connection", signedEvent(t, ownerSK), "", true},
+		{"owner event from another session", signedEvent(t, ownerSK), otherPK, true},
+		{"non-owner event from anonymous connection", signedEvent(t, otherSK), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAuthed(t, tt.authed)
+			reject, msg := policy(context.Background(), tt.event)
+			if reject != tt.reject {
+				t.Fatalf("expected reject=%t, got %t (%q)", tt.reject, reject, msg)
+			}
+		})
+	}
+}
+
+func TestRequireAuthBeforeEvent(t *testing.T) {
+	relay := khatru.NewRelay()
+	relay.RejectEvent = append(relay.RejectEvent, requireAuthBeforeEvent)
+	relay.StoreEvent = append(relay.StoreEvent, func(ctx context.Context, event *nostr.Event) error { return nil })
+	sk, _ := newKeypair(t)
+	event := signedEvent(t, sk)
+
+	// an anonymous submission gets an AUTH challenge and an auth-required OK
+	conn := dialRelay(t, relay)
+	if err := conn.WriteJSON(nostr.EventEnvelope{Event: *event}); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	challenge := readEnvelope(t, conn)
+	if challenge[0] != "AUTH" {
+		t.Fatalf("expected an AUTH challenge, got %v", challenge)
+	}
+	if ok := readEnvelope(t, conn); ok[0] != "OK" || ok[2] != false || ok[3] != "auth-required: authenticate before publishing events" {
+		t.Fatalf("expected the event to be refused until authentication, got %v", ok)
+	}
+
+	auth := nostr.Event{
+		Kind:      nostr.KindClientAuthentication,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"relay", "ws://" + conn.RemoteAddr().String()}, {"challenge", challenge[1].(string)}},
+	}
+	if err := auth.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if err := conn.WriteJSON(nostr.AuthEnvelope{Event: auth}); err != nil {
+		t.Fatalf("failed to send AUTH: %v", err)
+	}
+	if ok := readEnvelope(t, conn); ok[0] != "OK" || ok[2] != true {
+		t.Fatalf("expected AUTH to succeed, got %v", ok)
+	}
+
+	// once authenticated, the same event goes through the write path
+	if err := conn.WriteJSON(nostr.EventEnvelope{Event: *event}); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if ok := readEnvelope(t, conn); ok[0] != "OK" || ok[2] != true {
+		t.Fatalf("expected the event to be accepted after AUTH, got %v", ok)
+	}
+
+	// events the relay publishes itself have no connection
+	if reject, _ := requireAuthBeforeEvent(context.Background(), event); reject {
+		t.Fatal("expected internal events to pass")
+	}
+}
+
+func TestUppercaseHex(t *testing.T) {
+	sk, pk := newKeypair(t)
+	var stored []*nostr.Event
+	relay := khatru.NewRelay()
+	gate := &messageGate{maxMessage: relay.MaxMessageSize, checks: []messageCheck{unparsableMessage, uppercaseHex}}
+	relay.OnConnect = append(relay.OnConnect, gate.onConnect)
+	relay.RejectEvent = append(relay.RejectEvent, rejectUnsupportedSignature)
+	relay.StoreEvent = append(relay.StoreEvent, func(ctx context.Context, event *nostr.Event) error {
+		stored = append(stored, event)
+		return nil
+	})
+	server := httptest.NewServer(gate.middleware(relay))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a client that signs over an uppercase pubkey and sends uppercase hex throughout
+	upper := nostr.Event{PubKey: strings.ToUpper(pk), CreatedAt: nostr.Now(), Kind: nostr.KindTextNote, Tags: nostr.Tags{}, Content: "shouting"}
+	hash := sha256.Sum256(upper.Serialize())
+	skBytes, _ := hex.DecodeString(sk)
+	key, _ := btcec.PrivKeyFromBytes(skBytes)
+	signature, err := schnorr.Sign(key, hash[:])
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	upper.ID = hex.EncodeToString(hash[:])
+	upper.Sig = strings.ToUpper(hex.EncodeToString(signature.Serialize()))
+	// and one that only uppercases the id of an otherwise valid event
+	upperID := *signedEvent(t, sk)
+	upperID.ID = strings.ToUpper(upperID.ID)
+
+	for _, event := range []*nostr.Event{&upper, &upperID} {
+		conn.WriteJSON(nostr.EventEnvelope{Event: *event})
+		if ok := readEnvelope(t, conn); ok[0] != "OK" || ok[1] != event.ID || ok[2] != false || ok[3] != "invalid: id, pubkey and sig must be lowercase hex" {
+			t.Fatalf("expected %s to be refused for its hex case, got %v", event.ID, ok)
+		}
+	}
+
+	event := signedEvent(t, sk)
+	conn.WriteJSON(nostr.EventEnvelope{Event: *event})
+	if ok := readEnvelope(t, conn); ok[0] != "OK" || ok[2] != true {
+		t.Fatalf("expected the lowercase event to be accepted, got %v", ok)
+	}
+	if len(stored) != 1 || stored[0].ID != event.ID || stored[0].Sig != event.Sig {
+		t.Fatalf("expected only the lowercase event to be stored as sent, got %+v", stored)
+	}
+	if !stored[0].CheckID() {
+		t.Error("expected the stored event to keep a valid id")
+	}
+	if ok, err := stored[0].CheckSignature(); !ok || err != nil {
+		t.Errorf("expected the stored event to keep a valid signature, got %v", err)
+	}
+}
+
+func TestRejectUnsupportedSignature(t *testing.T) {
+	sk, pk := newKeypair(t)
+	valid := signedEvent(t, sk)
+	if reject, msg := rejectUnsupportedSignature(context.Background(), valid); reject {
+		t.Fatalf("expected a schnorr signed event to pass, got %s", msg)
+	}
+
+	tests := map[string]nostr.Event{
+		"short pubkey":        {PubKey: pk[:62], Sig: valid.Sig},
+		"33-byte pubkey":      {PubKey: "02" + pk, Sig: valid.Sig},
+		"pubkey not on curve": {PubKey: strings.Repeat("f", 64), Sig: valid.Sig},
+		"uppercase pubkey":    {PubKey: strings.ToUpper(pk), Sig: valid.Sig},
+		"short signature":     {PubKey: pk, Sig: valid.Sig[:126]},
+		"65-byte signature":   {PubKey: pk, Sig: valid.Sig + "01"},
+		"non-hex signature":   {PubKey: pk, Sig: strings.Repeat("z", 128)},
+		"signature r too big": {PubKey: pk, Sig: strings.Repeat("f", 64) + valid.Sig[64:]},
+		"uppercase signature": {PubKey: pk, Sig: strings.ToUpper(valid.Sig)},
+	}
+	for name, event := range tests {
+		if reject, msg := rejectUnsupportedSignature(context.Background(), &event); !reject || msg != "invalid: unsupported signature format" {
+			t.Errorf("%s: expected rejection, got %v %q", name, reject, msg)
+		}
+	}
+}
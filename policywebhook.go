@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// policyDecision is what the policy webhook answers: {"accept": false, "reason": "..."}.
+type policyDecision struct {
+	Accept bool   `json:"accept"`
+	Reason string `json:"reason"`
+}
+
+type cachedDecision struct {
+	decision policyDecision
+	expires  time.Time
+}
+
+// policyWebhook lets an external HTTP service accept or reject events.
+// Decisions are cached per event id for cacheTTL, so retries of the same event are not re-sent.
+type policyWebhook struct {
+	url      string
+	client   *http.Client
+	failOpen bool
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedDecision
+}
+
+func newPolicyWebhook(url string, timeout time.Duration, failOpen bool, cacheTTL time.Duration) *policyWebhook {
+	return &policyWebhook{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		failOpen: failOpen,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]cachedDecision),
+	}
+}
+
+// reject is the RejectEvent policy. When the webhook cannot be reached or answers with
+// anything but a 2xx JSON decision, the event is accepted if failOpen is set and rejected otherwise.
+func (p *policyWebhook) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	decision, ok := p.cached(event.ID)
+	if !ok {
+		var err error
+		decision, err = p.ask(ctx, event)
+		if err != nil {
+			log.Printf("Policy webhook failed for event %s: %v", event.ID, err)
+			if p.failOpen {
+				return false, ""
+			}
+			return true, "error: policy check unavailable, try again later"
+		}
+		p.remember(event.ID, decision)
+	}
+
+	if decision.Accept {
+		return false, ""
+	}
+	if decision.Reason == "" {
+		return true, "blocked: rejected by relay policy"
+	}
+	return true, decision.Reason
+}
+
+func (p *policyWebhook) ask(ctx context.Context, event *nostr.Event) (policyDecision, error) {
+	body, err := json.Marshal(map[string]any{
+		"event":         event,
+		"authed_pubkey": getAuthed(ctx),
+	})
+	if err != nil {
+		return policyDecision{}, fmt.Errorf("failed to encode policy request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return policyDecision{}, fmt.Errorf("failed to create policy request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return policyDecision{}, fmt.Errorf("failed to call policy webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return policyDecision{}, fmt.Errorf("policy webhook returned status %d", resp.StatusCode)
+	}
+
+	var decision policyDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return policyDecision{}, fmt.Errorf("failed to decode policy decision: %w", err)
+	}
+	return decision, nil
+}
+
+func (p *policyWebhook) cached(id string) (policyDecision, bool) {
+	if p.cacheTTL <= 0 {
+		return policyDecision{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.cache[id]
+	if !ok || time.Now().After(entry.expires) {
+		return policyDecision{}, false
+	}
+	return entry.decision, true
+}
+
+func (p *policyWebhook) remember(id string, decision policyDecision) {
+	if p.cacheTTL <= 0 {
+		return
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// drop expired decisions once the cache grows, so it stays bounded by the event rate
+	if len(p.cache) >= 10000 {
+		for key, entry := range p.cache {
+			if now.After(entry.expires) {
+				delete(p.cache, key)
+			}
+		}
+	}
+	p.cache[id] = cachedDecision{decision: decision, expires: now.Add(p.cacheTTL)}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPolicyWebhook(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var body struct {
+			Event nostr.Event `json:"event"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid policy request: %v", err)
+		}
+		switch body.Event.Content {
+		case "spam":
+			json.NewEncoder(w).Encode(policyDecision{Accept: false, Reason: "blocked: spam"})
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(policyDecision{Accept: true})
+		}
+	}))
+	defer server.Close()
+
+	sk, _ := newKeypair(t)
+	eventWith := func(content string) *nostr.Event {
+		event := &nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Content: content}
+		event.Sign(sk)
+		return event
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		failOpen bool
+		reject   bool
+		msg      string
+	}{
+		{"accepted", "hello", false, false, ""},
+		{"rejected with reason", "spam", false, true, "blocked: spam"},
+		{"error fails closed", "broken", false, true, "error: policy check unavailable, try again later"},
+		{"error fails open", "broken", true, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := newPolicyWebhook(server.URL, time.Second, tt.failOpen, time.Minute)
+			reject, msg := webhook.reject(context.Background(), eventWith(tt.content))
+			if reject != tt.reject || msg != tt.msg {
+				t.Errorf("expected (%t, %q), got (%t, %q)", tt.reject, tt.msg, reject, msg)
+			}
+		})
+	}
+
+	t.Run("decisions are cached per event", func(t *testing.T) {
+		webhook := newPolicyWebhook(server.URL, time.Second, false, time.Minute)
+		event := eventWith("spam")
+		before := calls.Load()
+		for range 3 {
+			if reject, _ := webhook.reject(context.Background(), event); !reject {
+				t.Fatal("expected the cached rejection to hold")
+			}
+		}
+		if made := calls.Load() - before; made != 1 {
+			t.Errorf("expected 1 webhook call, got %d", made)
+		}
+	})
+}
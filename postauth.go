@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// authTimeState is what authTimes knows about one connection.
+type authTimeState struct {
+	pubkey   string    // authenticated pubkey, as last seen
+	since    time.Time // authenticated as pubkey no earlier than this
+	lastSeen time.Time
+}
+
+// authTimes rejects events on authenticated connections that were created before the
+// connection authenticated, so old signed events can't be replayed through a fresh session.
+//
+// khatru does not report when a connection authenticates, so the auth time is bounded from
+// below instead: it is the last moment the connection was seen not (yet) authenticated as its
+// current pubkey, i.e. when it connected or its last REQ, COUNT or EVENT before AUTH. That is
+// never later than the real auth time, so events created after AUTH are always accepted.
+type authTimes struct {
+	now func() time.Time
+
+	mu          sync.Mutex
+	connections map[*khatru.WebSocket]*authTimeState
+}
+
+func newAuthTimes() *authTimes {
+	return &authTimes{now: time.Now, connections: make(map[*khatru.WebSocket]*authTimeState)}
+}
+
+func (a *authTimes) onConnect(ctx context.Context) {
+	if ws := getConnection(ctx); ws != nil {
+		now := a.now()
+		a.mu.Lock()
+		a.connections[ws] = &authTimeState{since: now, lastSeen: now}
+		a.mu.Unlock()
+	}
+}
+
+func (a *authTimes) onDisconnect(ctx context.Context) {
+	if ws := getConnection(ctx); ws != nil {
+		a.mu.Lock()
+		delete(a.connections, ws)
+		a.mu.Unlock()
+	}
+}
+
+// observe records the connection behind ctx as seen now and returns the earliest time it
+// may have authenticated as its current pubkey, or false if it has not authenticated.
+func (a *authTimes) observe(ctx context.Context) (time.Time, bool) {
+	ws := getConnection(ctx)
+	if ws == nil {
+		return time.Time{}, false
+	}
+	pubkey := ws.AuthedPublicKey
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	state, ok := a.connections[ws]
+	if !ok {
+		state = &authTimeState{since: now, lastSeen: now}
+		a.connections[ws] = state
+	}
+	if state.pubkey != pubkey {
+		state.pubkey = pubkey
+		state.since = state.lastSeen
+	}
+	state.lastSeen = now
+	return state.since, pubkey != ""
+}
+
+func (a *authTimes) rejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	authedAt, ok := a.observe(ctx)
+	if ok && event.CreatedAt < nostr.Timestamp(authedAt.Unix()) {
+		return true, "invalid: event predates session auth"
+	}
+	return false, ""
+}
+
+func (a *authTimes) rejectFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	a.observe(ctx)
+	return false, ""
+}
+
+// restart treats ws as not authenticated as of now, for when its authentication was taken away.
+func (a *authTimes) restart(ws *khatru.WebSocket) {
+	now := a.now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if state, ok := a.connections[ws]; ok {
+		state.pubkey = ""
+		state.lastSeen = now
+	}
+}
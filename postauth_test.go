@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAuthTimesRejectsEventsPredatingAuth(t *testing.T) {
+	ws := &khatru.WebSocket{}
+	withConnection(t, ws)
+	ctx := context.Background()
+
+	now := time.Unix(1_700_000_000, 0)
+	authed := newAuthTimes()
+	authed.now = func() time.Time { return now }
+	authed.onConnect(ctx)
+
+	// an unauthenticated connection is left to the other policies
+	if reject, msg := authed.rejectEvent(ctx, &nostr.Event{CreatedAt: nostr.Timestamp(now.Unix() - 3600)}); reject {
+		t.Fatalf("expected unauthenticated connections to be left alone, got %s", msg)
+	}
+
+	// the REQ that made the client authenticate, then AUTH a minute later
+	now = now.Add(time.Minute)
+	authed.rejectFilter(ctx, nostr.Filter{})
+	reqAt := now
+	now = now.Add(time.Minute)
+	ws.AuthedPublicKey = "alice"
+
+	for _, tc := range []struct {
+		createdAt time.Time
+		reject    bool
+	}{
+		{reqAt.Add(-time.Hour), true},
+		{reqAt.Add(-time.Second), true},
+		{reqAt, false},
+		{now, false},
+		{now.Add(time.Minute), false},
+	} {
+		event := &nostr.Event{CreatedAt: nostr.Timestamp(tc.createdAt.Unix())}
+		reject, msg := authed.rejectEvent(ctx, event)
+		if reject != tc.reject {
+			t.Errorf("created_at %s: expected reject=%v, got %v %q", tc.createdAt, tc.reject, reject, msg)
+		}
+		if reject && msg != "invalid: event predates session auth" {
+			t.Errorf("unexpected message %q", msg)
+		}
+	}
+
+	// authenticating again as someone else starts a new session
+	now = now.Add(time.Hour)
+	ws.AuthedPublicKey = "bob"
+	later := now.Add(time.Minute)
+	now = later
+	if reject, _ := authed.rejectEvent(ctx, &nostr.Event{CreatedAt: nostr.Timestamp(reqAt.Unix())}); !reject {
+		t.Errorf("expected events predating the new session to be rejected")
+	}
+	if reject, msg := authed.rejectEvent(ctx, &nostr.Event{CreatedAt: nostr.Timestamp(later.Unix())}); reject {
+		t.Errorf("expected events after the new session to pass, got %s", msg)
+	}
+
+	// events added by the relay itself have no connection
+	withConnection(t, nil)
+	if reject, msg := authed.rejectEvent(ctx, &nostr.Event{}); reject {
+		t.Errorf("expected internal events to pass, got %s", msg)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// profileTextFields are the NIP-01 and NIP-24 metadata fields that hold free text.
+var profileTextFields = []string{"name", "display_name", "about", "nip05", "lud06", "lud16"}
+
+// profileURLFields are the metadata fields that hold a link.
+var profileURLFields = []string{"picture", "banner", "website"}
+
+// rejectInvalidProfiles rejects profiles (kind 0) whose content is not a JSON object. With
+// checkFields, the known fields must also be well formed: text fields strings, picture, banner
+// and website http(s) URLs, and nip05 and lud16 name@domain identifiers. Unknown fields are
+// left alone, clients add their own.
+func rejectInvalidProfiles(checkFields bool) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if event.Kind != nostr.KindProfileMetadata {
+			return false, ""
+		}
+		var metadata map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(event.Content), &metadata); err != nil || metadata == nil {
+			return true, "invalid: kind 0 content must be valid JSON"
+		}
+		if !checkFields {
+			return false, ""
+		}
+		if err := checkProfileFields(metadata); err != nil {
+			return true, "invalid: kind 0 " + err.Error()
+		}
+		return false, ""
+	}
+}
+
+// checkProfileFields checks the known fields of a profile. Empty values count as unset.
+func checkProfileFields(metadata map[string]json.RawMessage) error {
+	text := func(field string) (string, error) {
+		raw, ok := metadata[field]
+		if !ok || string(raw) == "null" {
+			return "", nil
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return "", fmt.Errorf("field %s must be a string", field)
+		}
+		return value, nil
+	}
+
+	for _, field := range append(profileTextFields, profileURLFields...) {
+		if _, err := text(field); err != nil {
+			return err
+		}
+	}
+	for _, field := range profileURLFields {
+		value, _ := text(field)
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("field %s must be an http(s) URL", field)
+		}
+	}
+	for _, field := range []string{"nip05", "lud16"} {
+		value, _ := text(field)
+		if value == "" {
+			continue
+		}
+		name, domain, ok := strings.Cut(value, "@")
+		if !ok || name == "" || !strings.Contains(domain, ".") || strings.ContainsAny(value, " /") {
+			return fmt.Errorf("field %s must be a name@domain identifier", field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRejectInvalidProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    int
+		content string
+		json    string // expected message with json-only validation, empty if accepted
+		fields  string // expected message with field checks
+	}{
+		{"valid", 0, `{"name":"alice","about":"hi","picture":"https://example.com/a.png","nip05":"alice@example.com","custom":[1,2]}`, "", ""},
+		{"empty and null fields", 0, `{"name":"","picture":null,"website":""}`, "", ""},
+		{"empty object", 0, `{}`, "", ""},
+		{"invalid json", 0, `{"name":"alice"`, "invalid: kind 0 content must be valid JSON", "invalid: kind 0 content must be valid JSON"},
+		{"not an object", 0, `["alice"]`, "invalid: kind 0 content must be valid JSON", "invalid: kind 0 content must be valid JSON"},
+		{"null", 0, `null`, "invalid: kind 0 content must be valid JSON", "invalid: kind 0 content must be valid JSON"},
+		{"empty content", 0, ``, "invalid: kind 0 content must be valid JSON", "invalid: kind 0 content must be valid JSON"},
+		{"bad picture url", 0, `{"picture":"javascript:alert(1)"}`, "", "invalid: kind 0 field picture must be an http(s) URL"},
+		{"relative website", 0, `{"website":"example.com"}`, "", "invalid: kind 0 field website must be an http(s) URL"},
+		{"name not a string", 0, `{"name":42}`, "", "invalid: kind 0 field name must be a string"},
+		{"bad nip05", 0, `{"nip05":"alice"}`, "", "invalid: kind 0 field nip05 must be a name@domain identifier"},
+		{"other kinds", nostr.KindTextNote, `not json`, "", ""},
+	}
+	for _, tt := range tests {
+		event := &nostr.Event{Kind: tt.kind, Content: tt.content}
+		for _, mode := range []struct {
+			fields bool
+			want   string
+		}{{false, tt.json}, {true, tt.fields}} {
+			reject, msg := rejectInvalidProfiles(mode.fields)(context.Background(), event)
+			if reject != (mode.want != "") || msg != mode.want {
+				t.Errorf("%s (fields %t): expected %q, got (%t, %q)", tt.name, mode.fields, mode.want, reject, msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// pubkeyPruner keeps only the newest events of each pubkey. Saving an event marks its author
+// for pruning and a single background worker does the deleting, so writers never wait for it
+// and a burst from one pubkey causes one prune, not one per event.
+type pubkeyPruner struct {
+	prune func(ctx context.Context, pubkey string) error
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	wake    chan struct{}
+}
+
+func newPubkeyPruner(ctx context.Context, prune func(ctx context.Context, pubkey string) error) *pubkeyPruner {
+	p := &pubkeyPruner{
+		prune:   prune,
+		pending: make(map[string]struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+	go p.run(ctx)
+	return p
+}
+
+// onSaved is an OnEventSaved hook. Replaceable and addressable events are left alone: the
+// relay already keeps only their latest version, and they hold state such as profiles.
+func (p *pubkeyPruner) onSaved(ctx context.Context, event *nostr.Event) {
+	if !nostr.IsRegularKind(event.Kind) {
+		return
+	}
+
+	p.mu.Lock()
+	p.pending[event.PubKey] = struct{}{}
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pubkeyPruner) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		}
+
+		p.mu.Lock()
+		pubkeys := p.pending
+		p.pending = make(map[string]struct{})
+		p.mu.Unlock()
+
+		for pubkey := range pubkeys {
+			if err := p.prune(ctx, pubkey); err != nil {
+				log.Printf("Error pruning old events of %s: %v", pubkey, err)
+			}
+		}
+	}
+}
+
+// pruneBeyondLimit deletes the regular events of a pubkey beyond its limit newest ones.
+func pruneBeyondLimit(db *sql.DB, limit int) func(ctx context.Context, pubkey string) error {
+	return func(ctx context.Context, pubkey string) error {
+		query := `
+		DELETE FROM event WHERE id IN (
+			SELECT id FROM event
+			WHERE pubkey = $1 AND kind < 10000 AND kind NOT IN (0, 3)
+			ORDER BY created_at DESC, id
+			OFFSET $2
+		)`
+		if _, err := db.ExecContext(ctx, query, pubkey, limit); err != nil {
+			return fmt.Errorf("failed to delete events beyond the limit: %w", err)
+		}
+		return nil
+	}
+}
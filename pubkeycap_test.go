@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPubkeyPruner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pruned := make(chan string, 10)
+	release := make(chan struct{})
+	pruner := newPubkeyPruner(ctx, func(ctx context.Context, pubkey string) error {
+		pruned <- pubkey
+		<-release
+		return nil
+	})
+
+	pruner.onSaved(ctx, &nostr.Event{PubKey: "alice", Kind: nostr.KindTextNote})
+	if got := <-pruned; got != "alice" {
+		t.Fatalf("expected alice to be pruned, got %s", got)
+	}
+
+	// while the first prune runs, a burst from bob and replaceable events from carol arrive
+	for range 5 {
+		pruner.onSaved(ctx, &nostr.Event{PubKey: "bob", Kind: nostr.KindTextNote})
+	}
+	pruner.onSaved(ctx, &nostr.Event{PubKey: "carol", Kind: nostr.KindProfileMetadata})
+	pruner.onSaved(ctx, &nostr.Event{PubKey: "carol", Kind: 30023})
+	close(release)
+
+	select {
+	case got := <-pruned:
+		if got != "bob" {
+			t.Fatalf("expected bob to be pruned, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bob was never pruned")
+	}
+	select {
+	case got := <-pruned:
+		t.Errorf("expected one prune for the burst and none for replaceable events, got another for %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// eventPurger deletes every stored event of a pubkey and returns how many were deleted.
+type eventPurger func(ctx context.Context, pubkey string) (int64, error)
+
+// purgeStoredEvents deletes events from the event store's table directly, in one statement.
+func purgeStoredEvents(db *sql.DB) eventPurger {
+	return func(ctx context.Context, pubkey string) (int64, error) {
+		result, err := db.ExecContext(ctx, `DELETE FROM event WHERE pubkey = $1`, pubkey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to purge events of %s: %w", pubkey, err)
+		}
+		return result.RowsAffected()
+	}
+}
+
+// deallowPubkey removes pubkey from the allowlist and, when purge is set (PURGE_ON_DEALLOW),
+// then deletes its stored events. Nothing is purged if the pubkey was not allowed.
+func deallowPubkey(ctx context.Context, pubkey string, remove func(pubkey string) error, purge eventPurger) (purged int64, err error) {
+	if err := remove(pubkey); err != nil {
+		return 0, err
+	}
+	if purge == nil {
+		return 0, nil
+	}
+	return purge(ctx, pubkey)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDeallowPubkey(t *testing.T) {
+	ctx := context.Background()
+	var purgedPubkeys []string
+	purge := func(ctx context.Context, pubkey string) (int64, error) {
+		purgedPubkeys = append(purgedPubkeys, pubkey)
+		return 3, nil
+	}
+	removed := func(pubkey string) error { return nil }
+	notAllowed := func(pubkey string) error {
+		return fmt.Errorf("%w: %s is not in the allowed list", ErrPubkeyNotFound, pubkey)
+	}
+
+	if purged, err := deallowPubkey(ctx, "alice", removed, purge); err != nil || purged != 3 {
+		t.Fatalf("expected 3 events purged, got %d, %v", purged, err)
+	}
+	if _, err := deallowPubkey(ctx, "bob", notAllowed, purge); !errors.Is(err, ErrPubkeyNotFound) {
+		t.Fatal("expected the removal error to be returned")
+	}
+	if len(purgedPubkeys) != 1 || purgedPubkeys[0] != "alice" {
+		t.Fatalf("expected only alice to be purged, got %v", purgedPubkeys)
+	}
+
+	// without PURGE_ON_DEALLOW the events stay
+	if purged, err := deallowPubkey(ctx, "carol", removed, nil); err != nil || purged != 0 {
+		t.Fatalf("expected nothing purged, got %d, %v", purged, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// eventEnvelopeOverhead approximates what an event takes beyond its content and tag values:
+// id, pubkey, signature, kind, timestamp and the JSON around them.
+const eventEnvelopeOverhead = 400
+
+// queryBufferEvents caps how many events one query reads ahead, however small they are.
+const queryBufferEvents = 1024
+
+// byteBudget is a per-connection budget of bytes held by read-ahead query results. Queries
+// wait for room before reading the next event from the store, which pauses the store's cursor
+// until the client has drained enough.
+type byteBudget struct {
+	mu    sync.Mutex
+	limit int64
+	held  int64
+	// freed is closed and replaced whenever bytes are released
+	freed chan struct{}
+}
+
+func newByteBudget(limit int64) *byteBudget {
+	return &byteBudget{limit: limit, freed: make(chan struct{})}
+}
+
+// acquire waits until n bytes fit into the budget and takes them. An event larger than the
+// whole budget is let through once nothing else is held, so it cannot wait forever. Returns
+// false if ctx is done first.
+func (b *byteBudget) acquire(ctx context.Context, n int64) bool {
+	for {
+		b.mu.Lock()
+		if b.held == 0 || b.held+n <= b.limit {
+			b.held += n
+			b.mu.Unlock()
+			return true
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release gives n bytes back and wakes the queries waiting for room.
+func (b *byteBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.held -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// used returns the bytes currently held, 0 for a nil budget.
+func (b *byteBudget) used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.held
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// quietHours is a daily window, in a given time zone, during which writes are paused.
+// A window whose end is before its start spans midnight, e.g. 23:00-06:00.
+type quietHours struct {
+	start, end time.Duration // offsets from midnight
+	location   *time.Location
+}
+
+// parseQuietHours parses a "HH:MM-HH:MM" window.
+func parseQuietHours(window string, location *time.Location) (quietHours, error) {
+	startStr, endStr, ok := strings.Cut(window, "-")
+	if !ok {
+		return quietHours{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", window)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(startStr))
+	if err != nil {
+		return quietHours{}, fmt.Errorf("invalid start %q: expected HH:MM", startStr)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(endStr))
+	if err != nil {
+		return quietHours{}, fmt.Errorf("invalid end %q: expected HH:MM", endStr)
+	}
+	if start.Equal(end) {
+		return quietHours{}, fmt.Errorf("start and end of %q are the same", window)
+	}
+
+	sinceMidnight := func(t time.Time) time.Duration {
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return quietHours{start: sinceMidnight(start), end: sinceMidnight(end), location: location}, nil
+}
+
+// contains reports whether t falls in the window, start inclusive and end exclusive.
+func (q quietHours) contains(t time.Time) bool {
+	t = t.In(q.location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if q.start < q.end {
+		return offset >= q.start && offset < q.end
+	}
+	return offset >= q.start || offset < q.end
+}
+
+// rejectDuringQuietHours rejects every event not signed by the owner while the window is
+// active, checked against the server clock for each event.
+func rejectDuringQuietHours(q quietHours, ownerPubKey string, now func() time.Time) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if event.PubKey == ownerPubKey || !q.contains(now()) {
+			return false, ""
+		}
+		return true, "blocked: relay not accepting writes at this time"
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestQuietHours(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	overnight, err := parseQuietHours("23:00-06:00", berlin)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	daytime, _ := parseQuietHours("12:00 - 13:30", time.UTC)
+
+	cases := []struct {
+		window quietHours
+		at     time.Time
+		quiet  bool
+	}{
+		{overnight, time.Date(2026, 1, 10, 23, 0, 0, 0, berlin), true},
+		{overnight, time.Date(2026, 1, 10, 3, 0, 0, 0, berlin), true},
+		{overnight, time.Date(2026, 1, 10, 6, 0, 0, 0, berlin), false},
+		{overnight, time.Date(2026, 1, 10, 12, 0, 0, 0, berlin), false},
+		// 22:30 UTC is 23:30 in Berlin in winter
+		{overnight, time.Date(2026, 1, 10, 22, 30, 0, 0, time.UTC), true},
+		{daytime, time.Date(2026, 1, 10, 13, 29, 59, 0, time.UTC), true},
+		{daytime, time.Date(2026, 1, 10, 13, 30, 0, 0, time.UTC), false},
+		{daytime, time.Date(2026, 1, 10, 11, 59, 0, 0, time.UTC), false},
+	}
+	for _, tc := range cases {
+		if got := tc.window.contains(tc.at); got != tc.quiet {
+			t.Errorf("%v in %+v: expected quiet=%t, got %t", tc.at, tc.window, tc.quiet, got)
+		}
+	}
+
+	for _, invalid := range []string{"", "23:00", "25:00-06:00", "23:00-23:00", "late-early"} {
+		if _, err := parseQuietHours(invalid, time.UTC); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestRejectDuringQuietHours(t *testing.T) {
+	window, _ := parseQuietHours("00:00-01:00", time.UTC)
+	now := time.Date(2026, 1, 10, 0, 30, 0, 0, time.UTC)
+	reject := rejectDuringQuietHours(window, "owner", func() time.Time { return now })
+
+	if rejected, msg := reject(context.Background(), &nostr.Event{PubKey: "member"}); !rejected || msg != "blocked: relay not accepting writes at this time" {
+		t.Fatalf("expected writes to be paused, got %t %q", rejected, msg)
+	}
+	if rejected, _ := reject(context.Background(), &nostr.Event{PubKey: "owner"}); rejected {
+		t.Fatal("expected the owner to keep writing")
+	}
+
+	now = now.Add(time.Hour)
+	if rejected, _ := reject(context.Background(), &nostr.Event{PubKey: "member"}); rejected {
+		t.Fatal("expected writes to resume after the window")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// rateLimit allows a number of events per period, refilled continuously.
+type rateLimit struct {
+	events int
+	per    time.Duration
+}
+
+// parseRateLimit parses limits like "60/min", "10/s" or "1000/hour".
+func parseRateLimit(value string) (rateLimit, error) {
+	count, unit, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return rateLimit{}, fmt.Errorf("expected <count>/<unit>, got %q", value)
+	}
+
+	events, err := strconv.Atoi(count)
+	if err != nil || events <= 0 {
+		return rateLimit{}, fmt.Errorf("invalid event count %q", count)
+	}
+
+	var per time.Duration
+	switch strings.ToLower(unit) {
+	case "s", "sec", "second":
+		per = time.Second
+	case "m", "min", "minute":
+		per = time.Minute
+	case "h", "hour":
+		per = time.Hour
+	default:
+		return rateLimit{}, fmt.Errorf("invalid unit %q, expected s, min or hour", unit)
+	}
+
+	return rateLimit{events: events, per: per}, nil
+}
+
+// tokenBucket holds the remaining budget of one key.
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// tokenBucketLimiter is a token bucket rate limiter keyed by arbitrary strings.
+// Buckets start full and refill at limit.events per limit.per.
+type tokenBucketLimiter struct {
+	mu        sync.Mutex
+	limit     rateLimit
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newTokenBucketLimiter(limit rateLimit) *tokenBucketLimiter {
+	return &tokenBucketLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the key's bucket, reporting false if it is empty.
+func (l *tokenBucketLimiter) allow(key string, now time.Time) bool {
+	ok, _ := l.take(key, now)
+	return ok
+}
+
+// take is allow that also reports, when the bucket is empty, how long until it holds a token.
+func (l *tokenBucketLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(l.limit.events)
+	refill := func(bucket *tokenBucket) {
+		elapsed := now.Sub(bucket.lastSeen)
+		bucket.tokens = min(capacity, bucket.tokens+elapsed.Seconds()*capacity/l.limit.per.Seconds())
+		bucket.lastSeen = now
+	}
+
+	// drop buckets that have refilled completely, they behave exactly like new ones
+	if now.Sub(l.lastSweep) > l.limit.per {
+		for k, bucket := range l.buckets {
+			refill(bucket)
+			if bucket.tokens >= capacity {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+	refill(bucket)
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) * float64(l.limit.per) / capacity)
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// perKindRateLimiter limits how fast each pubkey can publish, with a separate budget per kind.
+// Kinds without an entry in limits use fallback; a zero fallback leaves them unlimited.
+func perKindRateLimiter(limits map[int]rateLimit, fallback rateLimit) func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	limiters := make(map[int]*tokenBucketLimiter, len(limits))
+	for kind, limit := range limits {
+		limiters[kind] = newTokenBucketLimiter(limit)
+	}
+	var fallbackLimiter *tokenBucketLimiter
+	if fallback.events > 0 {
+		fallbackLimiter = newTokenBucketLimiter(fallback)
+	}
+
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		now := time.Now()
+		if limiter, ok := limiters[event.Kind]; ok {
+			if !limiter.allow(event.PubKey, now) {
+				return true, "rate-limited: slow down, please"
+			}
+			return false, ""
+		}
+
+		if fallbackLimiter != nil && !fallbackLimiter.allow(event.PubKey+":"+strconv.Itoa(event.Kind), now) {
+			return true, "rate-limited: slow down, please"
+		}
+		return false, ""
+	}
+}
+
+// loadKindRateLimits reads RATE_LIMIT_KIND_<kind> variables and RATE_LIMIT_DEFAULT.
+// Invalid entries are logged and ignored.
+func loadKindRateLimits() (limits map[int]rateLimit, fallback rateLimit) {
+	limits = make(map[int]rateLimit)
+	for _, entry := range environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		kindStr, ok := strings.CutPrefix(key, "RATE_LIMIT_KIND_")
+		if !ok {
+			continue
+		}
+
+		kind, err := strconv.Atoi(kindStr)
+		if err != nil {
+			log.Printf("Ignoring %s: %q is not a kind number", key, kindStr)
+			continue
+		}
+		limit, err := parseRateLimit(value)
+		if err != nil {
+			log.Printf("Ignoring %s: %v", key, err)
+			continue
+		}
+		limits[kind] = limit
+	}
+
+	if value := getEnv("RATE_LIMIT_DEFAULT", ""); value != "" {
+		limit, err := parseRateLimit(value)
+		if err != nil {
+			log.Printf("Ignoring RATE_LIMIT_DEFAULT: %v", err)
+		} else {
+			fallback = limit
+		}
+	}
+
+	return limits, fallback
+}
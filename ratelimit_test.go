@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    rateLimit
+		wantErr bool
+	}{
+		{"60/min", rateLimit{60, time.Minute}, false},
+		{"10/s", rateLimit{10, time.Second}, false},
+		{"1000/hour", rateLimit{1000, time.Hour}, false},
+		{"60", rateLimit{}, true},
+		{"0/min", rateLimit{}, true},
+		{"5/day", rateLimit{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error state: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %+v, got %+v", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestTokenBucketLimiterRefills(t *testing.T) {
+	limiter := newTokenBucketLimiter(rateLimit{events: 2, per: time.Minute})
+	start := time.Now()
+
+	if !limiter.allow("a", start) || !limiter.allow("a", start) {
+		t.Fatal("expected the first two events to pass")
+	}
+	if limiter.allow("a", start) {
+		t.Fatal("expected the third event to be limited")
+	}
+	if !limiter.allow("b", start) {
+		t.Fatal("other keys must have their own budget")
+	}
+	if !limiter.allow("a", start.Add(30*time.Second)) {
+		t.Fatal("expected one token to be refilled after half the period")
+	}
+}
+
+func TestPerKindRateLimiter(t *testing.T) {
+	policy := perKindRateLimiter(map[int]rateLimit{7: {events: 1, per: time.Hour}}, rateLimit{events: 2, per: time.Hour})
+	event := func(kind int) *nostr.Event { return &nostr.Event{PubKey: "alice", Kind: kind} }
+
+	if reject, _ := policy(context.Background(), event(7)); reject {
+		t.Fatal("first reaction should pass")
+	}
+	if reject, _ := policy(context.Background(), event(7)); !reject {
+		t.Fatal("second reaction should exceed the kind 7 limit")
+	}
+
+	for i := 0; i < 2; i++ {
+		if reject, _ := policy(context.Background(), event(1)); reject {
+			t.Fatalf("note %d should be within the default limit", i+1)
+		}
+	}
+	if reject, _ := policy(context.Background(), event(1)); !reject {
+		t.Fatal("third note should exceed the default limit")
+	}
+	if reject, _ := policy(context.Background(), event(30023)); reject {
+		t.Fatal("each unlisted kind should have its own default budget")
+	}
+}
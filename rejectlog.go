@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// rejectionLogger logs rejected events, sampled at rate (0 logs nothing, 1 logs everything).
+type rejectionLogger struct {
+	rate    float64
+	trusted []*net.IPNet
+	random  func() float64
+	logf    func(format string, args ...any)
+}
+
+func newRejectionLogger(rate float64, trusted []*net.IPNet) *rejectionLogger {
+	return &rejectionLogger{rate: rate, trusted: trusted, random: rand.Float64, logf: log.Printf}
+}
+
+// log writes one key=value line for a rejection. Unless force is set, only a sample is kept;
+// escalations (e.g. a ban following the rejection) should pass force so they are never dropped.
+func (l *rejectionLogger) log(ctx context.Context, event *nostr.Event, reason string, force bool) {
+	if !force && (l.rate <= 0 || (l.rate < 1 && l.random() >= l.rate)) {
+		return
+	}
+
+	ip := ""
+	if conn := khatru.GetConnection(ctx); conn != nil && conn.Request != nil {
+		ip = clientIP(conn.Request, l.trusted).String()
+	}
+	l.logf("event rejected: id=%s kind=%d pubkey=%s ip=%s authed=%s reason=%q",
+		event.ID, event.Kind, event.PubKey, ip, getAuthed(ctx), reason)
+}
+
+// wrap makes every event policy report its rejections to the logger.
+func (l *rejectionLogger) wrap(policies []func(context.Context, *nostr.Event) (bool, string)) []func(context.Context, *nostr.Event) (bool, string) {
+	wrapped := make([]func(context.Context, *nostr.Event) (bool, string), len(policies))
+	for i, policy := range policies {
+		wrapped[i] = func(ctx context.Context, event *nostr.Event) (bool, string) {
+			reject, msg := policy(ctx, event)
+			if reject {
+				l.log(ctx, event, msg, false)
+			}
+			return reject, msg
+		}
+	}
+	return wrapped
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRejectionLoggerSampling(t *testing.T) {
+	var lines []string
+	logger := newRejectionLogger(0.25, nil)
+	logger.logf = func(format string, args ...any) { lines = append(lines, fmt.Sprintf(format, args...)) }
+
+	// deterministic "random" numbers: 0.0, 0.1, ..., 0.9
+	n := 0
+	logger.random = func() float64 {
+		n++
+		return float64((n-1)%10) / 10
+	}
+
+	reject := logger.wrap([]func(context.Context, *nostr.Event) (bool, string){
+		func(ctx context.Context, event *nostr.Event) (bool, string) { return true, "blocked: nope" },
+	})[0]
+
+	for i := 0; i < 10; i++ {
+		if rejected, msg := reject(context.Background(), &nostr.Event{ID: "x", Kind: 1}); !rejected || msg != "blocked: nope" {
+			t.Fatalf("the wrapped policy must keep its result, got %v %q", rejected, msg)
+		}
+	}
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 of 10 rejections logged at rate 0.25, got %d", len(lines))
+	}
+
+	logger.log(context.Background(), &nostr.Event{ID: "y"}, "escalated", true)
+	if len(lines) != 4 {
+		t.Fatalf("expected a forced rejection to always be logged")
+	}
+}
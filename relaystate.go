@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// The operational states reported in the NIP-11 document.
+const (
+	relayStateOpen        = "open"
+	relayStateReadOnly    = "read-only"
+	relayStateMaintenance = "maintenance"
+	relayStateArchive     = "archive"
+)
+
+// relayState derives the operational state of the relay from the same switches that enforce
+// it, so the NIP-11 document cannot claim writes are open while they are being refused.
+type relayState struct {
+	archive     bool
+	maintenance *maintenanceMode
+	// quiet is the enforced quiet hours window, nil if there is none
+	quiet *quietHours
+	now   func() time.Time
+}
+
+// current returns the state and, for maintenance, the reason given for it. Archive mode wins
+// over maintenance, which wins over quiet hours.
+func (s *relayState) current() (state, reason string) {
+	if s.archive {
+		return relayStateArchive, ""
+	}
+	if s.maintenance != nil {
+		if enabled, reason := s.maintenance.get(); enabled {
+			return relayStateMaintenance, reason
+		}
+	}
+	if s.quiet != nil && s.quiet.contains(s.now()) {
+		return relayStateReadOnly, ""
+	}
+	return relayStateOpen, ""
+}
+
+// nip11 adds the state as "relay_state", with "relay_state_reason" when there is one. While
+// writes are paused, "writes_paused" is set in the limitations alongside restricted_writes.
+func (s *relayState) nip11(r *http.Request, doc map[string]any) {
+	state, reason := s.current()
+	doc["relay_state"] = state
+	if reason != "" {
+		doc["relay_state_reason"] = reason
+	}
+	if state != relayStateOpen {
+		limitation := nip11Limitation(doc)
+		limitation["restricted_writes"] = true
+		limitation["writes_paused"] = true
+	}
+}
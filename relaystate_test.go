@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+func TestRelayStateFollowsMaintenance(t *testing.T) {
+	maintenance, err := loadMaintenanceMode(memorySettings{})
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	quiet, _ := parseQuietHours("23:00-06:00", time.UTC)
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	state := &relayState{maintenance: maintenance, quiet: &quiet, now: func() time.Time { return now }}
+
+	relay := khatru.NewRelay()
+	relay.Info.Limitation = &nip11.RelayLimitationDocument{AuthRequired: true}
+	handler := nip11Middleware([]nip11Extension{state.nip11}, relay)
+	fetch := func() (doc map[string]any, etag string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/nostr+json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid NIP-11 document %q: %v", rec.Body.String(), err)
+		}
+		return doc, rec.Header().Get("ETag")
+	}
+
+	doc, openTag := fetch()
+	if doc["relay_state"] != relayStateOpen || doc["limitation"].(map[string]any)["writes_paused"] != nil {
+		t.Fatalf("expected an open relay, got %v", doc)
+	}
+
+	if err := maintenance.set(true, "database upgrade"); err != nil {
+		t.Fatalf("failed to set maintenance: %v", err)
+	}
+	doc, maintenanceTag := fetch()
+	limitation := doc["limitation"].(map[string]any)
+	if doc["relay_state"] != relayStateMaintenance || doc["relay_state_reason"] != "database upgrade" ||
+		limitation["writes_paused"] != true || limitation["restricted_writes"] != true {
+		t.Fatalf("expected maintenance to be advertised, got %v", doc)
+	}
+	if maintenanceTag == openTag {
+		t.Fatalf("expected the ETag to change with the state")
+	}
+
+	// quiet hours only show once maintenance is over
+	now = time.Date(2026, 1, 10, 23, 30, 0, 0, time.UTC)
+	if got, _ := state.current(); got != relayStateMaintenance {
+		t.Fatalf("expected maintenance to win over quiet hours, got %s", got)
+	}
+	maintenance.set(false, "")
+	if doc, _ = fetch(); doc["relay_state"] != relayStateReadOnly || doc["relay_state_reason"] != nil {
+		t.Fatalf("expected read-only during quiet hours, got %v", doc)
+	}
+
+	state.archive = true
+	if got, _ := state.current(); got != relayStateArchive {
+		t.Fatalf("expected archive, got %s", got)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+	"github.com/nbd-wtf/go-nostr/nip17"
+)
+
+const defaultExpiryDMTemplate = "Your access to {relay} expires on {expires_at}. Please contact the relay operator to renew it."
+
+// renderExpiryDM fills the {relay} and {expires_at} placeholders of the reminder template.
+func renderExpiryDM(template, relayName string, expiresAt time.Time) string {
+	return strings.NewReplacer(
+		"{relay}", relayName,
+		"{expires_at}", expiresAt.UTC().Format("2006-01-02 15:04 MST"),
+	).Replace(template)
+}
+
+// expiryDMSender returns a function that sends an allowed user a NIP-17 direct message,
+// signed with the relay's own key, telling them their access is about to expire.
+// The gift wrap is published on this relay through the normal event pipeline.
+func expiryDMSender(relay *khatru.Relay, relaySecretKey, template string) (func(ctx context.Context, entry ExpiringPubkey) error, error) {
+	signer, err := keyer.NewPlainKeySigner(relaySecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load relay secret key: %w", err)
+	}
+
+	return func(ctx context.Context, entry ExpiringPubkey) error {
+		message := renderExpiryDM(template, relay.Info.Name, entry.ExpiresAt)
+		_, toThem, err := nip17.PrepareMessage(ctx, message, nostr.Tags{}, signer, entry.Pubkey, nil)
+		if err != nil {
+			return fmt.Errorf("failed to prepare reminder for %s: %w", entry.Pubkey, err)
+		}
+
+		skipBroadcast, err := relay.AddEvent(ctx, &toThem)
+		if err != nil {
+			return fmt.Errorf("failed to publish reminder for %s: %w", entry.Pubkey, err)
+		}
+		if !skipBroadcast {
+			relay.BroadcastEvent(&toThem)
+		}
+		return nil
+	}, nil
+}
+
+// loadExpiryDMSender sets up expiry reminders from RELAY_SECRET_KEY and EXPIRY_DM_TEMPLATE.
+func loadExpiryDMSender(relay *khatru.Relay) (func(context.Context, ExpiringPubkey) error, error) {
+	secretKey := getEnv("RELAY_SECRET_KEY", "")
+	if secretKey == "" {
+		return nil, fmt.Errorf("RELAY_SECRET_KEY is not set")
+	}
+	sk, err := parseSecretKey(secretKey)
+	if err != nil {
+		return nil, fmt.Errorf("RELAY_SECRET_KEY: %w", err)
+	}
+	return expiryDMSender(relay, sk, getEnv("EXPIRY_DM_TEMPLATE", defaultExpiryDMTemplate))
+}
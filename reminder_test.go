@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+	"github.com/nbd-wtf/go-nostr/nip59"
+)
+
+func TestExpiryDMSender(t *testing.T) {
+	relaySK, relayPK := newKeypair(t)
+	userSK, userPK := newKeypair(t)
+
+	relay := khatru.NewRelay()
+	relay.Info.Name = "brove relay"
+	var stored []*nostr.Event
+	relay.StoreEvent = append(relay.StoreEvent, func(ctx context.Context, event *nostr.Event) error {
+		stored = append(stored, event)
+		return nil
+	})
+
+	send, err := expiryDMSender(relay, relaySK, "{relay} access ends {expires_at}")
+	if err != nil {
+		t.Fatalf("failed to create sender: %v", err)
+	}
+	expiresAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := send(context.Background(), ExpiringPubkey{Pubkey: userPK, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("failed to send reminder: %v", err)
+	}
+
+	if len(stored) != 1 || stored[0].Kind != nostr.KindGiftWrap || giftWrapRecipient(stored[0]) != userPK {
+		t.Fatalf("expected one gift wrap for the user, got %v", stored)
+	}
+
+	userSigner, _ := keyer.NewPlainKeySigner(userSK)
+	rumor, err := nip59.GiftUnwrap(*stored[0], func(otherpubkey, ciphertext string) (string, error) {
+		return userSigner.Decrypt(context.Background(), ciphertext, otherpubkey)
+	})
+	if err != nil {
+		t.Fatalf("failed to unwrap: %v", err)
+	}
+	if rumor.PubKey != relayPK {
+		t.Fatalf("expected the message to come from the relay key, got %s", rumor.PubKey)
+	}
+	if want := "brove relay access ends 2026-03-01 12:00 UTC"; rumor.Content != want {
+		t.Fatalf("expected %q, got %q", want, rumor.Content)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// replaceableVersion is a stored version of a replaceable or addressable event.
+type replaceableVersion struct {
+	id        string
+	createdAt nostr.Timestamp
+}
+
+// olderThan reports whether v is replaced by event: it is older, or as old with a higher id
+// (NIP-01).
+func (v replaceableVersion) olderThan(event *nostr.Event) bool {
+	return v.createdAt < event.CreatedAt || v.createdAt == event.CreatedAt && v.id > event.ID
+}
+
+// replaceTx is what the replace path does inside one transaction.
+type replaceTx interface {
+	// versions locks the address of event until the transaction ends and returns its stored versions
+	versions(ctx context.Context, event *nostr.Event) ([]replaceableVersion, error)
+	delete(ctx context.Context, ids []string) error
+	insert(ctx context.Context, event *nostr.Event) error
+}
+
+// replaceableStore runs fn in a transaction, committing it if fn returns nil.
+type replaceableStore interface {
+	inTx(ctx context.Context, fn func(tx replaceTx) error) error
+}
+
+// replaceLatest stores event in place of the older versions at its address, in one transaction,
+// so a concurrent query sees either the previous latest version or event, never both and never
+// none. Events older than the stored version are dropped, as the event store does.
+func replaceLatest(ctx context.Context, store replaceableStore, event *nostr.Event) error {
+	return store.inTx(ctx, func(tx replaceTx) error {
+		versions, err := tx.versions(ctx, event)
+		if err != nil {
+			return err
+		}
+
+		var older []string
+		for _, version := range versions {
+			if !version.olderThan(event) {
+				return nil
+			}
+			older = append(older, version.id)
+		}
+		if len(older) > 0 {
+			if err := tx.delete(ctx, older); err != nil {
+				return err
+			}
+		}
+		return tx.insert(ctx, event)
+	})
+}
+
+// replaceAttempts is how often a replacement is tried when postgres aborts it to keep the
+// isolation level.
+const replaceAttempts = 3
+
+// storedReplaceables replaces events in the event table. Writers to the same address are
+// serialized with a transaction-scoped advisory lock, which also holds across relay instances
+// sharing the database; the isolation level is REPLACEABLE_ISOLATION.
+type storedReplaceables struct {
+	db        *sql.DB
+	isolation sql.IsolationLevel
+}
+
+func newStoredReplaceables(db *sql.DB, isolation sql.IsolationLevel) *storedReplaceables {
+	return &storedReplaceables{db: db, isolation: isolation}
+}
+
+// loadReplaceableIsolation reads REPLACEABLE_ISOLATION: read-committed (the default) or
+// serializable.
+func loadReplaceableIsolation() sql.IsolationLevel {
+	switch value := getEnv("REPLACEABLE_ISOLATION", "read-committed"); value {
+	case "read-committed":
+		return sql.LevelReadCommitted
+	case "serializable":
+		return sql.LevelSerializable
+	default:
+		log.Printf("Invalid REPLACEABLE_ISOLATION %q, must be read-committed or serializable, using read-committed", value)
+		return sql.LevelReadCommitted
+	}
+}
+
+// replaceEvent is a khatru ReplaceEvent hook.
+func (s *storedReplaceables) replaceEvent(ctx context.Context, event *nostr.Event) error {
+	err := replaceLatest(ctx, s, event)
+	if errors.Is(err, eventstore.ErrDupEvent) {
+		return eventstore.ErrDupEvent
+	}
+	if err != nil {
+		return fmt.Errorf("failed to replace %s: %w", event.ID, err)
+	}
+	return nil
+}
+
+func (s *storedReplaceables) inTx(ctx context.Context, fn func(tx replaceTx) error) error {
+	return retrySerializationFailures(replaceAttempts, func() error {
+		tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: s.isolation})
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		if err := fn(&sqlReplaceTx{tx: tx}); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// retrySerializationFailures calls fn up to attempts times while postgres aborts it with a
+// serialization failure or a deadlock, which only mean it has to be tried again.
+func retrySerializationFailures(attempts int, fn func() error) error {
+	var err error
+	for range attempts {
+		err = fn()
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != "40001" && pqErr.Code != "40P01" {
+			return err
+		}
+	}
+	return err
+}
+
+// sqlReplaceTx is a replaceTx on a postgres transaction.
+type sqlReplaceTx struct {
+	tx *sql.Tx
+}
+
+// replaceableAddress identifies the versions of event: kind, pubkey and, for addressable
+// events, the d tag.
+func replaceableAddress(event *nostr.Event) string {
+	address := strconv.Itoa(event.Kind) + ":" + event.PubKey + ":"
+	if nostr.IsAddressableKind(event.Kind) {
+		address += dTag(event.Tags)
+	}
+	return address
+}
+
+// dTag returns the d tag that tells the versions of an addressable event apart: the value of
+// the first d tag, and, as in NIP-01, an empty one when there is none. Unlike Tags.GetD, a d tag
+// without a value is not skipped for a later one, so an event has one address however its tags
+// are read.
+func dTag(tags nostr.Tags) string {
+	for _, tag := range tags {
+		if len(tag) > 0 && tag[0] == "d" {
+			if len(tag) < 2 {
+				return ""
+			}
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// rejectMalformedDTags rejects addressable events whose d tag other relays and clients could
+// read differently: a d tag without a value, or more than one d tag. With requireD, an
+// addressable event without a d tag is rejected too instead of being addressed by an empty one.
+func rejectMalformedDTags(requireD bool) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		if !nostr.IsAddressableKind(event.Kind) {
+			return false, ""
+		}
+		found := 0
+		for _, tag := range event.Tags {
+			if len(tag) == 0 || tag[0] != "d" {
+				continue
+			}
+			if found++; found > 1 || len(tag) < 2 {
+				return true, "invalid: missing or malformed d tag"
+			}
+		}
+		if found == 0 && requireD {
+			return true, "invalid: missing or malformed d tag"
+		}
+		return false, ""
+	}
+}
+
+func (t *sqlReplaceTx) versions(ctx context.Context, event *nostr.Event) ([]replaceableVersion, error) {
+	if _, err := t.tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, replaceableAddress(event)); err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", replaceableAddress(event), err)
+	}
+
+	query := `SELECT id, created_at, tags FROM event WHERE pubkey = $1 AND kind = $2`
+	args := []any{event.PubKey, event.Kind}
+	d := dTag(event.Tags)
+	if nostr.IsAddressableKind(event.Kind) && d != "" {
+		// narrow down with the tag index, the d tag itself is compared below
+		query += ` AND tagvalues @> ARRAY[$3]`
+		args = append(args, d)
+	}
+	rows, err := t.tx.QueryContext(ctx, query+` FOR UPDATE`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []replaceableVersion
+	for rows.Next() {
+		var version replaceableVersion
+		var encoded []byte
+		if err := rows.Scan(&version.id, &version.createdAt, &encoded); err != nil {
+			return nil, fmt.Errorf("failed to scan stored version: %w", err)
+		}
+		var tags nostr.Tags
+		if err := json.Unmarshal(encoded, &tags); err != nil {
+			return nil, fmt.Errorf("failed to decode tags of %s: %w", version.id, err)
+		}
+		if nostr.IsAddressableKind(event.Kind) && dTag(tags) != d {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (t *sqlReplaceTx) delete(ctx context.Context, ids []string) error {
+	if _, err := t.tx.ExecContext(ctx, `DELETE FROM event WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to delete replaced versions: %w", err)
+	}
+	return nil
+}
+
+func (t *sqlReplaceTx) insert(ctx context.Context, event *nostr.Event) error {
+	return insertEvent(ctx, t.tx.ExecContext, event)
+}
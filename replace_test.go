@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryReplaceables is an in-memory replaceableStore. Transactions run one at a time, like
+// writers holding the advisory lock, and work on a copy that is published atomically on commit.
+type memoryReplaceables struct {
+	txMu sync.Mutex
+
+	mu        sync.RWMutex
+	committed map[string]*nostr.Event
+}
+
+func newMemoryReplaceables() *memoryReplaceables {
+	return &memoryReplaceables{committed: make(map[string]*nostr.Event)}
+}
+
+func (m *memoryReplaceables) inTx(ctx context.Context, fn func(tx replaceTx) error) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	m.mu.RLock()
+	tx := &memoryReplaceTx{events: maps.Clone(m.committed)}
+	m.mu.RUnlock()
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.committed = tx.events
+	m.mu.Unlock()
+	return nil
+}
+
+// query returns the committed versions at the address of event.
+func (m *memoryReplaceables) query(event *nostr.Event) []*nostr.Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var found []*nostr.Event
+	for _, stored := range m.committed {
+		if replaceableAddress(stored) == replaceableAddress(event) {
+			found = append(found, stored)
+		}
+	}
+	return found
+}
+
+type memoryReplaceTx struct {
+	events map[string]*nostr.Event
+}
+
+func (t *memoryReplaceTx) versions(ctx context.Context, event *nostr.Event) ([]replaceableVersion, error) {
+	var versions []replaceableVersion
+	for _, stored := range t.events {
+		if replaceableAddress(stored) == replaceableAddress(event) {
+			versions = append(versions, replaceableVersion{id: stored.ID, createdAt: stored.CreatedAt})
+		}
+	}
+	return versions, nil
+}
+
+func (t *memoryReplaceTx) delete(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		delete(t.events, id)
+	}
+	return nil
+}
+
+func (t *memoryReplaceTx) insert(ctx context.Context, event *nostr.Event) error {
+	t.events[event.ID] = event
+	return nil
+}
+
+func TestReplaceLatestUnderConcurrency(t *testing.T) {
+	ctx := context.Background()
+	_, pubkey := newKeypair(t)
+	store := newMemoryReplaceables()
+	profile := func(i int, createdAt nostr.Timestamp) *nostr.Event {
+		return &nostr.Event{ID: fakeID(i), PubKey: pubkey, Kind: nostr.KindProfileMetadata, CreatedAt: createdAt}
+	}
+	first := profile(0, 1000)
+	if err := replaceLatest(ctx, store, first); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, replacements = 8, 100
+	var written sync.WaitGroup
+	latest := make(chan *nostr.Event, writers*replacements)
+	for w := range writers {
+		written.Add(1)
+		go func() {
+			defer written.Done()
+			for r := range replacements {
+				// out of order on purpose, including ties on created_at
+				event := profile(1+w*replacements+r, nostr.Timestamp(1000+rand.Intn(500)))
+				if err := replaceLatest(ctx, store, event); err != nil {
+					t.Error(err)
+					return
+				}
+				latest <- event
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	var read sync.WaitGroup
+	for range 4 {
+		read.Add(1)
+		go func() {
+			defer read.Done()
+			var seen *nostr.Event
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				found := store.query(first)
+				if len(found) != 1 {
+					t.Errorf("torn read: expected exactly one version, got %d", len(found))
+					return
+				}
+				if seen != nil && (replaceableVersion{id: found[0].ID, createdAt: found[0].CreatedAt}).olderThan(seen) {
+					t.Errorf("torn read: saw %d after the newer %d", found[0].CreatedAt, seen.CreatedAt)
+					return
+				}
+				seen = found[0]
+			}
+		}()
+	}
+
+	written.Wait()
+	close(done)
+	read.Wait()
+	close(latest)
+
+	want := first
+	for event := range latest {
+		if (replaceableVersion{id: want.ID, createdAt: want.CreatedAt}).olderThan(event) {
+			want = event
+		}
+	}
+	if found := store.query(first); len(found) != 1 || found[0].ID != want.ID {
+		t.Fatalf("expected only the newest version %s to be stored, got %v", want.ID, found)
+	}
+}
+
+func TestReplaceLatestAddressable(t *testing.T) {
+	ctx := context.Background()
+	_, pubkey := newKeypair(t)
+	store := newMemoryReplaceables()
+	article := func(id int, d string, createdAt nostr.Timestamp) *nostr.Event {
+		return &nostr.Event{ID: fakeID(id), PubKey: pubkey, Kind: nostr.KindArticle, CreatedAt: createdAt, Tags: nostr.Tags{{"d", d}}}
+	}
+
+	for _, event := range []*nostr.Event{article(1, "a", 10), article(2, "b", 10), article(3, "a", 20), article(4, "a", 5)} {
+		if err := replaceLatest(ctx, store, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if found := store.query(article(0, "a", 0)); len(found) != 1 || found[0].ID != fakeID(3) {
+		t.Errorf("expected the newest version of a, got %v", found)
+	}
+	if found := store.query(article(0, "b", 0)); len(found) != 1 || found[0].ID != fakeID(2) {
+		t.Errorf("expected other addresses to stay, got %v", found)
+	}
+}
+
+func TestReplaceLatestAddressesByDTag(t *testing.T) {
+	ctx := context.Background()
+	_, pubkey := newKeypair(t)
+	store := newMemoryReplaceables()
+	list := func(id int, createdAt nostr.Timestamp, tags ...nostr.Tag) *nostr.Event {
+		return &nostr.Event{ID: fakeID(id), PubKey: pubkey, Kind: nostr.KindCategorizedPeopleList, CreatedAt: createdAt, Tags: tags}
+	}
+
+	// missing, empty and value-less d tags are the same, empty, address; a d tag without a
+	// value is not skipped for a later one
+	for _, event := range []*nostr.Event{
+		list(1, 10),
+		list(2, 20, nostr.Tag{"d", ""}),
+		list(3, 30, nostr.Tag{"d"}, nostr.Tag{"d", "friends"}),
+		list(4, 15, nostr.Tag{"d", "friends"}),
+	} {
+		if err := replaceLatest(ctx, store, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if found := store.query(list(0, 0)); len(found) != 1 || found[0].ID != fakeID(3) {
+		t.Errorf("expected one version of the empty address, got %v", found)
+	}
+	if found := store.query(list(0, 0, nostr.Tag{"d", "friends"})); len(found) != 1 || found[0].ID != fakeID(4) {
+		t.Errorf("expected the friends address to be separate, got %v", found)
+	}
+}
+
+func TestRejectMalformedDTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    int
+		tags    nostr.Tags
+		lenient bool
+		strict  bool
+	}{
+		{"present", 30000, nostr.Tags{{"d", "friends"}, {"p", "x"}}, false, false},
+		{"empty", 30000, nostr.Tags{{"d", ""}}, false, false},
+		{"missing", 30000, nostr.Tags{{"p", "x"}}, false, true},
+		{"without a value", 30000, nostr.Tags{{"d"}}, true, true},
+		{"more than one", 30000, nostr.Tags{{"d", "a"}, {"d", "b"}}, true, true},
+		{"repeated", 30000, nostr.Tags{{"d", "a"}, {"d", "a"}}, true, true},
+		{"not addressable", nostr.KindTextNote, nostr.Tags{{"d"}, {"d", "a"}}, false, false},
+	}
+	for _, tt := range tests {
+		event := &nostr.Event{Kind: tt.kind, Tags: tt.tags}
+		for _, mode := range []struct {
+			strict bool
+			want   bool
+		}{{false, tt.lenient}, {true, tt.strict}} {
+			reject, msg := rejectMalformedDTags(mode.strict)(context.Background(), event)
+			if reject != mode.want || (reject && msg != "invalid: missing or malformed d tag") {
+				t.Errorf("%s (strict %t): expected rejected %t, got (%t, %q)", tt.name, mode.strict, mode.want, reject, msg)
+			}
+		}
+	}
+}
+
+func TestRetrySerializationFailures(t *testing.T) {
+	calls := 0
+	err := retrySerializationFailures(3, func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("failed: %w", &pq.Error{Code: "40001"})
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected serialization failures to be retried, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	other := errors.New("connection refused")
+	if err := retrySerializationFailures(3, func() error { calls++; return other }); err != other || calls != 1 {
+		t.Errorf("expected other errors not to be retried, got %v after %d calls", err, calls)
+	}
+}
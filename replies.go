@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxCheckedReplyTags bounds the lookups a single event can cause.
+const maxCheckedReplyTags = 10
+
+// memberReplies lets non-members reply to members: a text note (kind 1) or comment (kind 1111)
+// that e-tags an event authored by a member is accepted even if its author is not allowed.
+// Event authors never change, so the authors of looked up events are cached.
+type memberReplies struct {
+	store    eventstore.Store
+	isMember func(pubkey string) (bool, error)
+
+	mu      sync.Mutex
+	authors map[string]string
+}
+
+func newMemberReplies(store eventstore.Store, isMember func(pubkey string) (bool, error)) *memberReplies {
+	return &memberReplies{store: store, isMember: isMember, authors: make(map[string]string)}
+}
+
+// accepts reports whether event is a reply to an event by a member.
+func (m *memberReplies) accepts(ctx context.Context, event *nostr.Event) bool {
+	if event.Kind != nostr.KindTextNote && event.Kind != nostr.KindComment {
+		return false
+	}
+
+	checked := 0
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || (tag[0] != "e" && tag[0] != "E") || !nostr.IsValid32ByteHex(tag[1]) {
+			continue
+		}
+		if checked++; checked > maxCheckedReplyTags {
+			return false
+		}
+
+		author, ok := m.author(ctx, tag[1])
+		if !ok {
+			continue
+		}
+		member, err := m.isMember(author)
+		if err != nil {
+			log.Printf("Error checking if pubkey is allowed: %v", err)
+			return false
+		}
+		if member {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memberReplies) author(ctx context.Context, id string) (string, bool) {
+	m.mu.Lock()
+	author, ok := m.authors[id]
+	m.mu.Unlock()
+	if ok {
+		return author, true
+	}
+
+	events, err := m.store.QueryEvents(ctx, nostr.Filter{IDs: []string{id}})
+	if err != nil {
+		log.Printf("Error looking up replied-to event %s: %v", id, err)
+		return "", false
+	}
+	for event := range events {
+		author, ok = event.PubKey, true
+	}
+	if !ok {
+		// not cached: the event may still arrive
+		return "", false
+	}
+
+	m.mu.Lock()
+	if len(m.authors) >= 10000 {
+		clear(m.authors)
+	}
+	m.authors[id] = author
+	m.mu.Unlock()
+	return author, true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fiatjaf/eventstore/slicestore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMemberReplies(t *testing.T) {
+	store := &slicestore.SliceStore{}
+	store.Init()
+	memberSK, memberPK := newKeypair(t)
+	strangerSK, _ := newKeypair(t)
+
+	memberNote := signedEvent(t, memberSK)
+	strangerNote := signedEvent(t, strangerSK)
+	store.SaveEvent(context.Background(), memberNote)
+	store.SaveEvent(context.Background(), strangerNote)
+
+	replies := newMemberReplies(store, func(pubkey string) (bool, error) {
+		return pubkey == memberPK, nil
+	})
+
+	reply := func(kind int, tags ...nostr.Tag) *nostr.Event {
+		return &nostr.Event{Kind: kind, Tags: tags}
+	}
+	missing := fakeID(1)
+
+	tests := []struct {
+		name   string
+		event  *nostr.Event
+		accept bool
+	}{
+		{"reply to member", reply(1, nostr.Tag{"e", memberNote.ID}), true},
+		{"comment on member", reply(nostr.KindComment, nostr.Tag{"E", memberNote.ID}), true},
+		{"mention after unknown event", reply(1, nostr.Tag{"e", missing}, nostr.Tag{"e", memberNote.ID}), true},
+		{"reply to stranger", reply(1, nostr.Tag{"e", strangerNote.ID}), false},
+		{"reaction to member", reply(nostr.KindReaction, nostr.Tag{"e", memberNote.ID}), false},
+		{"no e tags", reply(1, nostr.Tag{"p", memberPK}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if accept := replies.accepts(context.Background(), tt.event); accept != tt.accept {
+				t.Errorf("expected accept=%t, got %t", tt.accept, accept)
+			}
+		})
+	}
+
+	// many e tags to unknown events stop being looked up after maxCheckedReplyTags
+	flood := reply(1)
+	for i := range maxCheckedReplyTags {
+		flood.Tags = append(flood.Tags, nostr.Tag{"e", fakeID(100 + i)})
+	}
+	flood.Tags = append(flood.Tags, nostr.Tag{"e", memberNote.ID})
+	if replies.accepts(context.Background(), flood) {
+		t.Error("expected tags beyond the lookup limit to be ignored")
+	}
+
+	// the author of a known event is cached
+	store.DeleteEvent(context.Background(), memberNote)
+	if !replies.accepts(context.Background(), reply(1, nostr.Tag{"e", memberNote.ID})) {
+		t.Error("expected the cached author to be used")
+	}
+}
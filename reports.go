@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// reportTarget is what a NIP-56 report is about: a pubkey, or one event of that pubkey.
+type reportTarget struct {
+	Pubkey string `json:"pubkey"`
+	Event  string `json:"event,omitempty"`
+}
+
+func (t reportTarget) String() string {
+	if t.Event != "" {
+		return "event " + t.Event + " of " + t.Pubkey
+	}
+	return "pubkey " + t.Pubkey
+}
+
+// report is one target of a kind 1984 report event.
+type report struct {
+	id       string
+	reporter string
+	target   reportTarget
+	kind     string // nudity, malware, profanity, illegal, spam, impersonation, other
+}
+
+// parseReports returns the targets of a NIP-56 report: each reported event (with the pubkey
+// from its "p" tag), or each reported pubkey if no event is named.
+func parseReports(event *nostr.Event) []report {
+	if event.Kind != nostr.KindReporting {
+		return nil
+	}
+	reportType := func(tag nostr.Tag) string {
+		if len(tag) >= 3 && tag[2] != "" {
+			return tag[2]
+		}
+		return "other"
+	}
+
+	pTag := event.Tags.Find("p")
+	if pTag == nil || !nostr.IsValidPublicKey(pTag[1]) {
+		return nil
+	}
+	var reports []report
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "e" && nostr.IsValid32ByteHex(tag[1]) {
+			reports = append(reports, report{id: event.ID, reporter: event.PubKey, target: reportTarget{Pubkey: pTag[1], Event: tag[1]}, kind: reportType(tag)})
+		}
+	}
+	if len(reports) > 0 {
+		return reports
+	}
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "p" && nostr.IsValidPublicKey(tag[1]) {
+			reports = append(reports, report{id: event.ID, reporter: event.PubKey, target: reportTarget{Pubkey: tag[1]}, kind: reportType(tag)})
+		}
+	}
+	return reports
+}
+
+// reportSummary is what the owner sees about one reported target.
+type reportSummary struct {
+	reportTarget
+	Reports        int            `json:"reports"`
+	Reporters      int            `json:"reporters"`
+	Types          map[string]int `json:"types"`
+	LastReportedAt time.Time      `json:"last_reported_at"`
+	Escalated      bool           `json:"escalated"`
+}
+
+// reportStore keeps the collected reports.
+type reportStore interface {
+	// add records a report and returns how many distinct pubkeys have reported its target
+	add(ctx context.Context, r report) (reporters int, err error)
+	// escalated returns the targets reported by at least threshold distinct pubkeys
+	escalated(ctx context.Context, threshold int) ([]reportTarget, error)
+	summaries(ctx context.Context) ([]reportSummary, error)
+	// dismiss forgets the reports of a target once it has been reviewed
+	dismiss(ctx context.Context, target reportTarget) error
+}
+
+// storedReports keeps reports in the reports table.
+type storedReports struct {
+	db *sql.DB
+}
+
+func newStoredReports(db *sql.DB) (*storedReports, error) {
+	query := `
+	CREATE TABLE IF NOT EXISTS reports (
+		report_id TEXT NOT NULL,
+		reporter VARCHAR(64) NOT NULL,
+		target_pubkey VARCHAR(64) NOT NULL,
+		target_event TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL,
+		reported_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (report_id, target_pubkey, target_event)
+	);
+	CREATE INDEX IF NOT EXISTS reports_target ON reports (target_pubkey, target_event);`
+	if _, err := db.Exec(query); err != nil {
+		return nil, fmt.Errorf("failed to create reports table: %w", err)
+	}
+	return &storedReports{db: db}, nil
+}
+
+func (s *storedReports) add(ctx context.Context, r report) (int, error) {
+	query := `
+	INSERT INTO reports (report_id, reporter, target_pubkey, target_event, type) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, query, r.id, r.reporter, r.target.Pubkey, r.target.Event, r.kind); err != nil {
+		return 0, fmt.Errorf("failed to record report %s: %w", r.id, err)
+	}
+
+	var reporters int
+	query = `SELECT COUNT(DISTINCT reporter) FROM reports WHERE target_pubkey = $1 AND target_event = $2`
+	if err := s.db.QueryRowContext(ctx, query, r.target.Pubkey, r.target.Event).Scan(&reporters); err != nil {
+		return 0, fmt.Errorf("failed to count reports of %s: %w", r.target, err)
+	}
+	return reporters, nil
+}
+
+func (s *storedReports) escalated(ctx context.Context, threshold int) ([]reportTarget, error) {
+	query := `
+	SELECT target_pubkey, target_event FROM reports
+	GROUP BY target_pubkey, target_event HAVING COUNT(DISTINCT reporter) >= $1`
+	rows, err := s.db.QueryContext(ctx, query, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load escalated reports: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []reportTarget
+	for rows.Next() {
+		var target reportTarget
+		if err := rows.Scan(&target.Pubkey, &target.Event); err != nil {
+			return nil, fmt.Errorf("failed to scan report target: %w", err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+func (s *storedReports) summaries(ctx context.Context) ([]reportSummary, error) {
+	query := `SELECT target_pubkey, target_event, reporter, type, reported_at FROM reports`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []report
+	var reportedAt []time.Time
+	for rows.Next() {
+		var r report
+		var at time.Time
+		if err := rows.Scan(&r.target.Pubkey, &r.target.Event, &r.reporter, &r.kind, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan report: %w", err)
+		}
+		reports = append(reports, r)
+		reportedAt = append(reportedAt, at)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load reports: %w", err)
+	}
+	return summarizeReports(reports, reportedAt), nil
+}
+
+func (s *storedReports) dismiss(ctx context.Context, target reportTarget) error {
+	query := `DELETE FROM reports WHERE target_pubkey = $1 AND target_event = $2`
+	if _, err := s.db.ExecContext(ctx, query, target.Pubkey, target.Event); err != nil {
+		return fmt.Errorf("failed to dismiss reports of %s: %w", target, err)
+	}
+	return nil
+}
+
+// summarizeReports groups reports by target, most reported first.
+func summarizeReports(reports []report, reportedAt []time.Time) []reportSummary {
+	byTarget := make(map[reportTarget]*reportSummary)
+	reporters := make(map[reportTarget]map[string]bool)
+	for i, r := range reports {
+		summary, ok := byTarget[r.target]
+		if !ok {
+			summary = &reportSummary{reportTarget: r.target, Types: make(map[string]int)}
+			byTarget[r.target] = summary
+			reporters[r.target] = make(map[string]bool)
+		}
+		summary.Reports++
+		summary.Types[r.kind]++
+		reporters[r.target][r.reporter] = true
+		if reportedAt[i].After(summary.LastReportedAt) {
+			summary.LastReportedAt = reportedAt[i]
+		}
+	}
+
+	summaries := make([]reportSummary, 0, len(byTarget))
+	for target, summary := range byTarget {
+		summary.Reporters = len(reporters[target])
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Reporters != summaries[j].Reporters {
+			return summaries[i].Reporters > summaries[j].Reporters
+		}
+		return summaries[i].LastReportedAt.After(summaries[j].LastReportedAt)
+	})
+	return summaries
+}
+
+// reportModeration collects NIP-56 reports and escalates targets reported by at least
+// threshold distinct pubkeys: they are flagged for the owner's review and, if hide is set,
+// hidden from everyone else's queries until the owner dismisses their reports.
+type reportModeration struct {
+	store     reportStore
+	threshold int // 0 never escalates
+	hide      bool
+	owner     string
+
+	mu        sync.RWMutex
+	escalated map[reportTarget]bool
+}
+
+func loadReportModeration(ctx context.Context, store reportStore, threshold int, hide bool, owner string) (*reportModeration, error) {
+	m := &reportModeration{store: store, threshold: threshold, hide: hide, owner: owner, escalated: make(map[reportTarget]bool)}
+	if threshold <= 0 {
+		return m, nil
+	}
+	targets, err := store.escalated(ctx, threshold)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		m.escalated[target] = true
+	}
+	return m, nil
+}
+
+// onSaved records the reports in a saved kind 1984 event.
+func (m *reportModeration) onSaved(ctx context.Context, event *nostr.Event) {
+	for _, r := range parseReports(event) {
+		reporters, err := m.store.add(ctx, r)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if m.threshold <= 0 || reporters < m.threshold {
+			continue
+		}
+
+		m.mu.Lock()
+		already := m.escalated[r.target]
+		m.escalated[r.target] = true
+		m.mu.Unlock()
+		if already {
+			continue
+		}
+		if m.hide {
+			log.Printf("Reports: %s was reported by %d pubkeys, hidden until reviewed", r.target, reporters)
+		} else {
+			log.Printf("Reports: %s was reported by %d pubkeys, flagged for review", r.target, reporters)
+		}
+	}
+}
+
+// hidden reports whether event is hidden by escalated reports about it or its author.
+func (m *reportModeration) hidden(event *nostr.Event) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.escalated[reportTarget{Pubkey: event.PubKey}] || m.escalated[reportTarget{Pubkey: event.PubKey, Event: event.ID}]
+}
+
+// wrapQuery leaves hidden events out of query results, except for the owner.
+func (m *reportModeration) wrapQuery(query func(context.Context, nostr.Filter) (chan *nostr.Event, error)) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events, err := query(ctx, filter)
+		if err != nil || (m.owner != "" && getAuthed(ctx) == m.owner) {
+			return events, err
+		}
+
+		filtered := make(chan *nostr.Event)
+		go func() {
+			defer close(filtered)
+			for event := range events {
+				if m.hidden(event) {
+					continue
+				}
+				select {
+				case filtered <- event:
+				case <-ctx.Done():
+					// keep draining so the underlying query can finish
+				}
+			}
+		}()
+		return filtered, nil
+	}
+}
+
+func (m *reportModeration) dismiss(ctx context.Context, target reportTarget) error {
+	if err := m.store.dismiss(ctx, target); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.escalated, target)
+	m.mu.Unlock()
+	return nil
+}
+
+// handleAdminReports serves the owner's moderation view: GET lists reported targets, most
+// reported first, with ?escalated=true for those past the threshold only, and
+// DELETE ?pubkey=...[&event=...] dismisses the reports of a target once reviewed.
+func handleAdminReports(m *reportModeration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			summaries, err := m.store.summaries(r.Context())
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			onlyEscalated := r.URL.Query().Get("escalated") == "true"
+			items := make([]reportSummary, 0, len(summaries))
+			m.mu.RLock()
+			for _, summary := range summaries {
+				summary.Escalated = m.escalated[summary.reportTarget]
+				if !onlyEscalated || summary.Escalated {
+					items = append(items, summary)
+				}
+			}
+			m.mu.RUnlock()
+			writeJSON(w, http.StatusOK, items)
+		case http.MethodDelete:
+			target := reportTarget{
+				Pubkey: strings.ToLower(r.URL.Query().Get("pubkey")),
+				Event:  strings.ToLower(r.URL.Query().Get("event")),
+			}
+			if err := validatePubkey(target.Pubkey); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if err := m.dismiss(r.Context(), target); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			log.Printf("Reports: dismissed the reports of %s", target)
+			writeJSON(w, http.StatusOK, map[string]string{"dismissed": target.String()})
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryReports is an in-memory reportStore.
+type memoryReports struct {
+	reports []report
+}
+
+func (m *memoryReports) add(ctx context.Context, r report) (int, error) {
+	duplicate := false
+	for _, existing := range m.reports {
+		duplicate = duplicate || existing.id == r.id && existing.target == r.target
+	}
+	if !duplicate {
+		m.reports = append(m.reports, r)
+	}
+	reporters := map[string]bool{}
+	for _, existing := range m.reports {
+		if existing.target == r.target {
+			reporters[existing.reporter] = true
+		}
+	}
+	return len(reporters), nil
+}
+
+func (m *memoryReports) escalated(ctx context.Context, threshold int) ([]reportTarget, error) {
+	var targets []reportTarget
+	for _, summary := range m.summarize() {
+		if summary.Reporters >= threshold {
+			targets = append(targets, summary.reportTarget)
+		}
+	}
+	return targets, nil
+}
+
+func (m *memoryReports) summaries(ctx context.Context) ([]reportSummary, error) {
+	return m.summarize(), nil
+}
+
+func (m *memoryReports) summarize() []reportSummary {
+	return summarizeReports(m.reports, make([]time.Time, len(m.reports)))
+}
+
+func (m *memoryReports) dismiss(ctx context.Context, target reportTarget) error {
+	kept := m.reports[:0]
+	for _, r := range m.reports {
+		if r.target != target {
+			kept = append(kept, r)
+		}
+	}
+	m.reports = kept
+	return nil
+}
+
+func reportEvent(t *testing.T, sk string, tags nostr.Tags) *nostr.Event {
+	t.Helper()
+	event := &nostr.Event{Kind: nostr.KindReporting, CreatedAt: nostr.Now(), Tags: tags}
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return event
+}
+
+func TestParseReports(t *testing.T) {
+	sk, reporter := newKeypair(t)
+	_, target := newKeypair(t)
+	noteID := fakeID(1)
+
+	reports := parseReports(reportEvent(t, sk, nostr.Tags{{"e", noteID, "spam"}, {"p", target}}))
+	if len(reports) != 1 || reports[0].target != (reportTarget{Pubkey: target, Event: noteID}) || reports[0].kind != "spam" || reports[0].reporter != reporter {
+		t.Errorf("expected an event report, got %+v", reports)
+	}
+
+	reports = parseReports(reportEvent(t, sk, nostr.Tags{{"p", target, "impersonation"}}))
+	if len(reports) != 1 || reports[0].target != (reportTarget{Pubkey: target}) || reports[0].kind != "impersonation" {
+		t.Errorf("expected a pubkey report, got %+v", reports)
+	}
+
+	if reports := parseReports(reportEvent(t, sk, nostr.Tags{{"e", noteID, "spam"}})); len(reports) != 0 {
+		t.Errorf("expected a report without p tag to be ignored, got %+v", reports)
+	}
+	if reports := parseReports(&nostr.Event{Kind: nostr.KindTextNote, Tags: nostr.Tags{{"p", target}}}); len(reports) != 0 {
+		t.Errorf("expected other kinds to be ignored, got %+v", reports)
+	}
+}
+
+func TestReportEscalationThreshold(t *testing.T) {
+	ctx := context.Background()
+	_, owner := newKeypair(t)
+	_, spammer := newKeypair(t)
+	target := reportTarget{Pubkey: spammer}
+
+	store := &memoryReports{}
+	moderation, err := loadReportModeration(ctx, store, 3, true, owner)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	spam := &nostr.Event{ID: fakeID(7), PubKey: spammer}
+
+	report := func(sk string) {
+		moderation.onSaved(ctx, reportEvent(t, sk, nostr.Tags{{"p", spammer, "spam"}}))
+	}
+	first, _ := newKeypair(t)
+	second, _ := newKeypair(t)
+	third, _ := newKeypair(t)
+
+	report(first)
+	report(first) // the same reporter again does not count twice
+	report(second)
+	if moderation.hidden(spam) {
+		t.Fatalf("expected two reporters to stay below the threshold of three")
+	}
+
+	report(third)
+	if !moderation.hidden(spam) {
+		t.Fatalf("expected the third reporter to escalate %s", target)
+	}
+
+	// the escalation survives a restart
+	restarted, err := loadReportModeration(ctx, store, 3, true, owner)
+	if err != nil || !restarted.hidden(spam) {
+		t.Fatalf("expected the escalation to be loaded again, got %v", err)
+	}
+
+	// hidden from everyone but the owner
+	query := moderation.wrapQuery(func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		ch := make(chan *nostr.Event, 2)
+		ch <- spam
+		ch <- &nostr.Event{ID: fakeID(8), PubKey: owner}
+		close(ch)
+		return ch, nil
+	})
+	count := func() int {
+		events, _ := query(ctx, nostr.Filter{})
+		n := 0
+		for range events {
+			n++
+		}
+		return n
+	}
+	if n := count(); n != 1 {
+		t.Errorf("expected the hidden event to be left out, got %d events", n)
+	}
+	withAuthed(t, owner)
+	if n := count(); n != 2 {
+		t.Errorf("expected the owner to see everything, got %d events", n)
+	}
+
+	// the owner reviews and dismisses the reports
+	rec := httptest.NewRecorder()
+	handleAdminReports(moderation)(rec, httptest.NewRequest(http.MethodGet, "/admin/reports?escalated=true", nil))
+	var summaries []reportSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summaries); err != nil || len(summaries) != 1 || summaries[0].Reporters != 3 || summaries[0].Types["spam"] != 3 || !summaries[0].Escalated {
+		t.Fatalf("expected one escalated target in the summary, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleAdminReports(moderation)(rec, httptest.NewRequest(http.MethodDelete, "/admin/reports?pubkey="+spammer, strings.NewReader("")))
+	if rec.Code != http.StatusOK || moderation.hidden(spam) {
+		t.Fatalf("expected dismissing to unhide the target, got %d", rec.Code)
+	}
+}
+
+func TestReportThresholdZeroNeverEscalates(t *testing.T) {
+	ctx := context.Background()
+	_, spammer := newKeypair(t)
+	moderation, _ := loadReportModeration(ctx, &memoryReports{}, 0, true, "")
+	for i := 0; i < 5; i++ {
+		sk, _ := newKeypair(t)
+		moderation.onSaved(ctx, reportEvent(t, sk, nostr.Tags{{"p", spammer}}))
+	}
+	if moderation.hidden(&nostr.Event{PubKey: spammer}) {
+		t.Errorf("expected a zero threshold to only collect reports")
+	}
+}
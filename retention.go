@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// retentionPolicy says how long events are kept: per kind, and optionally for all other kinds.
+// A zero fallback keeps unlisted kinds forever.
+//
+// With an engagement extension, an event is also kept while other pubkeys keep referencing it:
+// until extension after the newest stored event of someone else that references it in a tag,
+// such as a reaction, reply or repost, so engaged content outlives ignored content.
+type retentionPolicy struct {
+	kinds     map[int]time.Duration
+	fallback  time.Duration
+	extension time.Duration
+}
+
+func (p retentionPolicy) enabled() bool {
+	return len(p.kinds) > 0 || p.fallback > 0
+}
+
+// loadRetentionPolicy reads RETENTION_KIND_<kind>=<duration> and RETENTION_DEFAULT=<duration>.
+func loadRetentionPolicy() retentionPolicy {
+	policy := retentionPolicy{kinds: make(map[int]time.Duration)}
+	for _, entry := range environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		kindStr, ok := strings.CutPrefix(key, "RETENTION_KIND_")
+		if !ok {
+			continue
+		}
+
+		kind, err := strconv.Atoi(kindStr)
+		if err != nil {
+			log.Printf("Ignoring %s: %q is not a kind number", key, kindStr)
+			continue
+		}
+		if maxAge := getEnvDuration(key, 0); maxAge > 0 {
+			policy.kinds[kind] = maxAge
+		} else {
+			log.Printf("Ignoring %s: retention must be a positive duration", key)
+		}
+	}
+
+	policy.fallback = getEnvDuration("RETENTION_DEFAULT", 0)
+	if policy.fallback < 0 {
+		log.Printf("Ignoring RETENTION_DEFAULT: retention must be a positive duration")
+		policy.fallback = 0
+	}
+
+	policy.extension = getEnvDuration("RETENTION_ENGAGEMENT_EXTENSION", 0)
+	if policy.extension < 0 {
+		log.Printf("Ignoring RETENTION_ENGAGEMENT_EXTENSION: the extension must be a positive duration")
+		policy.extension = 0
+	}
+
+	return policy
+}
+
+// nip11 describes the policy as a NIP-11 retention array. Kinds sharing a duration are grouped,
+// and the catch-all entry without kinds comes last.
+func (p retentionPolicy) nip11() []*nip11.RelayRetentionDocument {
+	byDuration := make(map[time.Duration][]int)
+	for kind, maxAge := range p.kinds {
+		byDuration[maxAge] = append(byDuration[maxAge], kind)
+	}
+
+	durations := make([]time.Duration, 0, len(byDuration))
+	for maxAge := range byDuration {
+		durations = append(durations, maxAge)
+	}
+	slices.Sort(durations)
+
+	var retention []*nip11.RelayRetentionDocument
+	for _, maxAge := range durations {
+		kinds := byDuration[maxAge]
+		slices.Sort(kinds)
+
+		doc := &nip11.RelayRetentionDocument{Time: int64(maxAge.Seconds())}
+		for _, kind := range kinds {
+			// single kinds are written as [kind, kind] ranges, the only form the nip11 type can hold
+			doc.Kinds = append(doc.Kinds, []int{kind, kind})
+		}
+		retention = append(retention, doc)
+	}
+
+	if p.fallback > 0 {
+		retention = append(retention, &nip11.RelayRetentionDocument{Time: int64(p.fallback.Seconds())})
+	}
+
+	return retention
+}
+
+// startRetentionJob deletes events older than the policy allows, once right away and then every interval.
+func startRetentionJob(ctx context.Context, db *sql.DB, policy retentionPolicy, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			pruneExpiredEvents(ctx, db, policy, time.Now())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// retentionStatement is one DELETE the retention job runs.
+type retentionStatement struct {
+	what  string
+	query string
+	args  []any
+}
+
+// keepPinned is the condition every DELETE of the retention job carries, so pinned events
+// are kept whatever their age and kind.
+const keepPinned = ` AND NOT EXISTS (SELECT 1 FROM pinned_events p WHERE p.event_id = event.id)`
+
+// statements returns the DELETEs that enforce the policy at now.
+func (p retentionPolicy) statements(now time.Time) []retentionStatement {
+	// events referenced since the extension cutoff by a newer event of someone else are kept;
+	// the tagvalues array of the event store is indexed, which keeps the lookup cheap
+	engaged := ""
+	if p.extension > 0 {
+		engaged = ` AND NOT EXISTS (
+			SELECT 1 FROM event r WHERE r.tagvalues @> ARRAY[event.id]
+			AND r.created_at >= $3 AND r.created_at > event.created_at AND r.pubkey <> event.pubkey)`
+	}
+	withCutoff := func(args ...any) []any {
+		if p.extension > 0 {
+			args = append(args, now.Add(-p.extension).Unix())
+		}
+		return args
+	}
+
+	var statements []retentionStatement
+	listed := make([]int64, 0, len(p.kinds))
+	for kind, maxAge := range p.kinds {
+		listed = append(listed, int64(kind))
+		statements = append(statements, retentionStatement{
+			what:  "kind " + strconv.Itoa(kind),
+			query: `DELETE FROM event WHERE kind = $1 AND created_at < $2` + keepPinned + engaged,
+			args:  withCutoff(kind, now.Add(-maxAge).Unix()),
+		})
+	}
+
+	if p.fallback > 0 {
+		statements = append(statements, retentionStatement{
+			what:  "unlisted kinds",
+			query: `DELETE FROM event WHERE NOT (kind = ANY($1)) AND created_at < $2` + keepPinned + engaged,
+			args:  withCutoff(pq.Array(listed), now.Add(-p.fallback).Unix()),
+		})
+	}
+	return statements
+}
+
+// execer runs a statement; *sql.DB implements it.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func pruneExpiredEvents(ctx context.Context, db execer, policy retentionPolicy, now time.Time) {
+	for _, statement := range policy.statements(now) {
+		result, err := db.ExecContext(ctx, statement.query, statement.args...)
+		logPruned(result, err, statement.what)
+	}
+}
+
+func logPruned(result sql.Result, err error, what string) {
+	if err != nil {
+		log.Printf("Error pruning expired events for %s: %v", what, err)
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		log.Printf("Retention: deleted %d expired events for %s", deleted, what)
+	}
+}
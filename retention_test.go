@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyNIP11(t *testing.T) {
+	policy := retentionPolicy{
+		kinds: map[int]time.Duration{
+			1:     30 * 24 * time.Hour,
+			7:     7 * 24 * time.Hour,
+			30023: 30 * 24 * time.Hour,
+		},
+		fallback: 365 * 24 * time.Hour,
+	}
+
+	encoded, err := json.Marshal(policy.nip11())
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	want := `[{"time":604800,"kinds":[[7,7]]},{"time":2592000,"kinds":[[1,1],[30023,30023]]},{"time":31536000}]`
+	if string(encoded) != want {
+		t.Fatalf("expected %s, got %s", want, encoded)
+	}
+}
+
+func TestRetentionEngagementExtension(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	policy := retentionPolicy{kinds: map[int]time.Duration{1: time.Hour}, fallback: 24 * time.Hour}
+
+	for _, statement := range policy.statements(now) {
+		if strings.Contains(statement.query, "r.tagvalues") || len(statement.args) != 2 {
+			t.Errorf("%s: expected no engagement check without an extension, got %q %v", statement.what, statement.query, statement.args)
+		}
+	}
+
+	policy.extension = 7 * 24 * time.Hour
+	statements := policy.statements(now)
+	if len(statements) != 2 {
+		t.Fatalf("expected a statement per kind and one for unlisted kinds, got %d", len(statements))
+	}
+	for _, statement := range statements {
+		if !strings.Contains(statement.query, "r.tagvalues @> ARRAY[event.id]") || !strings.Contains(statement.query, "r.pubkey <> event.pubkey") {
+			t.Errorf("%s: expected events referenced by others to be kept, got %q", statement.what, statement.query)
+		}
+		if len(statement.args) != 3 || statement.args[2] != now.Add(-policy.extension).Unix() {
+			t.Errorf("%s: expected the extension cutoff as third argument, got %v", statement.what, statement.args)
+		}
+	}
+	if statements[0].args[1] != now.Add(-time.Hour).Unix() || statements[1].args[1] != now.Add(-24*time.Hour).Unix() {
+		t.Errorf("expected the retention cutoffs to stay the same, got %v and %v", statements[0].args, statements[1].args)
+	}
+}
+
+func TestLoadRetentionEngagementExtension(t *testing.T) {
+	t.Setenv("RETENTION_ENGAGEMENT_EXTENSION", "72h")
+	if policy := loadRetentionPolicy(); policy.extension != 72*time.Hour {
+		t.Errorf("expected a 72h extension, got %v", policy.extension)
+	}
+	t.Setenv("RETENTION_ENGAGEMENT_EXTENSION", "-1h")
+	if policy := loadRetentionPolicy(); policy.extension != 0 {
+		t.Errorf("expected negative extensions to be ignored, got %v", policy.extension)
+	}
+}
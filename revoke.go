@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// authedConnection is what the revoker knows about one authenticated connection: the pubkey
+// it authenticated as and the Done channels of the subscriptions it opened, by id.
+type authedConnection struct {
+	pubkey        string
+	subscriptions map[string]<-chan struct{}
+}
+
+// accessRevoker tracks connections by their authenticated pubkey, so that removing a pubkey
+// from the allowlist also cuts off the connections it already has open. Otherwise those keep
+// receiving events on their live subscriptions until they reconnect.
+//
+// khatru has no hook for a successful AUTH, so a connection is registered the first time it
+// uses its authentication, i.e. on its first REQ, COUNT or EVENT after AUTH.
+type accessRevoker struct {
+	close func(ws *khatru.WebSocket, subscriptions []string)
+
+	mu    sync.Mutex
+	conns map[*khatru.WebSocket]*authedConnection
+}
+
+func newAccessRevoker() *accessRevoker {
+	return &accessRevoker{
+		close: closeRevoked,
+		conns: make(map[*khatru.WebSocket]*authedConnection),
+	}
+}
+
+// claim registers the connection behind ctx under its authenticated pubkey and returns its
+// state, or nil for unauthenticated and internal calls. The caller must hold r.mu.
+func (r *accessRevoker) claim(ctx context.Context) *authedConnection {
+	ws := getConnection(ctx)
+	if ws == nil || ws.AuthedPublicKey == "" {
+		return nil
+	}
+	conn, ok := r.conns[ws]
+	if !ok || conn.pubkey != ws.AuthedPublicKey {
+		// new, or authenticated again as someone else
+		conn = &authedConnection{pubkey: ws.AuthedPublicKey, subscriptions: make(map[string]<-chan struct{})}
+		r.conns[ws] = conn
+	}
+	return conn
+}
+
+// overwriteFilter is an OverwriteFilter hook rather than a RejectFilter one because khatru
+// runs it first for every filter, including the limit:0 ones that skip the reject hooks.
+func (r *accessRevoker) overwriteFilter(ctx context.Context, filter *nostr.Filter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conn := r.claim(ctx)
+	if conn == nil || eventstore.IsNegentropySession(ctx) {
+		return
+	}
+	if id := khatru.GetSubscriptionID(ctx); id != "" {
+		conn.subscriptions[id] = ctx.Done()
+	}
+}
+
+// rejectEvent only registers connections that publish without ever subscribing.
+func (r *accessRevoker) rejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	r.mu.Lock()
+	r.claim(ctx)
+	r.mu.Unlock()
+	return false, ""
+}
+
+func (r *accessRevoker) onDisconnect(ctx context.Context) {
+	ws := getConnection(ctx)
+	if ws == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, ws)
+}
+
+// revoke closes every connection authenticated as pubkey and returns how many were closed.
+func (r *accessRevoker) revoke(pubkey string) int {
+	type revoked struct {
+		ws            *khatru.WebSocket
+		subscriptions []string
+	}
+	var targets []revoked
+
+	r.mu.Lock()
+	for ws, conn := range r.conns {
+		if conn.pubkey != pubkey || ws.AuthedPublicKey != pubkey {
+			continue
+		}
+		target := revoked{ws: ws}
+		for id, done := range conn.subscriptions {
+			select {
+			case <-done:
+				// closed by the client already
+			default:
+				target.subscriptions = append(target.subscriptions, id)
+			}
+		}
+		targets = append(targets, target)
+		delete(r.conns, ws)
+	}
+	r.mu.Unlock()
+
+	for _, target := range targets {
+		r.close(target.ws, target.subscriptions)
+	}
+	if len(targets) > 0 {
+		log.Printf("Closed %d connections of %s after its access was revoked", len(targets), pubkey)
+	}
+	return len(targets)
+}
+
+// closeRevoked ends each open subscription of ws with a CLOSED "restricted: access revoked",
+// then closes the connection itself.
+func closeRevoked(ws *khatru.WebSocket, subscriptions []string) {
+	for _, id := range subscriptions {
+		ws.WriteJSON(nostr.ClosedEnvelope{SubscriptionID: id, Reason: "restricted: access revoked"})
+	}
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "access revoked"))
+	closeWebSocket(ws)
+}
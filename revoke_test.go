@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRevokeClosesActiveSessions(t *testing.T) {
+	relay := khatru.NewRelay()
+	revoker := newAccessRevoker()
+	relay.OverwriteFilter = append(relay.OverwriteFilter, revoker.overwriteFilter)
+	relay.RejectEvent = append(relay.RejectEvent, revoker.rejectEvent)
+	relay.RejectFilter = append(relay.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if khatru.GetAuthed(ctx) == "" {
+			return true, "auth-required: please authenticate"
+		}
+		return false, ""
+	})
+	relay.OnDisconnect = append(relay.OnDisconnect, revoker.onDisconnect)
+
+	bannedSK, banned := newKeypair(t)
+	otherSK, _ := newKeypair(t)
+
+	session := dialGatedRelay(t, relay)
+	authenticate(t, session, bannedSK)
+	sendReq(t, session, "old", `{"kinds":[7]}`)
+	if err := session.WriteMessage(websocket.TextMessage, []byte(`["CLOSE","old"]`)); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if reply := sendReq(t, session, "feed", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected the subscription to be open, got %v", reply)
+	}
+	bystander := dialGatedRelay(t, relay)
+	authenticate(t, bystander, otherSK)
+	if reply := sendReq(t, bystander, "feed", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected the subscription to be open, got %v", reply)
+	}
+
+	if n := revoker.revoke(banned); n != 1 {
+		t.Fatalf("expected one connection to be closed, got %d", n)
+	}
+
+	if message := readEnvelope(t, session); message[0] != "CLOSED" || message[1] != "feed" || message[2] != "restricted: access revoked" {
+		t.Fatalf("expected only the open subscription to be closed, got %v", message)
+	}
+	session.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := session.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("expected the banned session to be closed, got %v", err)
+	}
+
+	// other pubkeys keep their sessions
+	if reply := sendReq(t, bystander, "again", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected the other session to keep working, got %v", reply)
+	}
+	if n := revoker.revoke(banned); n != 0 {
+		t.Errorf("expected nothing left to close, got %d", n)
+	}
+}
+
+func TestRevokeFollowsReauthentication(t *testing.T) {
+	ws := &khatru.WebSocket{AuthedPublicKey: "alice"}
+	withConnection(t, ws)
+	revoker := newAccessRevoker()
+	var closed []*khatru.WebSocket
+	revoker.close = func(ws *khatru.WebSocket, subscriptions []string) { closed = append(closed, ws) }
+
+	revoker.rejectEvent(context.Background(), &nostr.Event{})
+	ws.AuthedPublicKey = "bob"
+	revoker.rejectEvent(context.Background(), &nostr.Event{})
+
+	if n := revoker.revoke("alice"); n != 0 {
+		t.Errorf("expected alice to have no connections left, got %d", n)
+	}
+	if n := revoker.revoke("bob"); n != 1 || len(closed) != 1 || closed[0] != ws {
+		t.Errorf("expected bob's connection to be closed, got %d", n)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+)
+
+// defaultRobotsTxt keeps crawlers off every HTTP path of the relay.
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// loadRobotsTxt returns the contents of ROBOTS_TXT_FILE, or defaultRobotsTxt if it is not
+// set or cannot be read.
+func loadRobotsTxt() string {
+	path := getEnv("ROBOTS_TXT_FILE", "")
+	if path == "" {
+		return defaultRobotsTxt
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read ROBOTS_TXT_FILE, disallowing all crawlers: %v", err)
+		return defaultRobotsTxt
+	}
+	return string(content)
+}
+
+// handleRobots serves GET /robots.txt.
+func handleRobots(content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(content))
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRobotsTxt(t *testing.T) {
+	t.Setenv("ROBOTS_TXT_FILE", "")
+	rec := httptest.NewRecorder()
+	handleRobots(loadRobotsTxt())(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "User-agent: *\nDisallow: /\n" {
+		t.Fatalf("expected crawlers to be disallowed by default, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "robots.txt")
+	os.WriteFile(path, []byte("User-agent: *\nAllow: /\n"), 0o644)
+	t.Setenv("ROBOTS_TXT_FILE", path)
+	if content := loadRobotsTxt(); content != "User-agent: *\nAllow: /\n" {
+		t.Fatalf("expected the configured file, got %q", content)
+	}
+
+	t.Setenv("ROBOTS_TXT_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	if content := loadRobotsTxt(); content != defaultRobotsTxt {
+		t.Fatalf("expected the default for a missing file, got %q", content)
+	}
+
+	rec = httptest.NewRecorder()
+	handleRobots(defaultRobotsTxt)(rec, httptest.NewRequest(http.MethodPost, "/robots.txt", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
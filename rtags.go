@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// referenceRule matches r tag values. A rule with a scheme ("https://example.com/feed")
+// matches that URL only; a bare domain ("example.com") matches every URL on that domain and
+// its subdomains.
+type referenceRule struct {
+	url    string
+	domain string
+}
+
+func parseReferenceRules(entries []string) []referenceRule {
+	rules := make([]referenceRule, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "://") {
+			rules = append(rules, referenceRule{url: normalizeReference(entry)})
+		} else {
+			rules = append(rules, referenceRule{domain: strings.Trim(strings.ToLower(entry), ".")})
+		}
+	}
+	return rules
+}
+
+// normalizeReference lowercases the scheme and host of a URL and drops a trailing slash.
+func normalizeReference(value string) string {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	if err != nil || parsed.Host == "" {
+		return strings.TrimSpace(value)
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	return strings.TrimSuffix(parsed.String(), "/")
+}
+
+// referenceHost returns the lowercase host name of an r tag value, which may lack a scheme.
+func referenceHost(value string) string {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "://") {
+		value = "https://" + value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+func (r referenceRule) matches(value string) bool {
+	if r.url != "" {
+		return normalizeReference(value) == r.url
+	}
+	host := referenceHost(value)
+	return host != "" && (host == r.domain || strings.HasSuffix(host, "."+r.domain))
+}
+
+func matchesAnyReference(rules []referenceRule, value string) bool {
+	for _, rule := range rules {
+		if rule.matches(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// referencePolicy rejects events by their r tags: any r tag matching blocked rejects the
+// event, and when allowed is set, events need at least one r tag and all of them must match.
+// NIP-65 relay lists are exempt, their r tags name the author's relays.
+type referencePolicy struct {
+	allowed []referenceRule
+	blocked []referenceRule
+}
+
+func (p referencePolicy) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if event.Kind == nostr.KindRelayListMetadata {
+		return false, ""
+	}
+
+	references := 0
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "r" {
+			continue
+		}
+		references++
+		if matchesAnyReference(p.blocked, tag[1]) {
+			return true, "blocked: references to " + tag[1] + " are not accepted here"
+		}
+		if len(p.allowed) > 0 && !matchesAnyReference(p.allowed, tag[1]) {
+			return true, "blocked: this relay only accepts references to its sources, not " + tag[1]
+		}
+	}
+	if len(p.allowed) > 0 && references == 0 {
+		return true, "blocked: this relay only accepts events referencing its sources in an r tag"
+	}
+	return false, ""
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReferenceRuleMatching(t *testing.T) {
+	domain := parseReferenceRules([]string{"Example.com"})[0]
+	exact := parseReferenceRules([]string{"https://news.example.org/feed/"})[0]
+
+	tests := []struct {
+		rule  referenceRule
+		value string
+		want  bool
+	}{
+		{domain, "https://example.com/post/1", true},
+		{domain, "https://blog.example.com/post/1", true},
+		{domain, "http://EXAMPLE.com:8080", true},
+		{domain, "example.com/no-scheme", true},
+		{domain, "wss://relay.example.com", true},
+		{domain, "https://notexample.com", false},
+		{domain, "https://example.com.evil.net", false},
+		{domain, "not a url", false},
+		{exact, "https://news.example.org/feed", true},
+		{exact, "HTTPS://News.Example.org/feed/", true},
+		{exact, "https://news.example.org/feed/other", false},
+		{exact, "https://news.example.org", false},
+	}
+	for _, test := range tests {
+		if got := test.rule.matches(test.value); got != test.want {
+			t.Errorf("%+v matching %q: expected %v, got %v", test.rule, test.value, test.want, got)
+		}
+	}
+}
+
+func TestReferencePolicy(t *testing.T) {
+	withRefs := func(kind int, refs ...string) *nostr.Event {
+		event := &nostr.Event{Kind: kind, Tags: nostr.Tags{{"t", "news"}}}
+		for _, ref := range refs {
+			event.Tags = append(event.Tags, nostr.Tag{"r", ref})
+		}
+		return event
+	}
+	blocking := referencePolicy{blocked: parseReferenceRules([]string{"spam.example"})}
+	allowing := referencePolicy{allowed: parseReferenceRules([]string{"source.example", "https://other.example/feed"})}
+
+	tests := []struct {
+		name   string
+		policy referencePolicy
+		event  *nostr.Event
+		msg    string
+	}{
+		{"no references", blocking, withRefs(1), ""},
+		{"harmless reference", blocking, withRefs(1, "https://fine.example"), ""},
+		{"blocked subdomain", blocking, withRefs(1, "https://fine.example", "https://cdn.spam.example/x"), "blocked: references to https://cdn.spam.example/x are not accepted here"},
+		{"allowed source", allowing, withRefs(1, "https://www.source.example/a", "https://other.example/feed"), ""},
+		{"one unknown source", allowing, withRefs(1, "https://source.example/a", "https://elsewhere.example"), "blocked: this relay only accepts references to its sources, not https://elsewhere.example"},
+		{"no source at all", allowing, withRefs(1), "blocked: this relay only accepts events referencing its sources in an r tag"},
+		{"relay lists are exempt", allowing, withRefs(nostr.KindRelayListMetadata, "wss://relay.damus.io"), ""},
+	}
+	for _, test := range tests {
+		reject, msg := test.policy.reject(context.Background(), test.event)
+		if reject != (test.msg != "") || msg != test.msg {
+			t.Errorf("%s: expected %q, got %v %q", test.name, test.msg, reject, msg)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// pendingEvents holds events scheduled for later publication.
+type pendingEvents interface {
+	add(ctx context.Context, event *nostr.Event, publishAt time.Time) error
+	// due returns the events whose publication time is not after now, earliest first
+	due(ctx context.Context, now time.Time) ([]*nostr.Event, error)
+	remove(ctx context.Context, id string) error
+	all(ctx context.Context) ([]*nostr.Event, error)
+}
+
+// storedPendingEvents keeps scheduled events in the scheduled_events table, apart from the
+// event store, so nothing can query them before their time.
+type storedPendingEvents struct {
+	db *sql.DB
+}
+
+func newStoredPendingEvents(db *sql.DB) (*storedPendingEvents, error) {
+	query := `
+	CREATE TABLE IF NOT EXISTS scheduled_events (
+		id TEXT PRIMARY KEY,
+		pubkey TEXT NOT NULL,
+		publish_at TIMESTAMPTZ NOT NULL,
+		event JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS scheduled_events_publish_at ON scheduled_events (publish_at);`
+	if _, err := db.Exec(query); err != nil {
+		return nil, fmt.Errorf("failed to create scheduled_events table: %w", err)
+	}
+	return &storedPendingEvents{db: db}, nil
+}
+
+func (s *storedPendingEvents) add(ctx context.Context, event *nostr.Event, publishAt time.Time) error {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode scheduled event %s: %w", event.ID, err)
+	}
+	query := `
+	INSERT INTO scheduled_events (id, pubkey, publish_at, event) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (id) DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, query, event.ID, event.PubKey, publishAt.UTC(), raw); err != nil {
+		return fmt.Errorf("failed to schedule event %s: %w", event.ID, err)
+	}
+	return nil
+}
+
+func (s *storedPendingEvents) due(ctx context.Context, now time.Time) ([]*nostr.Event, error) {
+	return s.query(ctx, `SELECT event FROM scheduled_events WHERE publish_at <= $1 ORDER BY publish_at`, now.UTC())
+}
+
+func (s *storedPendingEvents) remove(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM scheduled_events WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to remove scheduled event %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *storedPendingEvents) all(ctx context.Context) ([]*nostr.Event, error) {
+	return s.query(ctx, `SELECT event FROM scheduled_events ORDER BY publish_at`)
+}
+
+func (s *storedPendingEvents) query(ctx context.Context, query string, args ...any) ([]*nostr.Event, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*nostr.Event
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled event row: %w", err)
+		}
+		event := &nostr.Event{}
+		if err := json.Unmarshal(raw, event); err != nil {
+			return nil, fmt.Errorf("failed to decode scheduled event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred while iterating over scheduled event rows: %w", err)
+	}
+	return events, nil
+}
+
+// scheduler implements scheduled posts: an event with a ["publish_at", "<unix timestamp>"] tag
+// in the future is acknowledged but held back in pendingEvents, and published (stored and
+// broadcast) once its time has come. Until then only the owner can query it.
+type scheduler struct {
+	relay    *khatru.Relay
+	pending  pendingEvents
+	maxDelay time.Duration
+	now      func() time.Time
+}
+
+func newScheduler(relay *khatru.Relay, pending pendingEvents, maxDelay time.Duration) *scheduler {
+	return &scheduler{relay: relay, pending: pending, maxDelay: maxDelay, now: time.Now}
+}
+
+// publishAt reads the publish_at tag of event. ok is false without a tag.
+func publishAt(event *nostr.Event) (at time.Time, ok bool, err error) {
+	tag := event.Tags.Find("publish_at")
+	if tag == nil {
+		return time.Time{}, false, nil
+	}
+	seconds, err := strconv.ParseInt(tag[1], 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}, true, fmt.Errorf("invalid: publish_at must be a unix timestamp")
+	}
+	return time.Unix(seconds, 0), true, nil
+}
+
+// rejectEvent checks the publish_at tag of events before they are accepted.
+func (s *scheduler) rejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	at, ok, err := publishAt(event)
+	if err != nil {
+		return true, err.Error()
+	}
+	if !ok || !at.After(s.now()) {
+		return false, ""
+	}
+	if event.Kind == nostr.KindDeletion {
+		// deletions are carried out before the event is stored, so they cannot wait
+		return true, "invalid: deletions cannot be scheduled"
+	}
+	if at.Sub(s.now()) > s.maxDelay {
+		return true, fmt.Sprintf("invalid: publish_at can be at most %s in the future", s.maxDelay)
+	}
+	return false, ""
+}
+
+// storeEvent holds back events scheduled for the future. It must be the first StoreEvent and
+// ReplaceEvent hook: answering with ErrDupEvent makes khatru acknowledge the event without
+// storing or broadcasting it.
+func (s *scheduler) storeEvent(ctx context.Context, event *nostr.Event) error {
+	at, ok, err := publishAt(event)
+	if !ok || err != nil || !at.After(s.now()) {
+		return nil
+	}
+	if err := s.pending.add(ctx, event, at); err != nil {
+		return err
+	}
+	return eventstore.ErrDupEvent
+}
+
+// publish stores a due event through the relay's store hooks and broadcasts it, as if it had
+// just been published. The policies are not run again, they were applied when it was submitted.
+func (s *scheduler) publish(ctx context.Context, event *nostr.Event) error {
+	hooks := s.relay.StoreEvent
+	if !nostr.IsRegularKind(event.Kind) {
+		hooks = s.relay.ReplaceEvent
+	}
+	if len(hooks) == 0 {
+		return errors.New("the relay does not store events")
+	}
+	for _, store := range hooks {
+		if err := store(ctx, event); errors.Is(err, eventstore.ErrDupEvent) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	for _, onSaved := range s.relay.OnEventSaved {
+		onSaved(ctx, event)
+	}
+	s.relay.BroadcastEvent(event)
+	return nil
+}
+
+// publishDue publishes every event whose time has come. Events that fail stay pending and
+// are tried again on the next run.
+func (s *scheduler) publishDue(ctx context.Context) {
+	due, err := s.pending.due(ctx, s.now())
+	if err != nil {
+		log.Printf("Error loading scheduled events: %v", err)
+		return
+	}
+	for _, event := range due {
+		if err := s.publish(ctx, event); err != nil {
+			log.Printf("Error publishing scheduled event %s: %v", event.ID, err)
+			continue
+		}
+		if err := s.pending.remove(ctx, event.ID); err != nil {
+			log.Printf("Error removing published scheduled event: %v", err)
+		}
+	}
+}
+
+// start publishes due events every interval until ctx is cancelled.
+func (s *scheduler) start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.publishDue(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// wrapQuery adds the pending events matching a filter to the results of the owner's queries,
+// ahead of the stored ones, so the owner can review what is scheduled.
+func (s *scheduler) wrapQuery(ownerPubKey string, query func(context.Context, nostr.Filter) (chan *nostr.Event, error)) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events, err := query(ctx, filter)
+		if err != nil || ownerPubKey == "" || getAuthed(ctx) != ownerPubKey {
+			return events, err
+		}
+		pending, err := s.pending.all(ctx)
+		if err != nil {
+			log.Printf("Error loading scheduled events for the owner: %v", err)
+			return events, nil
+		}
+
+		merged := make(chan *nostr.Event)
+		go func() {
+			defer close(merged)
+			for _, event := range pending {
+				if !filter.Matches(event) {
+					continue
+				}
+				select {
+				case merged <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			for event := range events {
+				select {
+				case merged <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return merged, nil
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryPendingEvents is an in-memory pendingEvents.
+type memoryPendingEvents struct {
+	events    []*nostr.Event
+	publishAt map[string]time.Time
+}
+
+func (m *memoryPendingEvents) add(ctx context.Context, event *nostr.Event, publishAt time.Time) error {
+	if m.publishAt == nil {
+		m.publishAt = make(map[string]time.Time)
+	}
+	m.events = append(m.events, event)
+	m.publishAt[event.ID] = publishAt
+	return nil
+}
+
+func (m *memoryPendingEvents) due(ctx context.Context, now time.Time) ([]*nostr.Event, error) {
+	var due []*nostr.Event
+	for _, event := range m.events {
+		if !m.publishAt[event.ID].After(now) {
+			due = append(due, event)
+		}
+	}
+	return due, nil
+}
+
+func (m *memoryPendingEvents) remove(ctx context.Context, id string) error {
+	m.events = slices.DeleteFunc(m.events, func(event *nostr.Event) bool { return event.ID == id })
+	return nil
+}
+
+func (m *memoryPendingEvents) all(ctx context.Context) ([]*nostr.Event, error) {
+	return m.events, nil
+}
+
+func scheduledEvent(t *testing.T, sk string, publishAt time.Time) *nostr.Event {
+	t.Helper()
+	event := &nostr.Event{
+		Kind:      nostr.KindTextNote,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"publish_at", strconv.FormatInt(publishAt.Unix(), 10)}},
+		Content:   "good morning",
+	}
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return event
+}
+
+func TestScheduledEventIsRevealedOnTime(t *testing.T) {
+	now := time.Now()
+	pending := &memoryPendingEvents{}
+	relay := khatru.NewRelay()
+	scheduled := newScheduler(relay, pending, 24*time.Hour)
+	scheduled.now = func() time.Time { return now }
+
+	var stored []*nostr.Event
+	relay.RejectEvent = append(relay.RejectEvent, scheduled.rejectEvent)
+	relay.StoreEvent = append(relay.StoreEvent, scheduled.storeEvent, func(ctx context.Context, event *nostr.Event) error {
+		stored = append(stored, event)
+		return nil
+	})
+
+	subscriber := dialRelay(t, relay)
+	if reply := sendReq(t, subscriber, "live", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected EOSE, got %v", reply)
+	}
+
+	sk, _ := newKeypair(t)
+	publishAt := now.Add(time.Hour).Truncate(time.Second)
+	event := scheduledEvent(t, sk, publishAt)
+	publisher := dialRelay(t, relay)
+	if err := publisher.WriteJSON(nostr.EventEnvelope{Event: *event}); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if ok := readEnvelope(t, publisher); ok[0] != "OK" || ok[2] != true {
+		t.Fatalf("expected the scheduled event to be accepted, got %v", ok)
+	}
+	if len(stored) != 0 || len(pending.events) != 1 {
+		t.Fatalf("expected the event to be held back, got %d stored and %d pending", len(stored), len(pending.events))
+	}
+
+	// a second before its time nothing happens
+	now = publishAt.Add(-time.Second)
+	scheduled.publishDue(context.Background())
+	if len(stored) != 0 || len(pending.events) != 1 {
+		t.Fatalf("expected the event to still be pending, got %d stored and %d pending", len(stored), len(pending.events))
+	}
+
+	// at its time it is stored and broadcast like a new event
+	now = publishAt
+	scheduled.publishDue(context.Background())
+	if len(stored) != 1 || stored[0].ID != event.ID || len(pending.events) != 0 {
+		t.Fatalf("expected the event to be published, got %d stored and %d pending", len(stored), len(pending.events))
+	}
+	if message := readEnvelope(t, subscriber); message[0] != "EVENT" || message[1] != "live" {
+		t.Fatalf("expected the event to be broadcast, got %v", message)
+	}
+}
+
+func TestScheduledEventPolicy(t *testing.T) {
+	now := time.Now()
+	scheduled := newScheduler(khatru.NewRelay(), &memoryPendingEvents{}, 24*time.Hour)
+	scheduled.now = func() time.Time { return now }
+	at := func(offset time.Duration) string { return strconv.FormatInt(now.Add(offset).Unix(), 10) }
+
+	tests := []struct {
+		name  string
+		event *nostr.Event
+		msg   string
+	}{
+		{"no tag", &nostr.Event{Kind: 1}, ""},
+		{"in an hour", &nostr.Event{Kind: 1, Tags: nostr.Tags{{"publish_at", at(time.Hour)}}}, ""},
+		{"in the past", &nostr.Event{Kind: 1, Tags: nostr.Tags{{"publish_at", at(-time.Hour)}}}, ""},
+		{"not a number", &nostr.Event{Kind: 1, Tags: nostr.Tags{{"publish_at", "tomorrow"}}}, "invalid: publish_at must be a unix timestamp"},
+		{"too far ahead", &nostr.Event{Kind: 1, Tags: nostr.Tags{{"publish_at", at(48 * time.Hour)}}}, "invalid: publish_at can be at most 24h0m0s in the future"},
+		{"deletion", &nostr.Event{Kind: nostr.KindDeletion, Tags: nostr.Tags{{"publish_at", at(time.Hour)}}}, "invalid: deletions cannot be scheduled"},
+	}
+	for _, test := range tests {
+		reject, msg := scheduled.rejectEvent(context.Background(), test.event)
+		if reject != (test.msg != "") || msg != test.msg {
+			t.Errorf("%s: expected %q, got %v %q", test.name, test.msg, reject, msg)
+		}
+	}
+}
+
+func TestScheduledEventsOnlyVisibleToOwner(t *testing.T) {
+	ownerSK, ownerPK := newKeypair(t)
+	pendingEvent := scheduledEvent(t, ownerSK, time.Now().Add(time.Hour))
+	storedEvent := signedEvent(t, ownerSK)
+	scheduled := newScheduler(khatru.NewRelay(), &memoryPendingEvents{events: []*nostr.Event{pendingEvent}}, 24*time.Hour)
+	query := scheduled.wrapQuery(ownerPK, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		events := make(chan *nostr.Event, 1)
+		events <- storedEvent
+		close(events)
+		return events, nil
+	})
+
+	collect := func(filter nostr.Filter) []string {
+		events, err := query(context.Background(), filter)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		var ids []string
+		for event := range events {
+			ids = append(ids, event.ID)
+		}
+		return ids
+	}
+
+	withAuthed(t, "")
+	if ids := collect(nostr.Filter{Kinds: []int{1}}); !slices.Equal(ids, []string{storedEvent.ID}) {
+		t.Fatalf("expected only the stored event, got %v", ids)
+	}
+	withAuthed(t, ownerPK)
+	if ids := collect(nostr.Filter{Kinds: []int{1}}); !slices.Equal(ids, []string{pendingEvent.ID, storedEvent.ID}) {
+		t.Fatalf("expected the owner to see the pending event too, got %v", ids)
+	}
+	if ids := collect(nostr.Filter{Kinds: []int{7}}); !slices.Equal(ids, []string{storedEvent.ID}) {
+		t.Fatalf("expected pending events not matching the filter to be left out, got %v", ids)
+	}
+}
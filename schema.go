@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// errUnknownEventFields is returned for events carrying top-level fields outside NIP-01.
+var errUnknownEventFields = errors.New("invalid: unknown event fields")
+
+// strictEvent has exactly the NIP-01 event fields.
+type strictEvent struct {
+	ID        json.RawMessage `json:"id"`
+	PubKey    json.RawMessage `json:"pubkey"`
+	CreatedAt json.RawMessage `json:"created_at"`
+	Kind      json.RawMessage `json:"kind"`
+	Tags      json.RawMessage `json:"tags"`
+	Content   json.RawMessage `json:"content"`
+	Sig       json.RawMessage `json:"sig"`
+}
+
+// strictEventSchema answers EVENT messages whose event has top-level fields outside the NIP-01
+// set with a failed OK. It is a messageGate check: khatru only hands RejectEvent policies the
+// decoded nostr.Event, which has already dropped unknown fields. Messages it can't make sense
+// of are left to khatru.
+func strictEventSchema(message []byte) any {
+	raw := eventMessage(message)
+	if raw == nil || checkEventSchema(raw) != errUnknownEventFields {
+		return nil
+	}
+	var event struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(raw, &event)
+	return nostr.OKEnvelope{EventID: event.ID, OK: false, Reason: errUnknownEventFields.Error()}
+}
+
+// checkEventSchema rejects raw event JSON with top-level fields outside the NIP-01 set.
+func checkEventSchema(raw []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	var event strictEvent
+	if err := decoder.Decode(&event); err != nil {
+		// encoding/json has no typed error for this case
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			return errUnknownEventFields
+		}
+		return errors.New("invalid: malformed event json")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCheckEventSchema(t *testing.T) {
+	sk, _ := newKeypair(t)
+	event := signedEvent(t, sk)
+	valid, _ := json.Marshal(event)
+
+	var withExtra map[string]any
+	json.Unmarshal(valid, &withExtra)
+	withExtra["client"] = "some-app"
+	extra, _ := json.Marshal(withExtra)
+
+	if err := checkEventSchema(valid); err != nil {
+		t.Fatalf("expected a NIP-01 event to pass, got %v", err)
+	}
+	if err := checkEventSchema(extra); err != errUnknownEventFields {
+		t.Fatalf("expected %v for an extra field, got %v", errUnknownEventFields, err)
+	}
+	if err := checkEventSchema([]byte(`{"id":`)); err == nil || err == errUnknownEventFields {
+		t.Fatalf("expected a malformed json error, got %v", err)
+	}
+}
+
+func TestStrictEventSchemaRejectsExtraFields(t *testing.T) {
+	relay := khatru.NewRelay()
+	gate := &messageGate{maxMessage: relay.MaxMessageSize, checks: []messageCheck{unparsableMessage, strictEventSchema}}
+	relay.OnConnect = append(relay.OnConnect, gate.onConnect)
+	var seen []string
+	relay.RejectEvent = append(relay.RejectEvent, func(ctx context.Context, event *nostr.Event) (bool, string) {
+		seen = append(seen, event.ID)
+		return true, "blocked: test relay"
+	})
+	server := httptest.NewServer(gate.middleware(relay))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sk, _ := newKeypair(t)
+	event := signedEvent(t, sk)
+	raw, _ := json.Marshal(event)
+	var withExtra map[string]any
+	json.Unmarshal(raw, &withExtra)
+	withExtra["client"] = "some-app"
+	extra, _ := json.Marshal([]any{"EVENT", withExtra})
+
+	conn.WriteMessage(websocket.TextMessage, extra)
+	if reply := readEnvelope(t, conn); reply[0] != "OK" || reply[1] != event.ID || reply[2] != false || reply[3] != "invalid: unknown event fields" {
+		t.Fatalf("expected the event with an extra field to be refused, got %v", reply)
+	}
+
+	conn.WriteJSON(nostr.EventEnvelope{Event: *event})
+	if reply := readEnvelope(t, conn); reply[0] != "OK" || reply[3] != "blocked: test relay" {
+		t.Fatalf("expected the NIP-01 event to reach the policies, got %v", reply)
+	}
+	if len(seen) != 1 {
+		t.Errorf("expected only the NIP-01 event to be parsed, got %d events", len(seen))
+	}
+}
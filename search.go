@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// searchRules are the normalizations applied to the search copy of event content and to
+// search terms, named in SEARCH_NORMALIZE. Stored events are never changed.
+type searchRules struct {
+	whitespace     bool // collapse runs of whitespace
+	trackingParams bool // drop utm_* and click-id parameters from URLs
+	stripURLs      bool // leave URLs out of the search copy entirely
+}
+
+var defaultSearchRules = []string{"whitespace", "tracking_params"}
+
+// parseSearchRules reads rule names and returns the ones it does not know.
+func parseSearchRules(names []string) (rules searchRules, unknown []string) {
+	for _, name := range names {
+		switch name {
+		case "whitespace":
+			rules.whitespace = true
+		case "tracking_params":
+			rules.trackingParams = true
+		case "strip_urls":
+			rules.stripURLs = true
+		default:
+			unknown = append(unknown, name)
+		}
+	}
+	return rules, unknown
+}
+
+var urlPattern = regexp.MustCompile(`https?://\S+`)
+
+// trackingParams are query parameters that only identify where a link was shared.
+var trackingParams = []string{"fbclid", "gclid", "dclid", "msclkid", "yclid", "twclid", "igshid", "mc_cid", "mc_eid", "si"}
+
+func (r searchRules) normalize(content string) string {
+	if r.stripURLs {
+		content = urlPattern.ReplaceAllString(content, " ")
+	} else if r.trackingParams {
+		content = urlPattern.ReplaceAllStringFunc(content, stripTrackingParams)
+	}
+	if r.whitespace {
+		content = strings.Join(strings.Fields(content), " ")
+	}
+	return content
+}
+
+func stripTrackingParams(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.RawQuery == "" {
+		return link
+	}
+	query := parsed.Query()
+	for name := range query {
+		if strings.HasPrefix(name, "utm_") || slices.Contains(trackingParams, name) {
+			query.Del(name)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// searchIndex serves NIP-50 search from a normalized copy of event content in the
+// search_content table, indexed as a tsvector. The store's own search is a plain LIKE on the
+// raw content, so searches there are sensitive to formatting.
+type searchIndex struct {
+	db    *sql.DB
+	rules searchRules
+	kinds []int
+
+	// lookup returns the ids of stored events matching terms and the rest of filter, newest first
+	lookup func(ctx context.Context, filter nostr.Filter, terms string) ([]string, error)
+}
+
+func newSearchIndex(db *sql.DB, rules searchRules, kinds []int) (*searchIndex, error) {
+	index := &searchIndex{db: db, rules: rules, kinds: kinds}
+	index.lookup = index.storedMatches
+
+	query := `
+	CREATE TABLE IF NOT EXISTS search_content (
+		id TEXT PRIMARY KEY,
+		created_at INTEGER NOT NULL,
+		document TSVECTOR NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS search_content_document ON search_content USING gin (document);
+	CREATE INDEX IF NOT EXISTS search_content_created_at ON search_content (created_at DESC);`
+	if _, err := db.Exec(query); err != nil {
+		return nil, fmt.Errorf("failed to create search_content table: %w", err)
+	}
+	return index, nil
+}
+
+func (s *searchIndex) add(ctx context.Context, event *nostr.Event) error {
+	query := `
+	INSERT INTO search_content (id, created_at, document) VALUES ($1, $2, to_tsvector('simple', $3))
+	ON CONFLICT (id) DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, query, event.ID, int64(event.CreatedAt), s.rules.normalize(event.Content)); err != nil {
+		return fmt.Errorf("failed to index event %s for search: %w", event.ID, err)
+	}
+	return nil
+}
+
+// onSaved indexes newly stored events of the searchable kinds.
+func (s *searchIndex) onSaved(ctx context.Context, event *nostr.Event) {
+	if !slices.Contains(s.kinds, event.Kind) {
+		return
+	}
+	if err := s.add(ctx, event); err != nil {
+		log.Print(err)
+	}
+}
+
+// onDeleted removes deleted events from the index. Events removed by other means, such as
+// replacements or retention, are skipped at query time and cleaned up by backfill.
+func (s *searchIndex) onDeleted(ctx context.Context, event *nostr.Event) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM search_content WHERE id = $1`, event.ID); err != nil {
+		return fmt.Errorf("failed to remove event %s from the search index: %w", event.ID, err)
+	}
+	return nil
+}
+
+// backfill indexes stored events that predate the index, then drops entries of events that
+// no longer exist. It runs until done or until ctx is cancelled.
+func (s *searchIndex) backfill(ctx context.Context) {
+	query := `
+	SELECT id, created_at, kind, content FROM event e
+	WHERE kind = ANY($1) AND NOT EXISTS (SELECT 1 FROM search_content s WHERE s.id = e.id)
+	LIMIT 500`
+	indexed := 0
+	for ctx.Err() == nil {
+		rows, err := s.db.QueryContext(ctx, query, pq.Array(s.kinds))
+		if err != nil {
+			log.Printf("Search backfill stopped: %v", err)
+			return
+		}
+		var batch []nostr.Event
+		for rows.Next() {
+			var event nostr.Event
+			if err := rows.Scan(&event.ID, &event.CreatedAt, &event.Kind, &event.Content); err != nil {
+				rows.Close()
+				log.Printf("Search backfill stopped: %v", err)
+				return
+			}
+			batch = append(batch, event)
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			break
+		}
+		for i := range batch {
+			if err := s.add(ctx, &batch[i]); err != nil {
+				log.Printf("Search backfill stopped: %v", err)
+				return
+			}
+		}
+		indexed += len(batch)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if indexed > 0 {
+		log.Printf("Indexed %d stored events for search", indexed)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM search_content s WHERE NOT EXISTS (SELECT 1 FROM event e WHERE e.id = s.id)`); err != nil {
+		log.Printf("Failed to clean up the search index: %v", err)
+	}
+}
+
+// storedMatches searches the index, applying the filter's kinds, authors, since and until to
+// the joined events so the limit counts matching events only.
+func (s *searchIndex) storedMatches(ctx context.Context, filter nostr.Filter, terms string) ([]string, error) {
+	params := []any{terms}
+	param := func(value any) string {
+		params = append(params, value)
+		return "$" + strconv.Itoa(len(params))
+	}
+	conditions := []string{"s.document @@ plainto_tsquery('simple', $1)"}
+	if len(filter.Kinds) > 0 {
+		conditions = append(conditions, "e.kind = ANY("+param(pq.Array(filter.Kinds))+")")
+	}
+	if len(filter.Authors) > 0 {
+		conditions = append(conditions, "e.pubkey = ANY("+param(pq.Array(filter.Authors))+")")
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "e.created_at >= "+param(int64(*filter.Since)))
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "e.created_at <= "+param(int64(*filter.Until)))
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	query := `SELECT s.id FROM search_content s JOIN event e ON e.id = s.id WHERE ` +
+		strings.Join(conditions, " AND ") +
+		` ORDER BY s.created_at DESC LIMIT ` + param(limit)
+
+	rows, err := s.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// wrapQuery answers filters with a search term from the index: the matching ids replace the
+// search term, and the store applies the rest of the filter as usual.
+func (s *searchIndex) wrapQuery(query func(context.Context, nostr.Filter) (chan *nostr.Event, error)) func(context.Context, nostr.Filter) (chan *nostr.Event, error) {
+	return func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		if filter.Search == "" {
+			return query(ctx, filter)
+		}
+
+		ids, err := s.lookup(ctx, filter, s.rules.normalize(filter.Search))
+		if err != nil {
+			return nil, err
+		}
+		if len(filter.IDs) > 0 {
+			ids = slices.DeleteFunc(ids, func(id string) bool { return !slices.Contains(filter.IDs, id) })
+		}
+		if len(ids) == 0 {
+			empty := make(chan *nostr.Event)
+			close(empty)
+			return empty, nil
+		}
+
+		filter.Search = ""
+		filter.IDs = ids
+		return query(ctx, filter)
+	}
+}
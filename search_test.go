@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSearchNormalization(t *testing.T) {
+	content := "Read   this:\n\thttps://example.com/post?id=7&utm_source=nostr&fbclid=abc  now"
+
+	rules, unknown := parseSearchRules([]string{"whitespace", "tracking_params", "stemming"})
+	if !slices.Equal(unknown, []string{"stemming"}) {
+		t.Fatalf("expected stemming to be reported as unknown, got %v", unknown)
+	}
+	if got := rules.normalize(content); got != "Read this: https://example.com/post?id=7 now" {
+		t.Fatalf("unexpected normalization %q", got)
+	}
+
+	stripped, _ := parseSearchRules([]string{"whitespace", "strip_urls"})
+	if got := stripped.normalize(content); got != "Read this: now" {
+		t.Fatalf("unexpected normalization %q", got)
+	}
+
+	if got := (searchRules{}).normalize(content); got != content {
+		t.Fatalf("expected no rules to leave content alone, got %q", got)
+	}
+}
+
+func TestSearchIndexQuery(t *testing.T) {
+	var searched string
+	index := &searchIndex{rules: searchRules{whitespace: true}}
+	index.lookup = func(ctx context.Context, filter nostr.Filter, terms string) ([]string, error) {
+		searched = terms
+		return []string{fakeID(1), fakeID(2)}, nil
+	}
+
+	var queried []nostr.Filter
+	query := index.wrapQuery(func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+		queried = append(queried, filter)
+		events := make(chan *nostr.Event)
+		close(events)
+		return events, nil
+	})
+
+	query(context.Background(), nostr.Filter{Kinds: []int{1}, Search: "  hello\n world "})
+	if searched != "hello world" {
+		t.Fatalf("expected the search terms to be normalized like the content, got %q", searched)
+	}
+	if len(queried) != 1 || queried[0].Search != "" || !slices.Equal(queried[0].IDs, []string{fakeID(1), fakeID(2)}) || queried[0].Kinds[0] != 1 {
+		t.Fatalf("expected the store to be queried for the matching ids, got %+v", queried)
+	}
+
+	// ids in the filter narrow the matches, and no match means no store query
+	query(context.Background(), nostr.Filter{IDs: []string{fakeID(2), fakeID(3)}, Search: "hello"})
+	if len(queried) != 2 || !slices.Equal(queried[1].IDs, []string{fakeID(2)}) {
+		t.Fatalf("expected only the requested matching id, got %+v", queried)
+	}
+	query(context.Background(), nostr.Filter{IDs: []string{fakeID(3)}, Search: "hello"})
+	if len(queried) != 2 {
+		t.Fatalf("expected no store query without matches, got %+v", queried)
+	}
+
+	// filters without a search term go straight to the store
+	query(context.Background(), nostr.Filter{Kinds: []int{7}})
+	if len(queried) != 3 || queried[2].IDs != nil {
+		t.Fatalf("expected a plain query, got %+v", queried)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// selftestKind is the kind of the throwaway event used by the self-test.
+// It is an ordinary regular kind so it takes the same storage path as real notes.
+const selftestKind = nostr.KindTextNote
+
+// selftestResult is what /admin/selftest reports.
+type selftestResult struct {
+	OK        bool    `json:"ok"`
+	Error     string  `json:"error,omitempty"`
+	EventID   string  `json:"event_id"`
+	StoreMs   float64 `json:"store_ms"`
+	QueryMs   float64 `json:"query_ms"`
+	CleanupMs float64 `json:"cleanup_ms"`
+	TotalMs   float64 `json:"total_ms"`
+}
+
+// runSelftest stores a freshly signed test event through the relay's store hooks, queries it
+// back by id and deletes it again. Policies are skipped on purpose: the test key is random and
+// would never pass the allowlist, and what we want to know is whether storage works.
+func runSelftest(ctx context.Context, relay *khatru.Relay) selftestResult {
+	start := time.Now()
+	var result selftestResult
+
+	event := nostr.Event{
+		Kind:      selftestKind,
+		CreatedAt: nostr.Now(),
+		Content:   "brove self-test, this event is deleted right away",
+		Tags:      nostr.Tags{},
+	}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		result.Error = fmt.Sprintf("failed to sign test event: %v", err)
+		return result
+	}
+	result.EventID = event.ID
+
+	stored := false
+	defer func() {
+		if stored {
+			cleanupStart := time.Now()
+			for _, del := range relay.DeleteEvent {
+				if err := del(ctx, &event); err != nil && result.Error == "" {
+					result.OK = false
+					result.Error = fmt.Sprintf("failed to delete test event: %v", err)
+				}
+			}
+			result.CleanupMs = millisSince(cleanupStart)
+		}
+		result.TotalMs = millisSince(start)
+	}()
+
+	storeStart := time.Now()
+	for _, store := range relay.StoreEvent {
+		if err := store(ctx, &event); err != nil {
+			result.Error = fmt.Sprintf("failed to store test event: %v", err)
+			return result
+		}
+		stored = true
+	}
+	result.StoreMs = millisSince(storeStart)
+
+	queryStart := time.Now()
+	found := false
+	for _, query := range relay.QueryEvents {
+		ch, err := query(ctx, nostr.Filter{IDs: []string{event.ID}})
+		if err != nil {
+			result.Error = fmt.Sprintf("failed to query test event: %v", err)
+			return result
+		}
+		for evt := range ch {
+			if evt.ID == event.ID {
+				found = true
+			}
+		}
+	}
+	result.QueryMs = millisSince(queryStart)
+
+	if !found {
+		result.Error = "test event was stored but could not be queried back"
+		return result
+	}
+
+	result.OK = true
+	return result
+}
+
+// handleSelftest serves /admin/selftest.
+func handleSelftest(relay *khatru.Relay) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		result := runSelftest(ctx, relay)
+		status := http.StatusOK
+		if !result.OK {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, result)
+	}
+}
+
+func millisSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
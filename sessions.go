@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// sessionTracker allows one authenticated connection per pubkey: when another connection
+// authenticates as the same pubkey, the older one is told "session replaced" and closed.
+//
+// khatru has no hook for a successful AUTH, so a connection is registered the first time it
+// uses its authentication, i.e. on its first REQ, COUNT or EVENT after AUTH.
+type sessionTracker struct {
+	close func(ws *khatru.WebSocket)
+
+	mu       sync.Mutex
+	sessions map[string]*khatru.WebSocket
+	owners   map[*khatru.WebSocket]string
+	replaced map[*khatru.WebSocket]bool
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		close:    closeWebSocket,
+		sessions: make(map[string]*khatru.WebSocket),
+		owners:   make(map[*khatru.WebSocket]string),
+		replaced: make(map[*khatru.WebSocket]bool),
+	}
+}
+
+// claim registers the connection behind ctx as the session of its authenticated pubkey,
+// replacing any older session. It reports false if this connection has itself been replaced.
+func (t *sessionTracker) claim(ctx context.Context) bool {
+	ws := getConnection(ctx)
+	if ws == nil || ws.AuthedPublicKey == "" {
+		return true
+	}
+	pubkey := ws.AuthedPublicKey
+
+	t.mu.Lock()
+	if t.replaced[ws] {
+		t.mu.Unlock()
+		return false
+	}
+	if t.owners[ws] == pubkey {
+		t.mu.Unlock()
+		return true
+	}
+	if previousPubkey, ok := t.owners[ws]; ok && t.sessions[previousPubkey] == ws {
+		// authenticated again, as someone else
+		delete(t.sessions, previousPubkey)
+	}
+	previous := t.sessions[pubkey]
+	t.sessions[pubkey] = ws
+	t.owners[ws] = pubkey
+	if previous != nil {
+		delete(t.owners, previous)
+		t.replaced[previous] = true
+	}
+	t.mu.Unlock()
+
+	if previous != nil {
+		log.Printf("Closing the previous session of %s, it authenticated again from %s", pubkey, khatru.GetIP(ctx))
+		previous.WriteJSON(nostr.NoticeEnvelope("session replaced: this pubkey authenticated on another connection"))
+		previous.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session replaced"))
+		t.close(previous)
+	}
+	return true
+}
+
+func (t *sessionTracker) rejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if !t.claim(ctx) {
+		return true, "auth-required: session replaced"
+	}
+	return false, ""
+}
+
+func (t *sessionTracker) rejectFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	if !t.claim(ctx) {
+		return true, "auth-required: session replaced"
+	}
+	return false, ""
+}
+
+// overwriteFilter claims on limit:0 REQs too, which skip the reject hooks.
+func (t *sessionTracker) overwriteFilter(ctx context.Context, filter *nostr.Filter) {
+	t.claim(ctx)
+}
+
+func (t *sessionTracker) onDisconnect(ctx context.Context) {
+	ws := getConnection(ctx)
+	if ws == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pubkey, ok := t.owners[ws]; ok && t.sessions[pubkey] == ws {
+		delete(t.sessions, pubkey)
+	}
+	delete(t.owners, ws)
+	delete(t.replaced, ws)
+}
+
+// closeWebSocket closes the network connection of ws, which makes khatru run its disconnect
+// cleanup. khatru does not export a way to do this, so it closes the connection the messageGate
+// in front of the relay took over.
+func closeWebSocket(ws *khatru.WebSocket) {
+	if !closeConnection(ws) {
+		log.Printf("Cannot close a connection that did not come through the message gate")
+	}
+}
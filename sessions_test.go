@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// authenticate answers the NIP-42 challenge the relay sends when a REQ needs auth.
+func authenticate(t *testing.T, conn *websocket.Conn, sk string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`["REQ","auth",{"kinds":[1]}]`)); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	var challenge string
+	for challenge == "" {
+		if message := readEnvelope(t, conn); message[0] == "AUTH" {
+			challenge = message[1].(string)
+		}
+	}
+	readEnvelope(t, conn) // CLOSED with auth-required
+
+	event := nostr.Event{
+		Kind:      nostr.KindClientAuthentication,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"relay", "ws://" + conn.RemoteAddr().String()}, {"challenge", challenge}},
+	}
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if err := conn.WriteJSON(nostr.AuthEnvelope{Event: event}); err != nil {
+		t.Fatalf("failed to send AUTH: %v", err)
+	}
+	if message := readEnvelope(t, conn); message[0] != "OK" || message[2] != true {
+		t.Fatalf("expected AUTH to succeed, got %v", message)
+	}
+}
+
+func TestSingleSessionPerPubkey(t *testing.T) {
+	relay := khatru.NewRelay()
+	sessions := newSessionTracker()
+	relay.RejectFilter = append(relay.RejectFilter, sessions.rejectFilter,
+		func(ctx context.Context, filter nostr.Filter) (bool, string) {
+			if khatru.GetAuthed(ctx) == "" {
+				return true, "auth-required: please authenticate"
+			}
+			return false, ""
+		})
+	relay.OnDisconnect = append(relay.OnDisconnect, sessions.onDisconnect)
+	sk, _ := newKeypair(t)
+
+	first := dialGatedRelay(t, relay)
+	authenticate(t, first, sk)
+	if reply := sendReq(t, first, "sub", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected the first session to work, got %v", reply)
+	}
+
+	second := dialGatedRelay(t, relay)
+	authenticate(t, second, sk)
+	if reply := sendReq(t, second, "sub", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected the second session to work, got %v", reply)
+	}
+
+	if message := readEnvelope(t, first); message[0] != "NOTICE" || message[1] != "session replaced: this pubkey authenticated on another connection" {
+		t.Fatalf("expected the first session to be told it was replaced, got %v", message)
+	}
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := first.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("expected the first session to be closed, got %v", err)
+	}
+
+	// the surviving session keeps working
+	if reply := sendReq(t, second, "again", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected the second session to keep working, got %v", reply)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// statusSelftestInterval is how often `brove status` runs the self-test, which stores and
+// deletes an event, however often it refreshes.
+const statusSelftestInterval = 30 * time.Second
+
+// metricTotals is one scrape of /metrics, by metric name. Series of a metric with labels are
+// summed up.
+type metricTotals map[string]float64
+
+func newMetricTotals(samples []metricSample) metricTotals {
+	totals := make(metricTotals)
+	for _, sample := range samples {
+		totals[sample.name] += sample.value
+	}
+	return totals
+}
+
+// statusClient reads the health of a running relay from its HTTP endpoints.
+type statusClient struct {
+	url       string
+	secretKey string
+	client    *http.Client
+}
+
+func (c *statusClient) get(ctx context.Context, path string, authenticated bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if authenticated {
+		auth, err := signNIP98(c.secretKey, c.url+path, http.MethodGet, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", c.url, err)
+	}
+	return resp, nil
+}
+
+func (c *statusClient) metrics(ctx context.Context) (metricTotals, error) {
+	resp, err := c.get(ctx, "/metrics", false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/metrics returned status %d", resp.StatusCode)
+	}
+	text, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /metrics: %w", err)
+	}
+	samples, err := parseMetrics(string(text))
+	if err != nil {
+		return nil, err
+	}
+	return newMetricTotals(samples), nil
+}
+
+// selftest runs /admin/selftest, which needs the owner's key. A failed self-test is reported
+// in the result, not as an error.
+func (c *statusClient) selftest(ctx context.Context) (selftestResult, error) {
+	resp, err := c.get(ctx, "/admin/selftest", true)
+	if err != nil {
+		return selftestResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var result selftestResult
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return result, fmt.Errorf("/admin/selftest returned status %d: %s", resp.StatusCode, body.Error)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return result, fmt.Errorf("invalid self-test response: %w", err)
+	}
+	return result, nil
+}
+
+// statusView is one frame of the `brove status` dashboard.
+type statusView struct {
+	url     string
+	at      time.Time
+	current metricTotals
+	// previous is the scrape before, elapsed before current, for the rates
+	previous   metricTotals
+	elapsed    time.Duration
+	metricsErr error
+
+	selftest    *selftestResult
+	selftestErr error
+}
+
+// rate returns how much a counter grew per second since the previous scrape.
+func (v statusView) rate(name string) (float64, bool) {
+	current, ok := v.current[name]
+	previous, hadPrevious := v.previous[name]
+	if !ok || !hadPrevious || v.elapsed <= 0 || current < previous {
+		return 0, false
+	}
+	return (current - previous) / v.elapsed.Seconds(), true
+}
+
+func (v statusView) formatRate(name string) string {
+	if rate, ok := v.rate(name); ok {
+		return fmt.Sprintf("%.1f/s", rate)
+	}
+	return "-"
+}
+
+func (v statusView) render(w io.Writer) {
+	fmt.Fprintf(w, "brove status  %s  %s\n\n", v.url, v.at.Format(time.TimeOnly))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if v.metricsErr != nil {
+		fmt.Fprintf(tw, "relay\tunreachable: %v\n", v.metricsErr)
+	} else {
+		m := v.current
+		fmt.Fprintf(tw, "connections\t%.0f open\t%.0f waiting\t%.0f refused\n", m["brove_connections_active"], m["brove_connections_waiting"], m["brove_connections_rejected_total"])
+		fmt.Fprintf(tw, "events\t%s stored\t%s rejected\n", v.formatRate("brove_events_stored_total"), v.formatRate("brove_events_rejected_total"))
+		fmt.Fprintf(tw, "queries\t%s\n", v.formatRate("brove_queries_total"))
+		if ratio, ok := m["brove_allowlist_cache_hit_ratio"]; ok {
+			fmt.Fprintf(tw, "allowlist cache\t%.1f%% hits\t%.0f entries\n", ratio*100, m["brove_allowlist_cache_entries"])
+		} else {
+			fmt.Fprintf(tw, "allowlist cache\toff\n")
+		}
+		if depth, ok := m["brove_broadcast_queue_depth"]; ok {
+			fmt.Fprintf(tw, "mirror queue\t%.0f pending\n", depth)
+		}
+	}
+	switch {
+	case v.selftestErr != nil:
+		fmt.Fprintf(tw, "database\tunknown: %v\n", v.selftestErr)
+	case v.selftest == nil:
+		fmt.Fprintf(tw, "database\tunknown, pass --key with the owner's key to check\n")
+	case !v.selftest.OK:
+		fmt.Fprintf(tw, "database\tFAILING: %s\n", v.selftest.Error)
+	default:
+		fmt.Fprintf(tw, "database\tok\tstore %.1fms\tquery %.1fms\n", v.selftest.StoreMs, v.selftest.QueryMs)
+	}
+	tw.Flush()
+}
+
+// runStatus shows a live dashboard of a running relay, refreshed every interval:
+//
+//	brove status --url http://localhost:3334 --key <owner-nsec>
+func runStatus(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	url := flags.String("url", "http://localhost:3334", "HTTP URL of the running relay")
+	key := flags.String("key", "", "owner secret key (nsec or hex), to check the database through /admin/selftest")
+	interval := flags.Duration("interval", 2*time.Second, "how often to refresh")
+	once := flags.Bool("once", false, "print a single view and exit, without rates")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: brove status [--url <relay-url>] [--key <owner-nsec>] [--interval 2s] [--once]")
+		return 2
+	}
+
+	client := &statusClient{url: strings.TrimRight(*url, "/"), client: &http.Client{Timeout: 10 * time.Second}}
+	if *key != "" {
+		secretKey, err := parseSecretKey(*key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 2
+		}
+		client.secretKey = secretKey
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var view statusView
+	var lastSelftest time.Time
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		next := statusView{url: client.url, at: now, selftest: view.selftest, selftestErr: view.selftestErr}
+		next.current, next.metricsErr = client.metrics(ctx)
+		if view.metricsErr == nil && view.current != nil {
+			next.previous, next.elapsed = view.current, now.Sub(view.at)
+		}
+		if client.secretKey != "" && now.Sub(lastSelftest) >= statusSelftestInterval {
+			result, err := client.selftest(ctx)
+			next.selftest, next.selftestErr = &result, err
+			if err != nil {
+				next.selftest = nil
+			}
+			lastSelftest = now
+		}
+		view = next
+
+		if *once {
+			view.render(os.Stdout)
+			if view.metricsErr != nil || view.selftestErr != nil || (view.selftest != nil && !view.selftest.OK) {
+				return 1
+			}
+			return 0
+		}
+		// clear the terminal and draw the new frame
+		fmt.Print("\033[H\033[2J")
+		view.render(os.Stdout)
+		fmt.Println("\nrefreshing every", *interval, "- ctrl-c to quit")
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusClient(t *testing.T) {
+	sk, owner := newKeypair(t)
+	counters := &relayCounters{}
+	counters.eventsStored.Add(10)
+	healthy := true
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics(counters.metrics, func(w *bufio.Writer) {
+		fmt.Fprintln(w, "brove_connections_active 3")
+		fmt.Fprintln(w, `brove_dry_run_rejections_total{policy="a"} 1`)
+		fmt.Fprintln(w, `brove_dry_run_rejections_total{policy="b"} 2`)
+	}))
+	mux.HandleFunc("/admin/selftest", requireOwner(owner, func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			writeJSON(w, http.StatusServiceUnavailable, selftestResult{Error: "connection refused"})
+			return
+		}
+		writeJSON(w, http.StatusOK, selftestResult{OK: true, StoreMs: 1.5, QueryMs: 0.5})
+	}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &statusClient{url: server.URL, secretKey: sk, client: server.Client()}
+	ctx := context.Background()
+	first, err := client.metrics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first["brove_connections_active"] != 3 || first["brove_dry_run_rejections_total"] != 3 || first["brove_events_stored_total"] != 10 {
+		t.Errorf("unexpected metrics %v", first)
+	}
+
+	result, err := client.selftest(ctx)
+	if err != nil || !result.OK || result.StoreMs != 1.5 {
+		t.Errorf("expected a passing self-test, got %+v %v", result, err)
+	}
+	healthy = false
+	if result, err := client.selftest(ctx); err != nil || result.OK || result.Error != "connection refused" {
+		t.Errorf("expected a failing self-test to be reported in the result, got %+v %v", result, err)
+	}
+	_, stranger := newKeypair(t)
+	if _, err := (&statusClient{url: server.URL, secretKey: stranger, client: server.Client()}).selftest(ctx); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected other keys to be refused, got %v", err)
+	}
+
+	server.Close()
+	if _, err := client.metrics(ctx); err == nil {
+		t.Error("expected an error for an unreachable relay")
+	}
+}
+
+func TestStatusViewRender(t *testing.T) {
+	view := statusView{
+		url:      "http://relay",
+		at:       time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		previous: metricTotals{"brove_events_stored_total": 100, "brove_queries_total": 50},
+		current:  metricTotals{"brove_events_stored_total": 120, "brove_queries_total": 40, "brove_connections_active": 7, "brove_allowlist_cache_hit_ratio": 0.925},
+		elapsed:  2 * time.Second,
+		selftest: &selftestResult{OK: true, StoreMs: 2, QueryMs: 1},
+	}
+	var out strings.Builder
+	view.render(&out)
+	for _, want := range []string{"7 open", "10.0/s stored", "- rejected", "92.5% hits", "database         ok"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in\n%s", want, out.String())
+		}
+	}
+	// a counter that went down, e.g. after a restart, has no rate
+	if strings.Contains(out.String(), "-5.0/s") {
+		t.Errorf("expected no negative rate in\n%s", out.String())
+	}
+
+	view = statusView{url: "http://relay", metricsErr: errors.New("connection refused")}
+	out.Reset()
+	view.render(&out)
+	if !strings.Contains(out.String(), "unreachable") || !strings.Contains(out.String(), "pass --key") {
+		t.Errorf("expected the relay to be shown as unreachable, got\n%s", out.String())
+	}
+}
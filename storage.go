@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventStorageSize is what an event counts against its author's storage quota: its content
+// plus the values of its tags, in bytes. event_storage_size in the database computes the same.
+func eventStorageSize(event *nostr.Event) int64 {
+	size := int64(len(event.Content))
+	for _, tag := range event.Tags {
+		for _, value := range tag {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+// storageQuota rejects events that would take their author over their storage quota: their
+// own if they have one, otherwise the default. A quota of zero means unlimited.
+//
+// A new version of a replaceable event is counted in full even though it replaces the old
+// one, so a pubkey right at its quota can't update its profile until it frees some space.
+type storageQuota struct {
+	usage    func(pubkey string) (StorageUsage, error)
+	fallback int64
+}
+
+func (q storageQuota) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	usage, err := q.usage(event.PubKey)
+	if err != nil {
+		log.Printf("Error checking storage usage: %v", err)
+		return true, "error: could not check storage quota, try again later"
+	}
+
+	quota := q.fallback
+	if usage.QuotaBytes != nil {
+		quota = *usage.QuotaBytes
+	}
+	if quota > 0 && usage.UsedBytes+eventStorageSize(event) > quota {
+		return true, "blocked: storage size quota exceeded"
+	}
+	return false, ""
+}
+
+// storageQuotas is the part of DBManager that manages storage quotas.
+type storageQuotas interface {
+	GetStorageUsage(pubkey string) (StorageUsage, error)
+	SetStorageQuota(pubkey string, quotaBytes int64) error
+	ClearStorageQuota(pubkey string) error
+}
+
+// handleAdminStorage serves the owner-only storage quotas: GET ?pubkey= returns the usage and
+// quota of a pubkey, PUT {"pubkey": ..., "quota_bytes": ...} sets its own quota and DELETE
+// ?pubkey= removes it, so the default applies again.
+func handleAdminStorage(quotas storageQuotas) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pubkey := strings.ToLower(r.URL.Query().Get("pubkey"))
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct {
+				Pubkey     string `json:"pubkey"`
+				QuotaBytes *int64 `json:"quota_bytes"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json body"})
+				return
+			}
+			if body.QuotaBytes == nil || *body.QuotaBytes < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "quota_bytes must be a number of bytes, 0 for unlimited"})
+				return
+			}
+			pubkey = strings.ToLower(body.Pubkey)
+			if err := quotas.SetStorageQuota(pubkey, *body.QuotaBytes); err != nil {
+				writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			log.Printf("Storage quota of %s set to %d bytes", pubkey, *body.QuotaBytes)
+		case http.MethodDelete:
+			if err := quotas.ClearStorageQuota(pubkey); err != nil {
+				writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			log.Printf("Storage quota of %s cleared, the default applies", pubkey)
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		usage, err := quotas.GetStorageUsage(pubkey)
+		if err != nil {
+			writeJSON(w, dbErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, usage)
+	}
+}
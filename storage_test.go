@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryStorageQuotas is an in-memory storageQuotas.
+type memoryStorageQuotas map[string]*StorageUsage
+
+func (m memoryStorageQuotas) GetStorageUsage(pubkey string) (StorageUsage, error) {
+	if err := validatePubkey(pubkey); err != nil {
+		return StorageUsage{}, err
+	}
+	if usage, ok := m[pubkey]; ok {
+		return *usage, nil
+	}
+	return StorageUsage{Pubkey: pubkey}, nil
+}
+
+func (m memoryStorageQuotas) SetStorageQuota(pubkey string, quotaBytes int64) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+	if m[pubkey] == nil {
+		m[pubkey] = &StorageUsage{Pubkey: pubkey}
+	}
+	m[pubkey].QuotaBytes = &quotaBytes
+	return nil
+}
+
+func (m memoryStorageQuotas) ClearStorageQuota(pubkey string) error {
+	if err := validatePubkey(pubkey); err != nil {
+		return err
+	}
+	if m[pubkey] != nil {
+		m[pubkey].QuotaBytes = nil
+	}
+	return nil
+}
+
+func TestEventStorageSize(t *testing.T) {
+	event := &nostr.Event{Content: "héllo", Tags: nostr.Tags{{"e", "abc"}, {"t", "x"}}}
+	if size := eventStorageSize(event); size != 6+4+2 {
+		t.Errorf("expected 12 bytes, got %d", size)
+	}
+}
+
+func TestStorageQuota(t *testing.T) {
+	pubkey := strings.Repeat("a", 64)
+	quotas := memoryStorageQuotas{pubkey: {Pubkey: pubkey, UsedBytes: 90}}
+	quota := storageQuota{usage: quotas.GetStorageUsage, fallback: 100}
+	event := func(content string) *nostr.Event { return &nostr.Event{PubKey: pubkey, Content: content} }
+
+	if reject, msg := quota.reject(context.Background(), event("0123456789")); reject {
+		t.Errorf("expected an event reaching the quota exactly to pass, got %s", msg)
+	}
+	if reject, msg := quota.reject(context.Background(), event("0123456789a")); !reject || msg != "blocked: storage size quota exceeded" {
+		t.Errorf("expected an event over the default quota to be rejected, got %v %q", reject, msg)
+	}
+
+	// the pubkey's own quota overrides the default, and zero is unlimited
+	quotas.SetStorageQuota(pubkey, 1000)
+	if reject, msg := quota.reject(context.Background(), event("0123456789a")); reject {
+		t.Errorf("expected the own quota to apply, got %s", msg)
+	}
+	quotas.SetStorageQuota(pubkey, 0)
+	if reject, msg := quota.reject(context.Background(), event(strings.Repeat("x", 10000))); reject {
+		t.Errorf("expected a zero quota to be unlimited, got %s", msg)
+	}
+
+	failing := storageQuota{usage: func(string) (StorageUsage, error) { return StorageUsage{}, fmt.Errorf("database down") }, fallback: 100}
+	if reject, _ := failing.reject(context.Background(), event("x")); !reject {
+		t.Errorf("expected events to be rejected when usage can't be checked")
+	}
+}
+
+func TestHandleAdminStorage(t *testing.T) {
+	pubkey := strings.Repeat("b", 64)
+	quotas := memoryStorageQuotas{pubkey: {Pubkey: pubkey, UsedBytes: 42}}
+	handler := handleAdminStorage(quotas)
+
+	serve := func(method, target, body string) (int, StorageUsage) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var usage StorageUsage
+		json.Unmarshal(rec.Body.Bytes(), &usage)
+		return rec.Code, usage
+	}
+
+	if code, usage := serve(http.MethodPut, "/admin/storage", `{"pubkey":"`+pubkey+`","quota_bytes":5000}`); code != http.StatusOK || usage.UsedBytes != 42 || usage.QuotaBytes == nil || *usage.QuotaBytes != 5000 {
+		t.Fatalf("expected the quota to be set, got %d %+v", code, usage)
+	}
+	if code, usage := serve(http.MethodGet, "/admin/storage?pubkey="+pubkey, ""); code != http.StatusOK || usage.QuotaBytes == nil {
+		t.Fatalf("expected the quota to be returned, got %d %+v", code, usage)
+	}
+	if code, usage := serve(http.MethodDelete, "/admin/storage?pubkey="+pubkey, ""); code != http.StatusOK || usage.QuotaBytes != nil {
+		t.Fatalf("expected the quota to be cleared, got %d %+v", code, usage)
+	}
+
+	if code, _ := serve(http.MethodPut, "/admin/storage", `{"pubkey":"`+pubkey+`","quota_bytes":-1}`); code != http.StatusBadRequest {
+		t.Errorf("expected a negative quota to be refused, got %d", code)
+	}
+	if code, _ := serve(http.MethodPut, "/admin/storage", `{"pubkey":"`+pubkey+`"}`); code != http.StatusBadRequest {
+		t.Errorf("expected a missing quota to be refused, got %d", code)
+	}
+	if code, _ := serve(http.MethodGet, "/admin/storage?pubkey=nope", ""); code != http.StatusBadRequest {
+		t.Errorf("expected an invalid pubkey to be refused, got %d", code)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	_ "unsafe" // for go:linkname
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// closeSubscription is what khatru runs for a CLOSE message. khatru does not export it, and
+// without it a REQ reusing a subscription id is added next to the old one instead of replacing it.
+// The link was checked against khatruLinkedVersion; TestCloseSubscriptionLink fails once khatru
+// is upgraded past it, so that the link is checked again.
+//
+//go:linkname closeSubscription github.com/fiatjaf/khatru.(*Relay).removeListenerId
+func closeSubscription(relay *khatru.Relay, ws *khatru.WebSocket, id string)
+
+// khatruLinkedVersion is the khatru release closeSubscription was checked against.
+const khatruLinkedVersion = "v0.18.0"
+
+// subscriptionState is the set of subscription ids one connection has opened.
+// Each id maps to the Done channel of the REQ that opened it, which is closed once that
+// REQ is closed by the client or rejected.
+type subscriptionState struct {
+	active       map[string]<-chan struct{}
+	replacements int
+	windowStart  time.Time
+	throttled    <-chan struct{}
+}
+
+// subscriptionTracker makes a REQ replace an open subscription with the same id, as NIP-01
+// requires, unless close is nil, in which case khatru keeps both. With maxReplacements set,
+// connections that reuse open subscription ids more often than that per minute get their new
+// REQs rejected.
+type subscriptionTracker struct {
+	close           func(ws *khatru.WebSocket, id string)
+	maxReplacements int
+
+	mu    sync.Mutex
+	conns map[*khatru.WebSocket]*subscriptionState
+}
+
+func newSubscriptionTracker(relay *khatru.Relay, replace bool, maxReplacements int) *subscriptionTracker {
+	t := &subscriptionTracker{
+		maxReplacements: maxReplacements,
+		conns:           make(map[*khatru.WebSocket]*subscriptionState),
+	}
+	if replace {
+		t.close = func(ws *khatru.WebSocket, id string) { closeSubscription(relay, ws, id) }
+	}
+	return t
+}
+
+// overwriteFilter is an OverwriteFilter hook rather than a RejectFilter one because khatru
+// skips the reject hooks for limit:0 filters, which still open a live subscription.
+func (t *subscriptionTracker) overwriteFilter(ctx context.Context, filter *nostr.Filter) {
+	ws := getConnection(ctx)
+	if ws == nil || eventstore.IsNegentropySession(ctx) {
+		return
+	}
+	id := khatru.GetSubscriptionID(ctx)
+	done := ctx.Done()
+
+	t.mu.Lock()
+	state, ok := t.conns[ws]
+	if !ok {
+		state = &subscriptionState{active: make(map[string]<-chan struct{})}
+		t.conns[ws] = state
+	}
+	previous, exists := state.active[id]
+	if exists && previous == done {
+		// another filter of the same REQ
+		t.mu.Unlock()
+		return
+	}
+	if len(state.active) >= 256 {
+		state.forgetClosed()
+	}
+	state.active[id] = done
+
+	replacing := exists && !isClosed(previous)
+	if replacing {
+		now := time.Now()
+		if now.Sub(state.windowStart) > time.Minute {
+			state.windowStart, state.replacements = now, 0
+		}
+		state.replacements++
+		if t.maxReplacements > 0 && state.replacements > t.maxReplacements {
+			state.throttled = done
+			if state.replacements == t.maxReplacements+1 {
+				log.Printf("Connection from %s keeps reusing subscription ids (%d replacements in a minute)", khatru.GetIP(ctx), state.replacements)
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	if replacing && t.close != nil {
+		t.close(ws, id)
+	}
+}
+
+// rejectFilter rejects the REQs that overwriteFilter found to exceed the replacement budget.
+func (t *subscriptionTracker) rejectFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	ws := getConnection(ctx)
+	if ws == nil {
+		return false, ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, ok := t.conns[ws]; ok && state.throttled != nil && state.throttled == ctx.Done() {
+		return true, "rate-limited: too many subscriptions reusing the same id"
+	}
+	return false, ""
+}
+
+func (t *subscriptionTracker) onDisconnect(ctx context.Context) {
+	if ws := getConnection(ctx); ws != nil {
+		t.mu.Lock()
+		delete(t.conns, ws)
+		t.mu.Unlock()
+	}
+}
+
+func (s *subscriptionState) forgetClosed() {
+	for id, done := range s.active {
+		if isClosed(done) {
+			delete(s.active, id)
+		}
+	}
+}
+
+func isClosed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
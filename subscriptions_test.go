@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func newSubscriptionRelay(replace bool, maxReplacements int) *khatru.Relay {
+	relay := khatru.NewRelay()
+	subscriptions := newSubscriptionTracker(relay, replace, maxReplacements)
+	relay.OverwriteFilter = append(relay.OverwriteFilter, subscriptions.overwriteFilter)
+	relay.RejectFilter = append(relay.RejectFilter, subscriptions.rejectFilter)
+	relay.OnDisconnect = append(relay.OnDisconnect, subscriptions.onDisconnect)
+	return relay
+}
+
+// sendReq sends a REQ and returns the first reply (EOSE or CLOSED).
+func sendReq(t *testing.T, conn *websocket.Conn, id, filter string) []any {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`["REQ","`+id+`",`+filter+`]`)); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	return readEnvelope(t, conn)
+}
+
+func TestDuplicateSubscriptionIDReplaces(t *testing.T) {
+	relay := newSubscriptionRelay(true, 0)
+	conn := dialRelay(t, relay)
+
+	sendReq(t, conn, "sub", `{"kinds":[1]}`)
+	sendReq(t, conn, "sub", `{"kinds":[7]}`)
+	// a multi-filter REQ must keep all its filters
+	sendReq(t, conn, "other", `{"kinds":[3]},{"kinds":[4]}`)
+
+	filters := relay.GetListeningFilters()
+	if len(filters) != 3 {
+		t.Fatalf("expected 3 active filters, got %v", filters)
+	}
+	for _, filter := range filters {
+		if filter.Kinds[0] == 1 {
+			t.Errorf("the replaced subscription is still active: %v", filters)
+		}
+	}
+}
+
+func TestSubscriptionReplacementLimit(t *testing.T) {
+	relay := newSubscriptionRelay(true, 1)
+	conn := dialRelay(t, relay)
+
+	// closing and reopening an id is not a replacement
+	sendReq(t, conn, "sub", `{"kinds":[1]}`)
+	conn.WriteMessage(websocket.TextMessage, []byte(`["CLOSE","sub"]`))
+	time.Sleep(50 * time.Millisecond)
+	if reply := sendReq(t, conn, "sub", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected EOSE after reopening, got %v", reply)
+	}
+
+	if reply := sendReq(t, conn, "sub", `{"kinds":[1]}`); reply[0] != "EOSE" {
+		t.Fatalf("expected the first replacement to be allowed, got %v", reply)
+	}
+	reply := sendReq(t, conn, "sub", `{"kinds":[1]}`)
+	if reply[0] != "CLOSED" || reply[2] != "rate-limited: too many subscriptions reusing the same id" {
+		t.Fatalf("expected the second replacement to be rejected, got %v", reply)
+	}
+}
+
+// TestCloseSubscriptionLink checks what the replacement relies on from khatru's internals: that
+// closeSubscription cancels the old subscription so it gets no more live events. It also stops
+// at khatru upgrades, so the link is checked against the new release and the version bumped.
+func TestCloseSubscriptionLink(t *testing.T) {
+	info, _ := debug.ReadBuildInfo()
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/fiatjaf/khatru" && dep.Version != khatruLinkedVersion {
+			t.Fatalf("khatru is %s, but closeSubscription links to (*Relay).removeListenerId as of %s: check it still closes a subscription and update khatruLinkedVersion", dep.Version, khatruLinkedVersion)
+		}
+	}
+
+	relay := newSubscriptionRelay(true, 0)
+	cancelled := make(chan error, 2)
+	relay.OverwriteFilter = append(relay.OverwriteFilter, func(ctx context.Context, filter *nostr.Filter) {
+		go func() {
+			<-ctx.Done()
+			cancelled <- context.Cause(ctx)
+		}()
+	})
+	conn := dialRelay(t, relay)
+
+	sendReq(t, conn, "sub", `{"kinds":[1]}`)
+	sendReq(t, conn, "sub", `{"kinds":[7]}`)
+	select {
+	case cause := <-cancelled:
+		if cause != khatru.ErrSubscriptionClosedByClient {
+			t.Fatalf("expected the replaced subscription to be closed as if by a CLOSE, got %v", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the replaced subscription to be closed")
+	}
+
+	// only the new subscription gets live events
+	relay.BroadcastEvent(&nostr.Event{ID: fakeID(1), Kind: 1})
+	relay.BroadcastEvent(&nostr.Event{ID: fakeID(7), Kind: 7})
+	if message := readEnvelope(t, conn); message[0] != "EVENT" || message[2].(map[string]any)["id"] != fakeID(7) {
+		t.Fatalf("expected only the kind 7 event to be sent, got %v", message)
+	}
+}
+
+func TestDuplicateSubscriptionIDKeptWhenDisabled(t *testing.T) {
+	relay := newSubscriptionRelay(false, 1)
+	conn := dialRelay(t, relay)
+
+	sendReq(t, conn, "sub", `{"kinds":[1]}`)
+	sendReq(t, conn, "sub", `{"kinds":[7]}`)
+	if filters := relay.GetListeningFilters(); len(filters) != 2 {
+		t.Fatalf("expected khatru to keep both subscriptions, got %v", filters)
+	}
+	// reuse is still limited
+	if reply := sendReq(t, conn, "sub", `{"kinds":[1]}`); reply[0] != "CLOSED" {
+		t.Fatalf("expected the second reuse to be rejected, got %v", reply)
+	}
+}
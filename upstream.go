@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// defaultBootstrapRelays are public relays used to look up data about pubkeys from the wider network.
+var defaultBootstrapRelays = []string{"wss://relay.damus.io", "wss://nos.lol", "wss://purplepag.es"}
+
+// upstream queries other relays on behalf of brove's policies.
+type upstream struct {
+	pool   *nostr.SimplePool
+	relays []string
+}
+
+func newUpstream(ctx context.Context, relays []string) *upstream {
+	return &upstream{pool: nostr.NewSimplePool(ctx), relays: relays}
+}
+
+// fetch returns the events matching filter from all bootstrap relays.
+// It fails only if none of the relays could be reached.
+func (u *upstream) fetch(ctx context.Context, filter nostr.Filter) ([]*nostr.Event, error) {
+	reachable := make([]string, 0, len(u.relays))
+	for _, url := range u.relays {
+		if _, err := u.pool.EnsureRelay(url); err == nil {
+			reachable = append(reachable, url)
+		}
+	}
+	if len(reachable) == 0 {
+		return nil, fmt.Errorf("none of the %d bootstrap relays could be reached", len(u.relays))
+	}
+
+	var events []*nostr.Event
+	for ie := range u.pool.FetchMany(ctx, reachable, filter) {
+		events = append(events, ie.Event)
+	}
+	return events, nil
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// configCheck validates the value of one setting. Empty values are never checked, since the
+// relay treats them as unset.
+type configCheck func(value string) error
+
+// configChecks lists every setting the relay reads, with the same rules it applies at startup.
+// At startup invalid values are logged and replaced by defaults; validate-config reports them.
+var configChecks = map[string]configCheck{
+	"ALLOW_REPLIES_TO_MEMBERS":     checkBool,
+	"ARCHIVE_MODE":                 checkBool,
+	"BOOTSTRAP_OWNER":              checkBool,
+	"DUPLICATE_CONTENT_PER_PUBKEY": checkBool,
+	"ENFORCE_OWNER_AUTH":           checkBool,
+	"FEDERATION_SYNC_ALLOWLIST":    checkBool,
+	"ENFORCE_POST_AUTH_TIMESTAMP":  checkBool,
+	"EXPIRY_DM":                    checkBool,
+	"GENESIS_ALLOWLIST":            checkBool,
+	"HTTP_GZIP":                    checkBool,
+	"NEGENTROPY":                   checkBool,
+	"NIP11_RELAY_STATE":            checkBool,
+	"POLICY_WEBHOOK_FAIL_OPEN":     checkBool,
+	"PRIVATE_ALLOWLIST":            checkBool,
+	"PURGE_ON_DEALLOW":             checkBool,
+	"REJECT_BACKDATED_EVENTS":      checkBool,
+	"REJECT_INLINE_MEDIA":          checkBool,
+	"REJECT_MISSING_CLIENT_TAG":    checkBool,
+	"REJECT_UPPERCASE_HEX":         checkBool,
+	"REPORTS":                      checkBool,
+	"REQUIRE_AUTH_BEFORE_EVENT":    checkBool,
+	"REQUIRE_STORED_PARENT":        checkBool,
+	"SCHEDULED_EVENTS":             checkBool,
+	"SEARCH_INDEX":                 checkBool,
+	"SINGLE_SESSION_PER_PUBKEY":    checkBool,
+	"STORAGE_QUOTAS":               checkBool,
+	"STRICT_EVENT_SCHEMA":          checkBool,
+	"STRICT_SIGNATURE_FORMAT":      checkBool,
+	"VALIDATE_DELEGATION":          checkBool,
+
+	"ALLOWLIST_CACHE_SIZE":                     checkInt(0),
+	"BATCH_SIZE":                               checkInt(0),
+	"DUPLICATE_CONTENT_MAX":                    checkInt(0),
+	"DUPLICATE_CONTENT_MIN_LENGTH":             checkInt(0),
+	"MAX_CONNECTIONS":                          checkInt(0),
+	"MAX_EVENTS_PER_PUBKEY":                    checkInt(0),
+	"MAX_EVENT_TAGS":                           checkInt(0),
+	"MAX_EVENT_MESSAGE_BYTES":                  checkInt(0),
+	"MAX_E_TAGS":                               checkInt(0),
+	"MAX_FILTER_VALUE_LENGTH":                  checkInt(0),
+	"MAX_SUBID_LENGTH":                         checkInt(0),
+	"MAX_P_TAGS":                               checkInt(0),
+	"MAX_PENDING_AUTH_PER_IP":                  checkInt(0),
+	"MAX_QUEUED_QUERIES_PER_CONNECTION":        checkInt(-1),
+	"MAX_SUBSCRIPTION_REPLACEMENTS_PER_MINUTE": checkInt(0),
+	"REPLACE_DUPLICATE_SUBSCRIPTIONS":          checkBool,
+	"MIN_FOLLOWERS":                            checkInt(0),
+	"MIN_WOT_SCORE":                            checkInt(0),
+	"QUERY_BUFFER_BYTES":                       checkInt(0),
+	"RELAY_LOG_MAX_LINES_PER_MINUTE":           checkInt(0),
+	"REPORT_THRESHOLD":                         checkInt(0),
+	"KEY_COMPROMISE_CHANGES":                   checkInt(0),
+	"STORAGE_QUOTA_DEFAULT":                    checkInt(0),
+	"DATABASE_MAX_CONNECTIONS":                 checkInt(0),
+	"DATABASE_FAILOVER_RETRIES":                checkInt(0),
+	"APPROVAL_QUORUM":                          checkInt(1),
+	"DUPLICATE_CONTENT_CACHE_SIZE":             checkInt(1),
+	"DRY_RUN_REPORT_SIZE":                      checkInt(1),
+	"INLINE_MEDIA_MAX_BYTES":                   checkInt(1),
+	"MIRROR_MAX_ATTEMPTS":                      checkInt(1),
+	"BACKUP_KEEP":                              checkInt(1),
+	"ZAP_REQUIRED_SATS":                        checkInt(0),
+	"MAX_CONCURRENT_QUERIES_PER_CONNECTION":    checkInt(1),
+	"RETENTION_ENGAGEMENT_EXTENSION":           checkDuration(false),
+
+	"ALLOWLIST_ENTRY_TTL":        checkDuration(false),
+	"ALLOWLIST_EXPIRY_LEAD_TIME": checkDuration(false),
+	"AUTH_SESSION_MAX_AGE":       checkDuration(false),
+	"BACKDATE_SLACK":             checkDuration(false),
+	"CREATED_AT_MAX_FUTURE":      checkDuration(false),
+	"CREATED_AT_MAX_PAST":        checkDuration(false),
+	"MIN_ACCOUNT_AGE":            checkDuration(false),
+	"POLICY_WEBHOOK_CACHE_TTL":   checkDuration(false),
+	"RETENTION_DEFAULT":          checkDuration(false),
+	"WS_PING_INTERVAL":           checkDuration(false),
+
+	"ALLOWLIST_CACHE_TTL":             checkDuration(true),
+	"ALLOWLIST_EXPIRY_CHECK_INTERVAL": checkDuration(true),
+	"BATCH_INTERVAL":                  checkDuration(true),
+	"METRICS_PUSH_INTERVAL":           checkDuration(true),
+	"CONNECTION_WAIT_TIMEOUT":         checkDuration(true),
+	"DATABASE_FAILOVER_RETRY_DELAY":   checkDuration(true),
+	"DUPLICATE_CONTENT_WINDOW":        checkDuration(true),
+	"KEY_COMPROMISE_WINDOW":           checkDuration(true),
+	"FOLLOWER_COUNT_TTL":              checkDuration(true),
+	"MEMBER_SYNC_INTERVAL":            checkDuration(true),
+	"BACKUP_SCHEDULE":                 checkDuration(true),
+	"MIRROR_CHECK_INTERVAL":           checkDuration(true),
+	"MIRROR_RETRY_BACKOFF":            checkDuration(true),
+	"MIRROR_RETRY_MAX_BACKOFF":        checkDuration(true),
+	"ZAP_PERIOD":                      checkDuration(true),
+	"ZAP_TOTAL_TTL":                   checkDuration(true),
+	"POLICY_WEBHOOK_TIMEOUT":          checkDuration(true),
+	"RETENTION_CHECK_INTERVAL":        checkDuration(true),
+	"SCHEDULE_CHECK_INTERVAL":         checkDuration(true),
+	"SCHEDULE_MAX_DELAY":              checkDuration(true),
+	"SLOW_CLIENT_TIMEOUT":             checkDuration(true),
+	"UPSTREAM_TIMEOUT":                checkDuration(true),
+	"WS_PONG_TIMEOUT":                 checkDuration(true),
+
+	"LOG_REJECT_SAMPLE_RATE": checkSampleRate,
+
+	"OLDEST_FIRST_KINDS":     checkList(checkKind),
+	"SEARCH_KINDS":           checkList(checkKind),
+	"KEY_COMPROMISE_KINDS":   checkList(checkKind),
+	"BLOCKED_ASNS":           checkList(func(item string) error { _, err := parseASN(item); return err }),
+	"BLOCKED_COUNTRIES":      checkList(checkCountryCode),
+	"BOOTSTRAP_RELAYS":       checkList(checkURL("ws", "wss")),
+	"CREATED_AT_KIND_LIMITS": checkList(func(item string) error { _, _, err := parseCreatedAtKindLimit(item); return err }),
+	"DRY_RUN_POLICIES":       checkList(checkDryRunPolicy),
+	"MIRROR_RELAYS":          checkList(checkURL("ws", "wss")),
+	"FEDERATION_PEERS":       checkList(checkURL("ws", "wss")),
+	"OUTPUT_STRIP_TAGS":      nil,
+	"ZAP_RECEIPT_PUBKEYS":    checkList(checkPubkey),
+	"SEARCH_NORMALIZE":       checkList(checkSearchRule),
+	"TRUSTED_PROXIES":        checkList(func(item string) error { _, err := parseTrustedProxy(item); return err }),
+	"R_TAG_ALLOW":            checkList(checkReferenceRule),
+	"R_TAG_BLOCK":            checkList(checkReferenceRule),
+	"REQUIRED_CLIENT_TAGS":   checkList(nil),
+	"BLOCKED_CLIENT_TAGS":    checkList(nil),
+	"VIRTUAL_RELAYS":         checkList(checkVirtualRelay),
+
+	"RELAY_PUBKEY":         checkPubkey,
+	"RELAY_SECRET_KEY":     func(value string) error { _, err := parseSecretKey(value); return err },
+	"DATABASE_URL":         checkDatabaseURL,
+	"DATABASE_SCHEMA":      validateSchemaName,
+	"RELAY_ICON":           checkURL("http", "https"),
+	"POLICY_WEBHOOK_URL":   checkURL("http", "https"),
+	"WEBHOOK_URL":          checkURL("http", "https"),
+	"RATE_LIMIT_DEFAULT":   func(value string) error { _, err := parseRateLimit(value); return err },
+	"QUIET_HOURS":          func(value string) error { _, err := parseQuietHours(value, time.UTC); return err },
+	"QUIET_HOURS_TIMEZONE": func(value string) error { _, err := time.LoadLocation(value); return err },
+	"ROBOTS_TXT_FILE":      checkReadable,
+	"GEOIP_COUNTRY_DB":     checkReadable,
+	"GEOIP_ASN_DB":         checkReadable,
+	"REJECT_MESSAGES_FILE": checkReadable,
+	"REPORT_ACTION":        checkOneOf("flag", "hide"),
+	"RELAY_NAME":           nil,
+	"RELAY_DESCRIPTION":    nil,
+	"EXPIRY_DM_TEMPLATE":   nil,
+
+	"DATABASE_DIVERGENCE":         checkOneOf("warn", "fail"),
+	"REPLACEABLE_ISOLATION":       checkOneOf("read-committed", "serializable"),
+	"PAYMENT_REQUIRED":            checkOneOf("auto", "true", "false"),
+	"D_TAG_VALIDATION":            checkOneOf("off", "lenient", "strict"),
+	"PROFILE_VALIDATION":          checkOneOf("off", "json", "fields"),
+	"KEY_COMPROMISE_ACTION":       checkOneOf("flag", "pause"),
+	"PAYMENTS_URL":                checkURL("http", "https"),
+	"MEMBER_SYNC_URL":             checkURL("http", "https"),
+	"MEMBER_SYNC_AUTH_HEADER":     nil,
+	"BACKUP_S3_URL":               func(value string) error { _, _, _, err := parseS3URL(value); return err },
+	"BACKUP_S3_REGION":            nil,
+	"BACKUP_S3_ACCESS_KEY_ID":     nil,
+	"BACKUP_S3_SECRET_ACCESS_KEY": nil,
+	"METRICS_BACKEND":             checkOneOf("prometheus", "statsd", "otel"),
+	"OTEL_EXPORTER_OTLP_ENDPOINT": checkURL("http", "https"),
+	"FEDERATION_TRUSTED_PUBKEYS":  checkList(checkPubkey),
+	"STATSD_ADDR":                 func(value string) error { _, _, err := net.SplitHostPort(value); return err },
+	"HTTP_RATE_LIMIT_DEFAULT":     func(value string) error { _, err := parseRateLimit(value); return err },
+	"HTTP_RATE_LIMIT_NIP11":       func(value string) error { _, err := parseRateLimit(value); return err },
+	"HTTP_RATE_LIMIT_MANAGEMENT":  func(value string) error { _, err := parseRateLimit(value); return err },
+	"HTTP_RATE_LIMIT_API":         func(value string) error { _, err := parseRateLimit(value); return err },
+	"HTTP_RATE_LIMIT_ADMIN":       func(value string) error { _, err := parseRateLimit(value); return err },
+	"HTTP_RATE_LIMIT_METRICS":     func(value string) error { _, err := parseRateLimit(value); return err },
+	"HTTP_RATE_LIMIT_OTHER":       func(value string) error { _, err := parseRateLimit(value); return err },
+}
+
+// configPrefixChecks cover the per-kind settings, e.g. RATE_LIMIT_KIND_7.
+var configPrefixChecks = map[string]configCheck{
+	"RATE_LIMIT_KIND_": func(value string) error { _, err := parseRateLimit(value); return err },
+	"RETENTION_KIND_":  checkDuration(true),
+}
+
+// dryRunPolicyNames are the names optional policies are registered under in main.
+var dryRunPolicyNames = []string{
+	"rate_limit", "min_account_age", "duplicate_content", "min_followers", "owner_auth",
+	"backdated", "max_event_tags", "max_mentions", "client_tags", "r_tags", "created_at_past",
+	"created_at_future", "delegation", "quiet_hours", "stored_parent", "policy_webhook",
+	"storage_quota", "inline_media", "zap_required", "min_wot_score", "d_tag", "profile",
+	"key_compromise",
+}
+
+func checkBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("%q is not a boolean", value)
+	}
+	return nil
+}
+
+func checkInt(min int) configCheck {
+	return func(value string) error {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		if number < min {
+			return fmt.Errorf("must be at least %d", min)
+		}
+		return nil
+	}
+}
+
+func checkDuration(positive bool) configCheck {
+	return func(value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration (e.g. 90s, 24h)", value)
+		}
+		if positive && duration <= 0 {
+			return fmt.Errorf("must be positive")
+		}
+		if duration < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+		return nil
+	}
+}
+
+func checkOneOf(values ...string) configCheck {
+	return func(value string) error {
+		if !slices.Contains(values, value) {
+			return fmt.Errorf("%q must be one of %s", value, strings.Join(values, ", "))
+		}
+		return nil
+	}
+}
+
+func checkSampleRate(value string) error {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("must be between 0 and 1")
+	}
+	return nil
+}
+
+// checkList applies check to each item of a comma-separated list; a nil check accepts any item.
+func checkList(check func(item string) error) configCheck {
+	return func(value string) error {
+		if check == nil {
+			return nil
+		}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			if err := check(item); err != nil {
+				return fmt.Errorf("%q: %v", item, err)
+			}
+		}
+		return nil
+	}
+}
+
+func checkKind(item string) error {
+	if kind, err := strconv.Atoi(item); err != nil || kind < 0 {
+		return fmt.Errorf("not a kind number")
+	}
+	return nil
+}
+
+func checkCountryCode(item string) error {
+	if len(item) != 2 {
+		return fmt.Errorf("not a two-letter country code")
+	}
+	for _, r := range strings.ToUpper(item) {
+		if r < 'A' || r > 'Z' {
+			return fmt.Errorf("not a two-letter country code")
+		}
+	}
+	return nil
+}
+
+func checkDryRunPolicy(item string) error {
+	if !slices.Contains(dryRunPolicyNames, item) {
+		return fmt.Errorf("no such policy (known: %s)", strings.Join(dryRunPolicyNames, ", "))
+	}
+	return nil
+}
+
+func checkSearchRule(item string) error {
+	if _, unknown := parseSearchRules([]string{item}); len(unknown) > 0 {
+		return fmt.Errorf("no such rule")
+	}
+	return nil
+}
+
+func checkReferenceRule(item string) error {
+	if strings.Contains(item, "://") {
+		return checkURL("http", "https")(item)
+	}
+	if strings.ContainsAny(item, "/ ") {
+		return fmt.Errorf("expected a domain or an http(s) URL")
+	}
+	return nil
+}
+
+func checkVirtualRelay(item string) error {
+	host, envFile, ok := strings.Cut(item, "=")
+	if !ok || normalizeHost(host) == "" || strings.TrimSpace(envFile) == "" {
+		return fmt.Errorf("expected host=env-file")
+	}
+	return checkReadable(strings.TrimSpace(envFile))
+}
+
+func checkURL(schemes ...string) configCheck {
+	return func(value string) error {
+		parsed, err := url.Parse(value)
+		if err != nil || parsed.Host == "" {
+			return fmt.Errorf("%q is not an absolute URL", value)
+		}
+		if !slices.Contains(schemes, strings.ToLower(parsed.Scheme)) {
+			return fmt.Errorf("%q must use %s", value, strings.Join(schemes, " or "))
+		}
+		return nil
+	}
+}
+
+func checkPubkey(value string) error {
+	if !nostr.IsValidPublicKey(value) {
+		return fmt.Errorf("must be a 64-character lowercase hex public key")
+	}
+	return nil
+}
+
+// checkDatabaseURL accepts postgres URLs and lib/pq "key=value" connection strings.
+func checkDatabaseURL(value string) error {
+	if !strings.Contains(value, "://") {
+		return nil
+	}
+	return checkURL("postgres", "postgresql")(value)
+}
+
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// configResult is the outcome of validating one setting.
+type configResult struct {
+	key     string
+	err     error
+	warning string
+}
+
+// validateConfig checks each setting in env. Unknown settings are only reported when strict,
+// since the process environment holds plenty of unrelated variables.
+func validateConfig(env map[string]string, strict bool) []configResult {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var results []configResult
+	for _, key := range keys {
+		check, known := lookupConfigCheck(key)
+		if !known {
+			if strict {
+				results = append(results, configResult{key: key, warning: "not a setting the relay reads, check for typos"})
+			}
+			continue
+		}
+		result := configResult{key: key}
+		if value := env[key]; value != "" && check != nil {
+			result.err = check(value)
+		}
+		results = append(results, result)
+	}
+
+	// settings that only work together
+	requires := []struct{ key, needs, why string }{
+		{"BLOCKED_COUNTRIES", "GEOIP_COUNTRY_DB", "country blocking needs a GeoIP country database"},
+		{"BLOCKED_ASNS", "GEOIP_ASN_DB", "ASN blocking needs a GeoIP ASN database"},
+		{"QUIET_HOURS_TIMEZONE", "QUIET_HOURS", "the time zone only applies to quiet hours"},
+		{"FEDERATION_TRUSTED_PUBKEYS", "FEDERATION_PEERS", "peers are only trusted in a federation"},
+		{"KEY_COMPROMISE_ACTION", "KEY_COMPROMISE_CHANGES", "the key compromise detector is off without a change limit"},
+		{"KEY_COMPROMISE_WINDOW", "KEY_COMPROMISE_CHANGES", "the key compromise detector is off without a change limit"},
+		{"KEY_COMPROMISE_KINDS", "KEY_COMPROMISE_CHANGES", "the key compromise detector is off without a change limit"},
+	}
+	for _, r := range requires {
+		if env[r.key] != "" && env[r.needs] == "" {
+			results = append(results, configResult{key: r.key, err: fmt.Errorf("%s is not set: %s", r.needs, r.why)})
+		}
+	}
+	if enabled, _ := strconv.ParseBool(env["EXPIRY_DM"]); enabled && env["RELAY_SECRET_KEY"] == "" {
+		results = append(results, configResult{key: "EXPIRY_DM", err: fmt.Errorf("RELAY_SECRET_KEY is not set: reminders are signed with the relay's key")})
+	}
+	if enabled, _ := strconv.ParseBool(env["FEDERATION_SYNC_ALLOWLIST"]); enabled && env["RELAY_SECRET_KEY"] == "" {
+		results = append(results, configResult{key: "FEDERATION_SYNC_ALLOWLIST", err: fmt.Errorf("RELAY_SECRET_KEY is not set: forwarded changes are signed with the relay's key")})
+	}
+	return results
+}
+
+func lookupConfigCheck(key string) (configCheck, bool) {
+	if check, ok := configChecks[key]; ok {
+		return check, true
+	}
+	for prefix, check := range configPrefixChecks {
+		if kind, ok := strings.CutPrefix(key, prefix); ok {
+			if checkKind(kind) != nil {
+				return func(string) error { return fmt.Errorf("%q is not a kind number", kind) }, true
+			}
+			return check, true
+		}
+	}
+	return nil, false
+}
+
+// readEnvFile reads KEY=VALUE lines, as used by `docker run --env-file` and compose. Blank
+// lines and # comments are skipped, and values may be wrapped in single or double quotes.
+func readEnvFile(r io.Reader) (map[string]string, error) {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[key] = value
+	}
+	return env, scanner.Err()
+}
+
+// readEnvFileAt reads the env file at path.
+func readEnvFileAt(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env, err := readEnvFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return env, nil
+}
+
+// checkDatabase connects to the database without creating any tables.
+func checkDatabase(ctx context.Context, databaseURL string) error {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// runValidateConfig checks an env file, or the current environment, without starting the relay:
+//
+//	brove validate-config [--check-db] [path/to/.env]
+func runValidateConfig(args []string) int {
+	flags := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	checkDB := flags.Bool("check-db", false, "also check that DATABASE_URL is reachable")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: brove validate-config [--check-db] [env-file]")
+		return 2
+	}
+
+	var env map[string]string
+	strict := flags.NArg() == 1
+	if strict {
+		var err error
+		if env, err = readEnvFileAt(flags.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+	} else {
+		env = make(map[string]string)
+		for _, entry := range os.Environ() {
+			key, value, _ := strings.Cut(entry, "=")
+			env[key] = value
+		}
+	}
+
+	results := validateConfig(env, strict)
+	if *checkDB {
+		databaseURL := env["DATABASE_URL"]
+		if databaseURL == "" {
+			databaseURL = defaultDatabaseURL
+		}
+		result := configResult{key: "database"}
+		if err := checkDatabase(context.Background(), databaseURL); err != nil {
+			result.err = fmt.Errorf("not reachable: %w", err)
+		}
+		results = append(results, result)
+	}
+	return printConfigReport(os.Stdout, results)
+}
+
+// printConfigReport writes one line per result and a summary, returning the exit code.
+func printConfigReport(w io.Writer, results []configResult) int {
+	var errors, warnings int
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			errors++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", result.key, result.err)
+		case result.warning != "":
+			warnings++
+			fmt.Fprintf(w, "WARN  %s: %s\n", result.key, result.warning)
+		default:
+			fmt.Fprintf(w, "ok    %s\n", result.key)
+		}
+	}
+
+	if errors > 0 {
+		fmt.Fprintf(w, "\nFAIL: %d errors, %d warnings\n", errors, warnings)
+		return 1
+	}
+	fmt.Fprintf(w, "\nPASS: %d settings checked, %d warnings\n", len(results)-warnings, warnings)
+	return 0
+}
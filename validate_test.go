@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	env, err := readEnvFile(strings.NewReader(`
+# relay identity
+RELAY_NAME="my relay"
+export RELAY_DESCRIPTION='a relay'
+MAX_CONNECTIONS = 100
+QUIET_HOURS=
+`))
+	if err != nil {
+		t.Fatalf("readEnvFile: %v", err)
+	}
+	want := map[string]string{
+		"RELAY_NAME":        "my relay",
+		"RELAY_DESCRIPTION": "a relay",
+		"MAX_CONNECTIONS":   "100",
+		"QUIET_HOURS":       "",
+	}
+	if len(env) != len(want) {
+		t.Fatalf("expected %v, got %v", want, env)
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("expected %s=%q, got %q", key, value, env[key])
+		}
+	}
+
+	if _, err := readEnvFile(strings.NewReader("RELAY_NAME\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected a line number in the error, got %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	_, pubkey := newKeypair(t)
+	env := map[string]string{
+		"RELAY_PUBKEY":           pubkey,
+		"DATABASE_URL":           "mysql://localhost/relay",
+		"MAX_CONNECTIONS":        "-5",
+		"WS_PONG_TIMEOUT":        "0s",
+		"LOG_REJECT_SAMPLE_RATE": "0.5",
+		"DRY_RUN_POLICIES":       "rate_limit, min_followrs",
+		"CREATED_AT_KIND_LIMITS": "1:1h:5m",
+		"CREATED_AT_MAX_PAST":    "-24h",
+		"RATE_LIMIT_KIND_7":      "60/min",
+		"RETENTION_KIND_x":       "24h",
+		"BLOCKED_COUNTRIES":      "US",
+		"QUIET_HOURS":            "",
+		"MAX_CONECTIONS":         "10",
+	}
+
+	results := make(map[string]configResult)
+	for _, result := range validateConfig(env, true) {
+		results[result.key] = result
+	}
+
+	for _, key := range []string{"RELAY_PUBKEY", "LOG_REJECT_SAMPLE_RATE", "CREATED_AT_KIND_LIMITS", "RATE_LIMIT_KIND_7", "QUIET_HOURS"} {
+		if result, ok := results[key]; !ok || result.err != nil || result.warning != "" {
+			t.Errorf("expected %s to pass, got %+v", key, result)
+		}
+	}
+	for _, key := range []string{"DATABASE_URL", "MAX_CONNECTIONS", "WS_PONG_TIMEOUT", "DRY_RUN_POLICIES", "CREATED_AT_MAX_PAST", "RETENTION_KIND_x", "BLOCKED_COUNTRIES"} {
+		if result := results[key]; result.err == nil {
+			t.Errorf("expected %s to fail", key)
+		}
+	}
+	if !strings.Contains(results["BLOCKED_COUNTRIES"].err.Error(), "GEOIP_COUNTRY_DB") {
+		t.Errorf("expected BLOCKED_COUNTRIES to need a GeoIP database, got %v", results["BLOCKED_COUNTRIES"].err)
+	}
+	if results["MAX_CONECTIONS"].warning == "" {
+		t.Errorf("expected a warning for the misspelled MAX_CONECTIONS")
+	}
+
+	// the process environment is full of unrelated variables
+	for _, result := range validateConfig(map[string]string{"HOME": "/root"}, false) {
+		t.Errorf("expected no result for unrelated variables, got %+v", result)
+	}
+}
+
+func TestPrintConfigReport(t *testing.T) {
+	var out bytes.Buffer
+	if code := printConfigReport(&out, []configResult{{key: "RELAY_NAME"}, {key: "TYPO", warning: "unknown"}}); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	if !strings.Contains(out.String(), "PASS: 1 settings checked, 1 warnings") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+
+	out.Reset()
+	results := validateConfig(map[string]string{"MAX_E_TAGS": "lots"}, true)
+	if code := printConfigReport(&out, results); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(out.String(), `FAIL  MAX_E_TAGS: "lots" is not an integer`) || !strings.Contains(out.String(), "FAIL: 1 errors") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// version can be set at build time with -ldflags "-X main.version=v1.2.3".
+var version = ""
+
+// fallbackVersion is advertised when neither ldflags nor build info name a version.
+const fallbackVersion = "0.1.0"
+
+const defaultSoftware = "https://github.com/mroxso/brove"
+
+// buildVersion returns the version of the running binary: the ldflags value if set, otherwise
+// the module version from the build info (for `go install ...@version` builds), otherwise the
+// fallback, with the VCS revision appended when the build info has one.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fallbackVersion
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	v := fallbackVersion
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			v += "+" + setting.Value[:12]
+		}
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.modified" && setting.Value == "true" {
+			v += ".dirty"
+		}
+	}
+	return v
+}
+
+// buildSoftware returns the URL of the software, taken from the main module path.
+func buildSoftware() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Path != "" && info.Main.Path != "command-line-arguments" {
+		return "https://" + info.Main.Path
+	}
+	return defaultSoftware
+}
+
+// runVersion prints the version information and exits.
+func runVersion(args []string) int {
+	fmt.Printf("brove %s (%s)\n", buildVersion(), buildSoftware())
+	return 0
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestBuildVersionPrefersLdflags(t *testing.T) {
+	previous := version
+	t.Cleanup(func() { version = previous })
+
+	version = "v1.2.3"
+	if got := buildVersion(); got != "v1.2.3" {
+		t.Fatalf("expected the ldflags version, got %q", got)
+	}
+
+	version = ""
+	if got := buildVersion(); got == "" {
+		t.Fatal("expected a version from the build info or the fallback")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// virtualRelay is a relay hosted alongside the main one, configured by an env file whose
+// settings apply over the process environment.
+type virtualRelay struct {
+	host    string
+	envFile string
+}
+
+// parseVirtualRelays parses "host=env-file" entries. Invalid and repeated entries are logged
+// and skipped.
+func parseVirtualRelays(entries []string) []virtualRelay {
+	var relays []virtualRelay
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		host, envFile, ok := strings.Cut(entry, "=")
+		host = normalizeHost(host)
+		envFile = strings.TrimSpace(envFile)
+		if !ok || host == "" || envFile == "" {
+			log.Printf("Ignoring invalid virtual relay %q, expected host=env-file", entry)
+			continue
+		}
+		if seen[host] {
+			log.Printf("Ignoring virtual relay %q, %s is already configured", entry, host)
+			continue
+		}
+		seen[host] = true
+		relays = append(relays, virtualRelay{host: host, envFile: envFile})
+	}
+	return relays
+}
+
+// normalizeHost lowercases a host name and drops its port and trailing dot.
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// virtualHosts routes requests to a relay by their Host header. Hosts without a relay of
+// their own get the fallback.
+type virtualHosts struct {
+	fallback http.Handler
+	hosts    map[string]http.Handler
+}
+
+func newVirtualHosts(fallback http.Handler) *virtualHosts {
+	return &virtualHosts{fallback: fallback, hosts: make(map[string]http.Handler)}
+}
+
+func (v *virtualHosts) add(host string, handler http.Handler) {
+	v.hosts[normalizeHost(host)] = handler
+}
+
+func (v *virtualHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := v.hosts[normalizeHost(r.Host)]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	v.fallback.ServeHTTP(w, r)
+}
+
+// validateSchemaName only allows plain lowercase names, which need no quoting in search_path.
+func validateSchemaName(schema string) error {
+	for i, r := range schema {
+		if (r < 'a' || r > 'z') && r != '_' && (i == 0 || r < '0' || r > '9') {
+			return fmt.Errorf("invalid schema name %q: use lowercase letters, digits and underscores", schema)
+		}
+	}
+	return nil
+}
+
+// ensureSchema creates a schema if it does not exist yet.
+func ensureSchema(databaseURL, schema string) error {
+	if err := validateSchemaName(schema); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE SCHEMA IF NOT EXISTS ` + pq.QuoteIdentifier(schema))
+	return err
+}
+
+// databaseURLWithSchema makes connections of databaseURL use schema for all tables, in URL as
+// well as "key=value" form.
+func databaseURLWithSchema(databaseURL, schema string) (string, error) {
+	if !strings.Contains(databaseURL, "://") {
+		return databaseURL + " search_path=" + schema, nil
+	}
+	parsed, err := url.Parse(databaseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid database URL: %w", err)
+	}
+	query := parsed.Query()
+	query.Set("search_path", schema)
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// cliDatabaseURL is the database of the relay the CLI commands manage: DATABASE_URL, in
+// DATABASE_SCHEMA if set. Run them with a virtual relay's settings to manage that relay.
+func cliDatabaseURL() (string, error) {
+	databaseURL := getEnv("DATABASE_URL", defaultDatabaseURL)
+	schema := getEnv("DATABASE_SCHEMA", "")
+	if schema == "" {
+		return databaseURL, nil
+	}
+	if err := validateSchemaName(schema); err != nil {
+		return "", err
+	}
+	return databaseURLWithSchema(databaseURL, schema)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestParseVirtualRelays(t *testing.T) {
+	relays := parseVirtualRelays([]string{
+		"Relay-A.example.com=/etc/brove/a.env",
+		"relay-b.example.com:443 = /etc/brove/b.env",
+		"relay-a.example.com=/etc/brove/other.env",
+		"missing-file.example.com=",
+		"no-separator",
+	})
+	want := []virtualRelay{
+		{host: "relay-a.example.com", envFile: "/etc/brove/a.env"},
+		{host: "relay-b.example.com", envFile: "/etc/brove/b.env"},
+	}
+	if !slices.Equal(relays, want) {
+		t.Errorf("expected %v, got %v", want, relays)
+	}
+}
+
+func TestVirtualHostsRouteByHost(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(name)) })
+	}
+	hosts := newVirtualHosts(named("main"))
+	hosts.add("a.example.com", named("a"))
+	hosts.add("B.example.com", named("b"))
+
+	for host, want := range map[string]string{
+		"a.example.com":      "a",
+		"A.Example.com:3334": "a",
+		"b.example.com.":     "b",
+		"c.example.com":      "main",
+		"localhost:3334":     "main",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		hosts.ServeHTTP(rec, req)
+		if rec.Body.String() != want {
+			t.Errorf("host %s: expected the %s relay, got %s", host, want, rec.Body.String())
+		}
+	}
+}
+
+func TestDatabaseURLWithSchema(t *testing.T) {
+	for input, want := range map[string]string{
+		"postgresql://postgres:postgres@db:5432/relay?sslmode=disable": "postgresql://postgres:postgres@db:5432/relay?search_path=tenant_a&sslmode=disable",
+		"host=db dbname=relay": "host=db dbname=relay search_path=tenant_a",
+	} {
+		if got, err := databaseURLWithSchema(input, "tenant_a"); err != nil || got != want {
+			t.Errorf("%s: expected %s, got %s (%v)", input, want, got, err)
+		}
+	}
+
+	for name, valid := range map[string]bool{"tenant_a": true, "relay2": true, "2relay": false, "Tenant": false, "a;drop": false} {
+		if err := validateSchemaName(name); (err == nil) != valid {
+			t.Errorf("schema %q: expected valid=%v, got %v", name, valid, err)
+		}
+	}
+}
+
+func TestWithEnvOverridesSettings(t *testing.T) {
+	t.Setenv("RELAY_NAME", "main relay")
+	t.Setenv("RATE_LIMIT_KIND_1", "10/min")
+
+	withEnv(map[string]string{"RELAY_NAME": "relay a", "RATE_LIMIT_KIND_7": "5/min"}, func() {
+		if name := getEnv("RELAY_NAME", ""); name != "relay a" {
+			t.Errorf("expected the override, got %q", name)
+		}
+		limits, _ := loadKindRateLimits()
+		if limits[1].events != 10 || limits[7].events != 5 {
+			t.Errorf("expected per-kind limits from both the environment and the overrides, got %v", limits)
+		}
+	})
+
+	if name := getEnv("RELAY_NAME", ""); name != "main relay" {
+		t.Errorf("expected the process environment after setup, got %q", name)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// contactList is the part of a stored contact list (kind 3) the trust graph needs.
+type contactList struct {
+	createdAt nostr.Timestamp
+	follows   map[string]bool
+}
+
+// newContactList reads the followed pubkeys out of a contact list, each once and without the
+// author, who cannot vouch for themselves.
+func newContactList(event *nostr.Event) contactList {
+	list := contactList{createdAt: event.CreatedAt, follows: make(map[string]bool)}
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "p" && tag[1] != event.PubKey {
+			list.follows[tag[1]] = true
+		}
+	}
+	return list
+}
+
+// trustGraph scores pubkeys by how many allowed pubkeys follow them. It keeps the latest
+// contact list of every author in memory, and the reverse index of who follows whom, and is
+// updated as contact lists are stored. Whether a follower is allowed is checked when a score is
+// asked for, so changes to the allowlist apply right away.
+type trustGraph struct {
+	isAllowed func(pubkey string) (bool, error)
+
+	mu        sync.RWMutex
+	lists     map[string]contactList
+	followers map[string]map[string]bool
+}
+
+func newTrustGraph(isAllowed func(pubkey string) (bool, error)) *trustGraph {
+	return &trustGraph{
+		isAllowed: isAllowed,
+		lists:     make(map[string]contactList),
+		followers: make(map[string]map[string]bool),
+	}
+}
+
+// loadContactLists adds every contact list in the event store to the graph.
+func (g *trustGraph) loadContactLists(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `SELECT pubkey, created_at, tags FROM event WHERE kind = 3`)
+	if err != nil {
+		return fmt.Errorf("failed to load contact lists: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := nostr.Event{Kind: nostr.KindFollowList}
+		var tags []byte
+		if err := rows.Scan(&event.PubKey, &event.CreatedAt, &tags); err != nil {
+			return fmt.Errorf("failed to scan contact list: %w", err)
+		}
+		if err := json.Unmarshal(tags, &event.Tags); err != nil {
+			return fmt.Errorf("failed to decode contact list of %s: %w", event.PubKey, err)
+		}
+		g.add(&event)
+	}
+	return rows.Err()
+}
+
+// add replaces the author's contact list with event, unless the graph has a newer one.
+func (g *trustGraph) add(event *nostr.Event) {
+	list := newContactList(event)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if previous, ok := g.lists[event.PubKey]; ok {
+		if previous.createdAt > list.createdAt {
+			return
+		}
+		for followed := range previous.follows {
+			delete(g.followers[followed], event.PubKey)
+			if len(g.followers[followed]) == 0 {
+				delete(g.followers, followed)
+			}
+		}
+	}
+	g.lists[event.PubKey] = list
+	for followed := range list.follows {
+		if g.followers[followed] == nil {
+			g.followers[followed] = make(map[string]bool)
+		}
+		g.followers[followed][event.PubKey] = true
+	}
+}
+
+// onSaved keeps the graph up to date with stored contact lists.
+func (g *trustGraph) onSaved(ctx context.Context, event *nostr.Event) {
+	if event.Kind == nostr.KindFollowList {
+		g.add(event)
+	}
+}
+
+// score returns how many allowed pubkeys follow pubkey.
+func (g *trustGraph) score(pubkey string) (int, error) {
+	g.mu.RLock()
+	followers := make([]string, 0, len(g.followers[pubkey]))
+	for follower := range g.followers[pubkey] {
+		followers = append(followers, follower)
+	}
+	g.mu.RUnlock()
+
+	score := 0
+	for _, follower := range followers {
+		allowed, err := g.isAllowed(follower)
+		if err != nil {
+			return 0, err
+		}
+		if allowed {
+			score++
+		}
+	}
+	return score, nil
+}
+
+// wotGate rejects writers that are not allowed themselves unless at least min allowed pubkeys
+// follow them, a softer gate than the allowlist that lets the members vouch for others.
+type wotGate struct {
+	graph *trustGraph
+	min   int
+}
+
+func (g wotGate) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	allowed, err := g.graph.isAllowed(event.PubKey)
+	if err != nil {
+		log.Printf("Error checking if pubkey is allowed: %v", err)
+		return true, "error checking authorization"
+	}
+	if allowed {
+		return false, ""
+	}
+
+	score, err := g.graph.score(event.PubKey)
+	if err != nil {
+		log.Printf("Error computing web of trust score: %v", err)
+		return true, "error: could not verify reputation, try again later"
+	}
+	if score < g.min {
+		return true, "blocked: insufficient web of trust score"
+	}
+	return false, ""
+}
+
+// handleAdminWoT serves /admin/wot: GET ?pubkey=<hex> returns the pubkey's current score and
+// the score required to write.
+func handleAdminWoT(gate wotGate) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		pubkey := strings.ToLower(r.URL.Query().Get("pubkey"))
+		if err := validatePubkey(pubkey); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		score, err := gate.graph.score(pubkey)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"pubkey": pubkey, "score": score, "min_score": gate.min})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func contactListEvent(author string, createdAt nostr.Timestamp, follows ...string) *nostr.Event {
+	event := &nostr.Event{PubKey: author, Kind: nostr.KindFollowList, CreatedAt: createdAt}
+	for _, followed := range follows {
+		event.Tags = append(event.Tags, nostr.Tag{"p", followed})
+	}
+	return event
+}
+
+func TestTrustGraphScore(t *testing.T) {
+	members := map[string]bool{"alice": true, "bob": true, "carol": true}
+	graph := newTrustGraph(func(pubkey string) (bool, error) { return members[pubkey], nil })
+
+	graph.onSaved(context.Background(), contactListEvent("alice", 10, "dave", "dave", "erin"))
+	graph.onSaved(context.Background(), contactListEvent("bob", 10, "dave"))
+	graph.onSaved(context.Background(), contactListEvent("mallory", 10, "dave", "erin"))
+	graph.onSaved(context.Background(), contactListEvent("erin", 10, "erin", "dave"))
+	graph.onSaved(context.Background(), &nostr.Event{PubKey: "carol", Kind: nostr.KindTextNote, Tags: nostr.Tags{{"p", "erin"}}})
+
+	scores := func() map[string]int {
+		got := make(map[string]int)
+		for _, pubkey := range []string{"dave", "erin", "frank"} {
+			score, err := graph.score(pubkey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[pubkey] = score
+		}
+		return got
+	}
+	// duplicates, self-follows, other kinds and follows by pubkeys that are not allowed don't count
+	want := map[string]int{"dave": 2, "erin": 1, "frank": 0}
+	if got := scores(); !maps.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// a newer contact list replaces the old one, an older one is ignored
+	graph.onSaved(context.Background(), contactListEvent("alice", 20, "frank"))
+	graph.onSaved(context.Background(), contactListEvent("bob", 5, "erin", "frank"))
+	want = map[string]int{"dave": 1, "erin": 0, "frank": 1}
+	if got := scores(); !maps.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// changes to the allowlist apply right away
+	members["erin"] = true
+	delete(members, "bob")
+	want = map[string]int{"dave": 1, "erin": 0, "frank": 1}
+	if got := scores(); !maps.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestWoTGate(t *testing.T) {
+	members := map[string]bool{"alice": true, "bob": true}
+	graph := newTrustGraph(func(pubkey string) (bool, error) {
+		if pubkey == "broken" {
+			return false, errors.New("db down")
+		}
+		return members[pubkey], nil
+	})
+	graph.add(contactListEvent("alice", 1, "dave", "erin"))
+	graph.add(contactListEvent("bob", 1, "dave"))
+	gate := wotGate{graph: graph, min: 2}
+
+	tests := []struct {
+		pubkey string
+		reject bool
+		msg    string
+	}{
+		{"alice", false, ""},
+		{"dave", false, ""},
+		{"erin", true, "blocked: insufficient web of trust score"},
+		{"frank", true, "blocked: insufficient web of trust score"},
+		{"broken", true, "error checking authorization"},
+	}
+	for _, tt := range tests {
+		reject, msg := gate.reject(context.Background(), &nostr.Event{PubKey: tt.pubkey})
+		if reject != tt.reject || msg != tt.msg {
+			t.Errorf("%s: expected (%t, %q), got (%t, %q)", tt.pubkey, tt.reject, tt.msg, reject, msg)
+		}
+	}
+
+	dave := fakeID(1)
+	graph.add(contactListEvent("alice", 2, dave))
+	graph.add(contactListEvent("bob", 2, dave))
+	rec := httptest.NewRecorder()
+	handleAdminWoT(gate)(rec, httptest.NewRequest(http.MethodGet, "/admin/wot?pubkey="+dave, nil))
+	var body struct {
+		Pubkey   string `json:"pubkey"`
+		Score    int    `json:"score"`
+		MinScore int    `json:"min_score"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || body.Pubkey != dave || body.Score != 2 || body.MinScore != 2 {
+		t.Errorf("unexpected response %d %+v", rec.Code, body)
+	}
+
+	rec = httptest.NewRecorder()
+	handleAdminWoT(gate)(rec, httptest.NewRequest(http.MethodGet, "/admin/wot?pubkey=npub1xyz", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid pubkey to be refused, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// bolt11Msats returns the amount of a BOLT-11 invoice in millisatoshis, read from its
+// human-readable part, e.g. lnbc2500u1... for 250,000,000 msats.
+func bolt11Msats(invoice string) (int64, error) {
+	invoice = strings.ToLower(invoice)
+	separator := strings.LastIndexByte(invoice, '1')
+	if !strings.HasPrefix(invoice, "ln") || separator < 0 {
+		return 0, fmt.Errorf("not a bolt11 invoice")
+	}
+	hrp := strings.TrimLeft(invoice[2:separator], "abcdefghijklmnopqrstuvwxyz")
+	if hrp == "" {
+		return 0, fmt.Errorf("invoice without an amount")
+	}
+
+	// multipliers of a bitcoin, expressed in msats per unit
+	perUnit := map[byte]float64{'m': 1e8, 'u': 1e5, 'n': 1e2, 'p': 1e-1}
+	multiplier := 1e11
+	if m, ok := perUnit[hrp[len(hrp)-1]]; ok {
+		multiplier = m
+		hrp = hrp[:len(hrp)-1]
+	}
+	amount, err := strconv.ParseInt(hrp, 10, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("invalid invoice amount %q", hrp)
+	}
+	return int64(float64(amount) * multiplier), nil
+}
+
+// parseZapReceipt checks a NIP-57 zap receipt (kind 9735) for recipient and returns who paid
+// it and how much. The receipt must embed a signed zap request (kind 9734) for recipient whose
+// amount, if given, matches the invoice. When issuers is not empty, only receipts signed by one
+// of them count: anyone can publish a receipt, only the recipient's LNURL server should.
+func parseZapReceipt(receipt *nostr.Event, recipient string, issuers []string) (sender string, msats int64, err error) {
+	if receipt.Kind != nostr.KindZap {
+		return "", 0, fmt.Errorf("not a zap receipt")
+	}
+	if len(issuers) > 0 && !slices.Contains(issuers, receipt.PubKey) {
+		return "", 0, fmt.Errorf("receipt issued by %s", receipt.PubKey)
+	}
+	if tag := receipt.Tags.Find("p"); tag == nil || tag[1] != recipient {
+		return "", 0, fmt.Errorf("receipt for someone else")
+	}
+
+	description, bolt11 := receipt.Tags.Find("description"), receipt.Tags.Find("bolt11")
+	if description == nil || bolt11 == nil {
+		return "", 0, fmt.Errorf("receipt without description or bolt11")
+	}
+	var request nostr.Event
+	if err := json.Unmarshal([]byte(description[1]), &request); err != nil {
+		return "", 0, fmt.Errorf("invalid zap request: %w", err)
+	}
+	if request.Kind != nostr.KindZapRequest {
+		return "", 0, fmt.Errorf("description is not a zap request")
+	}
+	if ok, _ := request.CheckSignature(); !ok {
+		return "", 0, fmt.Errorf("zap request with an invalid signature")
+	}
+	if tag := request.Tags.Find("p"); tag == nil || tag[1] != recipient {
+		return "", 0, fmt.Errorf("zap request for someone else")
+	}
+
+	msats, err = bolt11Msats(bolt11[1])
+	if err != nil {
+		return "", 0, err
+	}
+	if tag := request.Tags.Find("amount"); tag != nil && tag[1] != strconv.FormatInt(msats, 10) {
+		return "", 0, fmt.Errorf("invoice amount does not match the zap request")
+	}
+	return request.PubKey, msats, nil
+}
+
+// zapTotalLookup returns how many msats pubkey has zapped the owner since a point in time.
+type zapTotalLookup func(ctx context.Context, pubkey string, since time.Time) (int64, error)
+
+// storedZapTotal sums the zap receipts for owner in the event store. The sender only appears
+// inside the embedded zap request, so the query narrows the receipts down by text and each
+// candidate is verified with parseZapReceipt.
+func storedZapTotal(db *sql.DB, owner string, issuers []string) zapTotalLookup {
+	return func(ctx context.Context, pubkey string, since time.Time) (int64, error) {
+		query := `
+		SELECT pubkey, created_at, kind, tags FROM event
+		WHERE kind = 9735 AND created_at >= $1 AND tagvalues @> ARRAY[$2] AND tags::text LIKE '%' || $3 || '%'`
+		rows, err := db.QueryContext(ctx, query, since.Unix(), owner, pubkey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load zap receipts of %s: %w", pubkey, err)
+		}
+		defer rows.Close()
+
+		var total int64
+		for rows.Next() {
+			var receipt nostr.Event
+			var tags []byte
+			if err := rows.Scan(&receipt.PubKey, &receipt.CreatedAt, &receipt.Kind, &tags); err != nil {
+				return 0, fmt.Errorf("failed to scan zap receipt: %w", err)
+			}
+			if err := json.Unmarshal(tags, &receipt.Tags); err != nil {
+				return 0, fmt.Errorf("failed to decode zap receipt tags: %w", err)
+			}
+			if sender, msats, err := parseZapReceipt(&receipt, owner, issuers); err == nil && sender == pubkey {
+				total += msats
+			}
+		}
+		return total, rows.Err()
+	}
+}
+
+type zapTotalEntry struct {
+	msats     int64
+	checkedAt time.Time
+}
+
+// zapGate turns stored zap receipts into a lightweight payment gate: writers must have zapped
+// the owner at least minMsats within period. Totals are cached for ttl and bumped as new
+// receipts are saved, so they are approximate between lookups.
+type zapGate struct {
+	lookup   zapTotalLookup
+	owner    string
+	issuers  []string
+	minMsats int64
+	period   time.Duration
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]zapTotalEntry
+}
+
+func newZapGate(lookup zapTotalLookup, owner string, issuers []string, minMsats int64, period, ttl time.Duration) *zapGate {
+	return &zapGate{
+		lookup:   lookup,
+		owner:    owner,
+		issuers:  issuers,
+		minMsats: minMsats,
+		period:   period,
+		ttl:      ttl,
+		now:      time.Now,
+		cache:    make(map[string]zapTotalEntry),
+	}
+}
+
+// reject refuses writers without enough zaps. The owner is exempt, and so are zap receipts,
+// which the gate itself depends on.
+func (g *zapGate) reject(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if event.PubKey == g.owner || event.Kind == nostr.KindZap {
+		return false, ""
+	}
+
+	now := g.now()
+	g.mu.Lock()
+	entry, ok := g.cache[event.PubKey]
+	g.mu.Unlock()
+
+	if !ok || now.Sub(entry.checkedAt) > g.ttl {
+		msats, err := g.lookup(ctx, event.PubKey, now.Add(-g.period))
+		if err != nil {
+			log.Printf("Error looking up zap total: %v", err)
+			return true, "error: could not verify zaps, try again later"
+		}
+		entry = zapTotalEntry{msats: msats, checkedAt: now}
+		g.mu.Lock()
+		g.cache[event.PubKey] = entry
+		g.mu.Unlock()
+	}
+
+	if entry.msats < g.minMsats {
+		return true, "blocked: zap required"
+	}
+	return false, ""
+}
+
+// onSaved adds a saved zap receipt to the cached total of its sender, so a writer who has just
+// zapped does not have to wait for the cache to expire.
+func (g *zapGate) onSaved(ctx context.Context, event *nostr.Event) {
+	if event.Kind != nostr.KindZap {
+		return
+	}
+	sender, msats, err := parseZapReceipt(event, g.owner, g.issuers)
+	if err != nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if entry, ok := g.cache[sender]; ok {
+		entry.msats += msats
+		g.cache[sender] = entry
+	}
+}
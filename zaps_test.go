@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// zapReceipt returns a receipt issued by issuer for a zap of msats from senderSK to recipient.
+func zapReceipt(t *testing.T, issuer, senderSK, recipient, invoice, amount string) *nostr.Event {
+	t.Helper()
+	request := &nostr.Event{Kind: nostr.KindZapRequest, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", recipient}, {"relays", "wss://relay.example"}}}
+	if amount != "" {
+		request.Tags = append(request.Tags, nostr.Tag{"amount", amount})
+	}
+	if err := request.Sign(senderSK); err != nil {
+		t.Fatalf("failed to sign zap request: %v", err)
+	}
+	return &nostr.Event{Kind: nostr.KindZap, PubKey: issuer, CreatedAt: nostr.Now(), Tags: nostr.Tags{
+		{"p", recipient}, {"bolt11", invoice}, {"description", request.String()},
+	}}
+}
+
+func TestBolt11Msats(t *testing.T) {
+	for invoice, want := range map[string]int64{
+		"lnbc2500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypq": 250_000_000,
+		"lnbc21000n1pj3xyz":   2_100_000,
+		"LNBC1M1PJ3XYZ":       100_000_000,
+		"lntb20m1pvjluezhp58": 2_000_000_000,
+		"lnbcrt500p1pj3xyz":   50,
+	} {
+		if got, err := bolt11Msats(invoice); err != nil || got != want {
+			t.Errorf("%s: expected %d msats, got %d %v", invoice, want, got, err)
+		}
+	}
+	for _, invoice := range []string{"lnbc1pvjluezpp5", "not an invoice", "lnbcxu1pvj"} {
+		if _, err := bolt11Msats(invoice); err == nil {
+			t.Errorf("%s: expected an error", invoice)
+		}
+	}
+}
+
+func TestParseZapReceipt(t *testing.T) {
+	_, owner := newKeypair(t)
+	_, provider := newKeypair(t)
+	senderSK, sender := newKeypair(t)
+	_, someoneElse := newKeypair(t)
+
+	receipt := zapReceipt(t, provider, senderSK, owner, "lnbc21000n1pj3xyz", "2100000")
+	if got, msats, err := parseZapReceipt(receipt, owner, []string{provider}); err != nil || got != sender || msats != 2_100_000 {
+		t.Fatalf("expected a valid receipt from the sender, got %s %d %v", got, msats, err)
+	}
+
+	for name, tc := range map[string]struct {
+		receipt *nostr.Event
+		issuers []string
+	}{
+		"untrusted issuer":  {receipt, []string{someoneElse}},
+		"other recipient":   {zapReceipt(t, provider, senderSK, someoneElse, "lnbc21000n1pj3xyz", ""), nil},
+		"amount mismatch":   {zapReceipt(t, provider, senderSK, owner, "lnbc1u1pj3xyz", "2100000"), nil},
+		"invoice no amount": {zapReceipt(t, provider, senderSK, owner, "lnbc1pj3xyz", ""), nil},
+	} {
+		if _, _, err := parseZapReceipt(tc.receipt, owner, tc.issuers); err == nil {
+			t.Errorf("%s: expected the receipt to be refused", name)
+		}
+	}
+
+	request := &nostr.Event{Kind: nostr.KindZapRequest, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", owner}}}
+	request.Sign(senderSK)
+	request.Content = "tampered"
+	forged := &nostr.Event{Kind: nostr.KindZap, PubKey: provider, Tags: nostr.Tags{{"p", owner}, {"bolt11", "lnbc21000n1pj3xyz"}, {"description", request.String()}}}
+	if _, _, err := parseZapReceipt(forged, owner, nil); err == nil {
+		t.Error("expected a zap request with a broken signature to be refused")
+	}
+}
+
+func TestZapGate(t *testing.T) {
+	ctx := context.Background()
+	_, owner := newKeypair(t)
+	_, provider := newKeypair(t)
+	zapperSK, zapper := newKeypair(t)
+	stingySK, stingy := newKeypair(t)
+	lateSK, late := newKeypair(t)
+
+	stored := []*nostr.Event{
+		zapReceipt(t, provider, zapperSK, owner, "lnbc21000n1pj3xyz", ""), // 2100 sats
+		zapReceipt(t, provider, stingySK, owner, "lnbc10u1pj3xyz", ""),    // 1000 sats
+	}
+	lookups := 0
+	lookup := func(ctx context.Context, pubkey string, since time.Time) (int64, error) {
+		lookups++
+		var total int64
+		for _, receipt := range stored {
+			if sender, msats, err := parseZapReceipt(receipt, owner, []string{provider}); err == nil && sender == pubkey {
+				total += msats
+			}
+		}
+		return total, nil
+	}
+	gate := newZapGate(lookup, owner, []string{provider}, 2_000_000, 30*24*time.Hour, time.Hour)
+
+	if reject, msg := gate.reject(ctx, &nostr.Event{PubKey: zapper, Kind: nostr.KindTextNote}); reject {
+		t.Errorf("expected a writer with qualifying zaps to be accepted, got %q", msg)
+	}
+	if reject, msg := gate.reject(ctx, &nostr.Event{PubKey: stingy, Kind: nostr.KindTextNote}); !reject || msg != "blocked: zap required" {
+		t.Errorf("expected a writer with too few zaps to be refused, got %v %q", reject, msg)
+	}
+	if reject, _ := gate.reject(ctx, &nostr.Event{PubKey: owner, Kind: nostr.KindTextNote}); reject {
+		t.Error("expected the owner to be exempt")
+	}
+	if reject, _ := gate.reject(ctx, &nostr.Event{PubKey: provider, Kind: nostr.KindZap}); reject {
+		t.Error("expected zap receipts to be exempt")
+	}
+
+	// a new receipt counts right away for cached writers
+	if reject, _ := gate.reject(ctx, &nostr.Event{PubKey: late, Kind: nostr.KindTextNote}); !reject {
+		t.Fatal("expected a writer without zaps to be refused")
+	}
+	gate.onSaved(ctx, zapReceipt(t, provider, lateSK, owner, "lnbc5000u1pj3xyz", ""))
+	if reject, _ := gate.reject(ctx, &nostr.Event{PubKey: late, Kind: nostr.KindTextNote}); reject {
+		t.Error("expected a saved receipt to update the cached total")
+	}
+	if lookups != 3 {
+		t.Errorf("expected totals to be cached, got %d lookups", lookups)
+	}
+}